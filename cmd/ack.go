@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+	influx "github.com/influxdata/influxdb1-client/v2"
+)
+
+const (
+	// ackWritten and ackFailed are the statuses of write acknowledgements
+	ackWritten = "written"
+	ackFailed  = "failed"
+	// maxQueuedAcks bounds how many acknowledgements wait to be sent while
+	// the webhooks are slow, later ones are dropped
+	maxQueuedAcks = 1000
+)
+
+// writeAck summarizes the points of a write, posted as JSON to the
+// acknowledgement webhooks
+type writeAck struct {
+	Status  string   `json:"status"`
+	Devices []string `json:"devices"`
+	Points  int      `json:"points"`
+	// Start and End are the times of the oldest and newest point
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// Error is why InfluxDB rejected the points if they failed
+	Error string `json:"error,omitempty"`
+}
+
+// newWriteAck summarizes the points of the batches written for the devices
+func newWriteAck(status string, devices []string, batches []influx.BatchPoints) writeAck {
+	ack := writeAck{Status: status, Devices: devices}
+	for _, batch := range batches {
+		for _, pt := range batch.Points() {
+			t := pt.Time()
+			if ack.Points == 0 || t.Before(ack.Start) {
+				ack.Start = t
+			}
+			if ack.Points == 0 || t.After(ack.End) {
+				ack.End = t
+			}
+			ack.Points++
+		}
+	}
+	return ack
+}
+
+// writeAcks posts an acknowledgement to every webhook once points are
+// written to influx, or were permanently rejected by it, so that workflows
+// can start processing data once it's known to be persisted. They are sent
+// in the background in the order of the writes, and aren't retried.
+type writeAcks struct {
+	lc     logger.LoggingClient
+	urls   []string
+	client *http.Client
+	queue  chan writeAck
+
+	sent, failed, dropped uint64
+}
+
+func newWriteAcks(lc logger.LoggingClient, urls []string, timeout time.Duration) *writeAcks {
+	return &writeAcks{
+		lc:     lc,
+		urls:   urls,
+		client: &http.Client{Timeout: timeout},
+		queue:  make(chan writeAck, maxQueuedAcks),
+	}
+}
+
+// acknowledge queues the acknowledgement of a write
+func (a *writeAcks) acknowledge(ack writeAck) {
+	if a == nil || ack.Points == 0 {
+		return
+	}
+	select {
+	case a.queue <- ack:
+	default:
+		atomic.AddUint64(&a.dropped, 1)
+		a.lc.Warn(fmt.Sprintf("dropping the acknowledgement of %d points, the webhooks are too slow", ack.Points))
+	}
+}
+
+// run sends the queued acknowledgements
+func (a *writeAcks) run() {
+	for ack := range a.queue {
+		body, err := json.Marshal(ack)
+		if err != nil {
+			a.lc.Error(fmt.Sprintf("unable to encode write acknowledgement: %s", err))
+			continue
+		}
+		for _, u := range a.urls {
+			if err := a.post(u, body); err != nil {
+				atomic.AddUint64(&a.failed, 1)
+				a.lc.Warn(fmt.Sprintf("unable to send write acknowledgement to %s: %s", u, err))
+				continue
+			}
+			atomic.AddUint64(&a.sent, 1)
+		}
+	}
+}
+
+func (a *writeAcks) post(u string, body []byte) error {
+	resp, err := a.client.Post(u, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+func (a *writeAcks) writeMetrics(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %swrite_acks_total Write acknowledgements posted to webhooks, by result.\n", metricsPrefix)
+	fmt.Fprintf(w, "# TYPE %swrite_acks_total counter\n", metricsPrefix)
+	fmt.Fprintf(w, "%swrite_acks_total{result=\"sent\"} %d\n", metricsPrefix, atomic.LoadUint64(&a.sent))
+	fmt.Fprintf(w, "%swrite_acks_total{result=\"failed\"} %d\n", metricsPrefix, atomic.LoadUint64(&a.failed))
+	fmt.Fprintf(w, "%swrite_acks_total{result=\"dropped\"} %d\n", metricsPrefix, atomic.LoadUint64(&a.dropped))
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// adminAuth checks the bearer token of a request to the admin routes
+type adminAuth func(token string) error
+
+var errInvalidToken = errors.New("invalid token")
+
+// staticTokenAuth accepts only the token from the AdminToken setting
+func staticTokenAuth(expected string) adminAuth {
+	return func(token string) error {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+			return errInvalidToken
+		}
+		return nil
+	}
+}
+
+// requireAdmin wraps an admin handler so that it is only served to requests
+// with an "Authorization: Bearer <token>" header accepted by auth
+func requireAdmin(auth adminAuth, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if !strings.HasPrefix(header, "Bearer ") || auth(strings.TrimPrefix(header, "Bearer ")) != nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeProblem(w, r, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+	influx "github.com/influxdata/influxdb1-client/v2"
+)
+
+const (
+	// maxAdvisorSeries bounds how many series are counted per measurement,
+	// beyond it the cardinality is reported as at least this
+	maxAdvisorSeries = 100000
+	// bytesPerPoint is roughly how much disk a point takes once compacted,
+	// which is only good enough for an estimate
+	bytesPerPoint = 3
+	// InfluxDB recommends shard groups of at least this many points, with
+	// at least minPointsPerSeries points for each series
+	minPointsPerShard  = 100000
+	minPointsPerSeries = 1000
+)
+
+// shardDurations are the shard group durations recommended from, shortest
+// first, as InfluxQL duration literals
+var shardDurations = []struct {
+	d       time.Duration
+	literal string
+}{
+	{time.Hour, "1h"},
+	{24 * time.Hour, "1d"},
+	{7 * 24 * time.Hour, "7d"},
+	{30 * 24 * time.Hour, "30d"},
+}
+
+// shardDurationOf returns the duration of one of the shardDurations literals
+func shardDurationOf(literal string) time.Duration {
+	for _, sd := range shardDurations {
+		if sd.literal == literal {
+			return sd.d
+		}
+	}
+	return 0
+}
+
+// measurementLoad is what was observed of one measurement
+type measurementLoad struct {
+	points uint64
+	series map[string]bool
+}
+
+// retentionAdvice is the recommendation for the retention policy written to
+// as served by /admin/advice
+type retentionAdvice struct {
+	Database        string  `json:"database"`
+	RetentionPolicy string  `json:"retentionPolicy"`
+	ObservedFor     string  `json:"observedFor"`
+	PointsPerSecond float64 `json:"pointsPerSecond"`
+	Series          int     `json:"series"`
+	// Measurements are the points per second and series of each measurement
+	Measurements map[string]measurementAdvice `json:"measurements"`
+	// CurrentDuration and CurrentShardDuration are the settings of the
+	// retention policy, if they could be queried
+	CurrentDuration      string `json:"currentDuration,omitempty"`
+	CurrentShardDuration string `json:"currentShardDuration,omitempty"`
+	ShardDuration        string `json:"recommendedShardDuration"`
+	// Duration is how long the disk budget lasts at the observed rate, if a
+	// budget is set
+	Duration string `json:"recommendedDuration,omitempty"`
+}
+
+type measurementAdvice struct {
+	PointsPerSecond float64 `json:"pointsPerSecond"`
+	Series          int     `json:"series"`
+}
+
+// loadAdvisor is an InfluxDB client that counts the points and series
+// written to every measurement, to recommend retention policy and shard
+// group durations for the observed load
+type loadAdvisor struct {
+	influx.Client
+	lc         logger.LoggingClient
+	readClient influx.Client
+	ptConfig   influx.BatchPointsConfig
+	// diskBudget is how many bytes the database may use, 0 doesn't
+	// recommend a retention duration
+	diskBudget uint64
+	// apply is whether the recommended shard duration is applied to the
+	// retention policy
+	apply bool
+
+	mu           sync.Mutex
+	start        time.Time
+	measurements map[string]*measurementLoad
+}
+
+func newLoadAdvisor(lc logger.LoggingClient, client, readClient influx.Client, ptConfig influx.BatchPointsConfig, diskBudget uint64, apply bool) *loadAdvisor {
+	return &loadAdvisor{
+		Client:       client,
+		lc:           lc,
+		readClient:   readClient,
+		ptConfig:     ptConfig,
+		diskBudget:   diskBudget,
+		apply:        apply,
+		start:        time.Now(),
+		measurements: make(map[string]*measurementLoad),
+	}
+}
+
+func (a *loadAdvisor) Write(bp influx.BatchPoints) error {
+	if err := a.Client.Write(bp); err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, pt := range bp.Points() {
+		m, ok := a.measurements[pt.Name()]
+		if !ok {
+			m = &measurementLoad{series: make(map[string]bool)}
+			a.measurements[pt.Name()] = m
+		}
+		m.points++
+		if len(m.series) < maxAdvisorSeries {
+			m.series[seriesKey(pt.Name(), "", pt.Tags())] = true
+		}
+	}
+	return nil
+}
+
+// advise recommends durations for the load observed so far
+func (a *loadAdvisor) advise(now time.Time) retentionAdvice {
+	a.mu.Lock()
+	observed := now.Sub(a.start)
+	advice := retentionAdvice{
+		Database:        a.ptConfig.Database,
+		RetentionPolicy: a.ptConfig.RetentionPolicy,
+		ObservedFor:     observed.Round(time.Second).String(),
+		Measurements:    make(map[string]measurementAdvice, len(a.measurements)),
+	}
+	var points uint64
+	for name, m := range a.measurements {
+		points += m.points
+		advice.Series += len(m.series)
+		advice.Measurements[name] = measurementAdvice{
+			PointsPerSecond: float64(m.points) / observed.Seconds(),
+			Series:          len(m.series),
+		}
+	}
+	a.mu.Unlock()
+	advice.PointsPerSecond = float64(points) / observed.Seconds()
+
+	// the shortest shard duration that holds enough points overall and
+	// for every series, or the longest one for very light loads
+	shard := shardDurations[len(shardDurations)-1]
+	for _, sd := range shardDurations {
+		shardPoints := advice.PointsPerSecond * sd.d.Seconds()
+		if shardPoints >= minPointsPerShard && (advice.Series == 0 || shardPoints/float64(advice.Series) >= minPointsPerSeries) {
+			shard = sd
+			break
+		}
+	}
+	advice.ShardDuration = shard.literal
+	if a.diskBudget != 0 && advice.PointsPerSecond > 0 {
+		hours := float64(a.diskBudget) / (advice.PointsPerSecond * bytesPerPoint) / time.Hour.Seconds()
+		advice.Duration = fmt.Sprintf("%dh", int64(hours))
+	}
+
+	advice.RetentionPolicy, advice.CurrentDuration, advice.CurrentShardDuration = a.currentRetentionPolicy()
+	return advice
+}
+
+// currentRetentionPolicy returns the name, duration and shard group
+// duration of the retention policy written to, which is the default one if
+// none is configured. The durations are empty if they can't be queried.
+func (a *loadAdvisor) currentRetentionPolicy() (string, string, string) {
+	rows, err := queryRows(a.readClient, a.ptConfig.Database, "SHOW RETENTION POLICIES ON "+quoteIdentifier(a.ptConfig.Database))
+	if err != nil {
+		return a.ptConfig.RetentionPolicy, "", ""
+	}
+	for _, row := range rows {
+		for _, values := range row.Values {
+			if len(values) < 5 {
+				continue
+			}
+			name, _ := values[0].(string)
+			isDefault, _ := values[4].(bool)
+			if name == a.ptConfig.RetentionPolicy || (a.ptConfig.RetentionPolicy == "" && isDefault) {
+				duration, _ := values[1].(string)
+				shardDuration, _ := values[2].(string)
+				return name, duration, shardDuration
+			}
+		}
+	}
+	return a.ptConfig.RetentionPolicy, "", ""
+}
+
+// report logs the recommendations once the observation period is over,
+// applying the shard duration if enabled
+func (a *loadAdvisor) report(after time.Duration) {
+	time.Sleep(after)
+	advice := a.advise(time.Now())
+	msg := fmt.Sprintf("observed %.2f points/s in %d series over %s, recommended shard group duration for %s is %s",
+		advice.PointsPerSecond, advice.Series, advice.ObservedFor, advice.Database, advice.ShardDuration)
+	if advice.CurrentShardDuration != "" {
+		msg += fmt.Sprintf(" (currently %s)", advice.CurrentShardDuration)
+	}
+	if advice.Duration != "" {
+		msg += fmt.Sprintf(", the disk budget lasts a retention duration of %s", advice.Duration)
+	}
+	a.lc.Info(msg)
+
+	rp := advice.RetentionPolicy
+	if !a.apply || advice.PointsPerSecond == 0 || rp == "" {
+		return
+	}
+	// influx lists durations like 168h0m0s
+	if current, err := time.ParseDuration(advice.CurrentShardDuration); err == nil && current == shardDurationOf(advice.ShardDuration) {
+		return
+	}
+	resp, err := a.Client.Query(influx.NewQuery(fmt.Sprintf("ALTER RETENTION POLICY %s ON %s SHARD DURATION %s",
+		quoteIdentifier(rp), quoteIdentifier(advice.Database), advice.ShardDuration), "", ""))
+	if err == nil {
+		err = resp.Error()
+	}
+	if err != nil {
+		a.lc.Error(fmt.Sprintf("unable to apply shard group duration %s to %s: %s", advice.ShardDuration, rp, err))
+		return
+	}
+	a.lc.Info(fmt.Sprintf("applied shard group duration %s to %s", advice.ShardDuration, rp))
+}
+
+// adviceHandler serves /admin/advice with the recommendations for the load
+// observed so far
+func (a *loadAdvisor) adviceHandler(w http.ResponseWriter, r *http.Request) {
+	advice := a.advise(time.Now())
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(advice); err != nil {
+		writeProblem(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
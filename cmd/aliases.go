@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/edgexfoundry/app-functions-sdk-go/appcontext"
+	"github.com/edgexfoundry/go-mod-core-contracts/models"
+	influx "github.com/influxdata/influxdb1-client/v2"
+)
+
+const (
+	// aliasMeasurement is where a point is written whenever a device alias
+	// is set or removed through the admin routes
+	aliasMeasurement = "proxy_device_aliases"
+	// maxAliasHops bounds how many aliases are followed to the canonical
+	// name of a device
+	maxAliasHops = 8
+)
+
+// deviceAlias maps a name a device was provisioned under to its canonical
+// name, along with the audit of the events it was applied to
+type deviceAlias struct {
+	Canonical string `json:"canonical"`
+	// Configured aliases come from DeviceAliases and can't be removed
+	// through the admin routes
+	Configured bool      `json:"configured"`
+	Events     uint64    `json:"events"`
+	FirstUsed  time.Time `json:"firstUsed"`
+	LastUsed   time.Time `json:"lastUsed"`
+}
+
+// deviceAliases rewrites the names of devices that were re-provisioned under
+// a new name to their canonical name before their events are written, so
+// that their series aren't fragmented. Aliases set through the admin routes
+// are written to influx, which is also where they are loaded from at start.
+type deviceAliases struct {
+	client     influx.Client
+	readClient influx.Client
+	ptConfig   influx.BatchPointsConfig
+
+	mu      sync.Mutex
+	aliases map[string]*deviceAlias
+}
+
+func newDeviceAliases(client, readClient influx.Client, ptConfig influx.BatchPointsConfig, configured map[string]string) *deviceAliases {
+	a := &deviceAliases{
+		client:     client,
+		readClient: readClient,
+		ptConfig:   ptConfig,
+		aliases:    make(map[string]*deviceAlias),
+	}
+	for device, canonical := range configured {
+		a.aliases[device] = &deviceAlias{Canonical: canonical, Configured: true}
+	}
+	return a
+}
+
+// load restores the aliases set by the latest points of every device,
+// configured aliases take precedence
+func (a *deviceAliases) load() error {
+	rows, err := queryRows(a.readClient, a.ptConfig.Database, fmt.Sprintf(
+		"SELECT last(%s) FROM %s GROUP BY %s",
+		quoteIdentifier("canonical"), quoteIdentifier(aliasMeasurement), quoteIdentifier("device"),
+	))
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, row := range rows {
+		device := row.Tags["device"]
+		if alias, ok := a.aliases[device]; ok && alias.Configured {
+			continue
+		}
+		for _, values := range row.Values {
+			if len(values) < 2 {
+				continue
+			}
+			// removed aliases have an empty canonical name
+			if canonical, ok := values[1].(string); ok && canonical != "" {
+				a.aliases[device] = &deviceAlias{Canonical: canonical}
+			}
+		}
+	}
+	return nil
+}
+
+// write records the alias being set, or removed with an empty canonical name
+func (a *deviceAliases) write(device, canonical string) error {
+	bp, err := influx.NewBatchPoints(a.ptConfig)
+	if err != nil {
+		return err
+	}
+	pt, err := influx.NewPoint(aliasMeasurement, map[string]string{"device": device}, map[string]interface{}{"canonical": canonical}, time.Now())
+	if err != nil {
+		return err
+	}
+	bp.AddPoint(pt)
+	return a.client.Write(bp)
+}
+
+// errConfiguredAlias is returned when changing an alias set in DeviceAliases
+var errConfiguredAlias = errors.New("the alias is set by \"DeviceAliases\"")
+
+// check returns why the alias of the device can't be changed to the
+// canonical name, which is empty when removing the alias
+func (a *deviceAliases) check(device, canonical string) error {
+	if device == canonical {
+		return errors.New("a device can't be an alias of itself")
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if alias, ok := a.aliases[device]; ok && alias.Configured {
+		return errConfiguredAlias
+	}
+	if canonical != "" && a.resolveLocked(canonical) == device {
+		return fmt.Errorf("%s is already an alias of %s", canonical, device)
+	}
+	return nil
+}
+
+// set aliases the device to the canonical name
+func (a *deviceAliases) set(device, canonical string) error {
+	if err := a.write(device, canonical); err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.aliases[device] = &deviceAlias{Canonical: canonical}
+	return nil
+}
+
+// remove stops aliasing the device
+func (a *deviceAliases) remove(device string) error {
+	if err := a.write(device, ""); err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	delete(a.aliases, device)
+	return nil
+}
+
+// resolveLocked follows the aliases of the device to its canonical name
+func (a *deviceAliases) resolveLocked(device string) string {
+	for i := 0; i < maxAliasHops; i++ {
+		alias, ok := a.aliases[device]
+		if !ok {
+			break
+		}
+		device = alias.Canonical
+	}
+	return device
+}
+
+// apply returns the canonical name of the device, recording the alias as
+// used, and false if the device isn't an alias
+func (a *deviceAliases) apply(device string, now time.Time) (string, bool) {
+	if a == nil {
+		return device, false
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	alias, ok := a.aliases[device]
+	if !ok {
+		return device, false
+	}
+	if alias.Events == 0 {
+		alias.FirstUsed = now
+	}
+	alias.Events++
+	alias.LastUsed = now
+	return a.resolveLocked(device), true
+}
+
+// aliasFunc rewrites the names of aliased devices to their canonical name
+func aliasFunc(a *deviceAliases) func(edgexcontext *appcontext.Context, params ...interface{}) (bool, interface{}) {
+	return func(edgexcontext *appcontext.Context, params ...interface{}) (bool, interface{}) {
+		if len(params) < 1 {
+			// We didn't receive a result
+			return false, errors.New("no data received")
+		}
+
+		event, ok := params[0].(models.Event)
+		if !ok {
+			// not an event, let the next function decide what to do with it
+			return true, params[0]
+		}
+
+		canonical, aliased := a.apply(event.Device, time.Now())
+		if !aliased {
+			return true, event
+		}
+		edgexcontext.LoggingClient.Debug(fmt.Sprintf("writing event from device %q as %q", event.Device, canonical))
+		event.Device = canonical
+		readings := make([]models.Reading, len(event.Readings))
+		for i, reading := range event.Readings {
+			reading.Device = canonical
+			readings[i] = reading
+		}
+		event.Readings = readings
+		return true, event
+	}
+}
+
+// aliasesHandler serves /admin/aliases:
+//
+//	GET lists the aliases and how often each was applied
+//	POST ?device=X&canonical=Y writes the events of X as Y
+//	DELETE ?device=X stops aliasing X
+func (a *deviceAliases) aliasesHandler(w http.ResponseWriter, r *http.Request) {
+	device := r.URL.Query().Get("device")
+
+	var err error
+	switch r.Method {
+	case http.MethodPost:
+		canonical := r.URL.Query().Get("canonical")
+		if device == "" || canonical == "" {
+			writeProblem(w, r, "device and canonical are required", http.StatusBadRequest)
+			return
+		}
+		if err := a.check(device, canonical); err != nil {
+			writeProblem(w, r, err.Error(), aliasCheckStatus(err))
+			return
+		}
+		err = a.set(device, canonical)
+	case http.MethodDelete:
+		if device == "" {
+			writeProblem(w, r, "device is required", http.StatusBadRequest)
+			return
+		}
+		if err := a.check(device, ""); err != nil {
+			writeProblem(w, r, err.Error(), aliasCheckStatus(err))
+			return
+		}
+		err = a.remove(device)
+	}
+	if err != nil {
+		writeProblem(w, r, fmt.Sprintf("unable to write alias: %s", classifyWriteError(err).message), http.StatusServiceUnavailable)
+		return
+	}
+
+	a.mu.Lock()
+	aliases := make(map[string]deviceAlias, len(a.aliases))
+	for device, alias := range a.aliases {
+		aliases[device] = *alias
+	}
+	a.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(aliases); err != nil {
+		writeProblem(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// aliasCheckStatus is the status of the response to an alias that can't be
+// changed
+func aliasCheckStatus(err error) int {
+	if err == errConfiguredAlias {
+		return http.StatusConflict
+	}
+	return http.StatusBadRequest
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/edgexfoundry/app-functions-sdk-go/appcontext"
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+	"github.com/edgexfoundry/go-mod-core-contracts/models"
+)
+
+// anomalyAction is what to do with a reading that trips an anomaly check
+type anomalyAction int
+
+const (
+	// anomalyIgnore writes the reading without saying anything
+	anomalyIgnore anomalyAction = iota
+	// anomalyLog writes the reading and logs a warning
+	anomalyLog
+	// anomalyDrop drops the reading and logs a warning
+	anomalyDrop
+	// anomalyMetricOnly writes the reading and only counts the anomaly in the
+	// metrics, for anomalies too frequent to log
+	anomalyMetricOnly
+	// anomalyDeadLetter drops the reading, saving it to the dead-letter
+	// directory, and logs a warning
+	anomalyDeadLetter
+	// anomalyTag writes the reading, tagging its points unregistered=true,
+	// for readings of unknown devices only
+	anomalyTag
+	// anomalyReject drops the whole event, answering 422 if it was posted to
+	// /edgex, and logs a warning, for readings of unknown devices only
+	anomalyReject
+)
+
+// unregisteredTag is the tag set to true on the points of unknown devices
+// with the tag action
+const unregisteredTag = "unregistered"
+
+func parseAnomalyAction(s string) (anomalyAction, error) {
+	switch s {
+	case "ignore":
+		return anomalyIgnore, nil
+	case "log":
+		return anomalyLog, nil
+	case "drop":
+		return anomalyDrop, nil
+	case "metric-only":
+		return anomalyMetricOnly, nil
+	case "dead-letter":
+		return anomalyDeadLetter, nil
+	}
+	return 0, fmt.Errorf("invalid anomaly action %q, must be one of \"ignore\", \"log\", \"metric-only\", \"drop\" or \"dead-letter\"", s)
+}
+
+// parseUnknownDeviceAction parses the action for readings of unknown
+// devices, which can also be tagged or have their event rejected
+func parseUnknownDeviceAction(s string) (anomalyAction, error) {
+	switch s {
+	case "tag":
+		return anomalyTag, nil
+	case "reject":
+		return anomalyReject, nil
+	}
+	if action, err := parseAnomalyAction(s); err == nil {
+		return action, nil
+	}
+	return 0, fmt.Errorf("invalid unknown device action %q, must be one of \"ignore\", \"log\", \"metric-only\", \"tag\", \"drop\", \"dead-letter\" or \"reject\"", s)
+}
+
+// rejectedEventError is returned by the anomaly policy for the events it
+// rejects, which are permanent failures that retrying can't fix
+type rejectedEventError struct {
+	reason string
+}
+
+func (e *rejectedEventError) Error() string {
+	return "event rejected: " + e.reason
+}
+
+// anomalyPolicy decides what happens to readings that look odd, per category
+// of oddity
+type anomalyPolicy struct {
+	// typeMismatch applies to readings whose value parses as a different type
+	// than previous readings of the same name from the same device, which
+	// InfluxDB will reject as a field type conflict
+	typeMismatch anomalyAction
+	// timestampSkew applies to readings whose origin is further than
+	// skewTolerance away from the current time
+	timestampSkew anomalyAction
+	skewTolerance time.Duration
+	// unknownDevice applies to readings from devices matching none of the
+	// knownDevices patterns, as for path.Match, if there are any, unless
+	// their event's tenantTag names a tenant with an action of its own in
+	// tenantUnknownDevice
+	unknownDevice       anomalyAction
+	knownDevices        []string
+	tenantTag           string
+	tenantUnknownDevice map[string]anomalyAction
+	// floats is how base64 floats are decoded, as when writing them
+	floats *binaryFloats
+
+	mu        sync.Mutex
+	seenTypes map[string]dataValueType
+	// counts are the anomalies found by category, whatever their action
+	counts map[string]uint64
+}
+
+func newAnomalyPolicy(typeMismatch, timestampSkew anomalyAction, skewTolerance time.Duration, unknownDevice anomalyAction, knownDevices []string, tenantTag string, tenantUnknownDevice map[string]anomalyAction, floats *binaryFloats) *anomalyPolicy {
+	return &anomalyPolicy{
+		typeMismatch:        typeMismatch,
+		timestampSkew:       timestampSkew,
+		skewTolerance:       skewTolerance,
+		unknownDevice:       unknownDevice,
+		knownDevices:        knownDevices,
+		tenantTag:           tenantTag,
+		tenantUnknownDevice: tenantUnknownDevice,
+		floats:              floats,
+		seenTypes:           make(map[string]dataValueType),
+		counts:              make(map[string]uint64),
+	}
+}
+
+// unknownDeviceAction returns the action for readings of unknown devices in
+// an event with the tags, which is the tenant's if it has its own
+func (p *anomalyPolicy) unknownDeviceAction(tags map[string]string) anomalyAction {
+	if p.tenantTag != "" {
+		if action, ok := p.tenantUnknownDevice[tags[p.tenantTag]]; ok {
+			return action
+		}
+	}
+	return p.unknownDevice
+}
+
+// tagsUnknownDevices returns whether readings of unknown devices may be
+// tagged, so that the tag is written
+func (p *anomalyPolicy) tagsUnknownDevices() bool {
+	if p == nil || len(p.knownDevices) == 0 {
+		return false
+	}
+	if p.unknownDevice == anomalyTag {
+		return true
+	}
+	for _, action := range p.tenantUnknownDevice {
+		if action == anomalyTag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTypeMismatch returns a description of the anomaly if the reading's
+// value type differs from the first type seen for the same device and name
+func (p *anomalyPolicy) checkTypeMismatch(reading models.Reading) string {
+	readingType, _, _, _ := parseReadingValue(reading, p.floats)
+	key := reading.Device + "/" + reading.Name
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	seenType, ok := p.seenTypes[key]
+	if !ok {
+		p.seenTypes[key] = readingType
+		return ""
+	}
+	if seenType != readingType {
+		return fmt.Sprintf("value %q of reading %q from device %q is a %s, previously a %s",
+			reading.Value, reading.Name, reading.Device, readingType, seenType)
+	}
+	return ""
+}
+
+// checkTimestampSkew returns a description of the anomaly if the reading's
+// origin is too far from now
+func (p *anomalyPolicy) checkTimestampSkew(reading models.Reading, now time.Time) string {
+	skew := now.Sub(time.Unix(0, reading.Origin))
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > p.skewTolerance {
+		return fmt.Sprintf("origin of reading %q from device %q is %s away from now", reading.Name, reading.Device, skew)
+	}
+	return ""
+}
+
+// checkUnknownDevice returns a description of the anomaly if the reading's
+// device isn't one of the known devices
+func (p *anomalyPolicy) checkUnknownDevice(reading models.Reading) string {
+	if len(p.knownDevices) == 0 || matchesAny(p.knownDevices, reading.Device) {
+		return ""
+	}
+	return fmt.Sprintf("reading %q is from unknown device %q", reading.Name, reading.Device)
+}
+
+// apply runs the checks on the reading of an event with the tags. It returns
+// anomalyIgnore to keep the reading, anomalyTag to keep it tagged,
+// anomalyDrop or anomalyDeadLetter to drop it, or anomalyReject to drop the
+// whole event, with the description of the anomaly that decided it.
+func (p *anomalyPolicy) apply(lc logger.LoggingClient, tags map[string]string, reading models.Reading, now time.Time) (anomalyAction, string) {
+	outcome := anomalyIgnore
+	for _, check := range []struct {
+		category string
+		action   anomalyAction
+		run      func() string
+	}{
+		{"unknown-device", p.unknownDeviceAction(tags), func() string { return p.checkUnknownDevice(reading) }},
+		{"type-mismatch", p.typeMismatch, func() string { return p.checkTypeMismatch(reading) }},
+		{"timestamp-skew", p.timestampSkew, func() string { return p.checkTimestampSkew(reading, now) }},
+	} {
+		if check.action == anomalyIgnore {
+			continue
+		}
+		desc := check.run()
+		if desc == "" {
+			continue
+		}
+		p.mu.Lock()
+		p.counts[check.category]++
+		p.mu.Unlock()
+
+		switch check.action {
+		case anomalyDrop:
+			lc.Warn("dropping reading: " + desc)
+			return anomalyDrop, desc
+		case anomalyDeadLetter:
+			lc.Warn("dead-lettering reading: " + desc)
+			return anomalyDeadLetter, desc
+		case anomalyReject:
+			lc.Warn("rejecting event: " + desc)
+			return anomalyReject, desc
+		case anomalyTag:
+			outcome = anomalyTag
+		case anomalyLog:
+			lc.Warn(desc)
+		}
+	}
+	return outcome, ""
+}
+
+func (p *anomalyPolicy) writeMetrics(w io.Writer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %sanomalous_readings_total Readings found anomalous by the anomaly policy, by category.\n", metricsPrefix)
+	fmt.Fprintf(w, "# TYPE %sanomalous_readings_total counter\n", metricsPrefix)
+	categories := make([]string, 0, len(p.counts))
+	for category := range p.counts {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	for _, category := range categories {
+		fmt.Fprintf(w, "%sanomalous_readings_total{category=%q} %d\n", metricsPrefix, category, p.counts[category])
+	}
+}
+
+// anomalyPolicyFunc applies the anomaly policy to each reading of the event,
+// removing the readings that the policy drops or dead-letters, tagging the
+// event if the policy tags any and failing with a rejectedEventError if it
+// rejects any
+func anomalyPolicyFunc(policy *anomalyPolicy, drops *dropAccounting, dead *deadLetters) func(edgexcontext *appcontext.Context, params ...interface{}) (bool, interface{}) {
+	return func(edgexcontext *appcontext.Context, params ...interface{}) (bool, interface{}) {
+		if len(params) < 1 {
+			// We didn't receive a result
+			return false, errors.New("no data received")
+		}
+
+		event, ok := params[0].(models.Event)
+		if !ok {
+			// not an event, let the next function decide what to do with it
+			return true, params[0]
+		}
+
+		now := time.Now()
+		readings := make([]models.Reading, 0, len(event.Readings))
+		var deadReadings []models.Reading
+		tagged := false
+		for _, reading := range event.Readings {
+			action, desc := policy.apply(edgexcontext.LoggingClient, event.Tags, reading, now)
+			switch action {
+			case anomalyIgnore:
+				readings = append(readings, reading)
+			case anomalyTag:
+				readings = append(readings, reading)
+				tagged = true
+			case anomalyDeadLetter:
+				deadReadings = append(deadReadings, reading)
+			case anomalyReject:
+				drops.add(dropAnomaly, event.Device, len(event.Readings))
+				return false, &rejectedEventError{reason: desc}
+			}
+		}
+		drops.add(dropAnomaly, event.Device, len(event.Readings)-len(readings))
+		if len(deadReadings) != 0 {
+			deadEvent := event
+			deadEvent.Readings = deadReadings
+			dead.add(edgexcontext, dropAnomaly, deadEvent)
+		}
+		if len(readings) == 0 {
+			return false, nil
+		}
+		event.Readings = readings
+		if tagged {
+			// the tags may be shared with other copies of the event
+			tags := make(map[string]string, len(event.Tags)+1)
+			for k, v := range event.Tags {
+				tags[k] = v
+			}
+			tags[unregisteredTag] = "true"
+			event.Tags = tags
+		}
+
+		return true, event
+	}
+}
+
+// forget drops the types seen for the device's readings
+func (p *anomalyPolicy) forget(device string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for key := range p.seenTypes {
+		if strings.HasPrefix(key, device+"/") {
+			delete(p.seenTypes, key)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/edgexfoundry/app-functions-sdk-go/appcontext"
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+	"github.com/edgexfoundry/go-mod-core-contracts/models"
+)
+
+func TestAnomalyPolicyUnknownDevices(t *testing.T) {
+	tenantActions := map[string]anomalyAction{"acme": anomalyReject, "lab": anomalyIgnore}
+	policy := newAnomalyPolicy(anomalyIgnore, anomalyIgnore, time.Hour, anomalyTag, []string{"Known-*"}, "tenant", tenantActions, nil)
+	edgexcontext := &appcontext.Context{LoggingClient: logger.NewMockClient()}
+	now := time.Now().UnixNano()
+	event := func(device, tenant string) models.Event {
+		e := models.Event{Device: device, Origin: now, Readings: []models.Reading{{Device: device, Name: "Temperature", Value: "21", Origin: now}}}
+		if tenant != "" {
+			e.Tags = map[string]string{"tenant": tenant}
+		}
+		return e
+	}
+
+	for _, tc := range []struct {
+		name       string
+		event      models.Event
+		tagged     bool
+		rejected   bool
+		unfiltered bool
+	}{
+		{"known device", event("Known-Device", ""), false, false, true},
+		{"unknown device", event("Unknown-Device", ""), true, false, true},
+		{"unknown device of a tenant without an action", event("Unknown-Device", "other"), true, false, true},
+		{"unknown device of a rejecting tenant", event("Unknown-Device", "acme"), false, true, false},
+		{"known device of a rejecting tenant", event("Known-Device", "acme"), false, false, true},
+		{"unknown device of an ignoring tenant", event("Unknown-Device", "lab"), false, false, true},
+	} {
+		ok, result := anomalyPolicyFunc(policy, newDropAccounting(), nil)(edgexcontext, tc.event)
+		if ok != tc.unfiltered {
+			t.Errorf("%s: passed on %v, want %v", tc.name, ok, tc.unfiltered)
+		}
+		if tc.rejected {
+			if err, isErr := result.(*rejectedEventError); !isErr || !classifyWriteError(err).permanent {
+				t.Errorf("%s: got %v, want a permanent rejection", tc.name, result)
+			}
+			continue
+		}
+		out, isEvent := result.(models.Event)
+		if !isEvent {
+			t.Errorf("%s: got %v, want an event", tc.name, result)
+			continue
+		}
+		if got := out.Tags[unregisteredTag] == "true"; got != tc.tagged {
+			t.Errorf("%s: tagged %v, want %v", tc.name, got, tc.tagged)
+		}
+		if tc.event.Tags[unregisteredTag] != "" {
+			t.Errorf("%s: the tags of the original event were changed", tc.name)
+		}
+	}
+}
+
+func TestParseUnknownDeviceAction(t *testing.T) {
+	for _, s := range []string{"ignore", "log", "metric-only", "tag", "drop", "dead-letter", "reject"} {
+		if _, err := parseUnknownDeviceAction(s); err != nil {
+			t.Errorf("%q: %v", s, err)
+		}
+	}
+	for _, s := range []string{"tag", "reject"} {
+		if _, err := parseAnomalyAction(s); err == nil {
+			t.Errorf("%q was accepted for categories other than unknown devices", s)
+		}
+	}
+	if _, err := parseUnknownDeviceAction("quarantine"); err == nil {
+		t.Error("an invalid action was accepted")
+	}
+}
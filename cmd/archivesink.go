@@ -0,0 +1,257 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	edgexinfluxproxy "github.com/anonymouse64/edgex-influx-proxy"
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+	"github.com/influxdata/influxdb1-client/models"
+	influx "github.com/influxdata/influxdb1-client/v2"
+)
+
+const (
+	// archiveTimeLayout formats the start of the bucket in archive names
+	archiveTimeLayout = "20060102T150405Z"
+	// archiveSuffix and manifestSuffix end the names of archives and their
+	// manifests
+	archiveSuffix  = ".lp"
+	manifestSuffix = ".manifest.json"
+	// maxOpenArchives bounds how many archives the state to update their
+	// manifests with is kept for, the state of others is read back from
+	// the archives when points of their bucket arrive late
+	maxOpenArchives = 16
+)
+
+func init() {
+	edgexinfluxproxy.RegisterSink("file-archive", newFileArchiveSink)
+}
+
+// archiveManifest describes an archive, so that downstream tooling can
+// discover archives and validate them before ingesting
+type archiveManifest struct {
+	File string `json:"file"`
+	// Start and End are the bucket the archive holds the points of
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// First and Last are the times of the oldest and newest point
+	First   time.Time `json:"first"`
+	Last    time.Time `json:"last"`
+	Devices []string  `json:"devices"`
+	Rows    int       `json:"rows"`
+	Bytes   int64     `json:"bytes"`
+	SHA256  string    `json:"sha256"`
+}
+
+// openArchive is the state of an archive points are appended to
+type openArchive struct {
+	manifest archiveManifest
+	devices  map[string]bool
+	sum      hash.Hash
+	used     time.Time
+}
+
+// fileArchiveSink appends every point as line protocol to files of the
+// bucket of time it falls in, named deterministically after the start of the
+// bucket, and keeps a JSON manifest next to every file with its time range,
+// devices, rows and checksum. Manifests are rewritten after every write, so
+// that they match their archive whenever it isn't being written to.
+type fileArchiveSink struct {
+	dir    string
+	bucket time.Duration
+
+	mu   sync.Mutex
+	open map[string]*openArchive
+}
+
+func newFileArchiveSink(lc logger.LoggingClient, settings map[string]string) (edgexinfluxproxy.Sink, error) {
+	s := &fileArchiveSink{
+		dir:  settings["ArchiveDir"],
+		open: make(map[string]*openArchive),
+	}
+	if s.dir == "" {
+		return nil, errors.New("\"ArchiveDir\" is required")
+	}
+	var err error
+	s.bucket, err = durationSetting(settings, "ArchiveBucket", time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	if s.bucket < time.Second {
+		return nil, fmt.Errorf("invalid \"ArchiveBucket\" setting of %s, must be at least 1s", s.bucket)
+	}
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// archiveName returns the name of the archive of the bucket starting at
+// start
+func archiveName(start time.Time) string {
+	return "points-" + start.UTC().Format(archiveTimeLayout) + archiveSuffix
+}
+
+func (s *fileArchiveSink) Write(points []*influx.Point) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// append the points to the archives of their buckets in one write each
+	byName := make(map[string][]*influx.Point)
+	starts := make(map[string]time.Time)
+	for _, pt := range points {
+		start := pt.Time().UTC().Truncate(s.bucket)
+		name := archiveName(start)
+		byName[name] = append(byName[name], pt)
+		starts[name] = start
+	}
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	now := time.Now()
+	for _, name := range names {
+		a, err := s.archive(name, starts[name])
+		if err != nil {
+			return err
+		}
+		a.used = now
+
+		var b strings.Builder
+		for _, pt := range byName[name] {
+			b.WriteString(pt.String())
+			b.WriteByte('\n')
+			a.observe(pt.Time().UTC(), pt.Name(), pt.Tags())
+		}
+		if err := s.appendArchive(a, []byte(b.String())); err != nil {
+			// start over from the file next time
+			delete(s.open, name)
+			return err
+		}
+		if err := s.writeManifest(a); err != nil {
+			return err
+		}
+	}
+	s.evict()
+	return nil
+}
+
+// archive returns the state of the named archive, reading it back from the
+// archive if it isn't open, which also repairs its manifest if the proxy
+// stopped between appending to the archive and writing the manifest
+func (s *fileArchiveSink) archive(name string, start time.Time) (*openArchive, error) {
+	if a, ok := s.open[name]; ok {
+		return a, nil
+	}
+	a := &openArchive{
+		manifest: archiveManifest{File: name, Start: start, End: start.Add(s.bucket)},
+		devices:  make(map[string]bool),
+		sum:      sha256.New(),
+	}
+	data, err := ioutil.ReadFile(filepath.Join(s.dir, name))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(data) != 0 {
+		pts, err := models.ParsePoints(data)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read back archive %s: %v", name, err)
+		}
+		for _, pt := range pts {
+			a.observe(pt.Time().UTC(), string(pt.Name()), pt.Tags().Map())
+		}
+		a.sum.Write(data)
+		a.manifest.Bytes = int64(len(data))
+	}
+	s.open[name] = a
+	return a, nil
+}
+
+// observe adds a point at t of the measurement with the tags to the
+// manifest, the device of points without a device tag is the measurement, as
+// with the per-device layout
+func (a *openArchive) observe(t time.Time, measurement string, tags map[string]string) {
+	if a.manifest.Rows == 0 || t.Before(a.manifest.First) {
+		a.manifest.First = t
+	}
+	if t.After(a.manifest.Last) {
+		a.manifest.Last = t
+	}
+	if device, ok := tags[deviceTag]; ok {
+		a.devices[device] = true
+	} else {
+		a.devices[measurement] = true
+	}
+	a.manifest.Rows++
+}
+
+// appendArchive appends the lines to the archive
+func (s *fileArchiveSink) appendArchive(a *openArchive, lines []byte) error {
+	f, err := os.OpenFile(filepath.Join(s.dir, a.manifest.File), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(lines); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	a.sum.Write(lines)
+	a.manifest.Bytes += int64(len(lines))
+	return nil
+}
+
+// writeManifest replaces the manifest of the archive, writing it to a
+// temporary file first so that readers never see a partial manifest
+func (s *fileArchiveSink) writeManifest(a *openArchive) error {
+	a.manifest.Devices = make([]string, 0, len(a.devices))
+	for device := range a.devices {
+		a.manifest.Devices = append(a.manifest.Devices, device)
+	}
+	sort.Strings(a.manifest.Devices)
+	a.manifest.SHA256 = hex.EncodeToString(a.sum.Sum(nil))
+
+	data, err := json.MarshalIndent(a.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(s.dir, strings.TrimSuffix(a.manifest.File, archiveSuffix)+manifestSuffix)
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// evict forgets the state of the least recently written archives beyond
+// maxOpenArchives
+func (s *fileArchiveSink) evict() {
+	for len(s.open) > maxOpenArchives {
+		var oldest string
+		for name, a := range s.open {
+			if oldest == "" || a.used.Before(s.open[oldest].used) {
+				oldest = name
+			}
+		}
+		delete(s.open, oldest)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// encryptedSuffix is added to the names of files encrypted at rest
+	encryptedSuffix = ".enc"
+	// atRestSecretKey is the key of the encryption key in the secret store
+	atRestSecretKey = "key"
+)
+
+// atRestMagic starts every file encrypted at rest, followed by the nonce and
+// the sealed contents
+var atRestMagic = []byte("EIPENC1\n")
+
+// atRestCipher encrypts the files the proxy writes telemetry to with
+// AES-GCM, as gateways in the field can be physically accessed
+type atRestCipher struct {
+	aead cipher.AEAD
+}
+
+// parseAtRestKey parses a 16, 24 or 32 byte AES key, as hex, base64 or the
+// raw bytes, ignoring surrounding whitespace of the encoded forms
+func parseAtRestKey(data []byte) ([]byte, error) {
+	validLength := func(key []byte) bool {
+		return len(key) == 16 || len(key) == 24 || len(key) == 32
+	}
+	text := string(bytes.TrimSpace(data))
+	if key, err := hex.DecodeString(text); err == nil && validLength(key) {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && validLength(key) {
+		return key, nil
+	}
+	if validLength(data) {
+		return data, nil
+	}
+	return nil, errors.New("the key must be 16, 24 or 32 bytes, raw or encoded as hex or base64")
+}
+
+func newAtRestCipher(keyData []byte) (*atRestCipher, error) {
+	key, err := parseAtRestKey(keyData)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &atRestCipher{aead: aead}, nil
+}
+
+// seal encrypts the contents of a file
+func (c *atRestCipher) seal(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	out := append(append([]byte(nil), atRestMagic...), nonce...)
+	return c.aead.Seal(out, nonce, plaintext, atRestMagic), nil
+}
+
+// open decrypts the contents of a file sealed with the same key
+func (c *atRestCipher) open(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, atRestMagic) {
+		return nil, errors.New("not an encrypted file")
+	}
+	data = data[len(atRestMagic):]
+	if len(data) < c.aead.NonceSize() {
+		return nil, errors.New("truncated encrypted file")
+	}
+	nonce, sealed := data[:c.aead.NonceSize()], data[c.aead.NonceSize():]
+	plaintext, err := c.aead.Open(nil, nonce, sealed, atRestMagic)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt, wrong key or corrupted file: %v", err)
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+)
+
+func TestAtRestCipherRoundTrip(t *testing.T) {
+	plaintext := []byte(`{"device":"Sensor-1","readings":[{"name":"Temperature","value":"21"}]}`)
+	for _, size := range []int{16, 24, 32} {
+		key := bytes.Repeat([]byte{0x42}, size)
+		for name, keyData := range map[string][]byte{
+			"raw":    key,
+			"hex":    []byte(hex.EncodeToString(key) + "\n"),
+			"base64": []byte(" " + base64.StdEncoding.EncodeToString(key)),
+		} {
+			c, err := newAtRestCipher(keyData)
+			if err != nil {
+				t.Fatalf("%d byte %s key: %v", size, name, err)
+			}
+			sealed, err := c.seal(plaintext)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(sealed, plaintext[:16]) {
+				t.Errorf("%d byte %s key: the sealed file contains the plaintext", size, name)
+			}
+			opened, err := c.open(sealed)
+			if err != nil || !bytes.Equal(opened, plaintext) {
+				t.Errorf("%d byte %s key: opened %q, %v", size, name, opened, err)
+			}
+		}
+	}
+
+	for _, keyData := range [][]byte{nil, []byte("short"), bytes.Repeat([]byte{1}, 20), []byte(hex.EncodeToString(make([]byte, 20)))} {
+		if _, err := newAtRestCipher(keyData); err == nil {
+			t.Errorf("the key %q was accepted", keyData)
+		}
+	}
+}
+
+func TestAtRestCipherRejectsWrongKeysAndTampering(t *testing.T) {
+	c, err := newAtRestCipher(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := newAtRestCipher(bytes.Repeat([]byte{2}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := c.seal([]byte("Temperature,device=Sensor-1 value=21"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.open(sealed); err == nil {
+		t.Error("opened a file sealed with another key")
+	}
+
+	flip := func(i int) []byte {
+		tampered := append([]byte(nil), sealed...)
+		tampered[i] ^= 0x01
+		return tampered
+	}
+	nonceSize := c.aead.NonceSize()
+	for name, data := range map[string][]byte{
+		"magic":      flip(0),
+		"nonce":      flip(len(atRestMagic)),
+		"ciphertext": flip(len(atRestMagic) + nonceSize),
+		"tag":        flip(len(sealed) - 1),
+		"truncated":  sealed[:len(sealed)-1],
+		"no nonce":   sealed[:len(atRestMagic)+nonceSize-1],
+		"plaintext":  []byte("Temperature,device=Sensor-1 value=21"),
+	} {
+		if _, err := c.open(data); err == nil {
+			t.Errorf("opened a file with a tampered %s", name)
+		}
+	}
+}
+
+func TestAtRestCipherNonces(t *testing.T) {
+	c, err := newAtRestCipher(bytes.Repeat([]byte{1}, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext := []byte("the same contents")
+	nonces := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		sealed, err := c.seal(plaintext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nonce := string(sealed[len(atRestMagic) : len(atRestMagic)+c.aead.NonceSize()])
+		if nonces[nonce] {
+			t.Fatalf("nonce %x was used twice", nonce)
+		}
+		nonces[nonce] = true
+	}
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+	influx "github.com/influxdata/influxdb1-client/v2"
+)
+
+const (
+	// auditMeasurement is where the count and checksum of the readings of
+	// every device written in every interval are written
+	auditMeasurement = "delivery_audit"
+	// auditRetention is how long the totals of a written window are kept,
+	// so that readings arriving for it later are added to them
+	auditRetention = 24 * time.Hour
+)
+
+// auditEntry is a reading written to influx, as it was written
+type auditEntry struct {
+	device, resource string
+	tags             map[string]string
+	value            interface{}
+	t                time.Time
+}
+
+// auditWindow is the readings of one device in one interval
+type auditWindow struct {
+	// hashes are the hashes of the readings since the window was last
+	// written by their series and timestamp, so that a reading written again
+	// replaces the earlier one like it does in influx
+	hashes    map[string]uint64
+	resources map[string]bool
+	// count and checksum are the totals of the readings up to when the
+	// window was last written
+	count, checksum uint64
+	last            time.Time
+	written         time.Time
+}
+
+// deliveryAudit counts the readings written for every device in every
+// interval, along with the sum of their hashes as a checksum, and writes
+// them to delivery_audit once no reading arrived for a window for an
+// interval. admin verify-delivery recomputes them from the points in influx,
+// to prove that everything that was written is still there. Readings that
+// arrive after their window was written are added to its totals, but are
+// only deduplicated against the readings since then.
+type deliveryAudit struct {
+	lc        logger.LoggingClient
+	client    influx.Client
+	ptConfig  influx.BatchPointsConfig
+	interval  time.Duration
+	precision time.Duration
+
+	mu      sync.Mutex
+	windows map[string]map[int64]*auditWindow
+}
+
+func newDeliveryAudit(lc logger.LoggingClient, client influx.Client, ptConfig influx.BatchPointsConfig, interval time.Duration) (*deliveryAudit, error) {
+	precision := precisionDuration(ptConfig.Precision)
+	if interval%precision != 0 {
+		return nil, fmt.Errorf("the interval %s must be a multiple of the precision %q", interval, ptConfig.Precision)
+	}
+	return &deliveryAudit{
+		lc:        lc,
+		client:    client,
+		ptConfig:  ptConfig,
+		interval:  interval,
+		precision: precision,
+		windows:   make(map[string]map[int64]*auditWindow),
+	}, nil
+}
+
+// canonicalFloat formats a float the way influx returns it, where integral
+// floats look like integers
+func canonicalFloat(f float64) string {
+	if f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+		return strconv.FormatInt(int64(f), 10)
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// canonicalValue formats a field value alike whether it was written or
+// queried from influx, or returns false if it isn't a value
+func canonicalValue(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case bool:
+		return strconv.FormatBool(v), true
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case float64:
+		return canonicalFloat(v), true
+	case string:
+		return v, true
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return strconv.FormatInt(i, 10), true
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return "", false
+		}
+		return canonicalFloat(f), true
+	}
+	return "", false
+}
+
+// auditKey identifies the series of a reading and its timestamp, empty tags
+// are left out as influx returns absent tags as empty
+func auditKey(resource string, tags map[string]string, t int64) string {
+	pairs := make([]string, 0, len(tags))
+	for k, v := range tags {
+		if v != "" {
+			pairs = append(pairs, k+"="+v)
+		}
+	}
+	sort.Strings(pairs)
+	return resource + "\x00" + strings.Join(pairs, ",") + "\x00" + strconv.FormatInt(t, 10)
+}
+
+// auditHash hashes the series, timestamp and value of a reading
+func auditHash(key, value string) uint64 {
+	h := fnv.New64a()
+	io.WriteString(h, key)
+	io.WriteString(h, "\x00")
+	io.WriteString(h, value)
+	return h.Sum64()
+}
+
+// record adds the readings written to the windows of their devices
+func (a *deliveryAudit) record(entries []auditEntry, now time.Time) {
+	if a == nil || len(entries) == 0 {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, e := range entries {
+		value, ok := canonicalValue(e.value)
+		if !ok {
+			continue
+		}
+		t := e.t.Truncate(a.precision)
+		start := t.Truncate(a.interval).UnixNano()
+		windows, ok := a.windows[e.device]
+		if !ok {
+			windows = make(map[int64]*auditWindow)
+			a.windows[e.device] = windows
+		}
+		w, ok := windows[start]
+		if !ok {
+			w = &auditWindow{resources: make(map[string]bool)}
+			windows[start] = w
+		}
+		if w.hashes == nil {
+			w.hashes = make(map[string]uint64)
+		}
+		key := auditKey(e.resource, e.tags, t.UnixNano())
+		w.hashes[key] = auditHash(key, value)
+		w.resources[e.resource] = true
+		w.last = now
+	}
+}
+
+// totals returns the count and checksum of the window including the
+// readings since it was last written
+func (w *auditWindow) totals() (uint64, uint64) {
+	count, checksum := w.count, w.checksum
+	for _, h := range w.hashes {
+		count++
+		checksum += h
+	}
+	return count, checksum
+}
+
+// flush writes the windows that no reading arrived for in the last
+// interval, and forgets the totals of windows written long ago
+func (a *deliveryAudit) flush(now time.Time) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	bp, err := influx.NewBatchPoints(a.ptConfig)
+	if err != nil {
+		return err
+	}
+	var flushed []*auditWindow
+	for device, windows := range a.windows {
+		for start, w := range windows {
+			if w.hashes == nil {
+				if now.Sub(w.written) > auditRetention {
+					delete(windows, start)
+				}
+				continue
+			}
+			if now.Sub(w.last) < a.interval {
+				continue
+			}
+			count, checksum := w.totals()
+			resources := make([]string, 0, len(w.resources))
+			for resource := range w.resources {
+				resources = append(resources, resource)
+			}
+			sort.Strings(resources)
+			pt, err := influx.NewPoint(auditMeasurement, map[string]string{"device": device}, map[string]interface{}{
+				"count":     int64(count),
+				"checksum":  fmt.Sprintf("%016x", checksum),
+				"interval":  a.interval.String(),
+				"resources": strings.Join(resources, ","),
+			}, time.Unix(0, start))
+			if err != nil {
+				return err
+			}
+			bp.AddPoint(pt)
+			flushed = append(flushed, w)
+		}
+		if len(windows) == 0 {
+			delete(a.windows, device)
+		}
+	}
+	if len(flushed) == 0 {
+		return nil
+	}
+	if err := a.client.Write(bp); err != nil {
+		return err
+	}
+	for _, w := range flushed {
+		w.count, w.checksum = w.totals()
+		w.hashes = nil
+		w.written = now
+	}
+	return nil
+}
+
+// run writes the completed windows every interval
+func (a *deliveryAudit) run() {
+	for now := range time.Tick(a.interval) {
+		if err := a.flush(now); err != nil {
+			a.lc.Warn(fmt.Sprintf("unable to write delivery audit: %s", err))
+		}
+	}
+}
+
+// verifyOptions are the arguments of the admin verify-delivery command
+type verifyOptions struct {
+	start, end time.Time
+	device     string
+	// sdkArgs are the arguments after "--", which are passed on to the SDK
+	sdkArgs []string
+}
+
+// parseVerifyArgs parses the arguments following admin verify-delivery:
+//
+//	admin verify-delivery [-start <time>] [-end <time>] [-device <name>] [-- <SDK arguments>]
+func parseVerifyArgs(args []string) (*verifyOptions, error) {
+	opts := &verifyOptions{}
+	for i, arg := range args {
+		if arg == "--" {
+			opts.sdkArgs = args[i+1:]
+			args = args[:i]
+			break
+		}
+	}
+
+	var start, end string
+	fs := flag.NewFlagSet("admin verify-delivery", flag.ContinueOnError)
+	fs.StringVar(&start, "start", "", "RFC3339 time of the first window to verify, defaults to a day before -end")
+	fs.StringVar(&end, "end", "", "RFC3339 time to verify the windows before, defaults to now")
+	fs.StringVar(&opts.device, "device", "", "only verify the windows of this device")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != 0 {
+		return nil, errors.New("usage: admin verify-delivery [-start <time>] [-end <time>] [-device <name>] [-- <SDK arguments>]")
+	}
+
+	opts.end = time.Now()
+	if end != "" {
+		t, err := time.Parse(time.RFC3339, end)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -end: %v", err)
+		}
+		opts.end = t
+	}
+	opts.start = opts.end.Add(-24 * time.Hour)
+	if start != "" {
+		t, err := time.Parse(time.RFC3339, start)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -start: %v", err)
+		}
+		opts.start = t
+	}
+	if !opts.start.Before(opts.end) {
+		return nil, errors.New("-start must be before -end")
+	}
+	return opts, nil
+}
+
+// auditVerifier recomputes the windows written to delivery_audit from the
+// points in influx
+type auditVerifier struct {
+	readClient influx.Client
+	database   string
+	layout     measurementLayout
+	opts       *verifyOptions
+}
+
+// run compares every audited window with the points in influx, writing a
+// line per window to w, and returns how many windows didn't match
+func (v *auditVerifier) run(w io.Writer) (int, error) {
+	where := fmt.Sprintf("time >= %d AND time < %d", v.opts.start.UnixNano(), v.opts.end.UnixNano())
+	if v.opts.device != "" {
+		where += fmt.Sprintf(" AND %s = %s", quoteIdentifier("device"), quoteLiteral(v.opts.device))
+	}
+	rows, err := queryRows(v.readClient, v.database, fmt.Sprintf(
+		"SELECT %s, %s, %s, %s FROM %s WHERE %s GROUP BY %s",
+		quoteIdentifier("count"), quoteIdentifier("checksum"), quoteIdentifier("interval"), quoteIdentifier("resources"),
+		quoteIdentifier(auditMeasurement), where, quoteIdentifier("device"),
+	))
+	if err != nil {
+		return 0, err
+	}
+
+	mismatches := 0
+	for _, row := range rows {
+		device := row.Tags["device"]
+		for _, values := range row.Values {
+			if len(values) < 5 {
+				continue
+			}
+			startNum, _ := values[0].(json.Number)
+			start, err := startNum.Int64()
+			if err != nil {
+				return mismatches, fmt.Errorf("invalid audit time %v", values[0])
+			}
+			countNum, _ := values[1].(json.Number)
+			count, _ := countNum.Int64()
+			checksum, _ := values[2].(string)
+			intervalStr, _ := values[3].(string)
+			interval, err := time.ParseDuration(intervalStr)
+			if err != nil {
+				return mismatches, fmt.Errorf("invalid audit interval %q", intervalStr)
+			}
+			resources, _ := values[4].(string)
+
+			found, sum, err := v.recompute(device, splitList(resources), start, start+int64(interval))
+			if err != nil {
+				return mismatches, err
+			}
+			status := "ok"
+			switch {
+			case found < uint64(count):
+				status = "missing"
+			case found > uint64(count):
+				status = "extra"
+			case fmt.Sprintf("%016x", sum) != checksum:
+				status = "altered"
+			}
+			if status != "ok" {
+				mismatches++
+			}
+			fmt.Fprintf(w, "%s\t%s\taudited %d\tfound %d\t%s\n", device, time.Unix(0, start).UTC().Format(time.RFC3339), count, found, status)
+		}
+	}
+	return mismatches, nil
+}
+
+// recompute returns the count and checksum of the readings of the device's
+// resources in influx between start and end
+func (v *auditVerifier) recompute(device string, resources []string, start, end int64) (uint64, uint64, error) {
+	var count, checksum uint64
+	for _, resource := range resources {
+		field, measurement, condition := v.layout.query(device, resource)
+		where := fmt.Sprintf("time >= %d AND time < %d", start, end)
+		if condition != "" {
+			where = condition + " AND " + where
+		}
+		rows, err := queryRows(v.readClient, v.database, fmt.Sprintf(
+			"SELECT %s FROM %s WHERE %s GROUP BY *", field, measurement, where,
+		))
+		if err != nil {
+			return 0, 0, err
+		}
+		for _, row := range rows {
+			for _, values := range row.Values {
+				if len(values) < 2 || values[1] == nil {
+					continue
+				}
+				tNum, _ := values[0].(json.Number)
+				t, err := tNum.Int64()
+				if err != nil {
+					continue
+				}
+				value, ok := canonicalValue(values[1])
+				if !ok {
+					continue
+				}
+				key := auditKey(resource, row.Tags, t)
+				count++
+				checksum += auditHash(key, value)
+			}
+		}
+	}
+	return count, checksum, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// writeTuner adapts the batch size and flush interval of line protocol read
+// over TCP to how InfluxDB copes, so that the same configuration suits
+// gateways with very different loads. Like TCP congestion control, the batch
+// grows additively while writes are fast and halves when a write is slow or
+// fails, while the flush interval, which lets partial batches fill up, grows
+// multiplicatively under congestion and shrinks additively once it clears.
+type writeTuner struct {
+	// targetLatency is the write latency above which InfluxDB is taken to
+	// be congested
+	targetLatency time.Duration
+	minBatch      int
+	maxBatch      int
+	maxInterval   time.Duration
+
+	mu       sync.Mutex
+	batch    int
+	interval time.Duration
+}
+
+// tunerIntervalSteps is how many steps the flush interval takes to shrink
+// from its maximum back to 0
+const tunerIntervalSteps = 20
+
+func newWriteTuner(targetLatency time.Duration, minBatch, maxBatch int, maxInterval time.Duration) *writeTuner {
+	return &writeTuner{
+		targetLatency: targetLatency,
+		minBatch:      minBatch,
+		maxBatch:      maxBatch,
+		maxInterval:   maxInterval,
+		batch:         minBatch,
+	}
+}
+
+// batchSize returns the most lines to write at once, max unless tuning
+func (t *writeTuner) batchSize(max int) int {
+	if t == nil {
+		return max
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.batch
+}
+
+// flushInterval returns how long a partial batch waits for more lines, 0
+// unless tuning
+func (t *writeTuner) flushInterval() time.Duration {
+	if t == nil {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.interval
+}
+
+// observe adjusts the batch size and flush interval to a write that took
+// latency, congested being whether it failed in a way that retrying later
+// could fix
+func (t *writeTuner) observe(latency time.Duration, congested bool) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if congested || latency > t.targetLatency {
+		t.batch /= 2
+		if t.batch < t.minBatch {
+			t.batch = t.minBatch
+		}
+		if t.interval == 0 {
+			t.interval = t.maxInterval / tunerIntervalSteps
+		} else {
+			t.interval *= 2
+		}
+		if t.interval > t.maxInterval {
+			t.interval = t.maxInterval
+		}
+		return
+	}
+
+	t.batch += t.minBatch
+	if t.batch > t.maxBatch {
+		t.batch = t.maxBatch
+	}
+	t.interval -= t.maxInterval / tunerIntervalSteps
+	if t.interval < 0 {
+		t.interval = 0
+	}
+}
+
+func (t *writeTuner) writeMetrics(w io.Writer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %sline_protocol_batch_size Most lines read over TCP written at once, as tuned.\n", metricsPrefix)
+	fmt.Fprintf(w, "# TYPE %sline_protocol_batch_size gauge\n", metricsPrefix)
+	fmt.Fprintf(w, "%sline_protocol_batch_size %d\n", metricsPrefix, t.batch)
+	fmt.Fprintf(w, "# HELP %sline_protocol_flush_interval_seconds How long partial batches read over TCP wait for more lines, as tuned.\n", metricsPrefix)
+	fmt.Fprintf(w, "# TYPE %sline_protocol_flush_interval_seconds gauge\n", metricsPrefix)
+	fmt.Fprintf(w, "%sline_protocol_flush_interval_seconds %g\n", metricsPrefix, t.interval.Seconds())
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWriteTuner(t *testing.T) {
+	tuner := newWriteTuner(100*time.Millisecond, 100, 1000, time.Second)
+	for _, step := range []struct {
+		name         string
+		latency      time.Duration
+		congested    bool
+		wantBatch    int
+		wantInterval time.Duration
+	}{
+		{"fast write grows the batch", 10 * time.Millisecond, false, 200, 0},
+		{"fast write grows the batch again", 10 * time.Millisecond, false, 300, 0},
+		{"slow write halves the batch", 200 * time.Millisecond, false, 150, 50 * time.Millisecond},
+		{"failed write halves it down to the minimum", 0, true, 100, 100 * time.Millisecond},
+		{"more failures double the interval", 0, true, 100, 200 * time.Millisecond},
+		{"fast write shrinks the interval", 10 * time.Millisecond, false, 200, 150 * time.Millisecond},
+	} {
+		tuner.observe(step.latency, step.congested)
+		if got := tuner.batchSize(0); got != step.wantBatch {
+			t.Errorf("%s: got batch %d, want %d", step.name, got, step.wantBatch)
+		}
+		if got := tuner.flushInterval(); got != step.wantInterval {
+			t.Errorf("%s: got interval %s, want %s", step.name, got, step.wantInterval)
+		}
+	}
+
+	for i := 0; i < 20; i++ {
+		tuner.observe(10*time.Millisecond, false)
+	}
+	if got := tuner.batchSize(0); got != 1000 {
+		t.Errorf("got batch %d after many fast writes, want the maximum 1000", got)
+	}
+	if got := tuner.flushInterval(); got != 0 {
+		t.Errorf("got interval %s after many fast writes, want 0", got)
+	}
+	for i := 0; i < 20; i++ {
+		tuner.observe(0, true)
+	}
+	if got := tuner.flushInterval(); got != time.Second {
+		t.Errorf("got interval %s after many failures, want the maximum 1s", got)
+	}
+
+	var disabled *writeTuner
+	if got := disabled.batchSize(500); got != 500 {
+		t.Errorf("got batch %d without tuning, want 500", got)
+	}
+}
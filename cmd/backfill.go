@@ -0,0 +1,29 @@
+package main
+
+import (
+	"time"
+
+	influx "github.com/influxdata/influxdb1-client/v2"
+)
+
+// backfillRouting sends late readings to a separate retention policy and/or
+// measurement, so that backfilled data doesn't disturb real-time dashboards
+type backfillRouting struct {
+	// age is how old a reading must be when it arrives to be late
+	age               time.Duration
+	retentionPolicy   string
+	measurementSuffix string
+}
+
+// late returns whether a reading with the origin time arriving now is late
+func (b *backfillRouting) late(origin, now time.Time) bool {
+	return b != nil && now.Sub(origin) > b.age
+}
+
+// batchConfig returns the config of batches of late points
+func (b *backfillRouting) batchConfig(ptConfig influx.BatchPointsConfig) influx.BatchPointsConfig {
+	if b.retentionPolicy != "" {
+		ptConfig.RetentionPolicy = b.retentionPolicy
+	}
+	return ptConfig
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// binaryFloats is the byte order of base64 encoded floats, which is big
+// endian as EdgeX encodes them unless configured otherwise, such as for
+// device services passing on the little endian registers of a device. The
+// byte order can be overridden by device or resource name, resources taking
+// precedence.
+type binaryFloats struct {
+	order     binary.ByteOrder
+	overrides map[string]binary.ByteOrder
+}
+
+// parseByteOrder parses "big" or "little"
+func parseByteOrder(s string) (binary.ByteOrder, error) {
+	switch strings.ToLower(s) {
+	case "", "big":
+		return binary.BigEndian, nil
+	case "little":
+		return binary.LittleEndian, nil
+	}
+	return nil, fmt.Errorf("invalid byte order %q, must be \"big\" or \"little\"", s)
+}
+
+// newBinaryFloats parses the default byte order and the comma separated
+// name=order overrides
+func newBinaryFloats(order, overrides string) (*binaryFloats, error) {
+	b := &binaryFloats{overrides: make(map[string]binary.ByteOrder)}
+	var err error
+	if b.order, err = parseByteOrder(order); err != nil {
+		return nil, err
+	}
+	pairs, err := parseTags(overrides)
+	if err != nil {
+		return nil, err
+	}
+	for name, o := range pairs {
+		if b.overrides[name], err = parseByteOrder(o); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// byteOrder returns the byte order of the floats of the device's resource
+func (b *binaryFloats) byteOrder(device, resource string) binary.ByteOrder {
+	if b == nil {
+		return binary.BigEndian
+	}
+	if o, ok := b.overrides[resource]; ok {
+		return o
+	}
+	if o, ok := b.overrides[device]; ok {
+		return o
+	}
+	return b.order
+}
+
+// float16frombits returns the value of an IEEE 754 half precision float
+func float16frombits(bits uint16) float64 {
+	sign := 1.0
+	if bits&0x8000 != 0 {
+		sign = -1
+	}
+	exp := int(bits>>10) & 0x1f
+	frac := float64(bits & 0x3ff)
+	switch exp {
+	case 0:
+		// subnormal
+		return sign * math.Ldexp(frac, -24)
+	case 0x1f:
+		if frac != 0 {
+			return math.NaN()
+		}
+		return math.Inf(int(sign))
+	}
+	return sign * math.Ldexp(1+frac/1024, exp-15)
+}
+
+// decodeBinaryFloat decodes a 4 or 8 byte float, or with half a 2 byte
+// float, returning false for any other length
+func decodeBinaryFloat(data []byte, order binary.ByteOrder, half bool) (float64, bool) {
+	switch len(data) {
+	case 2:
+		if half {
+			return float16frombits(order.Uint16(data)), true
+		}
+	case 4:
+		return float64(math.Float32frombits(order.Uint32(data))), true
+	case 8:
+		return math.Float64frombits(order.Uint64(data)), true
+	}
+	return 0, false
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// bootstrapTimeout bounds fetching the configuration and its signature
+	bootstrapTimeout = time.Minute
+	// maxBootstrapConfig bounds the size of the fetched configuration
+	maxBootstrapConfig = 1 << 20
+)
+
+// bootstrapOptions are the arguments fetching the initial configuration of
+// a gateway on its first start
+type bootstrapOptions struct {
+	url string
+	// sha256 is the expected hex checksum of the configuration
+	sha256 string
+	// publicKeyFile is a PEM ed25519 public key verifying the base64
+	// signature of the configuration served next to it with a .sig suffix
+	publicKeyFile string
+	// client fetches the configuration, nil uses one timing out after
+	// bootstrapTimeout
+	client *http.Client
+}
+
+// bootstrapFlags are the arguments of bootstrapOptions, by their name
+// without dashes
+var bootstrapFlags = map[string]func(o *bootstrapOptions, value string){
+	"bootstrap-url":        func(o *bootstrapOptions, value string) { o.url = value },
+	"bootstrap-sha256":     func(o *bootstrapOptions, value string) { o.sha256 = strings.ToLower(value) },
+	"bootstrap-public-key": func(o *bootstrapOptions, value string) { o.publicKeyFile = value },
+}
+
+// flagValue returns the name without dashes and the value of the flag at
+// args[i], which is either -name=value or -name value with either one or two
+// dashes, and how many arguments it takes up, or 0 if it isn't one of names
+func flagValue(args []string, i int, names ...string) (string, string, int) {
+	arg := strings.TrimLeft(args[i], "-")
+	if arg == args[i] {
+		return "", "", 0
+	}
+	for _, name := range names {
+		if arg == name && i+1 < len(args) {
+			return name, args[i+1], 2
+		}
+		if strings.HasPrefix(arg, name+"=") {
+			return name, arg[len(name)+1:], 1
+		}
+	}
+	return "", "", 0
+}
+
+// parseBootstrapArgs returns the bootstrap options, or nil if there is no
+// --bootstrap-url, and the arguments without them for the SDK
+func parseBootstrapArgs(args []string) (*bootstrapOptions, []string, error) {
+	names := make([]string, 0, len(bootstrapFlags))
+	for name := range bootstrapFlags {
+		names = append(names, name)
+	}
+	opts := &bootstrapOptions{}
+	var rest []string
+	for i := 0; i < len(args); {
+		name, value, n := flagValue(args, i, names...)
+		if n == 0 {
+			rest = append(rest, args[i])
+			i++
+			continue
+		}
+		bootstrapFlags[name](opts, value)
+		i += n
+	}
+	if opts.url == "" {
+		if opts.sha256 != "" || opts.publicKeyFile != "" {
+			return nil, nil, errors.New("--bootstrap-sha256 and --bootstrap-public-key require --bootstrap-url")
+		}
+		return nil, rest, nil
+	}
+	u, err := url.Parse(opts.url)
+	if err != nil || u.Scheme != "https" {
+		return nil, nil, errors.New("--bootstrap-url must be an https URL")
+	}
+	if opts.sha256 == "" && opts.publicKeyFile == "" {
+		return nil, nil, errors.New("--bootstrap-url requires --bootstrap-sha256 or --bootstrap-public-key to verify the configuration")
+	}
+	return opts, rest, nil
+}
+
+// sdkConfigPath returns the configuration file the SDK loads given its
+// arguments, which is configuration.toml in the -confdir directory, ./res by
+// default, or in its -profile subdirectory
+func sdkConfigPath(args []string) string {
+	confDir, profile := "./res", ""
+	for i := 0; i < len(args); {
+		name, value, n := flagValue(args, i, "c", "confdir", "p", "profile")
+		if n == 0 {
+			i++
+			continue
+		}
+		switch name {
+		case "c", "confdir":
+			confDir = value
+		case "p", "profile":
+			profile = value
+		}
+		i += n
+	}
+	return filepath.Join(confDir, profile, "configuration.toml")
+}
+
+// fetch returns the body of the URL
+func (o *bootstrapOptions) fetch(client *http.Client, u string) ([]byte, error) {
+	resp, err := client.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &statusError{status: resp.StatusCode, msg: fmt.Sprintf("fetching %s returned %s", u, resp.Status)}
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxBootstrapConfig+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxBootstrapConfig {
+		return nil, fmt.Errorf("%s is larger than %d bytes", u, maxBootstrapConfig)
+	}
+	return body, nil
+}
+
+// verify checks the configuration against the expected checksum and the
+// signature, whichever are set
+func (o *bootstrapOptions) verify(client *http.Client, config []byte) error {
+	if o.sha256 != "" {
+		sum := sha256.Sum256(config)
+		if hex.EncodeToString(sum[:]) != o.sha256 {
+			return errors.New("the configuration doesn't match --bootstrap-sha256")
+		}
+	}
+	if o.publicKeyFile == "" {
+		return nil
+	}
+
+	keyPEM, err := ioutil.ReadFile(o.publicKeyFile)
+	if err != nil {
+		return err
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return fmt.Errorf("no PEM public key in %s", o.publicKeyFile)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return err
+	}
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return fmt.Errorf("the public key in %s isn't an ed25519 key", o.publicKeyFile)
+	}
+	sigBody, err := o.fetch(client, o.url+".sig")
+	if err != nil {
+		return err
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sigBody)))
+	if err != nil {
+		return fmt.Errorf("invalid signature: %v", err)
+	}
+	if !ed25519.Verify(publicKey, config, sig) {
+		return errors.New("the signature of the configuration doesn't verify with --bootstrap-public-key")
+	}
+	return nil
+}
+
+// bootstrap fetches and verifies the configuration and writes it to path,
+// unless a configuration already exists there, so that it is only fetched
+// on the first start. It returns whether the configuration was written.
+func (o *bootstrapOptions) bootstrap(path string) (bool, error) {
+	if _, err := os.Stat(path); err == nil {
+		return false, nil
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
+
+	client := o.client
+	if client == nil {
+		client = &http.Client{Timeout: bootstrapTimeout}
+	}
+	config, err := o.fetch(client, o.url)
+	if err != nil {
+		return false, err
+	}
+	if err := o.verify(client, config); err != nil {
+		return false, err
+	}
+
+	// write it to a temporary file first, so that a partial configuration
+	// is never left behind
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return false, err
+	}
+	tmp := path + ".bootstrap"
+	if err := ioutil.WriteFile(tmp, config, 0600); err != nil {
+		return false, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseBootstrapArgs(t *testing.T) {
+	opts, rest, err := parseBootstrapArgs([]string{"-confdir", "./res", "--bootstrap-url=https://config.example.com/gw1.toml", "--bootstrap-sha256", "ABCD"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.url != "https://config.example.com/gw1.toml" || opts.sha256 != "abcd" {
+		t.Errorf("got %+v", opts)
+	}
+	if len(rest) != 2 || rest[0] != "-confdir" || rest[1] != "./res" {
+		t.Errorf("left %v for the SDK", rest)
+	}
+
+	for _, args := range [][]string{
+		{"--bootstrap-url", "http://config.example.com/gw1.toml", "--bootstrap-sha256", "abcd"},
+		{"--bootstrap-url", "config.example.com/gw1.toml", "--bootstrap-sha256", "abcd"},
+		{"--bootstrap-url", "https://config.example.com/gw1.toml"},
+		{"--bootstrap-sha256", "abcd"},
+	} {
+		if _, _, err := parseBootstrapArgs(args); err == nil {
+			t.Errorf("%v was accepted", args)
+		}
+	}
+}
+
+func TestBootstrap(t *testing.T) {
+	config := []byte("[Writable]\nLogLevel = 'INFO'\n")
+	sum := sha256.Sum256(config)
+	checksum := hex.EncodeToString(sum[:])
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "bootstrap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	signatures := map[string][]byte{
+		"/valid.toml.sig":  ed25519.Sign(privateKey, config),
+		"/forged.toml.sig": ed25519.Sign(otherKey, config),
+	}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if sig, ok := signatures[r.URL.Path]; ok {
+			w.Write([]byte(base64.StdEncoding.EncodeToString(sig) + "\n"))
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, ".sig") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(config)
+	}))
+	defer server.Close()
+
+	for _, tc := range []struct {
+		name string
+		opts bootstrapOptions
+		ok   bool
+	}{
+		{"checksum", bootstrapOptions{url: server.URL + "/valid.toml", sha256: checksum}, true},
+		{"signature", bootstrapOptions{url: server.URL + "/valid.toml", publicKeyFile: keyFile}, true},
+		{"bad checksum", bootstrapOptions{url: server.URL + "/valid.toml", sha256: hex.EncodeToString(make([]byte, sha256.Size))}, false},
+		{"bad signature", bootstrapOptions{url: server.URL + "/forged.toml", publicKeyFile: keyFile}, false},
+		{"missing signature", bootstrapOptions{url: server.URL + "/unsigned.toml", publicKeyFile: keyFile}, false},
+		{"good checksum and bad signature", bootstrapOptions{url: server.URL + "/forged.toml", sha256: checksum, publicKeyFile: keyFile}, false},
+	} {
+		tc.opts.client = server.Client()
+		path := filepath.Join(dir, tc.name, "configuration.toml")
+		written, err := tc.opts.bootstrap(path)
+		if written != tc.ok || (err == nil) != tc.ok {
+			t.Errorf("%s: got %v, %v", tc.name, written, err)
+			continue
+		}
+		got, readErr := ioutil.ReadFile(path)
+		if tc.ok && string(got) != string(config) {
+			t.Errorf("%s: wrote %q", tc.name, got)
+		}
+		if !tc.ok && !os.IsNotExist(readErr) {
+			t.Errorf("%s: a configuration that didn't verify was written", tc.name)
+		}
+	}
+
+	// an existing configuration is kept without fetching anything
+	existing := filepath.Join(dir, "existing.toml")
+	if err := ioutil.WriteFile(existing, []byte("local"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	opts := bootstrapOptions{url: "https://unreachable.invalid/gw1.toml", sha256: checksum}
+	if written, err := opts.bootstrap(existing); written || err != nil {
+		t.Errorf("got %v, %v for an existing configuration", written, err)
+	}
+	if got, _ := ioutil.ReadFile(existing); string(got) != "local" {
+		t.Errorf("the existing configuration was replaced with %q", got)
+	}
+}
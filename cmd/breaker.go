@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/edgexfoundry/app-functions-sdk-go/appcontext"
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+	"github.com/edgexfoundry/go-mod-core-contracts/models"
+)
+
+// circuitBreaker tracks misbehaving devices and quarantines them for a
+// cooldown period so that they can't take down the rest of the pipeline
+type circuitBreaker struct {
+	// maxFailures is the number of consecutive readings from a device that
+	// failed to become points before the device is quarantined, 0 disables
+	maxFailures uint64
+	// maxReadingNames is the number of distinct reading names a device may
+	// produce within readingNamesWindow before the device is quarantined, 0
+	// disables
+	maxReadingNames uint64
+	// readingNamesWindow is how long a reading name counts towards
+	// maxReadingNames after the device last produced it
+	readingNamesWindow time.Duration
+	// cooldown is how long a device stays quarantined
+	cooldown time.Duration
+
+	lc logger.LoggingClient
+	// clock is the system clock unless set by tests
+	clock clock
+
+	mu      sync.Mutex
+	devices map[string]*deviceBreakerState
+}
+
+type deviceBreakerState struct {
+	failures uint64
+	// readingNames are when the device last produced each reading name
+	// within the window
+	readingNames map[string]time.Time
+	trippedUntil time.Time
+}
+
+func newCircuitBreaker(lc logger.LoggingClient, maxFailures, maxReadingNames uint64, readingNamesWindow, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		maxFailures:        maxFailures,
+		maxReadingNames:    maxReadingNames,
+		readingNamesWindow: readingNamesWindow,
+		cooldown:           cooldown,
+		lc:                 lc,
+		clock:              systemClock{},
+		devices:            make(map[string]*deviceBreakerState),
+	}
+}
+
+// state returns the state for the device, creating it if needed - the lock
+// must be held
+func (cb *circuitBreaker) state(device string) *deviceBreakerState {
+	s, ok := cb.devices[device]
+	if !ok {
+		s = &deviceBreakerState{readingNames: make(map[string]time.Time)}
+		cb.devices[device] = s
+	}
+	return s
+}
+
+// trip quarantines the device, the lock must be held. It returns the reason
+// to raise an alert with.
+func (cb *circuitBreaker) trip(device string, s *deviceBreakerState, reason string) string {
+	s.trippedUntil = cb.clock.Now().Add(cb.cooldown)
+	s.failures = 0
+	s.readingNames = make(map[string]time.Time)
+	cb.lc.Error(fmt.Sprintf("quarantining device %q for %s: %s", device, cb.cooldown, reason))
+	return reason
+}
+
+// allow checks whether the event's device is currently quarantined and
+// records the event's reading names, tripping the breaker if the device has
+// produced too many distinct names within the window, in which case it also
+// returns why
+func (cb *circuitBreaker) allow(event models.Event) (ok bool, tripped string) {
+	if cb == nil {
+		return true, ""
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	s := cb.state(event.Device)
+	now := cb.clock.Now()
+	if now.Before(s.trippedUntil) {
+		return false, ""
+	}
+
+	if cb.maxReadingNames == 0 {
+		return true, ""
+	}
+	// names slide out of the window once the device stops producing them,
+	// so that devices adding resources over their lifetime aren't tripped
+	for name, seen := range s.readingNames {
+		if now.Sub(seen) > cb.readingNamesWindow {
+			delete(s.readingNames, name)
+		}
+	}
+	for _, reading := range event.Readings {
+		s.readingNames[reading.Name] = now
+	}
+	if uint64(len(s.readingNames)) > cb.maxReadingNames {
+		return false, cb.trip(event.Device, s, fmt.Sprintf("more than %d distinct reading names within %s", cb.maxReadingNames, cb.readingNamesWindow))
+	}
+	return true, ""
+}
+
+// recordFailure notes that a reading from the device couldn't be turned into
+// a point, tripping the breaker after too many consecutive failures, in which
+// case it returns why
+func (cb *circuitBreaker) recordFailure(device string) string {
+	if cb == nil || cb.maxFailures == 0 {
+		return ""
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	s := cb.state(device)
+	s.failures++
+	if s.failures >= cb.maxFailures {
+		return cb.trip(device, s, fmt.Sprintf("%d consecutive malformed readings", s.failures))
+	}
+	return ""
+}
+
+// recordSuccess resets the consecutive failure count for the device
+func (cb *circuitBreaker) recordSuccess(device string) {
+	if cb == nil || cb.maxFailures == 0 {
+		return
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.state(device).failures = 0
+}
+
+// circuitBreakerFunc dead-letters events from devices that are quarantined by
+// the circuit breaker, raising an alert when a device is quarantined
+func circuitBreakerFunc(cb *circuitBreaker, drops *dropAccounting, dead *deadLetters, notifier *failureNotifier) func(edgexcontext *appcontext.Context, params ...interface{}) (bool, interface{}) {
+	return func(edgexcontext *appcontext.Context, params ...interface{}) (bool, interface{}) {
+		if len(params) < 1 {
+			// We didn't receive a result
+			return false, errors.New("no data received")
+		}
+
+		event, ok := params[0].(models.Event)
+		if !ok {
+			// not an event, let the next function decide what to do with it
+			return true, params[0]
+		}
+
+		allowed, tripped := cb.allow(event)
+		if tripped != "" {
+			notifier.quarantined(edgexcontext, event.Device, cb.cooldown, tripped)
+		}
+		if !allowed {
+			edgexcontext.LoggingClient.Debug(fmt.Sprintf("dropping event from quarantined device %q", event.Device))
+			drops.add(dropQuarantined, event.Device, len(event.Readings))
+			dead.add(edgexcontext, dropQuarantined, event)
+			return false, nil
+		}
+
+		return true, event
+	}
+}
+
+// forget drops everything known about the device
+func (cb *circuitBreaker) forget(device string) {
+	if cb == nil {
+		return
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	delete(cb.devices, device)
+}
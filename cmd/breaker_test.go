@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/anonymouse64/edgex-influx-proxy/pkg/testutil"
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+	"github.com/edgexfoundry/go-mod-core-contracts/models"
+)
+
+func TestCircuitBreakerCooldown(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Unix(1600000000, 0))
+	cb := newCircuitBreaker(logger.NewMockClient(), 2, 0, time.Hour, time.Minute)
+	cb.clock = clk
+	event := models.Event{Device: "device", Readings: []models.Reading{{Name: "Temperature"}}}
+
+	if cb.recordFailure("device") != "" {
+		t.Fatal("tripped after one failure")
+	}
+	if cb.recordFailure("device") == "" {
+		t.Fatal("didn't trip after two consecutive failures")
+	}
+	if ok, _ := cb.allow(event); ok {
+		t.Fatal("a quarantined device was allowed")
+	}
+
+	clk.Advance(time.Minute - time.Second)
+	if ok, _ := cb.allow(event); ok {
+		t.Fatal("a device was allowed before the end of its cooldown")
+	}
+	clk.Advance(time.Second)
+	if ok, _ := cb.allow(event); !ok {
+		t.Fatal("a device was still quarantined after its cooldown")
+	}
+}
+
+func TestCircuitBreakerReadingNamesWindow(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Unix(1600000000, 0))
+	cb := newCircuitBreaker(logger.NewMockClient(), 0, 2, time.Hour, time.Minute)
+	cb.clock = clk
+	event := func(names ...string) models.Event {
+		e := models.Event{Device: "device"}
+		for _, name := range names {
+			e.Readings = append(e.Readings, models.Reading{Name: name})
+		}
+		return e
+	}
+
+	// a device renaming its resources over time stays under the limit, as
+	// the old names slide out of the window
+	for _, name := range []string{"a", "b", "c", "d"} {
+		if ok, _ := cb.allow(event(name)); !ok {
+			t.Fatalf("reading %q was refused after the previous names left the window", name)
+		}
+		clk.Advance(40 * time.Minute)
+	}
+
+	// but not one producing too many of them within the window
+	clk.Advance(time.Hour)
+	if ok, _ := cb.allow(event("e", "f")); !ok {
+		t.Fatal("tripped at the limit")
+	}
+	if ok, tripped := cb.allow(event("g")); ok || tripped == "" {
+		t.Fatal("didn't trip after more distinct names than the limit within the window")
+	}
+}
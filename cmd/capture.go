@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/edgexfoundry/app-functions-sdk-go/appcontext"
+	"github.com/edgexfoundry/go-mod-core-contracts/models"
+)
+
+// maxCaptureTTL is the longest a capture can be enabled for, so that a
+// forgotten capture doesn't fill the disk
+const maxCaptureTTL = 24 * time.Hour
+
+// capturer saves the events of selected devices to files so that parsing
+// problems can be reproduced later
+type capturer struct {
+	dir string
+	// cipher encrypts the saved events if set
+	cipher *atRestCipher
+
+	mu      sync.Mutex
+	devices map[string]time.Time
+}
+
+func newCapturer(dir string, cipher *atRestCipher) *capturer {
+	return &capturer{
+		dir:     dir,
+		cipher:  cipher,
+		devices: make(map[string]time.Time),
+	}
+}
+
+// enable captures the events of the device until the TTL expires
+func (c *capturer) enable(device string, ttl time.Duration) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	until := time.Now().Add(ttl)
+	c.devices[device] = until
+	return until
+}
+
+// disable stops capturing the events of the device
+func (c *capturer) disable(device string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.devices, device)
+}
+
+// active returns the devices being captured and until when
+func (c *capturer) active(now time.Time) map[string]time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	active := make(map[string]time.Time)
+	for device, until := range c.devices {
+		if now.Before(until) {
+			active[device] = until
+		} else {
+			delete(c.devices, device)
+		}
+	}
+	return active
+}
+
+// capturing returns whether the events of the device are being captured
+func (c *capturer) capturing(device string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	until, ok := c.devices[device]
+	if ok && !now.Before(until) {
+		delete(c.devices, device)
+		return false
+	}
+	return ok
+}
+
+// save writes the payload to a new file in the device's capture directory
+func (c *capturer) save(device string, payload []byte, now time.Time) (string, error) {
+	// device names may contain anything, so keep them from escaping the
+	// capture directory
+	dir := filepath.Join(c.dir, strings.Replace(filepath.Clean("/"+device), "/", "_", -1))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	name := filepath.Join(dir, now.UTC().Format("20060102T150405.000000000Z")+".json")
+	if c.cipher == nil {
+		return name, ioutil.WriteFile(name, payload, 0644)
+	}
+	sealed, err := c.cipher.seal(payload)
+	if err != nil {
+		return "", err
+	}
+	name += encryptedSuffix
+	return name, ioutil.WriteFile(name, sealed, 0600)
+}
+
+// captureFunc saves the events of devices being captured before passing them
+// on unchanged
+func captureFunc(c *capturer) func(edgexcontext *appcontext.Context, params ...interface{}) (bool, interface{}) {
+	return func(edgexcontext *appcontext.Context, params ...interface{}) (bool, interface{}) {
+		if len(params) < 1 {
+			// We didn't receive a result
+			return false, errors.New("no data received")
+		}
+
+		event, ok := params[0].(models.Event)
+		if !ok || c == nil {
+			// not an event, let the next function decide what to do with it
+			return true, params[0]
+		}
+
+		now := time.Now()
+		if c.capturing(event.Device, now) {
+			// the SDK has already decoded the event, so this is the event as
+			// the SDK understood it re-encoded as JSON
+			payload, err := json.MarshalIndent(event, "", "  ")
+			if err == nil {
+				_, err = c.save(event.Device, payload, now)
+			}
+			if err != nil {
+				edgexcontext.LoggingClient.Warn(fmt.Sprintf("unable to capture event from device %q: %s", event.Device, err))
+			}
+		}
+
+		return true, event
+	}
+}
+
+// captureHandler serves /admin/capture:
+//
+//	GET lists the devices being captured
+//	POST ?device=X&ttl=10m starts capturing the device's events
+//	DELETE ?device=X stops capturing the device's events
+func (c *capturer) captureHandler(w http.ResponseWriter, r *http.Request) {
+	device := r.URL.Query().Get("device")
+
+	switch r.Method {
+	case http.MethodPost:
+		if device == "" {
+			writeProblem(w, r, "device is required", http.StatusBadRequest)
+			return
+		}
+		ttl, err := durationSetting(map[string]string{"ttl": r.URL.Query().Get("ttl")}, "ttl", 10*time.Minute)
+		if err != nil || ttl == 0 || ttl > maxCaptureTTL {
+			writeProblem(w, r, fmt.Sprintf("ttl must be a positive duration of at most %s", maxCaptureTTL), http.StatusBadRequest)
+			return
+		}
+		c.enable(device, ttl)
+	case http.MethodDelete:
+		if device == "" {
+			writeProblem(w, r, "device is required", http.StatusBadRequest)
+			return
+		}
+		c.disable(device)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(c.active(time.Now())); err != nil {
+		writeProblem(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/edgexfoundry/app-functions-sdk-go/appcontext"
+	"github.com/edgexfoundry/go-mod-core-contracts/models"
+)
+
+// maxChatterSeries bounds how many series the last value is remembered for,
+// readings of series beyond it are never suppressed
+const maxChatterSeries = 100000
+
+// chatterValue is the last value kept for a series
+type chatterValue struct {
+	value string
+	t     time.Time
+}
+
+// chatterSuppression drops readings of any type repeating the value last
+// kept for their series within a window of it, for sensors that publish the
+// same value many times a second. As the window starts at the last reading
+// kept, an unchanging value is still written once every window.
+type chatterSuppression struct {
+	window time.Duration
+
+	mu         sync.Mutex
+	last       map[string]chatterValue
+	suppressed uint64
+}
+
+func newChatterSuppression(window time.Duration) *chatterSuppression {
+	return &chatterSuppression{
+		window: window,
+		last:   make(map[string]chatterValue),
+	}
+}
+
+// keep returns whether the reading isn't a repeat of the last value kept for
+// its series within the window, remembering it if it isn't
+func (c *chatterSuppression) keep(device string, reading models.Reading, now time.Time) bool {
+	t := now
+	if reading.Origin > 0 {
+		t = time.Unix(0, reading.Origin)
+	}
+	value := reading.Value
+	if reading.BinaryValue != nil {
+		value = string(reading.BinaryValue)
+	}
+
+	key := device + "/" + reading.Name
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	last, ok := c.last[key]
+	if ok && last.value == value && t.Sub(last.t) >= 0 && t.Sub(last.t) < c.window {
+		c.suppressed++
+		return false
+	}
+	if ok || len(c.last) < maxChatterSeries {
+		c.last[key] = chatterValue{value: value, t: t}
+	}
+	return true
+}
+
+func (c *chatterSuppression) writeMetrics(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %ssuppressed_readings_total Readings repeating the last value of their series within the chatter window.\n", metricsPrefix)
+	fmt.Fprintf(w, "# TYPE %ssuppressed_readings_total counter\n", metricsPrefix)
+	fmt.Fprintf(w, "%ssuppressed_readings_total %d\n", metricsPrefix, c.suppressed)
+}
+
+// chatterFunc drops the readings repeating the last value of their series
+// within the window
+func chatterFunc(c *chatterSuppression, drops *dropAccounting) func(edgexcontext *appcontext.Context, params ...interface{}) (bool, interface{}) {
+	return func(edgexcontext *appcontext.Context, params ...interface{}) (bool, interface{}) {
+		if len(params) < 1 {
+			// We didn't receive a result
+			return false, errors.New("no data received")
+		}
+
+		event, ok := params[0].(models.Event)
+		if !ok || c == nil {
+			// not an event, let the next function decide what to do with it
+			return true, params[0]
+		}
+
+		now := time.Now()
+		readings := make([]models.Reading, 0, len(event.Readings))
+		for _, reading := range event.Readings {
+			if c.keep(event.Device, reading, now) {
+				readings = append(readings, reading)
+			}
+		}
+		drops.add(dropChattering, event.Device, len(event.Readings)-len(readings))
+		if len(readings) == 0 {
+			return false, nil
+		}
+		event.Readings = readings
+
+		return true, event
+	}
+}
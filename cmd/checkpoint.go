@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// checkpoint records the progress of a long running replay-file or admin
+// remap in a file, so that it resumes where it was interrupted instead of
+// starting over. Points written again after an interruption are identical to
+// the ones already written, so influx overwrites them.
+type checkpoint struct {
+	path string
+
+	// Job identifies what was being done, so that a checkpoint isn't
+	// resumed by a different job
+	Job string `json:"job"`
+	// Done are the paths or measurements completed
+	Done []string `json:"done,omitempty"`
+	// Current is the path or measurement in progress, and Offset how many of
+	// its files or rows were written
+	Current string `json:"current,omitempty"`
+	Offset  int    `json:"offset,omitempty"`
+	// LastWritten is the time of the newest point written
+	LastWritten time.Time `json:"lastWritten,omitempty"`
+}
+
+// loadCheckpoint reads the checkpoint of the job from the file, or starts a
+// new one if the file doesn't exist, an empty path disables checkpoints
+func loadCheckpoint(path, job string) (*checkpoint, error) {
+	if path == "" {
+		return nil, nil
+	}
+	c := &checkpoint{path: path, Job: job}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint %s: %v", path, err)
+	}
+	if c.Job != job {
+		return nil, fmt.Errorf("the checkpoint %s is of %q, not %q, remove it to start over", path, c.Job, job)
+	}
+	return c, nil
+}
+
+// done returns whether the path or measurement was completed
+func (c *checkpoint) done(unit string) bool {
+	if c == nil {
+		return false
+	}
+	for _, d := range c.Done {
+		if d == unit {
+			return true
+		}
+	}
+	return false
+}
+
+// offset returns how many files or rows of the path or measurement were
+// written
+func (c *checkpoint) offset(unit string) int {
+	if c == nil || c.Current != unit {
+		return 0
+	}
+	return c.Offset
+}
+
+// progress records that offset files or rows of the path or measurement were
+// written, the newest at t
+func (c *checkpoint) progress(unit string, offset int, t time.Time) error {
+	if c == nil {
+		return nil
+	}
+	c.Current, c.Offset = unit, offset
+	if t.After(c.LastWritten) {
+		c.LastWritten = t
+	}
+	return c.save()
+}
+
+// complete records that the path or measurement was completed, the newest
+// point written at t
+func (c *checkpoint) complete(unit string, t time.Time) error {
+	if c == nil {
+		return nil
+	}
+	c.Done = append(c.Done, unit)
+	if t.After(c.LastWritten) {
+		c.LastWritten = t
+	}
+	c.Current, c.Offset = "", 0
+	return c.save()
+}
+
+// save writes the checkpoint to a temporary file first, so that an
+// interruption never leaves a partial checkpoint behind
+func (c *checkpoint) save() error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+package main
+
+import "time"
+
+// clock tells the time to the write path and the stages and listeners that
+// depend on it, so that tests can control it
+type clock interface {
+	Now() time.Time
+	// Tick delivers the time every d, like time.Tick
+	Tick(d time.Duration) <-chan time.Time
+}
+
+// systemClock is the clock of the system
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) Tick(d time.Duration) <-chan time.Time {
+	return time.Tick(d)
+}
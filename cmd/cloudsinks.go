@@ -0,0 +1,228 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"time"
+
+	edgexinfluxproxy "github.com/anonymouse64/edgex-influx-proxy"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+	influx "github.com/influxdata/influxdb1-client/v2"
+)
+
+// mqttPublishTimeout is how long a sink waits for the broker to acknowledge a
+// published batch
+const mqttPublishTimeout = 30 * time.Second
+
+func init() {
+	registerCloudSinks(edgexinfluxproxy.DefaultRegistrar)
+}
+
+// registerCloudSinks registers the sinks publishing to cloud IoT services
+func registerCloudSinks(r edgexinfluxproxy.Registrar) {
+	r.RegisterSink("azure-iothub", newAzureIoTHubSink)
+	r.RegisterSink("aws-iotcore", newAWSIoTCoreSink)
+}
+
+// jsonPoint is how points are serialized for cloud sinks
+type jsonPoint struct {
+	Measurement string                 `json:"measurement"`
+	Tags        map[string]string      `json:"tags,omitempty"`
+	Fields      map[string]interface{} `json:"fields"`
+	Time        time.Time              `json:"time"`
+}
+
+// marshalPoints serializes the points as a JSON array of jsonPoint
+func marshalPoints(points []*influx.Point) ([]byte, error) {
+	out := make([]jsonPoint, 0, len(points))
+	for _, pt := range points {
+		fields, err := pt.Fields()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, jsonPoint{
+			Measurement: pt.Name(),
+			Tags:        pt.Tags(),
+			Fields:      fields,
+			Time:        pt.Time().UTC(),
+		})
+	}
+	return json.Marshal(out)
+}
+
+// mqttSink publishes every batch of points as a JSON message to a topic
+type mqttSink struct {
+	client mqtt.Client
+	topic  string
+}
+
+func (s *mqttSink) Write(points []*influx.Point) error {
+	payload, err := marshalPoints(points)
+	if err != nil {
+		return err
+	}
+	token := s.client.Publish(s.topic, 1, false, payload)
+	if !token.WaitTimeout(mqttPublishTimeout) {
+		return fmt.Errorf("timed out publishing to %s", s.topic)
+	}
+	return token.Error()
+}
+
+// connectMQTTSink connects to the broker and returns a sink publishing to
+// topic
+func connectMQTTSink(lc logger.LoggingClient, opts *mqtt.ClientOptions, topic string) (*mqttSink, error) {
+	opts.SetAutoReconnect(true)
+	opts.SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+		lc.Warn(fmt.Sprintf("lost connection to MQTT broker for topic %s: %s", topic, err))
+	})
+	client := mqtt.NewClient(opts)
+	token := client.Connect()
+	if !token.WaitTimeout(mqttPublishTimeout) {
+		return nil, errors.New("timed out connecting to MQTT broker")
+	}
+	if token.Error() != nil {
+		return nil, token.Error()
+	}
+	return &mqttSink{client: client, topic: topic}, nil
+}
+
+// subscribeMQTT connects to the broker and subscribes to topic, subscribing
+// again whenever the connection is reestablished
+func subscribeMQTT(lc logger.LoggingClient, opts *mqtt.ClientOptions, topic string, handler mqtt.MessageHandler) error {
+	opts.SetAutoReconnect(true)
+	opts.SetOnConnectHandler(func(client mqtt.Client) {
+		token := client.Subscribe(topic, 1, handler)
+		if token.WaitTimeout(mqttPublishTimeout) && token.Error() != nil {
+			lc.Error(fmt.Sprintf("unable to subscribe to %s: %s", topic, token.Error()))
+		}
+	})
+	opts.SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+		lc.Warn(fmt.Sprintf("lost connection to MQTT broker for topic %s: %s", topic, err))
+	})
+	token := mqtt.NewClient(opts).Connect()
+	if !token.WaitTimeout(mqttPublishTimeout) {
+		return errors.New("timed out connecting to MQTT broker")
+	}
+	return token.Error()
+}
+
+// loadClientTLSConfig returns a TLS configuration using the client certificate
+// and key, trusting the CA certificate if set in addition to the system roots
+func loadClientTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	conf := &tls.Config{}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		conf.RootCAs = pool
+	}
+	return conf, nil
+}
+
+// azureSASToken creates a shared access signature for the resource URI that
+// is valid until expiry
+func azureSASToken(resourceURI, key string, expiry time.Time) (string, error) {
+	decodedKey, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return "", fmt.Errorf("shared access key is not valid base64: %v", err)
+	}
+	sr := url.QueryEscape(resourceURI)
+	se := strconv.FormatInt(expiry.Unix(), 10)
+	mac := hmac.New(sha256.New, decodedKey)
+	mac.Write([]byte(sr + "\n" + se))
+	sig := url.QueryEscape(base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	return fmt.Sprintf("SharedAccessSignature sr=%s&sig=%s&se=%s", sr, sig, se), nil
+}
+
+// newAzureIoTHubSink sends points as device-to-cloud messages of a device in
+// an Azure IoT Hub, authenticating either with the device's shared access key
+// or with an X.509 certificate
+func newAzureIoTHubSink(lc logger.LoggingClient, settings map[string]string) (edgexinfluxproxy.Sink, error) {
+	host := settings["AzureIoTHubHostname"]
+	deviceID := settings["AzureIoTHubDeviceID"]
+	if host == "" || deviceID == "" {
+		return nil, errors.New("AzureIoTHubHostname and AzureIoTHubDeviceID are required")
+	}
+	tokenTTL, err := durationSetting(settings, "AzureIoTHubTokenTTL", time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := loadClientTLSConfig(settings["AzureIoTHubCertFile"], settings["AzureIoTHubKeyFile"], settings["AzureIoTHubCAFile"])
+	if err != nil {
+		return nil, err
+	}
+
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(fmt.Sprintf("ssl://%s:8883", host))
+	opts.SetClientID(deviceID)
+	opts.SetTLSConfig(tlsConfig)
+	opts.SetUsername(fmt.Sprintf("%s/%s/?api-version=2018-06-30", host, deviceID))
+	if key := settings["AzureIoTHubSharedAccessKey"]; key != "" {
+		resourceURI := fmt.Sprintf("%s/devices/%s", host, deviceID)
+		if _, err := azureSASToken(resourceURI, key, time.Now()); err != nil {
+			return nil, err
+		}
+		// make a new token for every (re)connect so that expired tokens
+		// don't prevent reconnecting
+		opts.SetCredentialsProvider(func() (string, string) {
+			token, _ := azureSASToken(resourceURI, key, time.Now().Add(tokenTTL))
+			return fmt.Sprintf("%s/%s/?api-version=2018-06-30", host, deviceID), token
+		})
+	} else if len(tlsConfig.Certificates) == 0 {
+		return nil, errors.New("either AzureIoTHubSharedAccessKey or AzureIoTHubCertFile and AzureIoTHubKeyFile are required")
+	}
+
+	return connectMQTTSink(lc, opts, fmt.Sprintf("devices/%s/messages/events/", deviceID))
+}
+
+// newAWSIoTCoreSink publishes points to a topic of AWS IoT Core,
+// authenticating with the thing's X.509 certificate
+func newAWSIoTCoreSink(lc logger.LoggingClient, settings map[string]string) (edgexinfluxproxy.Sink, error) {
+	endpoint := settings["AWSIoTCoreEndpoint"]
+	clientID := settings["AWSIoTCoreClientID"]
+	topic := settings["AWSIoTCoreTopic"]
+	if endpoint == "" || clientID == "" || topic == "" {
+		return nil, errors.New("AWSIoTCoreEndpoint, AWSIoTCoreClientID and AWSIoTCoreTopic are required")
+	}
+	certFile := settings["AWSIoTCoreCertFile"]
+	keyFile := settings["AWSIoTCoreKeyFile"]
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("AWSIoTCoreCertFile and AWSIoTCoreKeyFile are required")
+	}
+	tlsConfig, err := loadClientTLSConfig(certFile, keyFile, settings["AWSIoTCoreCAFile"])
+	if err != nil {
+		return nil, err
+	}
+
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(fmt.Sprintf("ssl://%s:8883", endpoint))
+	opts.SetClientID(clientID)
+	opts.SetTLSConfig(tlsConfig)
+
+	return connectMQTTSink(lc, opts, topic)
+}
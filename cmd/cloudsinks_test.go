@@ -0,0 +1,17 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/anonymouse64/edgex-influx-proxy/pkg/testutil"
+)
+
+func TestRegisterCloudSinks(t *testing.T) {
+	r := &testutil.RecordingRegistrar{}
+	registerCloudSinks(r)
+	for _, name := range []string{"azure-iothub", "aws-iotcore"} {
+		if r.Sink(name) == nil {
+			t.Errorf("sink %q wasn't registered", name)
+		}
+	}
+}
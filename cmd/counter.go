@@ -0,0 +1,98 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// correctedFieldSuffix is appended to the field of a counter resource for
+// the field with its corrected cumulative value
+const correctedFieldSuffix = "_corrected"
+
+// counterSeries is the state of one counter resource of one device
+type counterSeries struct {
+	last     float64
+	lastTime time.Time
+	// offset is added to raw values to correct for the resets and rollovers
+	// seen so far
+	offset float64
+}
+
+// counters corrects the readings of cumulative counters, such as kWh or
+// pulse counts, for the counter resetting when its device reboots or rolling
+// over at its maximum, so that the corrected value keeps increasing. The
+// corrections are only kept in memory, so they start over when the proxy
+// restarts.
+type counters struct {
+	resources map[string]bool
+	// max is the value counters roll over at, a decrease from above half of
+	// it is a rollover rather than a reset, 0 treats every decrease as a
+	// reset
+	max float64
+
+	mu     sync.Mutex
+	series map[string]*counterSeries
+}
+
+func newCounters(resources []string, max float64) *counters {
+	c := &counters{
+		resources: make(map[string]bool),
+		max:       max,
+		series:    make(map[string]*counterSeries),
+	}
+	for _, resource := range resources {
+		c.resources[resource] = true
+	}
+	return c
+}
+
+// correct returns the corrected cumulative value of the counter resource at
+// t, and false if the resource isn't a counter or the reading is older than
+// the latest one of the resource
+func (c *counters) correct(device, resource string, value float64, t time.Time) (float64, bool) {
+	if c == nil || !c.resources[resource] {
+		return 0, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := device + "/" + resource
+	ser, ok := c.series[key]
+	if !ok {
+		c.series[key] = &counterSeries{last: value, lastTime: t}
+		return value, true
+	}
+	if t.Before(ser.lastTime) {
+		return 0, false
+	}
+
+	if value < ser.last {
+		if c.max != 0 && ser.last > c.max/2 {
+			// rolled over, counting on from 0 after max
+			ser.offset += c.max
+		} else {
+			// reset, counting again from 0
+			ser.offset += ser.last
+		}
+	}
+	ser.last = value
+	ser.lastTime = t
+	return ser.offset + value, true
+}
+
+// forget drops the corrections of the device's counters
+func (c *counters) forget(device string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key := range c.series {
+		if strings.HasPrefix(key, device+"/") {
+			delete(c.series, key)
+		}
+	}
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/edgexfoundry/app-functions-sdk-go/appcontext"
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+	"github.com/edgexfoundry/go-mod-core-contracts/models"
+)
+
+// csvCheckpointEvery is how many rows are imported between saving the
+// checkpoint
+const csvCheckpointEvery = 1000
+
+// csvImportOptions are the arguments of the import-csv command
+type csvImportOptions struct {
+	paths []string
+	// timeColumn holds the timestamps, parsed with timeFormat in location
+	// if they don't have a zone of their own
+	timeColumn string
+	timeFormat string
+	location   *time.Location
+	// device is the device of every row, unless read from deviceColumn
+	device, deviceColumn string
+	// columns maps the columns to the resources they are readings of, where
+	// every other column is a reading of the resource named by its header
+	// if empty, unless resourceColumn and valueColumn hold one reading a row
+	columns                     map[string]string
+	resourceColumn, valueColumn string
+	delimiter                   rune
+	decimal, thousands          string
+	dryRun                      bool
+	// checkpointPath is the file recording the progress, empty disables it
+	checkpointPath string
+	// sdkArgs are the arguments after "--", which are passed on to the SDK
+	sdkArgs []string
+}
+
+// parseCSVImportArgs parses the arguments following import-csv:
+//
+//	import-csv (-device <name> | -device-column <column>) [options] <path>... [-- <SDK arguments>]
+func parseCSVImportArgs(args []string) (*csvImportOptions, error) {
+	opts := &csvImportOptions{}
+	for i, arg := range args {
+		if arg == "--" {
+			opts.sdkArgs = args[i+1:]
+			args = args[:i]
+			break
+		}
+	}
+
+	var timezone, columns, delimiter string
+	fs := flag.NewFlagSet("import-csv", flag.ContinueOnError)
+	fs.StringVar(&opts.timeColumn, "time-column", "time", "column holding the timestamps")
+	fs.StringVar(&opts.timeFormat, "time-format", "rfc3339", "rfc3339, unix, unix-ms, unix-us, unix-ns or a Go time layout such as \"02.01.2006 15:04:05\"")
+	fs.StringVar(&timezone, "timezone", "UTC", "time zone of timestamps without one, such as Europe/Berlin")
+	fs.StringVar(&opts.device, "device", "", "device of every row")
+	fs.StringVar(&opts.deviceColumn, "device-column", "", "column holding the device of each row")
+	fs.StringVar(&columns, "columns", "", "comma separated column=resource pairs of the columns to import, all other columns by their header by default")
+	fs.StringVar(&opts.resourceColumn, "resource-column", "", "column holding the resource of each row, for one reading a row")
+	fs.StringVar(&opts.valueColumn, "value-column", "", "column holding the value of each row, for one reading a row")
+	fs.StringVar(&delimiter, "delimiter", ",", "field delimiter, such as ; or \\t")
+	fs.StringVar(&opts.decimal, "decimal", ".", "decimal separator of numbers, such as ,")
+	fs.StringVar(&opts.thousands, "thousands", "", "thousands separator of numbers to remove, such as .")
+	fs.BoolVar(&opts.dryRun, "dry-run", false, "print the points instead of writing them to influx")
+	fs.StringVar(&opts.checkpointPath, "checkpoint", "", "file to record the progress in, to resume from it after an interruption")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	opts.paths = fs.Args()
+	if len(opts.paths) == 0 || (opts.device == "") == (opts.deviceColumn == "") {
+		return nil, errors.New("usage: import-csv (-device <name> | -device-column <column>) [options] <path>... [-- <SDK arguments>]")
+	}
+	if (opts.resourceColumn == "") != (opts.valueColumn == "") || (opts.resourceColumn != "" && columns != "") {
+		return nil, errors.New("-resource-column and -value-column must be used together, and not with -columns")
+	}
+	if opts.dryRun && opts.checkpointPath != "" {
+		return nil, errors.New("-checkpoint can't be used with -dry-run")
+	}
+	if opts.decimal == "" || opts.decimal == opts.thousands {
+		return nil, errors.New("-decimal must be set and differ from -thousands")
+	}
+
+	var err error
+	if opts.location, err = time.LoadLocation(timezone); err != nil {
+		return nil, fmt.Errorf("invalid -timezone: %v", err)
+	}
+	if opts.columns, err = parseTags(columns); err != nil {
+		return nil, fmt.Errorf("invalid -columns: %v", err)
+	}
+	if delimiter == `\t` {
+		delimiter = "\t"
+	}
+	if utf8.RuneCountInString(delimiter) != 1 {
+		return nil, errors.New("-delimiter must be a single character")
+	}
+	opts.delimiter, _ = utf8.DecodeRuneInString(delimiter)
+	return opts, nil
+}
+
+// parseTime parses a timestamp in the configured format
+func (o *csvImportOptions) parseTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	units := map[string]time.Duration{"unix": time.Second, "unix-ms": time.Millisecond, "unix-us": time.Microsecond, "unix-ns": time.Nanosecond}
+	if unit, ok := units[o.timeFormat]; ok {
+		// fractional seconds are common in exports
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return time.Unix(0, n*int64(unit)), nil
+		}
+		f, err := strconv.ParseFloat(o.number(s), 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid timestamp %q", s)
+		}
+		return time.Unix(0, int64(f*float64(unit))), nil
+	}
+	layout := o.timeFormat
+	if layout == "rfc3339" {
+		layout = time.RFC3339Nano
+	}
+	return time.ParseInLocation(layout, s, o.location)
+}
+
+// number returns the value with the configured separators replaced by the
+// ones Go parses, such as "1.234,5" as "1234.5"
+func (o *csvImportOptions) number(s string) string {
+	if o.thousands != "" {
+		s = strings.Replace(s, o.thousands, "", -1)
+	}
+	if o.decimal != "." {
+		s = strings.Replace(s, o.decimal, ".", -1)
+	}
+	return s
+}
+
+// value returns the value of a cell as a reading value, where numbers are
+// rewritten with a decimal point and every other value is left for the
+// pipeline to parse
+func (o *csvImportOptions) value(s string) string {
+	s = strings.TrimSpace(s)
+	if n := o.number(s); n != s {
+		if _, err := strconv.ParseFloat(n, 64); err == nil {
+			return n
+		}
+	}
+	return s
+}
+
+// csvRowError is a row that can't be imported, the rows after it can still
+// be
+type csvRowError struct {
+	row int
+	err error
+}
+
+func (e *csvRowError) Error() string {
+	return fmt.Sprintf("row %d: %v", e.row, e.err)
+}
+
+// csvColumn is a column read as readings of a resource
+type csvColumn struct {
+	index    int
+	resource string
+}
+
+// csvEvents turns the rows of a CSV file into events
+type csvEvents struct {
+	opts      *csvImportOptions
+	r         *csv.Reader
+	header    map[string]int
+	resources []csvColumn
+	row       int
+}
+
+func newCSVEvents(opts *csvImportOptions, r io.Reader) (*csvEvents, error) {
+	c := &csvEvents{opts: opts, r: csv.NewReader(r), header: make(map[string]int)}
+	c.r.Comma = opts.delimiter
+	c.r.FieldsPerRecord = -1
+	header, err := c.r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the header: %v", err)
+	}
+	for i, name := range header {
+		c.header[strings.TrimSpace(name)] = i
+	}
+
+	required := []string{opts.timeColumn, opts.deviceColumn, opts.resourceColumn, opts.valueColumn}
+	for column := range opts.columns {
+		required = append(required, column)
+	}
+	for _, column := range required {
+		if _, ok := c.header[column]; column != "" && !ok {
+			return nil, fmt.Errorf("no column %q in the header", column)
+		}
+	}
+	if opts.resourceColumn != "" {
+		return c, nil
+	}
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		switch {
+		case len(opts.columns) != 0:
+			if resource, ok := opts.columns[name]; ok {
+				c.resources = append(c.resources, csvColumn{index: i, resource: resource})
+			}
+		case name != opts.timeColumn && name != opts.deviceColumn:
+			c.resources = append(c.resources, csvColumn{index: i, resource: name})
+		}
+	}
+	return c, nil
+}
+
+// next returns the event of the next row, or io.EOF after the last one
+func (c *csvEvents) next() (models.Event, error) {
+	record, err := c.r.Read()
+	if err == io.EOF {
+		return models.Event{}, err
+	}
+	if parseErr, ok := err.(*csv.ParseError); ok {
+		c.row++
+		return models.Event{}, &csvRowError{row: c.row, err: parseErr.Err}
+	}
+	if err != nil {
+		return models.Event{}, err
+	}
+	c.row++
+	cell := func(column string) string {
+		if i, ok := c.header[column]; ok && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+
+	t, err := c.opts.parseTime(cell(c.opts.timeColumn))
+	if err != nil {
+		return models.Event{}, &csvRowError{row: c.row, err: err}
+	}
+	device := c.opts.device
+	if c.opts.deviceColumn != "" {
+		device = strings.TrimSpace(cell(c.opts.deviceColumn))
+	}
+	event := models.Event{Device: device, Origin: t.UnixNano()}
+	add := func(resource, value string) {
+		if value = c.opts.value(value); value == "" {
+			// missing values are common in wide exports
+			return
+		}
+		event.Readings = append(event.Readings, models.Reading{Device: device, Name: resource, Value: value, Origin: event.Origin})
+	}
+	if c.opts.resourceColumn != "" {
+		add(strings.TrimSpace(cell(c.opts.resourceColumn)), cell(c.opts.valueColumn))
+		return event, nil
+	}
+	for _, column := range c.resources {
+		if column.index < len(record) {
+			add(column.resource, record[column.index])
+		}
+	}
+	return event, nil
+}
+
+// importCSV runs the rows of the CSV files through the pipeline as events,
+// returning how many rows were imported and failed. With a checkpoint, the
+// rows already imported are skipped, and importing stops at the first
+// failure so that resuming retries it.
+func importCSV(lc logger.LoggingClient, pipeline []appcontext.AppFunction, opts *csvImportOptions, cp *checkpoint) (imported, failed int) {
+	for _, path := range opts.paths {
+		if cp.done(path) {
+			lc.Info(fmt.Sprintf("skipping %s, already imported", path))
+			continue
+		}
+		n, f, stopped, err := importCSVFile(lc, pipeline, opts, path, cp)
+		imported += n
+		failed += f
+		if err != nil {
+			lc.Error(fmt.Sprintf("unable to import %s: %s", path, err))
+			failed++
+			if cp != nil {
+				stopped = true
+			}
+		}
+		if stopped {
+			lc.Info(fmt.Sprintf("stopped importing, run the same command again to resume from %s", cp.path))
+			return imported, failed
+		}
+	}
+	return imported, failed
+}
+
+// importCSVFile imports the rows of one file, returning whether it stopped
+// at a failure to resume from
+func importCSVFile(lc logger.LoggingClient, pipeline []appcontext.AppFunction, opts *csvImportOptions, path string, cp *checkpoint) (imported, failed int, stopped bool, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	defer f.Close()
+	events, err := newCSVEvents(opts, f)
+	if err != nil {
+		return 0, 0, false, err
+	}
+
+	skip := cp.offset(path)
+	if skip != 0 {
+		lc.Info(fmt.Sprintf("resuming %s after %d rows", path, skip))
+	}
+	var newest time.Time
+	for {
+		event, err := events.next()
+		if err == io.EOF {
+			break
+		}
+		if _, isRowErr := err.(*csvRowError); err != nil && !isRowErr {
+			return imported, failed, false, err
+		}
+		if events.row <= skip {
+			continue
+		}
+		if err == nil && len(event.Readings) != 0 {
+			_, err = runPipeline(lc, pipeline, fmt.Sprintf("%s:%d", path, events.row), event)
+		}
+		if err != nil {
+			lc.Error(fmt.Sprintf("importing row %d of %s failed: %s", events.row, path, err))
+			failed++
+			if cp != nil {
+				return imported, failed, true, cp.progress(path, events.row-1, newest)
+			}
+			continue
+		}
+		imported++
+		if t := time.Unix(0, event.Origin); t.After(newest) {
+			newest = t
+		}
+		if events.row%csvCheckpointEvery == 0 {
+			if err := cp.progress(path, events.row, newest); err != nil {
+				return imported, failed, false, fmt.Errorf("unable to save the checkpoint: %v", err)
+			}
+		}
+	}
+	return imported, failed, false, cp.complete(path, newest)
+}
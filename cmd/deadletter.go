@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/edgexfoundry/app-functions-sdk-go/appcontext"
+	"github.com/edgexfoundry/go-mod-core-contracts/models"
+)
+
+// deadLetters saves the events the proxy refuses to write, such as those of
+// quarantined devices, in a directory per reason and device, in the same
+// format as captured events so that they can be inspected and replayed with
+// replay-file once the cause is fixed
+type deadLetters struct {
+	dir    string
+	cipher *atRestCipher
+
+	mu sync.Mutex
+	// files save the events of each reason
+	files  map[string]*capturer
+	counts map[string]uint64
+}
+
+func newDeadLetters(dir string, cipher *atRestCipher) *deadLetters {
+	return &deadLetters{
+		dir:    dir,
+		cipher: cipher,
+		files:  make(map[string]*capturer),
+		counts: make(map[string]uint64),
+	}
+}
+
+// add saves the event dead-lettered for the reason
+func (d *deadLetters) add(edgexcontext *appcontext.Context, reason string, event models.Event) {
+	if d == nil || len(event.Readings) == 0 {
+		return
+	}
+	d.mu.Lock()
+	files, ok := d.files[reason]
+	if !ok {
+		files = newCapturer(filepath.Join(d.dir, reason), d.cipher)
+		d.files[reason] = files
+	}
+	d.counts[reason]++
+	d.mu.Unlock()
+
+	payload, err := json.MarshalIndent(event, "", "  ")
+	if err == nil {
+		_, err = files.save(event.Device, payload, time.Now())
+	}
+	if err != nil {
+		edgexcontext.LoggingClient.Warn(fmt.Sprintf("unable to dead-letter event from device %q: %s", event.Device, err))
+	}
+}
+
+func (d *deadLetters) writeMetrics(w io.Writer) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %sdead_lettered_events_total Events saved to the dead-letter directory instead of being written, by reason.\n", metricsPrefix)
+	fmt.Fprintf(w, "# TYPE %sdead_lettered_events_total counter\n", metricsPrefix)
+	reasons := make([]string, 0, len(d.counts))
+	for reason := range d.counts {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		fmt.Fprintf(w, "%sdead_lettered_events_total{reason=%q} %d\n", metricsPrefix, reason, d.counts[reason])
+	}
+}
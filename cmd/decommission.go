@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/edgexfoundry/app-functions-sdk-go/appcontext"
+	"github.com/edgexfoundry/go-mod-core-contracts/models"
+	influx "github.com/influxdata/influxdb1-client/v2"
+)
+
+// tombstoneMeasurement is where a point is written whenever a device is
+// decommissioned or recommissioned
+const tombstoneMeasurement = "proxy_tombstones"
+
+// decommissionedDevice is a device whose events are dropped
+type decommissionedDevice struct {
+	// DropAfter is when the grace period ends and events start being dropped
+	DropAfter time.Time `json:"dropAfter"`
+	purged    bool
+}
+
+// decommissions drops the events of decommissioned devices after a grace
+// period and forgets everything known about them. Decommissioning writes a
+// tombstone to influx, which is also where decommissioned devices are loaded
+// from at start.
+type decommissions struct {
+	client     influx.Client
+	readClient influx.Client
+	ptConfig   influx.BatchPointsConfig
+	// forgetters drop the state kept about a device
+	forgetters []func(device string)
+
+	mu      sync.Mutex
+	devices map[string]*decommissionedDevice
+}
+
+func newDecommissions(client, readClient influx.Client, ptConfig influx.BatchPointsConfig, forgetters ...func(device string)) *decommissions {
+	return &decommissions{
+		client:     client,
+		readClient: readClient,
+		ptConfig:   ptConfig,
+		forgetters: forgetters,
+		devices:    make(map[string]*decommissionedDevice),
+	}
+}
+
+// load restores the devices decommissioned by the latest tombstones
+func (d *decommissions) load() error {
+	rows, err := queryRows(d.readClient, d.ptConfig.Database, fmt.Sprintf(
+		"SELECT last(%s), %s FROM %s GROUP BY %s",
+		quoteIdentifier("decommissioned"), quoteIdentifier("drop_after"),
+		quoteIdentifier(tombstoneMeasurement), quoteIdentifier("device"),
+	))
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, row := range rows {
+		device := row.Tags["device"]
+		for _, values := range row.Values {
+			if len(values) < 3 {
+				continue
+			}
+			if decommissioned, ok := values[1].(bool); !ok || !decommissioned {
+				continue
+			}
+			dropAfter, ok := values[2].(json.Number)
+			if !ok {
+				continue
+			}
+			nsec, err := dropAfter.Int64()
+			if err != nil {
+				continue
+			}
+			d.devices[device] = &decommissionedDevice{DropAfter: time.Unix(0, nsec)}
+		}
+	}
+	return nil
+}
+
+// writeTombstone records the device being decommissioned or recommissioned
+func (d *decommissions) writeTombstone(device string, decommissioned bool, dropAfter time.Time) error {
+	bp, err := influx.NewBatchPoints(d.ptConfig)
+	if err != nil {
+		return err
+	}
+	fields := map[string]interface{}{"decommissioned": decommissioned}
+	if decommissioned {
+		fields["drop_after"] = dropAfter.UnixNano()
+	}
+	pt, err := influx.NewPoint(tombstoneMeasurement, map[string]string{"device": device}, fields, time.Now())
+	if err != nil {
+		return err
+	}
+	bp.AddPoint(pt)
+	return d.client.Write(bp)
+}
+
+// decommission drops the device's events once the grace period is over
+func (d *decommissions) decommission(device string, grace time.Duration) error {
+	dropAfter := time.Now().Add(grace)
+	if err := d.writeTombstone(device, true, dropAfter); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.devices[device] = &decommissionedDevice{DropAfter: dropAfter}
+	return nil
+}
+
+// recommission stops dropping the device's events
+func (d *decommissions) recommission(device string) error {
+	if err := d.writeTombstone(device, false, time.Time{}); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.devices, device)
+	return nil
+}
+
+// drop returns whether the device's events should be dropped, forgetting
+// the device the first time they are
+func (d *decommissions) drop(device string, now time.Time) bool {
+	if d == nil {
+		return false
+	}
+
+	d.mu.Lock()
+	dev, ok := d.devices[device]
+	if !ok || now.Before(dev.DropAfter) {
+		d.mu.Unlock()
+		return false
+	}
+	purge := !dev.purged
+	dev.purged = true
+	d.mu.Unlock()
+
+	if purge {
+		for _, forget := range d.forgetters {
+			forget(device)
+		}
+	}
+	return true
+}
+
+// decommissionFunc drops the events of decommissioned devices
+func decommissionFunc(d *decommissions, drops *dropAccounting) func(edgexcontext *appcontext.Context, params ...interface{}) (bool, interface{}) {
+	return func(edgexcontext *appcontext.Context, params ...interface{}) (bool, interface{}) {
+		if len(params) < 1 {
+			// We didn't receive a result
+			return false, errors.New("no data received")
+		}
+
+		event, ok := params[0].(models.Event)
+		if !ok {
+			// not an event, let the next function decide what to do with it
+			return true, params[0]
+		}
+
+		if d.drop(event.Device, time.Now()) {
+			edgexcontext.LoggingClient.Debug(fmt.Sprintf("dropping event from decommissioned device %q", event.Device))
+			drops.add(dropDecommissioned, event.Device, len(event.Readings))
+			return false, nil
+		}
+
+		return true, event
+	}
+}
+
+// decommissionHandler serves /admin/decommission:
+//
+//	GET lists the decommissioned devices
+//	POST ?device=X&grace=1h decommissions the device after the grace period
+//	DELETE ?device=X recommissions the device
+func (d *decommissions) decommissionHandler(w http.ResponseWriter, r *http.Request) {
+	device := r.URL.Query().Get("device")
+
+	var err error
+	switch r.Method {
+	case http.MethodPost:
+		if device == "" {
+			writeProblem(w, r, "device is required", http.StatusBadRequest)
+			return
+		}
+		grace, graceErr := durationSetting(map[string]string{"grace": r.URL.Query().Get("grace")}, "grace", 0)
+		if graceErr != nil {
+			writeProblem(w, r, "grace must be a non-negative duration", http.StatusBadRequest)
+			return
+		}
+		err = d.decommission(device, grace)
+	case http.MethodDelete:
+		if device == "" {
+			writeProblem(w, r, "device is required", http.StatusBadRequest)
+			return
+		}
+		err = d.recommission(device)
+	}
+	if err != nil {
+		writeProblem(w, r, fmt.Sprintf("unable to write tombstone: %s", classifyWriteError(err).message), http.StatusServiceUnavailable)
+		return
+	}
+
+	d.mu.Lock()
+	devices := make(map[string]decommissionedDevice, len(d.devices))
+	for device, dev := range d.devices {
+		devices[device] = *dev
+	}
+	d.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(devices); err != nil {
+		writeProblem(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// zScoreDetector keeps an exponentially weighted moving mean and variance of
+// every numeric series and flags values that are too many standard deviations
+// away from the mean
+type zScoreDetector struct {
+	// threshold is the z-score above which a value is anomalous
+	threshold float64
+	// alpha is the EWMA smoothing factor, larger values forget faster
+	alpha float64
+	// minSamples is how many values a series needs before values are judged
+	minSamples uint64
+	// maxRecent is how many detections are kept for the /anomalies endpoint
+	maxRecent int
+
+	mu     sync.Mutex
+	series map[string]*seriesStats
+	recent []detection
+}
+
+type seriesStats struct {
+	count    uint64
+	mean     float64
+	variance float64
+}
+
+// detection is an anomalous value as reported by the /anomalies endpoint
+type detection struct {
+	Device   string    `json:"device"`
+	Resource string    `json:"resource"`
+	Value    float64   `json:"value"`
+	Mean     float64   `json:"mean"`
+	StdDev   float64   `json:"stddev"`
+	ZScore   float64   `json:"zscore"`
+	Time     time.Time `json:"time"`
+}
+
+func newZScoreDetector(threshold, alpha float64, minSamples uint64, maxRecent int) *zScoreDetector {
+	return &zScoreDetector{
+		threshold:  threshold,
+		alpha:      alpha,
+		minSamples: minSamples,
+		maxRecent:  maxRecent,
+		series:     make(map[string]*seriesStats),
+	}
+}
+
+// observe adds the value to the statistics of its series and returns whether
+// it is anomalous compared to the values seen before it
+func (d *zScoreDetector) observe(device, resource string, value float64, t time.Time) bool {
+	if d == nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key := device + "/" + resource
+	s, ok := d.series[key]
+	if !ok {
+		d.series[key] = &seriesStats{count: 1, mean: value}
+		return false
+	}
+
+	anomalous := false
+	stddev := math.Sqrt(s.variance)
+	if s.count >= d.minSamples && stddev > 0 {
+		z := math.Abs(value-s.mean) / stddev
+		if z > d.threshold {
+			anomalous = true
+			d.recent = append(d.recent, detection{
+				Device:   device,
+				Resource: resource,
+				Value:    value,
+				Mean:     s.mean,
+				StdDev:   stddev,
+				ZScore:   z,
+				Time:     t,
+			})
+			if len(d.recent) > d.maxRecent {
+				d.recent = d.recent[len(d.recent)-d.maxRecent:]
+			}
+		}
+	}
+
+	// update the exponentially weighted mean and variance
+	diff := value - s.mean
+	incr := d.alpha * diff
+	s.mean += incr
+	s.variance = (1 - d.alpha) * (s.variance + diff*incr)
+	s.count++
+
+	return anomalous
+}
+
+// recentDetections returns a copy of the most recent detections, oldest first
+func (d *zScoreDetector) recentDetections() []detection {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return append([]detection{}, d.recent...)
+}
+
+// anomaliesHandler serves the recent detections as JSON
+func (d *zScoreDetector) anomaliesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(d.recentDetections()); err != nil {
+		writeProblem(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// forget drops the statistics of the device's series
+func (d *zScoreDetector) forget(device string) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for key := range d.series {
+		if strings.HasPrefix(key, device+"/") {
+			delete(d.series, key)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strings"
+	"time"
+)
+
+// diagnosticSection is one part of a diagnostic dump
+type diagnosticSection struct {
+	name  string
+	write func(w io.Writer) error
+}
+
+// diagnostics writes snapshots of the state of the service to files, for
+// debugging in the field where a debugger can't be attached
+type diagnostics struct {
+	dir      string
+	sections []diagnosticSection
+}
+
+// add adds a section to the dumps
+func (d *diagnostics) add(name string, write func(w io.Writer) error) {
+	d.sections = append(d.sections, diagnosticSection{name: name, write: write})
+}
+
+// addJSON adds a section with the value returned by get encoded as JSON
+func (d *diagnostics) addJSON(name string, get func() interface{}) {
+	d.add(name, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(get())
+	})
+}
+
+// dump writes a snapshot to a new file in the directory, returning its name
+func (d *diagnostics) dump(now time.Time) (string, error) {
+	if err := os.MkdirAll(d.dir, 0700); err != nil {
+		return "", err
+	}
+	name := filepath.Join(d.dir, "diagnostics-"+now.UTC().Format("20060102T150405Z")+".txt")
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	fmt.Fprintf(f, "diagnostics taken at %s\n", now.UTC().Format(time.RFC3339))
+	for _, section := range d.sections {
+		fmt.Fprintf(f, "\n=== %s ===\n", section.name)
+		if err := section.write(f); err != nil {
+			fmt.Fprintf(f, "error: %s\n", err)
+		}
+	}
+	return name, f.Close()
+}
+
+// redactedSettings returns the settings with the values of secrets hidden
+func redactedSettings(appSettings map[string]string) func(w io.Writer) error {
+	return func(w io.Writer) error {
+		keys := make([]string, 0, len(appSettings))
+		for k := range appSettings {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			v := appSettings[k]
+			lower := strings.ToLower(k)
+			if v != "" && (strings.Contains(lower, "password") || strings.Contains(lower, "secret") || strings.Contains(lower, "token")) {
+				v = "<redacted>"
+			}
+			if _, err := fmt.Fprintf(w, "%s = %q\n", k, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// writeMemStats writes the runtime memory statistics
+func writeMemStats(w io.Writer) error {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(stats)
+}
+
+// writeGoroutines writes the stacks of all goroutines
+func writeGoroutines(w io.Writer) error {
+	return pprof.Lookup("goroutine").WriteTo(w, 2)
+}
@@ -0,0 +1,30 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+)
+
+// dumpOnSignal writes a diagnostic dump whenever SIGUSR1 is received
+func dumpOnSignal(lc logger.LoggingClient, d *diagnostics) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR1)
+	go func() {
+		for range c {
+			name, err := d.dump(time.Now())
+			if err != nil {
+				lc.Error(fmt.Sprintf("unable to write diagnostics: %s", err))
+				continue
+			}
+			lc.Info(fmt.Sprintf("wrote diagnostics to %s", name))
+		}
+	}()
+}
@@ -0,0 +1,10 @@
+package main
+
+import (
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+)
+
+// dumpOnSignal does nothing as there is no SIGUSR1 on windows
+func dumpOnSignal(lc logger.LoggingClient, d *diagnostics) {
+	lc.Warn("diagnostic dumps on SIGUSR1 are not supported on windows")
+}
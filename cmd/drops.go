@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// reasons readings are dropped for instead of being written
+const (
+	// dropStale is an event older than MaxEventAge
+	dropStale = "stale"
+	// dropDecommissioned is an event of a decommissioned device
+	dropDecommissioned = "decommissioned"
+	// dropQuarantined is an event of a device quarantined by the circuit
+	// breaker
+	dropQuarantined = "quarantined"
+	// dropQuota is an event over the quota of its device or tenant
+	dropQuota = "quota"
+	// dropAnomaly is a reading dropped by the anomaly policy
+	dropAnomaly = "anomaly"
+	// dropOutOfOrder is a reading older than the one already written for
+	// its series at the same timestamp
+	dropOutOfOrder = "out-of-order"
+	// dropRejected is an event InfluxDB permanently rejected
+	dropRejected = "rejected"
+	// dropChattering is a reading repeating the last value of its series
+	// within the chatter window
+	dropChattering = "chattering"
+	// dropQuality is a reading of a quality excluded by QualityExclude
+	dropQuality = "quality"
+)
+
+// dropReasons are the reasons for dropping readings
+var dropReasons = []string{dropStale, dropDecommissioned, dropQuarantined, dropQuota, dropAnomaly, dropOutOfOrder, dropRejected, dropChattering, dropQuality}
+
+// dropCounts are the readings dropped for one reason
+type dropCounts struct {
+	Total   uint64            `json:"total"`
+	Devices map[string]uint64 `json:"devices"`
+}
+
+// dropAccounting counts the readings every feature that can drop data
+// dropped, by reason and device, so that investigating missing data starts
+// in one place: the dropped_readings_total metric by reason, and
+// /stats/drops by reason and device.
+type dropAccounting struct {
+	since time.Time
+
+	mu      sync.Mutex
+	reasons map[string]*dropCounts
+}
+
+func newDropAccounting() *dropAccounting {
+	d := &dropAccounting{
+		since:   time.Now(),
+		reasons: make(map[string]*dropCounts),
+	}
+	for _, reason := range dropReasons {
+		d.reasons[reason] = &dropCounts{Devices: make(map[string]uint64)}
+	}
+	return d
+}
+
+// add counts n readings of the device dropped for the reason
+func (d *dropAccounting) add(reason, device string, n int) {
+	if d == nil || n <= 0 {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	counts := d.reasons[reason]
+	counts.Total += uint64(n)
+	counts.Devices[device] += uint64(n)
+}
+
+func (d *dropAccounting) writeMetrics(w io.Writer) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %sdropped_readings_total Readings dropped instead of being written, by reason.\n", metricsPrefix)
+	fmt.Fprintf(w, "# TYPE %sdropped_readings_total counter\n", metricsPrefix)
+	reasons := append([]string(nil), dropReasons...)
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		fmt.Fprintf(w, "%sdropped_readings_total{reason=%q} %d\n", metricsPrefix, reason, d.reasons[reason].Total)
+	}
+}
+
+// dropsHandler serves /stats/drops, the readings dropped since the start by
+// reason and device
+func (d *dropAccounting) dropsHandler(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	reasons := make(map[string]dropCounts, len(d.reasons))
+	for reason, counts := range d.reasons {
+		devices := make(map[string]uint64, len(counts.Devices))
+		for device, n := range counts.Devices {
+			devices[device] = n
+		}
+		reasons[reason] = dropCounts{Total: counts.Total, Devices: devices}
+	}
+	d.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(struct {
+		Since   time.Time             `json:"since"`
+		Reasons map[string]dropCounts `json:"reasons"`
+	}{d.since, reasons})
+	if err != nil {
+		writeProblem(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// exit codes of the service and its commands by the class of the failure,
+// which are documented in the README so that automation can branch on them
+const (
+	// exitFailure is a failure that fits none of the other classes
+	exitFailure = 1
+	// exitUsage is invalid command line arguments
+	exitUsage = 2
+	// exitConfig is a missing or invalid configuration setting
+	exitConfig = 3
+	// exitUnavailable is a dependency that can't be reached, such as
+	// InfluxDB, the secret store, an MQTT broker or a running instance
+	exitUnavailable = 4
+	// exitAuth is credentials rejected by a dependency or a running instance
+	exitAuth = 5
+	// exitPartial is an import, replay or remap that wrote some of the data
+	// but failed for the rest
+	exitPartial = 6
+	// exitMismatch is admin verify-delivery finding audited windows that
+	// don't match the points in InfluxDB
+	exitMismatch = 7
+)
+
+// statusError is an HTTP error response from a dependency or a running
+// instance
+type statusError struct {
+	status int
+	msg    string
+}
+
+func (e *statusError) Error() string {
+	return e.msg
+}
+
+// exitCode returns the exit code of a command failing with err, which is
+// exitAuth if credentials were rejected or exitUnavailable if a dependency
+// couldn't be reached, and otherwise def
+func exitCode(err error, def int) int {
+	var se *statusError
+	if errors.As(err, &se) {
+		switch {
+		case se.status == http.StatusUnauthorized || se.status == http.StatusForbidden:
+			return exitAuth
+		case se.status == http.StatusServiceUnavailable || se.status == http.StatusBadGateway || se.status == http.StatusGatewayTimeout:
+			return exitUnavailable
+		}
+		return def
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return exitUnavailable
+	}
+	// the InfluxDB client returns the body of error responses as the error
+	if msg := classifyWriteError(err).message; strings.Contains(msg, "authorization failed") || strings.Contains(msg, "authentication credentials") {
+		return exitAuth
+	}
+	return def
+}
+
+// partialExitCode returns the exit code of an import, replay or remap that
+// wrote some of the data, failing for the rest with err, which takes
+// precedence if it is of another class
+func partialExitCode(written int, err error) int {
+	def := exitFailure
+	if written != 0 {
+		def = exitPartial
+	}
+	if err == nil {
+		return def
+	}
+	return exitCode(err, def)
+}
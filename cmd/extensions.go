@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	edgexinfluxproxy "github.com/anonymouse64/edgex-influx-proxy"
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+	influx "github.com/influxdata/influxdb1-client/v2"
+)
+
+// splitList splits a comma separated setting into its non-empty items
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// fanoutClient is an InfluxDB client that also hands every batch it
+// successfully writes to the registered sinks enabled in the configuration
+type fanoutClient struct {
+	influx.Client
+	lc    logger.LoggingClient
+	sinks map[string]edgexinfluxproxy.Sink
+}
+
+func (c *fanoutClient) Write(bp influx.BatchPoints) error {
+	if err := c.Client.Write(bp); err != nil {
+		return err
+	}
+	for name, sink := range c.sinks {
+		if err := sink.Write(bp.Points()); err != nil {
+			c.lc.Error(fmt.Sprintf("error writing points to sink %q: %s", name, err))
+		}
+	}
+	return nil
+}
+
+// clientSink is the sink handed to registered sources, which writes their
+// points to InfluxDB
+type clientSink struct {
+	// name is the name of the source the sink was handed to
+	name     string
+	client   influx.Client
+	ptConfig influx.BatchPointsConfig
+	controls *ingestionControls
+	lease    *leaderLease
+	// sourceTag is the tag recording the name of the source, empty doesn't
+	// record it
+	sourceTag string
+}
+
+func (s *clientSink) Write(points []*influx.Point) error {
+	if s.controls.isPaused(s.name) {
+		return errSourcePaused
+	}
+	if !s.lease.isLeader() {
+		return errStandby
+	}
+	bp, err := influx.NewBatchPoints(s.ptConfig)
+	if err != nil {
+		return err
+	}
+	for _, pt := range points {
+		if s.sourceTag != "" {
+			tags := pt.Tags()
+			if _, ok := tags[s.sourceTag]; !ok {
+				tags[s.sourceTag] = s.name
+				fields, err := pt.Fields()
+				if err != nil {
+					return err
+				}
+				if pt, err = influx.NewPoint(pt.Name(), tags, fields, pt.Time()); err != nil {
+					return err
+				}
+			}
+		}
+		bp.AddPoint(pt)
+	}
+	return s.client.Write(bp)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/edgexfoundry/app-functions-sdk-go/appcontext"
+	influx "github.com/influxdata/influxdb1-client/v2"
+)
+
+// errInjectedFault is returned by writes failed on purpose
+var errInjectedFault = errors.New("write failed by fault injection")
+
+// faultSettings are the faults being injected, as served by /admin/faults
+type faultSettings struct {
+	// WriteFailurePercent is the percentage of writes to InfluxDB that fail
+	WriteFailurePercent float64 `json:"writeFailurePercent"`
+	// WriteDelay delays every write to InfluxDB
+	WriteDelay string `json:"writeDelay"`
+	// EventDelay delays every EdgeX event before it enters the pipeline
+	EventDelay string `json:"eventDelay"`
+}
+
+// faultInjector is an InfluxDB client that fails and delays writes on
+// demand, to check that retries, store-and-forward and alerting behave as
+// designed when InfluxDB or the message bus misbehave
+type faultInjector struct {
+	influx.Client
+
+	mu                  sync.Mutex
+	writeFailurePercent float64
+	writeDelay          time.Duration
+	eventDelay          time.Duration
+}
+
+func newFaultInjector(client influx.Client) *faultInjector {
+	return &faultInjector{Client: client}
+}
+
+func (f *faultInjector) Write(bp influx.BatchPoints) error {
+	f.mu.Lock()
+	failurePercent, delay := f.writeFailurePercent, f.writeDelay
+	f.mu.Unlock()
+
+	time.Sleep(delay)
+	if failurePercent > 0 && rand.Float64()*100 < failurePercent {
+		return errInjectedFault
+	}
+	return f.Client.Write(bp)
+}
+
+// delayEvent sleeps for the event delay
+func (f *faultInjector) delayEvent() {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	delay := f.eventDelay
+	f.mu.Unlock()
+
+	time.Sleep(delay)
+}
+
+func (f *faultInjector) settings() faultSettings {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return faultSettings{
+		WriteFailurePercent: f.writeFailurePercent,
+		WriteDelay:          f.writeDelay.String(),
+		EventDelay:          f.eventDelay.String(),
+	}
+}
+
+// faultFunc delays EdgeX events as if the message bus was slow
+func faultFunc(f *faultInjector) func(edgexcontext *appcontext.Context, params ...interface{}) (bool, interface{}) {
+	return func(edgexcontext *appcontext.Context, params ...interface{}) (bool, interface{}) {
+		if len(params) < 1 {
+			// We didn't receive a result
+			return false, errors.New("no data received")
+		}
+
+		f.delayEvent()
+		return true, params[0]
+	}
+}
+
+// faultsHandler serves /admin/faults:
+//
+//	GET shows the faults being injected
+//	POST ?writeFailurePercent=10&writeDelay=1s&eventDelay=0 changes the given
+//	faults, where 0 stops injecting them
+//	DELETE stops injecting all faults
+func (f *faultInjector) faultsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		query := r.URL.Query()
+		failurePercent := -1.0
+		if s := query.Get("writeFailurePercent"); s != "" {
+			var err error
+			failurePercent, err = strconv.ParseFloat(s, 64)
+			if err != nil || failurePercent < 0 || failurePercent > 100 {
+				writeProblem(w, r, "writeFailurePercent must be between 0 and 100", http.StatusBadRequest)
+				return
+			}
+		}
+		writeDelay, err := durationSetting(map[string]string{"writeDelay": query.Get("writeDelay")}, "writeDelay", -1)
+		if err != nil {
+			writeProblem(w, r, "writeDelay must be a non-negative duration", http.StatusBadRequest)
+			return
+		}
+		eventDelay, err := durationSetting(map[string]string{"eventDelay": query.Get("eventDelay")}, "eventDelay", -1)
+		if err != nil {
+			writeProblem(w, r, "eventDelay must be a non-negative duration", http.StatusBadRequest)
+			return
+		}
+
+		f.mu.Lock()
+		if failurePercent >= 0 {
+			f.writeFailurePercent = failurePercent
+		}
+		if writeDelay >= 0 {
+			f.writeDelay = writeDelay
+		}
+		if eventDelay >= 0 {
+			f.eventDelay = eventDelay
+		}
+		f.mu.Unlock()
+	case http.MethodDelete:
+		f.mu.Lock()
+		f.writeFailurePercent, f.writeDelay, f.eventDelay = 0, 0, 0
+		f.mu.Unlock()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(f.settings()); err != nil {
+		writeProblem(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
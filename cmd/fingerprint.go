@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+
+	influx "github.com/influxdata/influxdb1-client/v2"
+)
+
+// configMeasurement is where a point describing the configuration is written
+// at every start
+const configMeasurement = "proxy_config"
+
+// configFingerprint returns a hash of the application settings, which changes
+// whenever any setting does without revealing their values
+func configFingerprint(appSettings map[string]string) string {
+	keys := make([]string, 0, len(appSettings))
+	for k := range appSettings {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	for _, k := range keys {
+		fmt.Fprintf(h, "%s=%s\n", k, appSettings[k])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// lastConfigFingerprint queries the fingerprint written at the previous start,
+// returning "" if there is none
+func lastConfigFingerprint(client influx.Client, database, serviceKey string) (string, error) {
+	q := influx.NewQuery(
+		fmt.Sprintf("SELECT last(%s) FROM %s WHERE %s = %s",
+			quoteIdentifier("fingerprint"), quoteIdentifier(configMeasurement), quoteIdentifier("service"), quoteLiteral(serviceKey)),
+		database,
+		"",
+	)
+	resp, err := client.Query(q)
+	if err != nil {
+		return "", err
+	}
+	if resp.Error() != nil {
+		return "", resp.Error()
+	}
+	for _, result := range resp.Results {
+		for _, row := range result.Series {
+			for _, values := range row.Values {
+				if len(values) >= 2 {
+					if fp, ok := values[1].(string); ok {
+						return fp, nil
+					}
+				}
+			}
+		}
+	}
+	return "", nil
+}
+
+// writeConfigFingerprint writes a point with the fingerprint of the current
+// configuration and the version of the proxy, and whether the fingerprint
+// differs from the one written at the previous start
+func writeConfigFingerprint(client, readClient influx.Client, ptConfig influx.BatchPointsConfig, serviceKey, version, fingerprint string) (changed bool, err error) {
+	last, err := lastConfigFingerprint(readClient, ptConfig.Database, serviceKey)
+	if err != nil {
+		return false, fmt.Errorf("unable to query previous configuration fingerprint: %v", err)
+	}
+	changed = last != "" && last != fingerprint
+
+	bp, err := influx.NewBatchPoints(ptConfig)
+	if err != nil {
+		return false, err
+	}
+	pt, err := influx.NewPoint(
+		configMeasurement,
+		map[string]string{"service": serviceKey},
+		map[string]interface{}{
+			"fingerprint": fingerprint,
+			"version":     version,
+			"changed":     changed,
+		},
+		time.Now(),
+	)
+	if err != nil {
+		return false, err
+	}
+	bp.AddPoint(pt)
+	return changed, client.Write(bp)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/edgexfoundry/go-mod-core-contracts/models"
+)
+
+// fixtureReadings are representative readings of every value encoding the
+// proxy parses, keyed by the name of the fixture they are written to
+func fixtureReadings() map[string]models.Reading {
+	float32Bytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(float32Bytes, math.Float32bits(21.5))
+	float64Bytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(float64Bytes, math.Float64bits(-1234.5678))
+	// 21.5 as a half precision float
+	float16Bytes := []byte{0x4d, 0x60}
+
+	return map[string]models.Reading{
+		"bool":              {Name: "Switch", Value: "true", ValueType: "Bool"},
+		"int8":              {Name: "Int8", Value: "-12", ValueType: "Int8"},
+		"int64":             {Name: "Int64", Value: "-9007199254740993", ValueType: "Int64"},
+		"uint64":            {Name: "Uint64", Value: "18446744073709551615", ValueType: "Uint64"},
+		"float32-enotation": {Name: "Float32", Value: "2.150000e+01", ValueType: "Float32", FloatEncoding: "eNotation"},
+		"float32-base64":    {Name: "Float32", Value: base64.StdEncoding.EncodeToString(float32Bytes), ValueType: "Float32", FloatEncoding: "Base64"},
+		"float64-base64":    {Name: "Float64", Value: base64.StdEncoding.EncodeToString(float64Bytes), ValueType: "Float64", FloatEncoding: "Base64"},
+		"float16-base64":    {Name: "Float16", Value: base64.StdEncoding.EncodeToString(float16Bytes), ValueType: "Float32", FloatEncoding: "Base64"},
+		"string":            {Name: "Message", Value: "hello world", ValueType: "String"},
+		"binary":            {Name: "Image", BinaryValue: []byte{0x89, 'P', 'N', 'G'}, ValueType: "Binary", MediaType: "image/png"},
+		"untyped-int":       {Name: "Untyped", Value: "42"},
+		"untyped-float":     {Name: "Untyped", Value: "4.2"},
+		"untyped-bool":      {Name: "Untyped", Value: "false"},
+	}
+}
+
+// genFixtures writes an event for every fixture reading to its own file in
+// dir, in the form /admin/capture saves them and replay-file reads them:
+//
+//	gen-fixtures [-device <name>] <dir>
+func genFixtures(args []string) error {
+	fs := flag.NewFlagSet("gen-fixtures", flag.ContinueOnError)
+	device := fs.String("device", "Fixture-Device", "device the events are from")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: gen-fixtures [-device <name>] <dir>")
+	}
+	dir := fs.Arg(0)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	origin := time.Now().UnixNano()
+	for name, reading := range fixtureReadings() {
+		reading.Device = *device
+		reading.Origin = origin
+		event := models.Event{
+			Device:   *device,
+			Origin:   origin,
+			Readings: []models.Reading{reading},
+		}
+		data, err := json.MarshalIndent(event, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name+".json"), data, 0644); err != nil {
+			return fmt.Errorf("unable to write fixture %s: %v", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	influx "github.com/influxdata/influxdb1-client/v2"
+)
+
+const (
+	// maxForecastPoints limits how many predictions a single request can ask
+	// for, regardless of the horizon
+	maxForecastPoints = 1000
+
+	defaultForecastHistory = 6 * time.Hour
+	defaultForecastHorizon = time.Hour
+	defaultForecastAlpha   = 0.5
+	defaultForecastBeta    = 0.1
+)
+
+var errNotEnoughSamples = errors.New("not enough numeric samples to forecast")
+
+// forecaster predicts future values of a series from its recent history in
+// InfluxDB
+type forecaster struct {
+	client   influx.Client
+	database string
+	layout   measurementLayout
+}
+
+// sample is a single value of a series
+type sample struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// forecastResponse is what the forecast endpoint returns
+type forecastResponse struct {
+	Device   string   `json:"device"`
+	Resource string   `json:"resource"`
+	Interval string   `json:"interval"`
+	Samples  int      `json:"samples"`
+	Forecast []sample `json:"forecast"`
+}
+
+// quoteIdentifier quotes an InfluxQL identifier such as a measurement or field
+// name
+func quoteIdentifier(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	return `"` + s + `"`
+}
+
+// history queries the numeric values of the resource of the device since the
+// given duration ago, oldest first
+func (f *forecaster) history(device, resource string, since time.Duration) ([]sample, error) {
+	field, measurement, condition := f.layout.query(device, resource)
+	where := fmt.Sprintf("time > now() - %ds", int64(since/time.Second))
+	if condition != "" {
+		where = condition + " AND " + where
+	}
+	q := influx.NewQuery(
+		fmt.Sprintf("SELECT %s FROM %s WHERE %s", field, measurement, where),
+		f.database,
+		"ns",
+	)
+	resp, err := f.client.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Error() != nil {
+		return nil, resp.Error()
+	}
+
+	var samples []sample
+	for _, result := range resp.Results {
+		for _, row := range result.Series {
+			for _, values := range row.Values {
+				if len(values) < 2 {
+					continue
+				}
+				ts, ok := values[0].(json.Number)
+				if !ok {
+					continue
+				}
+				nsec, err := ts.Int64()
+				if err != nil {
+					continue
+				}
+				// skip non-numeric values
+				num, ok := values[1].(json.Number)
+				if !ok {
+					continue
+				}
+				val, err := num.Float64()
+				if err != nil {
+					continue
+				}
+				samples = append(samples, sample{Time: time.Unix(0, nsec).UTC(), Value: val})
+			}
+		}
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].Time.Before(samples[j].Time) })
+	return samples, nil
+}
+
+// medianInterval returns the median time between consecutive samples
+func medianInterval(samples []sample) time.Duration {
+	intervals := make([]time.Duration, 0, len(samples)-1)
+	for i := 1; i < len(samples); i++ {
+		intervals = append(intervals, samples[i].Time.Sub(samples[i-1].Time))
+	}
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i] < intervals[j] })
+	return intervals[len(intervals)/2]
+}
+
+// holtForecast fits Holt's linear exponential smoothing to the samples, which
+// are assumed to be roughly evenly spaced, and predicts the next n values
+func holtForecast(samples []sample, alpha, beta float64, n int) []float64 {
+	level := samples[0].Value
+	trend := samples[1].Value - samples[0].Value
+	for _, s := range samples[1:] {
+		prevLevel := level
+		level = alpha*s.Value + (1-alpha)*(level+trend)
+		trend = beta*(level-prevLevel) + (1-beta)*trend
+	}
+
+	predictions := make([]float64, n)
+	for i := range predictions {
+		predictions[i] = level + float64(i+1)*trend
+	}
+	return predictions
+}
+
+// forecast predicts the values of the resource of the device over the horizon
+func (f *forecaster) forecast(device, resource string, history, horizon time.Duration, alpha, beta float64) (*forecastResponse, error) {
+	samples, err := f.history(device, resource, history)
+	if err != nil {
+		return nil, err
+	}
+	if len(samples) < 2 {
+		return nil, errNotEnoughSamples
+	}
+
+	interval := medianInterval(samples)
+	if interval <= 0 {
+		return nil, errNotEnoughSamples
+	}
+	n := int(horizon / interval)
+	if n < 1 {
+		n = 1
+	}
+	if n > maxForecastPoints {
+		n = maxForecastPoints
+	}
+
+	last := samples[len(samples)-1].Time
+	resp := &forecastResponse{
+		Device:   device,
+		Resource: resource,
+		Interval: interval.String(),
+		Samples:  len(samples),
+	}
+	for i, val := range holtForecast(samples, alpha, beta, n) {
+		resp.Forecast = append(resp.Forecast, sample{
+			Time:  last.Add(time.Duration(i+1) * interval),
+			Value: val,
+		})
+	}
+	return resp, nil
+}
+
+// forecastHandler serves /api/v1/forecast?device=X&resource=Y&horizon=1h, with
+// optional history, alpha and beta query parameters
+func (f *forecaster) forecastHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	device := query.Get("device")
+	resource := query.Get("resource")
+	if device == "" || resource == "" {
+		writeProblem(w, r, "device and resource are required", http.StatusBadRequest)
+		return
+	}
+
+	params := map[string]string{}
+	for _, name := range []string{"history", "horizon", "alpha", "beta"} {
+		params[name] = query.Get(name)
+	}
+	history, err := durationSetting(params, "history", defaultForecastHistory)
+	if err != nil {
+		writeProblem(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	horizon, err := durationSetting(params, "horizon", defaultForecastHorizon)
+	if err != nil {
+		writeProblem(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	alpha, err := floatSetting(params, "alpha", defaultForecastAlpha)
+	if err != nil || alpha > 1 {
+		writeProblem(w, r, "alpha must be between 0 and 1", http.StatusBadRequest)
+		return
+	}
+	beta, err := floatSetting(params, "beta", defaultForecastBeta)
+	if err != nil || beta > 1 {
+		writeProblem(w, r, "beta must be between 0 and 1", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := f.forecast(device, resource, history, horizon, alpha, beta)
+	if err == errNotEnoughSamples {
+		writeProblem(w, r, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		writeProblem(w, r, fmt.Sprintf("error querying influx: %s", err), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		writeProblem(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/anonymouse64/edgex-influx-proxy/pkg/testutil"
+	influxmodels "github.com/influxdata/influxdb1-client/models"
+	influx "github.com/influxdata/influxdb1-client/v2"
+)
+
+// queryClient answers every query with the samples, recording the queries
+type queryClient struct {
+	*testutil.RecordingClient
+	samples []sample
+	queries []string
+}
+
+func (c *queryClient) Query(q influx.Query) (*influx.Response, error) {
+	c.queries = append(c.queries, q.Command)
+	row := influxmodels.Row{Columns: []string{"time", "value"}}
+	for _, s := range c.samples {
+		row.Values = append(row.Values, []interface{}{json.Number(strconv.FormatInt(s.Time.UnixNano(), 10)), json.Number(strconv.FormatFloat(s.Value, 'f', -1, 64))})
+	}
+	return &influx.Response{Results: []influx.Result{{Series: []influxmodels.Row{row}}}}, nil
+}
+
+// series returns samples of value(i) every interval from start
+func series(start time.Time, interval time.Duration, n int, value func(i int) float64) []sample {
+	samples := make([]sample, n)
+	for i := range samples {
+		samples[i] = sample{Time: start.Add(time.Duration(i) * interval), Value: value(i)}
+	}
+	return samples
+}
+
+func TestHoltForecast(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	for _, tc := range []struct {
+		name    string
+		samples []sample
+		want    []float64
+	}{
+		{"constant", series(start, time.Minute, 10, func(int) float64 { return 21 }), []float64{21, 21, 21}},
+		{"linear", series(start, time.Minute, 10, func(i int) float64 { return 10 + 2*float64(i) }), []float64{30, 32, 34}},
+		{"two samples", series(start, time.Minute, 2, func(i int) float64 { return float64(i) }), []float64{2, 3, 4}},
+	} {
+		got := holtForecast(tc.samples, defaultForecastAlpha, defaultForecastBeta, len(tc.want))
+		for i := range tc.want {
+			if math.Abs(got[i]-tc.want[i]) > 1e-9 {
+				t.Errorf("%s: predicted %v, want %v", tc.name, got, tc.want)
+				break
+			}
+		}
+	}
+}
+
+func TestMedianInterval(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	at := func(offsets ...time.Duration) []sample {
+		var samples []sample
+		for _, offset := range offsets {
+			samples = append(samples, sample{Time: start.Add(offset)})
+		}
+		return samples
+	}
+	for _, tc := range []struct {
+		name    string
+		samples []sample
+		want    time.Duration
+	}{
+		{"regular", at(0, time.Minute, 2*time.Minute, 3*time.Minute), time.Minute},
+		{"with a gap", at(0, time.Minute, 2*time.Minute, time.Hour, time.Hour+time.Minute), time.Minute},
+		{"with bursts", at(0, time.Second, 2*time.Second, 10*time.Second, 20*time.Second, 30*time.Second, 40*time.Second), 10 * time.Second},
+		{"two samples", at(0, 5*time.Second), 5 * time.Second},
+		{"duplicate timestamps", at(0, 0, 0, time.Second), 0},
+	} {
+		if got := medianInterval(tc.samples); got != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestForecast(t *testing.T) {
+	start := time.Unix(1600000000, 0).UTC()
+	client := &queryClient{
+		RecordingClient: &testutil.RecordingClient{},
+		samples:         series(start, time.Minute, 30, func(i int) float64 { return float64(i) }),
+	}
+	f := &forecaster{client: client, database: "edgex", layout: perDeviceLayout}
+
+	resp, err := f.forecast("Sensor-1", "Temperature", time.Hour, 5*time.Minute, defaultForecastAlpha, defaultForecastBeta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Interval != "1m0s" || resp.Samples != 30 || len(resp.Forecast) != 5 {
+		t.Fatalf("got %+v", resp)
+	}
+	if want := `SELECT "Temperature" FROM "Sensor-1" WHERE time > now() - 3600s`; client.queries[0] != want {
+		t.Errorf("queried %q, want %q", client.queries[0], want)
+	}
+	if first := resp.Forecast[0]; !first.Time.Equal(start.Add(30*time.Minute)) || math.Abs(first.Value-30) > 1e-9 {
+		t.Errorf("first prediction %+v, want 30 at %s", first, start.Add(30*time.Minute))
+	}
+
+	// the per-resource layout selects the device's series with a condition
+	perResource := &forecaster{client: client, database: "edgex", layout: perResourceLayout}
+	if _, err := perResource.forecast("Sensor-1", "Temperature", time.Hour, time.Hour, defaultForecastAlpha, defaultForecastBeta); err != nil {
+		t.Fatal(err)
+	}
+	if want := `SELECT "value" FROM "Temperature" WHERE "device" = 'Sensor-1' AND time > now() - 3600s`; client.queries[1] != want {
+		t.Errorf("queried %q, want %q", client.queries[1], want)
+	}
+
+	// horizons are capped at maxForecastPoints
+	resp, err = f.forecast("Sensor-1", "Temperature", time.Hour, 365*24*time.Hour, defaultForecastAlpha, defaultForecastBeta)
+	if err != nil || len(resp.Forecast) != maxForecastPoints {
+		t.Errorf("got %v predictions, %v, want %d", len(resp.Forecast), err, maxForecastPoints)
+	}
+
+	for name, samples := range map[string][]sample{
+		"no samples":           nil,
+		"one sample":           client.samples[:1],
+		"duplicate timestamps": {{Time: start, Value: 1}, {Time: start, Value: 2}},
+	} {
+		client.samples = samples
+		if _, err := f.forecast("Sensor-1", "Temperature", time.Hour, time.Hour, defaultForecastAlpha, defaultForecastBeta); err != errNotEnoughSamples {
+			t.Errorf("%s: got %v, want %v", name, err, errNotEnoughSamples)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anonymouse64/edgex-influx-proxy/pkg/testutil"
+	"github.com/edgexfoundry/app-functions-sdk-go/appcontext"
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+	"github.com/edgexfoundry/go-mod-core-contracts/models"
+	influxmodels "github.com/influxdata/influxdb1-client/models"
+	influx "github.com/influxdata/influxdb1-client/v2"
+)
+
+// native fuzzing needs Go 1.18, so the fuzz tests mutate table seeds with a
+// seeded random source instead, which keeps failures reproducible
+var fuzzIterations = flag.Int("fuzz.iterations", 2000, "mutations of every seed tried by the fuzz tests")
+
+// fuzzTokens are spliced into the seeds, as they are what parsers trip over
+var fuzzTokens = []string{
+	"\n", "\r\n", "\\", `"`, ",", "=", " ", "\x00", "\xff", "é", "-", "+", ".", "e", "E",
+	"NaN", "Inf", "-Inf", "1e309", "0x1p-2", "9223372036854775808", "==", "{", "}", "[", "]",
+}
+
+// mutate returns a copy of seed with a few random edits
+func mutate(r *rand.Rand, seed []byte) []byte {
+	data := append([]byte(nil), seed...)
+	for n := 1 + r.Intn(4); n > 0; n-- {
+		pos := 0
+		if len(data) != 0 {
+			pos = r.Intn(len(data))
+		}
+		switch r.Intn(5) {
+		case 0:
+			if len(data) != 0 {
+				data[pos] = byte(r.Intn(256))
+			}
+		case 1:
+			data = append(data[:pos], append([]byte{byte(r.Intn(256))}, data[pos:]...)...)
+		case 2:
+			if len(data) != 0 {
+				data = append(data[:pos], data[pos+1:]...)
+			}
+		case 3:
+			token := fuzzTokens[r.Intn(len(fuzzTokens))]
+			data = append(data[:pos], append([]byte(token), data[pos:]...)...)
+		case 4:
+			if len(data) != 0 {
+				end := pos + r.Intn(len(data)-pos) + 1
+				data = append(data[:end], append(append([]byte(nil), data[pos:end]...), data[end:]...)...)
+			}
+		}
+	}
+	return data
+}
+
+// fuzz runs fn with the seeds and the mutations of every seed
+func fuzz(t *testing.T, seeds [][]byte, fn func(t *testing.T, data []byte)) {
+	t.Helper()
+	iterations := *fuzzIterations
+	if testing.Short() {
+		iterations /= 10
+	}
+	r := rand.New(rand.NewSource(1))
+	for _, seed := range seeds {
+		fn(t, seed)
+		for i := 0; i < iterations; i++ {
+			data := mutate(r, seed)
+			func() {
+				defer func() {
+					if p := recover(); p != nil {
+						t.Fatalf("panic on %q: %v", data, p)
+					}
+				}()
+				fn(t, data)
+			}()
+		}
+	}
+}
+
+// fixtureSeeds returns the fixture readings in a stable order
+func fixtureSeeds() []models.Reading {
+	fixtures := fixtureReadings()
+	names := make([]string, 0, len(fixtures))
+	for name := range fixtures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	readings := make([]models.Reading, 0, len(names))
+	for _, name := range names {
+		readings = append(readings, fixtures[name])
+	}
+	return readings
+}
+
+func TestFuzzParseReadingValue(t *testing.T) {
+	floats, err := newBinaryFloats("big", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, seed := range fixtureSeeds() {
+		seed := seed
+		t.Run(seed.ValueType+"/"+seed.FloatEncoding, func(t *testing.T) {
+			fuzz(t, [][]byte{[]byte(seed.Value)}, func(t *testing.T, data []byte) {
+				// values that don't parse as their declared type are
+				// written as strings, which mustn't panic either
+				reading := seed
+				reading.Value = string(data)
+				parseReadingValue(reading, floats)
+			})
+		})
+	}
+}
+
+func TestFuzzEventDecoding(t *testing.T) {
+	origin := time.Unix(1600000000, 0).UnixNano()
+	var seeds [][]byte
+	for _, reading := range fixtureSeeds() {
+		reading.Device, reading.Origin = "Fixture-Device", origin
+		payload, err := json.Marshal(models.Event{Device: reading.Device, Origin: origin, Readings: []models.Reading{reading}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		seeds = append(seeds, payload)
+	}
+
+	client := &testutil.RecordingClient{}
+	write := sendToInfluxDBFunc(testWriteConfig(client, testutil.NewFakeClock(time.Unix(0, origin))))
+	edgexcontext := &appcontext.Context{LoggingClient: logger.NewMockClient()}
+	fuzz(t, seeds, func(t *testing.T, data []byte) {
+		// retried events are decoded by the write stage itself, and must be
+		// written or skipped without panicking whatever they hold
+		write(edgexcontext, data)
+
+		var event models.Event
+		if err := json.Unmarshal(data, &event); err != nil {
+			return
+		}
+		for _, split := range splitByDevice(event) {
+			for _, reading := range split.Readings {
+				// readings without a device are of the event's device
+				if reading.Device != split.Device && reading.Device != "" {
+					t.Fatalf("reading of device %q split into event of %q", reading.Device, split.Device)
+				}
+			}
+		}
+	})
+}
+
+func TestFuzzTagSanitizer(t *testing.T) {
+	const maxLength = 32
+	v := newTagValidator(logger.NewMockClient(), maxLength)
+	seeds := [][]byte{
+		[]byte("Random-Integer-Device"),
+		[]byte("site=factory, line 1"),
+		[]byte(`C:\gateway\`),
+		[]byte("multi\nline\r\nvalue"),
+		[]byte(strings.Repeat("é", 20)),
+	}
+	fuzz(t, seeds, func(t *testing.T, data []byte) {
+		tags := map[string]string{"tag": string(data)}
+		v.sanitize("device", tags)
+		value := tags["tag"]
+		if strings.ContainsAny(value, "\r\n") || strings.HasSuffix(value, `\`) || len(value) > maxLength {
+			t.Fatalf("sanitized %q to %q", data, value)
+		}
+		if value == "" {
+			return
+		}
+
+		// the sanitized value must survive being written as line protocol
+		pt, err := influx.NewPoint("m", tags, map[string]interface{}{"f": 1}, time.Unix(0, 0))
+		if err != nil {
+			return
+		}
+		parsed, err := influxmodels.ParsePoints([]byte(pt.String()))
+		if err != nil {
+			t.Fatalf("sanitized %q to %q, which doesn't parse: %v", data, value, err)
+		}
+		if got := string(parsed[0].Tags().Get([]byte("tag"))); got != value {
+			t.Fatalf("sanitized %q to %q, which parses back as %q", data, value, got)
+		}
+	})
+}
+
+func TestFuzzLineProtocol(t *testing.T) {
+	client := &testutil.RecordingClient{}
+	lw := &lineProtocolWriter{
+		client:   client,
+		ptConfig: influx.BatchPointsConfig{Database: "edgex"},
+		tags:     map[string]string{"site": "factory"},
+	}
+	seeds := [][]byte{
+		[]byte("cpu,host=gw1 usage=12.5 1600000000000000000"),
+		[]byte("temperature,device=Sensor\\ 1 value=21i,ok=true,unit=\"degC\"\nhumidity value=40"),
+		[]byte(`weather,location=us-midwest temperature=82 1465839830100400200`),
+	}
+	fuzz(t, seeds, func(t *testing.T, data []byte) {
+		if err := lw.write(sourceWrite, data, nil, "", "", ""); err != nil {
+			if _, ok := err.(*lineProtocolError); !ok {
+				t.Fatalf("writing %q failed with %v instead of being rejected", data, err)
+			}
+		}
+	})
+}
+
+func TestFuzzSparkplugPayload(t *testing.T) {
+	s := newSparkplugSubscriber(logger.NewMockClient(), nil)
+	if _, _, err := s.event("spBv1.0/plant/NBIRTH/gw1", sparkplugPayloads["NBIRTH"]); err != nil {
+		t.Fatal(err)
+	}
+	var seeds [][]byte
+	for _, msgType := range []string{"NBIRTH", "DBIRTH", "NDATA", "DDATA"} {
+		seeds = append(seeds, sparkplugPayloads[msgType])
+	}
+	fuzz(t, seeds, func(t *testing.T, data []byte) {
+		event, ok, err := s.event("spBv1.0/plant/NDATA/gw1", data)
+		if err != nil || !ok {
+			return
+		}
+		for _, r := range event.Readings {
+			if r.Name == "" || r.ValueType == "" {
+				t.Fatalf("decoded %q to a reading without a name or type: %+v", data, r)
+			}
+		}
+	})
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/edgexfoundry/app-functions-sdk-go/appcontext"
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+	"github.com/edgexfoundry/go-mod-core-contracts/models"
+)
+
+// leaderLease elects one of several instances sharing a lock file as the
+// leader. The leader holds an exclusive lock on the file for as long as it
+// runs, so the lock is released by the kernel as soon as it dies, and the
+// standbys keep trying to take it.
+type leaderLease struct {
+	path   string
+	lc     logger.LoggingClient
+	leader int32
+	file   *os.File
+	// elected is closed once this instance is the leader
+	elected chan struct{}
+}
+
+// errStandby is returned to writers while this instance is a standby
+var errStandby = errors.New("this instance is a standby, write to the leader")
+
+// startLeaderLease tries to take the lease every interval of the clock until
+// it has it
+func startLeaderLease(lc logger.LoggingClient, path string, interval time.Duration, clk clock) (*leaderLease, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	l := &leaderLease{path: path, lc: lc, file: f, elected: make(chan struct{})}
+	err = tryLockFile(f)
+	switch err {
+	case nil:
+		l.acquired()
+	case errLocked:
+		lc.Info(fmt.Sprintf("another instance holds %s, starting as standby", path))
+		go func() {
+			for range clk.Tick(interval) {
+				err := tryLockFile(f)
+				if err == nil {
+					l.acquired()
+					return
+				}
+				if err != errLocked {
+					lc.Warn(fmt.Sprintf("unable to lock %s: %s", path, err))
+				}
+			}
+		}()
+	default:
+		f.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *leaderLease) acquired() {
+	atomic.StoreInt32(&l.leader, 1)
+	close(l.elected)
+	l.lc.Info(fmt.Sprintf("took %s, now the leader", l.path))
+}
+
+// isLeader returns whether this instance should write, which is always the
+// case when there is no lease
+func (l *leaderLease) isLeader() bool {
+	return l == nil || atomic.LoadInt32(&l.leader) == 1
+}
+
+// waitUntilLeader blocks until this instance is the leader
+func (l *leaderLease) waitUntilLeader() {
+	if l == nil {
+		return
+	}
+	<-l.elected
+}
+
+// leaderFunc drops events received while this instance is a standby, as the
+// leader receives and writes the same events
+func leaderFunc(l *leaderLease) func(edgexcontext *appcontext.Context, params ...interface{}) (bool, interface{}) {
+	return func(edgexcontext *appcontext.Context, params ...interface{}) (bool, interface{}) {
+		if len(params) < 1 {
+			// We didn't receive a result
+			return false, errors.New("no data received")
+		}
+
+		event, ok := params[0].(models.Event)
+		if !ok {
+			// not an event, such as an event being retried from when this
+			// instance was the leader, let the next function decide what to
+			// do with it
+			return true, params[0]
+		}
+
+		if !l.isLeader() {
+			return false, nil
+		}
+
+		return true, event
+	}
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// errLocked is returned when another process holds the lock
+var errLocked = errors.New("locked by another process")
+
+// tryLockFile takes an exclusive lock on the file without waiting for it
+func tryLockFile(f *os.File) error {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return errLocked
+	}
+	return err
+}
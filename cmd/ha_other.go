@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// errLocked is returned when another process holds the lock
+var errLocked = errors.New("locked by another process")
+
+func tryLockFile(f *os.File) error {
+	return errors.New("leader election is only supported on linux")
+}
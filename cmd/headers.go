@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+// responseHeaders are the headers added to the responses of every route,
+// such as security headers like Strict-Transport-Security, and the
+// Cache-Control of individual routes. Handlers can still override them.
+type responseHeaders struct {
+	common       http.Header
+	cacheControl map[string]string
+}
+
+// splitHeaderList splits a list separated by "|", since header values can
+// contain commas, semicolons and equal signs
+func splitHeaderList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, "|") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// validHeaderName returns whether name is an HTTP token
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if c <= ' ' || c >= 0x7f || strings.ContainsRune("\"(),/:;<=>?@[\\]{}", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseResponseHeaders parses "|" separated "Name: value" headers and
+// "|" separated route=cache-control pairs
+func parseResponseHeaders(headers, cacheControl string) (*responseHeaders, error) {
+	h := &responseHeaders{
+		common:       make(http.Header),
+		cacheControl: make(map[string]string),
+	}
+	for _, header := range splitHeaderList(headers) {
+		kv := strings.SplitN(header, ":", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid header %q, must be Name: value", header)
+		}
+		name, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		if !validHeaderName(name) || value == "" || strings.ContainsAny(value, "\r\n") {
+			return nil, fmt.Errorf("invalid header %q, must be Name: value", header)
+		}
+		h.common.Add(textproto.CanonicalMIMEHeaderKey(name), value)
+	}
+	for _, pair := range splitHeaderList(cacheControl) {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || !strings.HasPrefix(kv[0], "/") || kv[1] == "" || strings.ContainsAny(kv[1], "\r\n") {
+			return nil, fmt.Errorf("invalid cache control %q, must be /route=value", pair)
+		}
+		h.cacheControl[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return h, nil
+}
+
+// set adds the headers of the route to the response
+func (h *responseHeaders) set(route string, w http.ResponseWriter) {
+	if h == nil {
+		return
+	}
+	for name, values := range h.common {
+		w.Header()[name] = append([]string(nil), values...)
+	}
+	if value, ok := h.cacheControl[route]; ok {
+		w.Header().Set("Cache-Control", value)
+	}
+}
+
+// wrap adds the headers of the route to the responses of the handler
+func (h *responseHeaders) wrap(route string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.set(route, w)
+		handler(w, r)
+	}
+}
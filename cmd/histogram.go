@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	edgexinfluxproxy "github.com/anonymouse64/edgex-influx-proxy"
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+	influx "github.com/influxdata/influxdb1-client/v2"
+)
+
+// histogramMeasurement is where a point summarizing every window of a
+// high-rate resource is written
+const histogramMeasurement = "histograms"
+
+// what is done with the raw samples of high-rate resources
+const (
+	// rawDrop only writes the summaries
+	rawDrop = "drop"
+	// rawInflux writes the samples to InfluxDB next to the summaries
+	rawInflux = "influx"
+	// rawSinks hands the samples to the registered sinks, such as a file
+	// archive, instead of writing them to InfluxDB
+	rawSinks = "sinks"
+)
+
+// histogramWindow accumulates the samples of one window of one resource of
+// one device
+type histogramWindow struct {
+	start      time.Time
+	count      uint64
+	min, max   float64
+	sum, sumSq float64
+	// buckets counts the samples at or below every bound, the last one
+	// counting all samples
+	buckets []uint64
+}
+
+// histograms summarizes the samples of high-rate resources, such as 1kHz
+// vibration data, in a point per window with their count, min, max, mean,
+// rms and cumulative bucket counts, instead of writing every sample. A
+// window is only written once a sample after it arrives.
+type histograms struct {
+	resources map[string]bool
+	window    time.Duration
+	// bounds are the upper bounds of the buckets, in increasing order
+	bounds []float64
+	raw    string
+	lc     logger.LoggingClient
+	sinks  map[string]edgexinfluxproxy.Sink
+
+	mu      sync.Mutex
+	windows map[string]*histogramWindow
+}
+
+// parseHistogramBounds parses comma separated bucket bounds, which must
+// increase
+func parseHistogramBounds(s string) ([]float64, error) {
+	var bounds []float64
+	for _, b := range splitList(s) {
+		bound, err := strconv.ParseFloat(b, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bucket bound %q", b)
+		}
+		if len(bounds) != 0 && bound <= bounds[len(bounds)-1] {
+			return nil, fmt.Errorf("bucket bounds must increase, %q doesn't", b)
+		}
+		bounds = append(bounds, bound)
+	}
+	return bounds, nil
+}
+
+func newHistograms(resources []string, window time.Duration, bounds []float64, raw string) (*histograms, error) {
+	switch raw {
+	case rawDrop, rawInflux, rawSinks:
+	default:
+		return nil, fmt.Errorf("invalid raw samples action %q, must be one of %q, %q or %q", raw, rawDrop, rawInflux, rawSinks)
+	}
+	h := &histograms{
+		resources: make(map[string]bool),
+		window:    window,
+		bounds:    bounds,
+		raw:       raw,
+		windows:   make(map[string]*histogramWindow),
+	}
+	for _, resource := range resources {
+		h.resources[resource] = true
+	}
+	return h, nil
+}
+
+// observe records a sample of the resource at t, returning the points of
+// the windows it completes and true if the resource is summarized. Samples
+// from before the current window of the resource are ignored.
+func (h *histograms) observe(device, resource string, value float64, t time.Time) ([]*influx.Point, bool) {
+	if h == nil || !h.resources[resource] {
+		return nil, false
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := device + "/" + resource
+	start := t.Truncate(h.window)
+	var pts []*influx.Point
+	w, ok := h.windows[key]
+	if ok && start.After(w.start) {
+		if pt := h.point(device, resource, w); pt != nil {
+			pts = append(pts, pt)
+		}
+		ok = false
+	}
+	if !ok {
+		w = &histogramWindow{
+			start:   start,
+			min:     math.Inf(1),
+			max:     math.Inf(-1),
+			buckets: make([]uint64, len(h.bounds)+1),
+		}
+		h.windows[key] = w
+	}
+	if start.Before(w.start) {
+		return pts, true
+	}
+
+	w.count++
+	w.min = math.Min(w.min, value)
+	w.max = math.Max(w.max, value)
+	w.sum += value
+	w.sumSq += value * value
+	for i, bound := range h.bounds {
+		if value <= bound {
+			w.buckets[i]++
+		}
+	}
+	w.buckets[len(h.bounds)]++
+	return pts, true
+}
+
+// point returns the point summarizing the window, or nil if it can't be
+// made
+func (h *histograms) point(device, resource string, w *histogramWindow) *influx.Point {
+	n := float64(w.count)
+	fields := map[string]interface{}{
+		"count": int64(w.count),
+		"min":   w.min,
+		"max":   w.max,
+		"mean":  w.sum / n,
+		"rms":   math.Sqrt(w.sumSq / n),
+	}
+	for i, bound := range h.bounds {
+		fields["le_"+strconv.FormatFloat(bound, 'g', -1, 64)] = int64(w.buckets[i])
+	}
+	fields["le_inf"] = int64(w.buckets[len(h.bounds)])
+	tags := map[string]string{"device": device, "resource": resource}
+	pt, err := influx.NewPoint(histogramMeasurement, tags, fields, w.start)
+	if err != nil {
+		return nil
+	}
+	return pt
+}
+
+// archive hands the raw samples to the registered sinks if they are
+// archived there
+func (h *histograms) archive(pts []*influx.Point) {
+	if h == nil || h.raw != rawSinks || len(pts) == 0 {
+		return
+	}
+	for name, sink := range h.sinks {
+		if err := sink.Write(pts); err != nil {
+			h.lc.Error(fmt.Sprintf("error archiving raw samples to sink %q: %s", name, err))
+		}
+	}
+}
+
+// forget drops the windows of the device's resources
+func (h *histograms) forget(device string) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for key := range h.windows {
+		if strings.HasPrefix(key, device+"/") {
+			delete(h.windows, key)
+		}
+	}
+}
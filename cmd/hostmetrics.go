@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	edgexinfluxproxy "github.com/anonymouse64/edgex-influx-proxy"
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+	influx "github.com/influxdata/influxdb1-client/v2"
+)
+
+// hostMetricsMeasurement is the measurement host metrics are written to
+const hostMetricsMeasurement = "gateway_metrics"
+
+func init() {
+	edgexinfluxproxy.RegisterSource("host-metrics", newHostMetricsSource)
+}
+
+// hostMetricsSource samples the CPU, memory, disk, temperature and network
+// stats of the gateway every interval and writes them to the
+// gateway_metrics measurement, so that the health of a fleet is in the same
+// database as its telemetry without running Telegraf next to the proxy
+type hostMetricsSource struct {
+	lc       logger.LoggingClient
+	interval time.Duration
+	host     string
+	// disks are the mount points disk usage is sampled for
+	disks []string
+
+	sampler *hostSampler
+}
+
+func newHostMetricsSource(lc logger.LoggingClient, settings map[string]string) (edgexinfluxproxy.Source, error) {
+	s := &hostMetricsSource{
+		lc:      lc,
+		host:    settings["HostMetricsHost"],
+		disks:   splitList(settings["HostMetricsDisks"]),
+		sampler: &hostSampler{},
+	}
+	var err error
+	s.interval, err = durationSetting(settings, "HostMetricsInterval", 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if s.interval < time.Second {
+		return nil, fmt.Errorf("invalid \"HostMetricsInterval\" setting of %s, must be at least 1s", s.interval)
+	}
+	if s.host == "" {
+		if s.host, err = os.Hostname(); err != nil {
+			return nil, err
+		}
+	}
+	if len(s.disks) == 0 {
+		s.disks = []string{"/"}
+	}
+	if err := s.sampler.check(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *hostMetricsSource) Start(sink edgexinfluxproxy.Sink) error {
+	// take the first sample now, as the CPU usage is measured between two
+	s.sampler.sample(s.host, s.disks, time.Now())
+	go func() {
+		for now := range time.Tick(s.interval) {
+			pts, err := s.sampler.sample(s.host, s.disks, now)
+			if err != nil {
+				s.lc.Warn(fmt.Sprintf("unable to sample host metrics: %s", err))
+			}
+			if len(pts) == 0 {
+				continue
+			}
+			err = sink.Write(pts)
+			switch {
+			case err == errSourcePaused:
+			case err != nil:
+				s.lc.Error(fmt.Sprintf("error writing host metrics: %s", err))
+			}
+		}
+	}()
+	return nil
+}
+
+// hostPoint makes a gateway_metrics point of the host with the extra tags
+func hostPoint(host string, tags map[string]string, fields map[string]interface{}, t time.Time) (*influx.Point, error) {
+	allTags := map[string]string{"host": host}
+	for k, v := range tags {
+		allTags[k] = v
+	}
+	return influx.NewPoint(hostMetricsMeasurement, allTags, fields, t)
+}
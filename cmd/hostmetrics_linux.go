@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	influx "github.com/influxdata/influxdb1-client/v2"
+)
+
+// hostSampler reads host metrics from /proc and /sys
+type hostSampler struct {
+	// idle and total are the CPU times of the previous sample, to measure
+	// the usage between two samples
+	idle, total uint64
+}
+
+// check returns an error if host metrics can't be sampled
+func (h *hostSampler) check() error {
+	_, err := os.Stat("/proc/stat")
+	return err
+}
+
+// sample returns the points of the host metrics at now, returning the
+// points it could make along with the first error
+func (h *hostSampler) sample(host string, disks []string, now time.Time) ([]*influx.Point, error) {
+	var pts []*influx.Point
+	var firstErr error
+	add := func(tags map[string]string, fields map[string]interface{}, err error) {
+		if err == nil && len(fields) != 0 {
+			var pt *influx.Point
+			if pt, err = hostPoint(host, tags, fields, now); err == nil {
+				pts = append(pts, pt)
+			}
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	fields, err := h.cpu()
+	add(nil, fields, err)
+	fields, err = memory()
+	add(nil, fields, err)
+	for _, path := range disks {
+		fields, err = disk(path)
+		add(map[string]string{"path": path}, fields, err)
+	}
+	zones, err := temperatures()
+	if err != nil {
+		add(nil, nil, err)
+	}
+	for zone, z := range zones {
+		tags := map[string]string{"zone": zone}
+		if z.typ != "" {
+			tags["zone_type"] = z.typ
+		}
+		add(tags, map[string]interface{}{"temperature_celsius": z.celsius}, nil)
+	}
+	interfaces, err := network()
+	if err != nil {
+		add(nil, nil, err)
+	}
+	for name, fields := range interfaces {
+		add(map[string]string{"interface": name}, fields, nil)
+	}
+	return pts, firstErr
+}
+
+// cpu returns the CPU usage since the previous sample and the load averages
+func (h *hostSampler) cpu() (map[string]interface{}, error) {
+	data, err := ioutil.ReadFile("/proc/stat")
+	if err != nil {
+		return nil, err
+	}
+	line := data
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		line = data[:i]
+	}
+	cols := strings.Fields(string(line))
+	if len(cols) < 5 || cols[0] != "cpu" {
+		return nil, fmt.Errorf("unexpected /proc/stat line %q", line)
+	}
+	var idle, total uint64
+	for i, col := range cols[1:] {
+		v, err := strconv.ParseUint(col, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected /proc/stat line %q", line)
+		}
+		// idle and iowait
+		if i == 3 || i == 4 {
+			idle += v
+		}
+		total += v
+	}
+
+	fields := make(map[string]interface{})
+	if h.total != 0 && total > h.total {
+		busy := float64((total - h.total) - (idle - h.idle))
+		fields["cpu_usage_percent"] = 100 * busy / float64(total-h.total)
+	}
+	h.idle, h.total = idle, total
+
+	load, err := ioutil.ReadFile("/proc/loadavg")
+	if err != nil {
+		return nil, err
+	}
+	avgs := strings.Fields(string(load))
+	for i, name := range []string{"load1", "load5", "load15"} {
+		if i >= len(avgs) {
+			break
+		}
+		if v, err := strconv.ParseFloat(avgs[i], 64); err == nil {
+			fields[name] = v
+		}
+	}
+	return fields, nil
+}
+
+// memory returns the total and available memory
+func memory() (map[string]interface{}, error) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	kB := make(map[string]uint64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		cols := strings.Fields(scanner.Text())
+		if len(cols) < 2 {
+			continue
+		}
+		if v, err := strconv.ParseUint(cols[1], 10, 64); err == nil {
+			kB[strings.TrimSuffix(cols[0], ":")] = v
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	total, available := kB["MemTotal"], kB["MemAvailable"]
+	if total == 0 {
+		return nil, fmt.Errorf("no MemTotal in /proc/meminfo")
+	}
+	return map[string]interface{}{
+		"mem_total_bytes":     int64(total * 1024),
+		"mem_available_bytes": int64(available * 1024),
+		"mem_used_percent":    100 * float64(total-available) / float64(total),
+	}, nil
+}
+
+// disk returns the size and free space of the filesystem mounted at path
+func disk(path string) (map[string]interface{}, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return nil, fmt.Errorf("unable to stat %s: %v", path, err)
+	}
+	total := st.Blocks * uint64(st.Bsize)
+	free := st.Bavail * uint64(st.Bsize)
+	fields := map[string]interface{}{
+		"disk_total_bytes": int64(total),
+		"disk_free_bytes":  int64(free),
+	}
+	if total != 0 {
+		fields["disk_used_percent"] = 100 * float64(total-free) / float64(total)
+	}
+	return fields, nil
+}
+
+// thermalZone is the temperature of a thermal zone and the type of its
+// sensor, which several zones can share
+type thermalZone struct {
+	typ     string
+	celsius float64
+}
+
+// temperatures returns the temperature of every thermal zone by its
+// directory, such as thermal_zone0, boards without any have none
+func temperatures() (map[string]thermalZone, error) {
+	dirs, err := filepath.Glob("/sys/class/thermal/thermal_zone*")
+	if err != nil {
+		return nil, err
+	}
+	zones := make(map[string]thermalZone)
+	for _, dir := range dirs {
+		temp, err := ioutil.ReadFile(filepath.Join(dir, "temp"))
+		if err != nil {
+			// zones of disabled sensors can't be read
+			continue
+		}
+		milli, err := strconv.ParseInt(strings.TrimSpace(string(temp)), 10, 64)
+		if err != nil {
+			continue
+		}
+		zone := thermalZone{celsius: float64(milli) / 1000}
+		if typ, err := ioutil.ReadFile(filepath.Join(dir, "type")); err == nil {
+			zone.typ = strings.TrimSpace(string(typ))
+		}
+		zones[filepath.Base(dir)] = zone
+	}
+	return zones, nil
+}
+
+// network returns the traffic and error counters of every network interface
+// but the loopback
+func network() (map[string]map[string]interface{}, error) {
+	f, err := os.Open("/proc/net/dev")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	interfaces := make(map[string]map[string]interface{})
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		i := strings.IndexByte(line, ':')
+		if i < 0 {
+			// the headers
+			continue
+		}
+		name := strings.TrimSpace(line[:i])
+		cols := strings.Fields(line[i+1:])
+		if name == "lo" || len(cols) < 11 {
+			continue
+		}
+		fields := make(map[string]interface{})
+		for col, field := range map[int]string{0: "rx_bytes", 2: "rx_errors", 8: "tx_bytes", 10: "tx_errors"} {
+			if v, err := strconv.ParseUint(cols[col], 10, 64); err == nil {
+				fields[field] = int64(v)
+			}
+		}
+		interfaces[name] = fields
+	}
+	return interfaces, scanner.Err()
+}
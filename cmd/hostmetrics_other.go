@@ -0,0 +1,21 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"time"
+
+	influx "github.com/influxdata/influxdb1-client/v2"
+)
+
+type hostSampler struct{}
+
+func (h *hostSampler) check() error {
+	return errors.New("host metrics are only supported on linux")
+}
+
+func (h *hostSampler) sample(host string, disks []string, now time.Time) ([]*influx.Point, error) {
+	return nil, h.check()
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/edgexfoundry/app-functions-sdk-go/appcontext"
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+	"github.com/edgexfoundry/go-mod-core-contracts/models"
+)
+
+const (
+	// syncWriteHeader overrides whether a request to /edgex waits for the
+	// write to influx
+	syncWriteHeader = "X-Sync-Write"
+	// maxEventBody is the largest event accepted at /edgex
+	maxEventBody = 10 * 1024 * 1024
+	// maxQueuedEvents bounds how many events posted to /edgex wait to be
+	// written when not waiting for their write
+	maxQueuedEvents = 1000
+)
+
+// eventIngest accepts EdgeX events posted to /edgex and runs them through the
+// pipeline. By default events are queued and accepted right away, but in
+// synchronous mode the request waits for the write to influx and fails if it
+// does, so that integrations relying on retrying upstream don't lose events
+// to a buffer.
+type eventIngest struct {
+	lc       logger.LoggingClient
+	pipeline []appcontext.AppFunction
+	// sync waits for the writes unless a request asks otherwise, for up to
+	// timeout
+	sync    bool
+	timeout time.Duration
+	// fallback, if set, is how long to wait before answering 202 with the
+	// ID to query the write with from tracker instead
+	fallback time.Duration
+	tracker  *writeTracker
+	queue    chan queuedEvent
+	// headerTags maps request headers to the event tags their values are
+	// added as, which the write stage writes as tags of the points
+	headerTags map[string]string
+}
+
+// queuedEvent is an event waiting to be written
+type queuedEvent struct {
+	correlationID string
+	event         models.Event
+}
+
+func newEventIngest(lc logger.LoggingClient, pipeline []appcontext.AppFunction, sync bool, timeout, fallback time.Duration) *eventIngest {
+	in := &eventIngest{
+		lc:       lc,
+		pipeline: pipeline,
+		sync:     sync,
+		timeout:  timeout,
+		fallback: fallback,
+		queue:    make(chan queuedEvent, maxQueuedEvents),
+	}
+	if fallback != 0 {
+		in.tracker = newWriteTracker()
+	}
+	return in
+}
+
+// run writes the queued events
+func (in *eventIngest) run() {
+	for q := range in.queue {
+		if _, err := runPipeline(in.lc, in.pipeline, q.correlationID, q.event); err != nil {
+			in.lc.Error(fmt.Sprintf("writing event from device %q posted to /edgex failed: %s", q.event.Device, err))
+		}
+	}
+}
+
+// ingestResult is the outcome of running an event through the pipeline
+type ingestResult struct {
+	filtered bool
+	err      error
+}
+
+// eventHandler serves POST /edgex, answering 202 once the event is queued,
+// or with X-Sync-Write: true or in synchronous mode, 200 once it's written,
+// 422 if influx rejected it, 502 if the write failed and 504 if it didn't
+// finish in time. With a fallback, writes taking longer than it are answered
+// 202 with the ID to query their outcome with instead.
+func (in *eventIngest) eventHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxEventBody))
+	if err != nil {
+		writeProblem(w, r, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	var event models.Event
+	if err := json.Unmarshal(body, &event); err != nil {
+		writeProblem(w, r, fmt.Sprintf("invalid event: %s", err), http.StatusBadRequest)
+		return
+	}
+	sync := in.sync
+	if v := r.Header.Get(syncWriteHeader); v != "" {
+		if sync, err = strconv.ParseBool(v); err != nil {
+			writeProblem(w, r, fmt.Sprintf("invalid %s header %q", syncWriteHeader, v), http.StatusBadRequest)
+			return
+		}
+	}
+	for header, tag := range in.headerTags {
+		if v := r.Header.Get(header); v != "" {
+			if event.Tags == nil {
+				event.Tags = make(map[string]string)
+			}
+			event.Tags[tag] = v
+		}
+	}
+	correlationID := r.Header.Get(correlationIDHeader)
+	if correlationID == "" {
+		correlationID = "edgex-http"
+	}
+
+	if !sync {
+		select {
+		case in.queue <- queuedEvent{correlationID: correlationID, event: event}:
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			w.Header().Set("Retry-After", "1")
+			writeProblem(w, r, "too many events waiting to be written, try again later", http.StatusServiceUnavailable)
+		}
+		return
+	}
+
+	done := make(chan ingestResult, 1)
+	go func() {
+		filtered, err := runPipeline(in.lc, in.pipeline, correlationID, event)
+		done <- ingestResult{filtered: filtered, err: err}
+	}()
+	deadline := in.timeout
+	if in.fallback != 0 {
+		deadline = in.fallback
+	}
+	select {
+	case res := <-done:
+		switch {
+		case res.err == nil:
+			status := "written"
+			if res.filtered {
+				// dropped on purpose, such as stale events, so retrying
+				// is pointless
+				status = "filtered"
+			}
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(map[string]string{"status": status}); err != nil {
+				writeProblem(w, r, err.Error(), http.StatusInternalServerError)
+			}
+		case classifyWriteError(res.err).permanent:
+			writeProblem(w, r, classifyWriteError(res.err).message, http.StatusUnprocessableEntity)
+		default:
+			writeProblem(w, r, res.err.Error(), http.StatusBadGateway)
+		}
+	case <-time.After(deadline):
+		if in.fallback == 0 {
+			writeProblem(w, r, fmt.Sprintf("the write didn't finish within %s", in.timeout), http.StatusGatewayTimeout)
+			return
+		}
+		id, err := in.tracker.track()
+		if err != nil {
+			writeProblem(w, r, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		go func() {
+			in.tracker.finish(id, <-done)
+		}()
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", "/status/writes/"+id)
+		w.WriteHeader(http.StatusAccepted)
+		if err := json.NewEncoder(w).Encode(map[string]string{"status": "pending", "id": id}); err != nil {
+			in.lc.Error(fmt.Sprintf("unable to answer the /edgex request: %s", err))
+		}
+	}
+}
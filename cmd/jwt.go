@@ -0,0 +1,272 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// jwksMinRefresh is the least time between fetches of the key set, so
+	// that tokens with unknown key ids can't make us hammer the endpoint
+	jwksMinRefresh = time.Minute
+	// jwtLeeway is the clock skew allowed when checking expiry
+	jwtLeeway = time.Minute
+)
+
+// jwtAlgorithms are the supported signing algorithms and their hashes
+var jwtAlgorithms = map[string]crypto.Hash{
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+	"ES256": crypto.SHA256,
+	"ES384": crypto.SHA384,
+	"ES512": crypto.SHA512,
+}
+
+// jwtAuth validates JWTs signed by a key from a JWKS endpoint, such as that of
+// an OpenID Connect identity provider
+type jwtAuth struct {
+	jwksURL  string
+	issuer   string
+	audience string
+	client   *http.Client
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+func newJWTAuth(jwksURL, issuer, audience string) *jwtAuth {
+	return &jwtAuth{
+		jwksURL:  jwksURL,
+		issuer:   issuer,
+		audience: audience,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		keys:     make(map[string]crypto.PublicKey),
+	}
+}
+
+// jwk is a JSON web key, only the members of RSA and EC keys are decoded
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// publicKey returns the key, or nil if it isn't a supported signing key
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	if k.Use != "" && k.Use != "sig" {
+		return nil, nil
+	}
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, nil
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, nil
+}
+
+// key returns the key with the id, fetching the key set again if it isn't
+// known and it wasn't fetched recently. The key set is fetched without
+// holding the lock, so that a slow endpoint doesn't hold up tokens signed with
+// known keys.
+func (a *jwtAuth) key(kid string) (crypto.PublicKey, error) {
+	a.mu.Lock()
+	if key, ok := a.keys[kid]; ok {
+		a.mu.Unlock()
+		return key, nil
+	}
+	if time.Since(a.fetched) < jwksMinRefresh {
+		a.mu.Unlock()
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	a.fetched = time.Now()
+	a.mu.Unlock()
+
+	keys, err := a.fetchKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.keys = keys
+	if key, ok := a.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+// fetchKeys fetches the supported signing keys of the key set by their id
+func (a *jwtAuth) fetchKeys() (map[string]crypto.PublicKey, error) {
+	resp, err := a.client.Get(a.jwksURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s returned %s", a.jwksURL, resp.Status)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("invalid key set from %s: %v", a.jwksURL, err)
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		key, err := k.publicKey()
+		if err != nil || key == nil {
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	return keys, nil
+}
+
+// audience is the aud claim, which is either a string or an array of them
+type audience []string
+
+func (aud *audience) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*aud = audience{one}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(aud))
+}
+
+// verify checks the signature of the token and its claims
+func (a *jwtAuth) verify(token string) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return errInvalidToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(headerJSON, &header) != nil {
+		return errInvalidToken
+	}
+	hash, ok := jwtAlgorithms[header.Alg]
+	if !ok {
+		return fmt.Errorf("unsupported algorithm %q", header.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return errInvalidToken
+	}
+	key, err := a.key(header.Kid)
+	if err != nil {
+		return err
+	}
+
+	h := hash.New()
+	h.Write([]byte(parts[0] + "." + parts[1]))
+	digest := h.Sum(nil)
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(header.Alg, "RS") || rsa.VerifyPKCS1v15(key, hash, digest, sig) != nil {
+			return errInvalidToken
+		}
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if !strings.HasPrefix(header.Alg, "ES") || len(sig) != 2*size {
+			return errInvalidToken
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(key, digest, r, s) {
+			return errInvalidToken
+		}
+	default:
+		return errInvalidToken
+	}
+
+	var claims struct {
+		Iss string   `json:"iss"`
+		Aud audience `json:"aud"`
+		Exp *int64   `json:"exp"`
+		Nbf *int64   `json:"nbf"`
+	}
+	claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || json.Unmarshal(claimsJSON, &claims) != nil {
+		return errInvalidToken
+	}
+	now := time.Now()
+	if claims.Exp == nil || now.After(time.Unix(*claims.Exp, 0).Add(jwtLeeway)) {
+		return errors.New("token expired")
+	}
+	if claims.Nbf != nil && now.Add(jwtLeeway).Before(time.Unix(*claims.Nbf, 0)) {
+		return errors.New("token not yet valid")
+	}
+	if a.issuer != "" && claims.Iss != a.issuer {
+		return errors.New("token from another issuer")
+	}
+	if a.audience != "" {
+		for _, aud := range claims.Aud {
+			if aud == a.audience {
+				return nil
+			}
+		}
+		return errors.New("token for another audience")
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// jwksServer serves the public keys of the signing keys by their id
+type jwksServer struct {
+	mu      sync.Mutex
+	keys    map[string]crypto.Signer
+	fetches int
+}
+
+func (s *jwksServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fetches++
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	encode := func(i *big.Int) string { return base64.RawURLEncoding.EncodeToString(i.Bytes()) }
+	for kid, key := range s.keys {
+		switch pub := key.Public().(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, jwk{Kid: kid, Kty: "RSA", Use: "sig", N: encode(pub.N), E: encode(big.NewInt(int64(pub.E)))})
+		case *ecdsa.PublicKey:
+			set.Keys = append(set.Keys, jwk{Kid: kid, Kty: "EC", Crv: "P-256", X: encode(pub.X), Y: encode(pub.Y)})
+		}
+	}
+	json.NewEncoder(w).Encode(set)
+}
+
+// signJWT returns a token with the header and claims signed by key
+func signJWT(t *testing.T, key crypto.Signer, alg, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	encode := func(v interface{}) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signed := encode(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"}) + "." + encode(claims)
+	hash, ok := jwtAlgorithms[alg]
+	if !ok {
+		hash = crypto.SHA256
+	}
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	var sig []byte
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, key, hash, digest); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestJWTVerify(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherRSAKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherECKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwks := &jwksServer{keys: map[string]crypto.Signer{"rsa": rsaKey, "ec": ecKey}}
+	server := httptest.NewServer(jwks)
+	defer server.Close()
+	auth := newJWTAuth(server.URL, "https://idp.example.com", "edgex-influx-proxy")
+
+	now := time.Now()
+	claims := func(change func(map[string]interface{})) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss": "https://idp.example.com",
+			"aud": []string{"other", "edgex-influx-proxy"},
+			"exp": now.Add(time.Hour).Unix(),
+		}
+		if change != nil {
+			change(c)
+		}
+		return c
+	}
+
+	for _, tc := range []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"RS256", signJWT(t, rsaKey, "RS256", "rsa", claims(nil)), true},
+		{"RS512", signJWT(t, rsaKey, "RS512", "rsa", claims(nil)), true},
+		{"ES256", signJWT(t, ecKey, "ES256", "ec", claims(nil)), true},
+		{"single audience", signJWT(t, rsaKey, "RS256", "rsa", claims(func(c map[string]interface{}) { c["aud"] = "edgex-influx-proxy" })), true},
+		{"expired within the leeway", signJWT(t, rsaKey, "RS256", "rsa", claims(func(c map[string]interface{}) { c["exp"] = now.Add(-jwtLeeway / 2).Unix() })), true},
+		{"wrong issuer", signJWT(t, rsaKey, "RS256", "rsa", claims(func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" })), false},
+		{"wrong audience", signJWT(t, rsaKey, "RS256", "rsa", claims(func(c map[string]interface{}) { c["aud"] = "other" })), false},
+		{"expired", signJWT(t, rsaKey, "RS256", "rsa", claims(func(c map[string]interface{}) { c["exp"] = now.Add(-time.Hour).Unix() })), false},
+		{"without expiry", signJWT(t, rsaKey, "RS256", "rsa", claims(func(c map[string]interface{}) { delete(c, "exp") })), false},
+		{"not yet valid", signJWT(t, rsaKey, "RS256", "rsa", claims(func(c map[string]interface{}) { c["nbf"] = now.Add(time.Hour).Unix() })), false},
+		{"alg none", unsignedJWT(t, claims(nil)), false},
+		{"alg HS256", signJWT(t, rsaKey, "HS256", "rsa", claims(nil)), false},
+		{"EC alg with an RSA key", signJWT(t, ecKey, "ES256", "rsa", claims(nil)), false},
+		{"RSA alg with an EC key", signJWT(t, rsaKey, "RS256", "ec", claims(nil)), false},
+		{"RSA signature of another key", signJWT(t, otherRSAKey, "RS256", "rsa", claims(nil)), false},
+		{"EC signature of another key", signJWT(t, otherECKey, "ES256", "ec", claims(nil)), false},
+		{"malformed", "not.a-token", false},
+	} {
+		if err := auth.verify(tc.token); (err == nil) != tc.valid {
+			t.Errorf("%s: got %v, want valid %v", tc.name, err, tc.valid)
+		}
+	}
+	if jwks.fetches != 1 {
+		t.Errorf("fetched the key set %d times, want once", jwks.fetches)
+	}
+
+	// a rotated key isn't known until the key set is fetched again, which
+	// unknown key ids only trigger every jwksMinRefresh
+	rotatedKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks.mu.Lock()
+	jwks.keys["rotated"] = rotatedKey
+	jwks.mu.Unlock()
+	rotated := signJWT(t, rotatedKey, "ES256", "rotated", claims(nil))
+	if err := auth.verify(rotated); err == nil {
+		t.Error("the key set was fetched again before jwksMinRefresh")
+	}
+	auth.mu.Lock()
+	auth.fetched = time.Now().Add(-jwksMinRefresh)
+	auth.mu.Unlock()
+	if err := auth.verify(rotated); err != nil {
+		t.Errorf("a token signed with a rotated key was rejected: %v", err)
+	}
+	if jwks.fetches != 2 {
+		t.Errorf("fetched the key set %d times, want twice", jwks.fetches)
+	}
+}
+
+// unsignedJWT returns a token with alg none and an empty signature
+func unsignedJWT(t *testing.T, claims map[string]interface{}) string {
+	t.Helper()
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","kid":"rsa"}`))
+	body, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return header + "." + base64.RawURLEncoding.EncodeToString(body) + "."
+}
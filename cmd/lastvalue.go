@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// quality flags of last values
+const (
+	// qualityAnomaly is a value tagged as an outlier
+	qualityAnomaly = "anomaly"
+	// qualityLate is a value written to the backfill measurements
+	qualityLate = "late"
+	// qualityConverted is a value converted to the canonical unit of its
+	// quantity
+	qualityConverted = "converted"
+)
+
+// lastValue is the newest value written for a resource of a device
+type lastValue struct {
+	Device   string      `json:"device"`
+	Resource string      `json:"resource"`
+	Value    interface{} `json:"value"`
+	Time     time.Time   `json:"time"`
+	Flags    []string    `json:"flags,omitempty"`
+}
+
+// lastValues caches the newest value written for every resource of every
+// device, so that dashboards can show current values without querying influx
+// for every refresh
+type lastValues struct {
+	// maxSeries bounds how many resources values are kept for, values of
+	// new ones are not kept once it's reached
+	maxSeries int
+
+	mu      sync.Mutex
+	series  int
+	devices map[string]map[string]lastValue
+}
+
+func newLastValues(maxSeries int) *lastValues {
+	return &lastValues{
+		maxSeries: maxSeries,
+		devices:   make(map[string]map[string]lastValue),
+	}
+}
+
+// record keeps the values written unless newer ones were
+func (l *lastValues) record(values []lastValue) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, v := range values {
+		resources, ok := l.devices[v.Device]
+		if !ok {
+			resources = make(map[string]lastValue)
+			l.devices[v.Device] = resources
+		}
+		last, ok := resources[v.Resource]
+		if !ok {
+			if l.series >= l.maxSeries {
+				continue
+			}
+			l.series++
+		} else if last.Time.After(v.Time) {
+			continue
+		}
+		resources[v.Resource] = v
+	}
+}
+
+// values returns the last values of the device's resources, or of every
+// device if device is empty, sorted by device and resource
+func (l *lastValues) values(device, resource string) []lastValue {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	values := []lastValue{}
+	for d, resources := range l.devices {
+		if device != "" && d != device {
+			continue
+		}
+		for res, v := range resources {
+			if resource != "" && res != resource {
+				continue
+			}
+			values = append(values, v)
+		}
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if values[i].Device != values[j].Device {
+			return values[i].Device < values[j].Device
+		}
+		return values[i].Resource < values[j].Resource
+	})
+	return values
+}
+
+// lastHandler serves /api/v1/last?device=X&resource=Y with the last values
+// of every resource, optionally only of the device or resource
+func (l *lastValues) lastHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(l.values(q.Get("device"), q.Get("resource"))); err != nil {
+		writeProblem(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// forget drops the last values of the device
+func (l *lastValues) forget(device string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.series -= len(l.devices[device])
+	delete(l.devices, device)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// measurementLayout is how readings are arranged into measurements
+type measurementLayout int
+
+const (
+	// perDeviceLayout writes a measurement per device with a field per
+	// resource
+	perDeviceLayout measurementLayout = iota
+	// perResourceLayout writes a measurement per resource with the device as
+	// a tag and the reading in a "value" field
+	perResourceLayout
+)
+
+// deviceTag and valueField are the tag and field used by perResourceLayout
+const (
+	deviceTag  = "device"
+	valueField = "value"
+)
+
+func parseMeasurementLayout(s string) (measurementLayout, error) {
+	switch s {
+	case "per-device":
+		return perDeviceLayout, nil
+	case "per-resource":
+		return perResourceLayout, nil
+	}
+	return 0, fmt.Errorf("invalid measurement layout %q, must be one of \"per-device\" or \"per-resource\"", s)
+}
+
+// point returns the measurement and field to write a reading of the device's
+// resource to, adding the tags it needs to tags
+func (l measurementLayout) point(device, resource string, tags map[string]string) (measurement, field string) {
+	if l == perResourceLayout {
+		tags[deviceTag] = device
+		return resource, valueField
+	}
+	return device, resource
+}
+
+// query returns the field to select and the measurement to select it from to
+// query the series of the device's resource, along with the condition
+// selecting the device's series in the measurement, if the layout needs one
+func (l measurementLayout) query(device, resource string) (field, measurement, condition string) {
+	if l == perResourceLayout {
+		return quoteIdentifier(valueField), quoteIdentifier(resource),
+			fmt.Sprintf("%s = %s", quoteIdentifier(deviceTag), quoteLiteral(device))
+	}
+	return quoteIdentifier(resource), quoteIdentifier(device), ""
+}
+
+// quoteLiteral quotes an InfluxQL string literal such as a tag value
+func quoteLiteral(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `'`, `\'`, -1)
+	return `'` + s + `'`
+}
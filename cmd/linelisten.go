@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+	influx "github.com/influxdata/influxdb1-client/v2"
+)
+
+const (
+	// maxLineBatch is the default of the most lines read from a TCP
+	// connection before they are written out, even if more are immediately
+	// available
+	maxLineBatch = 5000
+	// maxLineBatchBytes is the most bytes of lines read from a TCP connection
+	// before they are written out, whatever the number of lines
+	maxLineBatchBytes = 1024 * 1024
+	// maxLineProtocolLine is the longest line read from a TCP connection
+	maxLineProtocolLine = 64 * 1024
+	// queuedLines is the most lines read ahead from a TCP connection while
+	// a batch is being written
+	queuedLines = 64
+	// lineRetryBackoff is the first wait before retrying a batch from a TCP
+	// connection that failed to be written, doubling after every failure up
+	// to lineRetryMaxBackoff
+	lineRetryBackoff    = time.Second
+	lineRetryMaxBackoff = 30 * time.Second
+	// lineRetryTimeout is how long a batch from a TCP connection is retried
+	// for before it's dropped
+	lineRetryTimeout = 5 * time.Minute
+	// maxUDPPacket is the largest UDP datagram we can receive
+	maxUDPPacket = 64 * 1024
+)
+
+// listenLineProtocolTCP accepts TCP connections on addr that send newline
+// separated line protocol, like the socket_writer output of Telegraf
+func listenLineProtocolTCP(lc logger.LoggingClient, lw *lineProtocolWriter, addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				lc.Error(fmt.Sprintf("line protocol listener on %s stopped: %s", addr, err))
+				return
+			}
+			go serveLineProtocolConn(lc, lw, conn)
+		}
+	}()
+
+	return nil
+}
+
+// serveLineProtocolConn reads lines from the connection until it's closed,
+// writing them out whenever no more input is immediately available, or once
+// the flush interval has passed when tuning. Lines longer than
+// maxLineProtocolLine close the connection.
+func serveLineProtocolConn(lc logger.LoggingClient, lw *lineProtocolWriter, conn net.Conn) {
+	defer conn.Close()
+
+	// lines are read in the background so that partial batches can be
+	// flushed on time, reading stops while the lines read aren't written
+	lines := make(chan []byte, queuedLines)
+	var readErr error
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(conn)
+		scanner.Buffer(make([]byte, 0, 4096), maxLineProtocolLine)
+		for scanner.Scan() {
+			lines <- append([]byte(nil), scanner.Bytes()...)
+		}
+		readErr = scanner.Err()
+	}()
+
+	var batch []byte
+	n := 0
+	var linger *time.Timer
+	var lingered <-chan time.Time
+	flush := func() {
+		if linger != nil {
+			linger.Stop()
+			linger, lingered = nil, nil
+		}
+		if bp := lw.parseLines(lc, sourceTCP, conn.RemoteAddr(), batch); bp != nil {
+			lw.writeRetrying(lc, conn.RemoteAddr(), bp)
+		}
+		batch = batch[:0]
+		n = 0
+		// stop reading while ingestion is paused or memory is low, so that
+		// the sender slows down
+		lw.controls.waitUntilResumed(sourceTCP)
+		lw.mem.waitUntilResumed()
+	}
+
+	for {
+		select {
+		case line, more := <-lines:
+			if !more {
+				if len(batch) > 0 {
+					flush()
+				}
+				if readErr != nil {
+					lc.Warn(fmt.Sprintf("error reading line protocol from %s: %s", conn.RemoteAddr(), readErr))
+				}
+				return
+			}
+			batch = append(batch, line...)
+			batch = append(batch, '\n')
+			n++
+
+			switch {
+			case n >= lw.mem.batchLimit(lw.tuner.batchSize(lw.maxBatch)),
+				len(batch) >= lw.mem.batchLimit(maxLineBatchBytes):
+				flush()
+			case len(lines) != 0 || linger != nil:
+				// more lines are coming, or the batch is already waiting
+				// for them
+			case lw.tuner.flushInterval() == 0:
+				flush()
+			default:
+				linger = time.NewTimer(lw.tuner.flushInterval())
+				lingered = linger.C
+			}
+		case <-lingered:
+			linger, lingered = nil, nil
+			flush()
+		}
+	}
+}
+
+// listenLineProtocolUDP receives line protocol on addr, where every datagram
+// is written as its own batch
+func listenLineProtocolUDP(lc logger.LoggingClient, lw *lineProtocolWriter, addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		buf := make([]byte, maxUDPPacket)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				lc.Error(fmt.Sprintf("line protocol listener on %s stopped: %s", addr, err))
+				return
+			}
+			if lw.controls.isPaused(sourceUDP) || lw.mem.isPaused() {
+				// datagrams can't be held back, so drop them while
+				// ingestion is paused or memory is low
+				continue
+			}
+			// datagrams that fail to be written are dropped too, as
+			// retrying would hold back the ones that follow
+			if bp := lw.parseLines(lc, sourceUDP, from, buf[:n]); bp != nil {
+				if err := lw.client.Write(bp); err != nil {
+					lc.Error(fmt.Sprintf("error writing line protocol from %s: %s", from, err))
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+// parseLines parses the lines that arrived through the source from addr into
+// a batch, dropping the invalid ones, or returns nil if there is nothing to
+// write
+func (lw *lineProtocolWriter) parseLines(lc logger.LoggingClient, source string, from net.Addr, data []byte) influx.BatchPoints {
+	if !lw.lease.isLeader() {
+		lc.Error(fmt.Sprintf("error writing line protocol from %s: %s", from, errStandby))
+		return nil
+	}
+	bp, err := lw.batch(source, data, nil, "", "", "", true)
+	if err != nil {
+		lc.Warn(fmt.Sprintf("dropping invalid line protocol from %s: %s", from, err))
+	}
+	if bp == nil || len(bp.Points()) == 0 {
+		return nil
+	}
+	return bp
+}
+
+// writeRetrying writes a batch read from a TCP connection, retrying it with
+// backoff while the write fails transiently, for up to lineRetryTimeout.
+// Nothing more is read from the connection meanwhile, so that the sender
+// holds on to its points.
+func (lw *lineProtocolWriter) writeRetrying(lc logger.LoggingClient, from net.Addr, bp influx.BatchPoints) {
+	backoff := lw.retryBackoff
+	if backoff == 0 {
+		backoff = lineRetryBackoff
+	}
+	deadline := time.Now().Add(lineRetryTimeout)
+	for {
+		start := time.Now()
+		err := lw.client.Write(bp)
+		if err == nil {
+			lw.tuner.observe(time.Since(start), false)
+			return
+		}
+		failure := classifyWriteError(err)
+		lw.tuner.observe(time.Since(start), !failure.permanent)
+		switch {
+		case failure.permanent:
+			// retrying would only fail the same way again
+			lc.Error(fmt.Sprintf("dropping line protocol from %s rejected by influx: %s", from, failure.message))
+			return
+		case !lw.lease.isLeader(), time.Now().Add(backoff).After(deadline):
+			lc.Error(fmt.Sprintf("dropping %d points of line protocol from %s that failed to be written: %s", len(bp.Points()), from, failure.message))
+			return
+		}
+		lc.Warn(fmt.Sprintf("error writing line protocol from %s, retrying in %s: %s", from, backoff, failure.message))
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > lineRetryMaxBackoff {
+			backoff = lineRetryMaxBackoff
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/anonymouse64/edgex-influx-proxy/pkg/testutil"
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+	influx "github.com/influxdata/influxdb1-client/v2"
+)
+
+// flakyClient fails the first writes before recording the next ones
+type flakyClient struct {
+	*testutil.RecordingClient
+	failures int
+	attempts int
+}
+
+func (c *flakyClient) Write(bp influx.BatchPoints) error {
+	c.attempts++
+	if c.attempts <= c.failures {
+		return errors.New("connection refused")
+	}
+	return c.RecordingClient.Write(bp)
+}
+
+func TestLineProtocolConnRetriesValidLines(t *testing.T) {
+	client := &flakyClient{RecordingClient: &testutil.RecordingClient{}, failures: 2}
+	lw := &lineProtocolWriter{
+		client:       client,
+		ptConfig:     influx.BatchPointsConfig{Database: "edgex"},
+		maxBatch:     maxLineBatch,
+		retryBackoff: time.Millisecond,
+	}
+
+	server, sender := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		serveLineProtocolConn(logger.NewMockClient(), lw, server)
+	}()
+	if _, err := sender.Write([]byte("cpu,host=gw1 usage=12.5\nnot line protocol\nmem,host=gw1 used=42i\n")); err != nil {
+		t.Fatal(err)
+	}
+	sender.Close()
+	<-done
+
+	if client.attempts != 3 {
+		t.Errorf("tried writing %d times, want 3", client.attempts)
+	}
+	var names []string
+	for _, pt := range client.Points() {
+		names = append(names, pt.Name())
+	}
+	if len(names) != 2 || names[0] != "cpu" || names[1] != "mem" {
+		t.Errorf("wrote %v, want the valid lines [cpu mem]", names)
+	}
+}
+
+func TestLineProtocolConnDropsRejectedBatches(t *testing.T) {
+	client := &testutil.RecordingClient{Err: errors.New(`{"error":"field type conflict"}`)}
+	lw := &lineProtocolWriter{
+		client:       client,
+		ptConfig:     influx.BatchPointsConfig{Database: "edgex"},
+		maxBatch:     maxLineBatch,
+		retryBackoff: time.Hour,
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		lw.writeRetrying(logger.NewMockClient(), &net.TCPAddr{}, lw.parseLines(logger.NewMockClient(), sourceTCP, &net.TCPAddr{}, []byte("cpu usage=1")))
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("a batch influx rejected was retried")
+	}
+}
+
+func TestLineProtocolConnLineLimit(t *testing.T) {
+	client := &testutil.RecordingClient{}
+	lw := &lineProtocolWriter{client: client, ptConfig: influx.BatchPointsConfig{Database: "edgex"}, maxBatch: maxLineBatch}
+
+	server, sender := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		serveLineProtocolConn(logger.NewMockClient(), lw, server)
+	}()
+	long := make([]byte, maxLineProtocolLine+1)
+	for i := range long {
+		long[i] = 'x'
+	}
+	// the connection is closed without reading the rest of the line
+	sender.Write(long)
+	<-done
+	sender.Close()
+	if len(client.Points()) != 0 {
+		t.Errorf("wrote %d points of an overlong line", len(client.Points()))
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/influxdata/influxdb1-client/models"
+	influx "github.com/influxdata/influxdb1-client/v2"
+)
+
+// maxLineProtocolBody limits the size of a single /write request body
+const maxLineProtocolBody = 10 * 1024 * 1024
+
+// lineProtocolWriter writes points that arrive already encoded as InfluxDB
+// line protocol instead of as EdgeX events
+type lineProtocolWriter struct {
+	client   influx.Client
+	ptConfig influx.BatchPointsConfig
+	// tags are added to every point, overriding tags of the same name
+	tags map[string]string
+	// mem pauses writes and shrinks batches when memory runs low
+	mem *memoryGuard
+	// controls pause the TCP and UDP listeners
+	controls *ingestionControls
+	// lease rejects writes while this instance is a standby
+	lease *leaderLease
+	// sourceTag is the tag recording the path points arrived through, empty
+	// doesn't record it
+	sourceTag string
+	// headerTags maps request headers to the tags their values are added
+	// to the points posted to /write as
+	headerTags map[string]string
+	// databases are the databases points may be written to besides the
+	// configured one, as /write isn't authenticated and /relay is signed by
+	// every edge proxy alike
+	databases map[string]bool
+	// maxBatch is the most lines read from a TCP connection before they are
+	// written out
+	maxBatch int
+	// tuner adapts the batch size and flush interval of TCP connections to
+	// the write latency, nil writes maxBatch lines at most as soon as no more
+	// are available
+	tuner *writeTuner
+	// retryBackoff is the first wait before retrying a batch from a TCP
+	// connection, zero waits lineRetryBackoff
+	retryBackoff time.Duration
+}
+
+// parseTags parses a comma separated list of key=value pairs such as
+// "site=factory,gateway=gw1"
+func parseTags(s string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("invalid tag %q, must be key=value", pair)
+		}
+		tags[kv[0]] = kv[1]
+	}
+	return tags, nil
+}
+
+// write parses the line protocol that arrived through source in data and
+// writes it to the database and retention policy, using the configured ones
+// if empty, with the extra tags added. Points without a timestamp get the
+// current time. Any invalid line fails the whole write.
+func (lw *lineProtocolWriter) write(source string, data []byte, extraTags map[string]string, database, retentionPolicy, precision string) error {
+	if !lw.lease.isLeader() {
+		return errStandby
+	}
+	bp, err := lw.batch(source, data, extraTags, database, retentionPolicy, precision, false)
+	if err != nil {
+		return err
+	}
+	return lw.client.Write(bp)
+}
+
+// batch parses the line protocol that arrived through source in data into a
+// batch for the database and retention policy, like write. With partial, the
+// invalid lines are left out of the batch of the valid ones and reported in
+// the *lineProtocolError returned along with it, otherwise they fail the
+// whole batch.
+func (lw *lineProtocolWriter) batch(source string, data []byte, extraTags map[string]string, database, retentionPolicy, precision string, partial bool) (influx.BatchPoints, error) {
+	if database != "" && database != lw.ptConfig.Database && !lw.databases[database] {
+		return nil, &databaseNotAllowedError{database}
+	}
+	if precision == "" {
+		precision = "ns"
+	}
+	pts, parseErr := models.ParsePointsWithPrecision(data, time.Now().UTC(), precision)
+	if parseErr != nil && !partial {
+		return nil, &lineProtocolError{parseErr}
+	}
+
+	conf := lw.ptConfig
+	conf.Precision = precision
+	if database != "" {
+		conf.Database = database
+	}
+	if retentionPolicy != "" {
+		conf.RetentionPolicy = retentionPolicy
+	}
+	bp, err := influx.NewBatchPoints(conf)
+	if err != nil {
+		return nil, &lineProtocolError{err}
+	}
+	for _, pt := range pts {
+		for k, v := range extraTags {
+			pt.AddTag(k, v)
+		}
+		for k, v := range lw.tags {
+			pt.AddTag(k, v)
+		}
+		// points relayed by edge proxies keep the source they arrived at
+		// the edge through
+		if lw.sourceTag != "" && !pt.HasTag([]byte(lw.sourceTag)) {
+			pt.AddTag(lw.sourceTag, source)
+		}
+		bp.AddPoint(influx.NewPointFrom(pt))
+	}
+
+	if parseErr != nil {
+		return bp, &lineProtocolError{parseErr}
+	}
+	return bp, nil
+}
+
+// lineProtocolError is returned by write when the request itself is invalid,
+// as opposed to the write to InfluxDB failing
+type lineProtocolError struct {
+	err error
+}
+
+func (e *lineProtocolError) Error() string {
+	return e.err.Error()
+}
+
+// databaseNotAllowedError is returned by write for databases other than the
+// configured one that aren't allowed either
+type databaseNotAllowedError struct {
+	database string
+}
+
+func (e *databaseNotAllowedError) Error() string {
+	return fmt.Sprintf("writing to database %q is not allowed", e.database)
+}
+
+// writeHandler serves /write, which mimics the InfluxDB 1.x endpoint of the
+// same name so that anything speaking line protocol can be pointed at the
+// proxy instead
+func (lw *lineProtocolWriter) writeHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxLineProtocolBody))
+	if err != nil {
+		writeInfluxProblem(w, r, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// tag the points with the values of the mapped headers, such as the
+	// gateway they came from
+	var extraTags map[string]string
+	for header, tag := range lw.headerTags {
+		if v := r.Header.Get(header); v != "" {
+			if extraTags == nil {
+				extraTags = make(map[string]string)
+			}
+			extraTags[tag] = v
+		}
+	}
+
+	query := r.URL.Query()
+	err = lw.write(sourceWrite, body, extraTags, query.Get("db"), query.Get("rp"), query.Get("precision"))
+	if err != nil {
+		switch err.(type) {
+		case *lineProtocolError:
+			writeInfluxProblem(w, r, err.Error(), http.StatusBadRequest)
+			return
+		case *databaseNotAllowedError:
+			writeInfluxProblem(w, r, err.Error(), http.StatusForbidden)
+			return
+		}
+		failure := classifyWriteError(err)
+		if failure.permanent {
+			writeInfluxProblem(w, r, failure.message, http.StatusBadRequest)
+			return
+		}
+		writeInfluxProblem(w, r, failure.message, http.StatusServiceUnavailable)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
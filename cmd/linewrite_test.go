@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/anonymouse64/edgex-influx-proxy/pkg/testutil"
+	influx "github.com/influxdata/influxdb1-client/v2"
+)
+
+func TestLineProtocolDatabases(t *testing.T) {
+	client := &testutil.RecordingClient{}
+	lw := &lineProtocolWriter{
+		client:    client,
+		ptConfig:  influx.BatchPointsConfig{Database: "edgex"},
+		databases: map[string]bool{"telegraf": true},
+	}
+
+	for _, tc := range []struct {
+		database string
+		status   int
+	}{
+		{"", http.StatusNoContent},
+		{"edgex", http.StatusNoContent},
+		{"telegraf", http.StatusNoContent},
+		{"_internal", http.StatusForbidden},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/write?db="+tc.database, strings.NewReader("cpu usage=1"))
+		rec := httptest.NewRecorder()
+		lw.writeHandler(rec, req)
+		if rec.Code != tc.status {
+			t.Errorf("db=%q: got status %d, want %d", tc.database, rec.Code, tc.status)
+		}
+	}
+	if n := len(client.Batches()); n != 3 {
+		t.Errorf("wrote %d batches, want 3", n)
+	}
+
+	// every writer of line protocol goes through the same check
+	if _, ok := lw.write(sourceTCP, []byte("cpu usage=1"), nil, "_internal", "", "").(*databaseNotAllowedError); !ok {
+		t.Error("a write to a database that isn't allowed wasn't rejected")
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat is used in the names of rotated log files
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// rotatingFile is a log file that is rotated once it grows past maxSize,
+// keeping at most maxBackups old files that are younger than maxAge
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	maxAge     time.Duration
+	compress   bool
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func newRotatingFile(path string, maxSize int64, maxBackups int, maxAge time.Duration, compress bool) (*rotatingFile, error) {
+	rf := &rotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+		maxAge:     maxAge,
+		compress:   compress,
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+// open opens the log file for appending, the lock must be held
+func (rf *rotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.file = f
+	rf.size = info.Size()
+	return nil
+}
+
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.maxSize > 0 && rf.size+int64(len(p)) > rf.maxSize && rf.size > 0 {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// rotate moves the current file aside and starts a new one, the lock must be
+// held
+func (rf *rotatingFile) rotate() error {
+	if err := rf.file.Close(); err != nil {
+		return err
+	}
+
+	ext := filepath.Ext(rf.path)
+	backup := fmt.Sprintf("%s-%s%s", strings.TrimSuffix(rf.path, ext), time.Now().UTC().Format(backupTimeFormat), ext)
+	if err := os.Rename(rf.path, backup); err != nil {
+		return err
+	}
+	if err := rf.open(); err != nil {
+		return err
+	}
+
+	// compressing and removing old files can take a while, so don't hold up
+	// the writers for it
+	go rf.cleanup(backup)
+	return nil
+}
+
+// cleanup compresses the just rotated backup if needed and removes the
+// backups that are too many or too old
+func (rf *rotatingFile) cleanup(backup string) {
+	if rf.compress {
+		if err := gzipFile(backup); err == nil {
+			os.Remove(backup)
+		}
+	}
+
+	ext := filepath.Ext(rf.path)
+	backups, err := filepath.Glob(strings.TrimSuffix(rf.path, ext) + "-*" + ext + "*")
+	if err != nil {
+		return
+	}
+	// the timestamp format sorts chronologically, newest last
+	sort.Strings(backups)
+	for i, name := range backups {
+		tooMany := rf.maxBackups > 0 && i < len(backups)-rf.maxBackups
+		tooOld := false
+		if rf.maxAge > 0 {
+			if info, err := os.Stat(name); err == nil {
+				tooOld = time.Since(info.ModTime()) > rf.maxAge
+			}
+		}
+		if tooMany || tooOld {
+			os.Remove(name)
+		}
+	}
+}
+
+// gzipFile writes a gzip compressed copy of the file next to it
+func gzipFile(name string) error {
+	in, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(name+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		out.Close()
+		os.Remove(name + ".gz")
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		out.Close()
+		os.Remove(name + ".gz")
+		return err
+	}
+	return out.Close()
+}
+
+// teeWriter writes everything to the primary writer, and also to the
+// secondary writer for as long as that works - so that a full disk doesn't
+// stop logging to the console. It never fails, since whatever is copying to it
+// must keep going or the processes writing logs would block.
+type teeWriter struct {
+	primary   io.Writer
+	secondary io.Writer
+	failed    bool
+}
+
+func (t *teeWriter) Write(p []byte) (int, error) {
+	if !t.failed {
+		if _, err := t.secondary.Write(p); err != nil {
+			t.failed = true
+			fmt.Fprintf(t.primary, "error writing log file, no longer logging to it: %v\n", err)
+		}
+	}
+	t.primary.Write(p)
+	return len(p), nil
+}
+
+// setupLogFile copies everything written to stdout and stderr to a rotated log
+// file at path, configured by the LogFile* application settings
+func setupLogFile(appSettings map[string]string, path string) error {
+	maxSizeMB, err := uintSetting(appSettings, "LogFileMaxSize", 100)
+	if err != nil {
+		return err
+	}
+	maxBackups, err := uintSetting(appSettings, "LogFileMaxBackups", 5)
+	if err != nil {
+		return err
+	}
+	maxAge, err := durationSetting(appSettings, "LogFileMaxAge", 0)
+	if err != nil {
+		return err
+	}
+	compress, err := boolSetting(appSettings, "LogFileCompress", false)
+	if err != nil {
+		return err
+	}
+
+	rf, err := newRotatingFile(path, int64(maxSizeMB)*1024*1024, int(maxBackups), maxAge, compress)
+	if err != nil {
+		return err
+	}
+	if err := teeFd(int(os.Stdout.Fd()), rf); err != nil {
+		return err
+	}
+	return teeFd(int(os.Stderr.Fd()), rf)
+}
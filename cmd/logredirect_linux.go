@@ -0,0 +1,32 @@
+package main
+
+import (
+	"io"
+	"os"
+	"syscall"
+)
+
+// teeFd makes everything written to the file descriptor, such as stdout, also
+// go to w. This works at the file descriptor level so that it also captures
+// the output of loggers that were created before it was called.
+func teeFd(fd int, w io.Writer) error {
+	orig, err := syscall.Dup(fd)
+	if err != nil {
+		return err
+	}
+	r, pw, err := os.Pipe()
+	if err != nil {
+		syscall.Close(orig)
+		return err
+	}
+	if err := syscall.Dup3(int(pw.Fd()), fd, 0); err != nil {
+		syscall.Close(orig)
+		r.Close()
+		pw.Close()
+		return err
+	}
+
+	tee := &teeWriter{primary: os.NewFile(uintptr(orig), "orig"), secondary: w}
+	go io.Copy(tee, r)
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+func teeFd(fd int, w io.Writer) error {
+	return errors.New("logging to a file is only supported on linux")
+}
@@ -3,17 +3,26 @@ package main
 import (
 	"encoding/base64"
 	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"math"
+	"net/http"
 	"os"
+	"path"
+	"runtime"
+	"runtime/debug"
 	"strconv"
 	"strings"
 	"time"
 
+	edgexinfluxproxy "github.com/anonymouse64/edgex-influx-proxy"
 	"github.com/edgexfoundry/app-functions-sdk-go/appcontext"
 	"github.com/edgexfoundry/app-functions-sdk-go/appsdk"
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/notifications"
 	"github.com/edgexfoundry/go-mod-core-contracts/models"
 	influx "github.com/influxdata/influxdb1-client/v2"
 )
@@ -24,18 +33,229 @@ const (
 )
 
 func main() {
+	// replay-file runs saved events through the pipeline and exits instead of
+	// running the service
+	var replay *replayOptions
+	if len(os.Args) > 1 && os.Args[1] == "replay-file" {
+		var err error
+		replay, err = parseReplayArgs(os.Args[2:])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitUsage)
+		}
+		os.Args = append(os.Args[:1], replay.sdkArgs...)
+	}
+
+	// soak-test runs simulated events through the pipeline offline like
+	// replay-file and exits, failing if the heap or goroutines keep growing
+	var soakTest *soakOptions
+	if len(os.Args) > 1 && os.Args[1] == "soak-test" {
+		var err error
+		soakTest, err = parseSoakArgs(os.Args[2:])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitUsage)
+		}
+		replay = &replayOptions{}
+		os.Args = append(os.Args[:1], soakTest.sdkArgs...)
+	}
+
+	// import-csv runs the rows of CSV files through the pipeline offline like
+	// replay-file and exits, to backfill historical data
+	var csvImport *csvImportOptions
+	if len(os.Args) > 1 && os.Args[1] == "import-csv" {
+		var err error
+		csvImport, err = parseCSVImportArgs(os.Args[2:])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitUsage)
+		}
+		replay = &replayOptions{dryRun: csvImport.dryRun}
+		os.Args = append(os.Args[:1], csvImport.sdkArgs...)
+	}
+
+	// --read-only starts with ingestion from all sources paused, for
+	// maintenance windows, it is removed before the SDK parses the arguments
+	readOnly := false
+	for i := 1; i < len(os.Args); i++ {
+		if os.Args[i] == "--read-only" || os.Args[i] == "-read-only" {
+			readOnly = true
+			os.Args = append(os.Args[:i], os.Args[i+1:]...)
+			break
+		}
+	}
+
+	// --bootstrap-url fetches the configuration on the first start, for
+	// zero-touch provisioning, its arguments are removed before the SDK
+	// parses the arguments
+	bootstrap, sdkArgs, bootstrapErr := parseBootstrapArgs(os.Args[1:])
+	if bootstrapErr != nil {
+		fmt.Fprintln(os.Stderr, bootstrapErr)
+		os.Exit(exitUsage)
+	}
+	if bootstrap != nil {
+		os.Args = append(os.Args[:1], sdkArgs...)
+		path := sdkConfigPath(sdkArgs)
+		written, err := bootstrap.bootstrap(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to bootstrap the configuration: %v\n", err)
+			os.Exit(exitCode(err, exitFailure))
+		}
+		if written {
+			fmt.Fprintf(os.Stderr, "bootstrapped the configuration to %s from %s\n", path, bootstrap.url)
+		}
+	}
+
+	// gen-fixtures writes representative events to replay and exits
+	if len(os.Args) > 1 && os.Args[1] == "gen-fixtures" {
+		if err := genFixtures(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitFailure)
+		}
+		os.Exit(0)
+	}
+
+	// admin pause and resume ask a running instance to pause or resume
+	// ingestion from a source and exit
+	if len(os.Args) > 2 && os.Args[1] == "admin" && (os.Args[2] == "pause" || os.Args[2] == "resume") {
+		if err := runIngestionCommand(os.Args[2], os.Args[3:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitCode(err, exitFailure))
+		}
+		os.Exit(0)
+	}
+
+	// admin remap rewrites the points of a database under the current
+	// mapping rules and exits instead of running the service, admin
+	// dedupe-series does the same dropping the id tag
+	var remap *remapOptions
+	var verify *verifyOptions
+	if len(os.Args) > 2 && os.Args[1] == "admin" && (os.Args[2] == "remap" || os.Args[2] == "dedupe-series") {
+		var err error
+		if os.Args[2] == "remap" {
+			remap, err = parseRemapArgs(os.Args[3:])
+		} else {
+			remap, err = parseDedupeArgs(os.Args[3:])
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitUsage)
+		}
+		os.Args = append(os.Args[:1], remap.sdkArgs...)
+	}
+
+	// admin verify-delivery compares the delivery audit with the points in
+	// influx and exits instead of running the service
+	if len(os.Args) > 2 && os.Args[1] == "admin" && os.Args[2] == "verify-delivery" {
+		var err error
+		verify, err = parseVerifyArgs(os.Args[3:])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitUsage)
+		}
+		os.Args = append(os.Args[:1], verify.sdkArgs...)
+	}
+
 	// create the SDK with the service key
 	edgexSdk := &appsdk.AppFunctionsSDK{ServiceKey: serviceKey}
 	err := edgexSdk.Initialize()
 	if err != nil {
 		edgexSdk.LoggingClient.Error(fmt.Sprintf("SDK initialization failed: %v\n", err))
-		os.Exit(-1)
+		os.Exit(exitConfig)
 	}
 
 	// get the app service configuration
 	influxConfig := influx.HTTPConfig{}
+	influxReadConfig := influx.HTTPConfig{}
 	ptConfig := influx.BatchPointsConfig{}
+	var breaker *circuitBreaker
+	var anomalies *anomalyPolicy
+	var floats *binaryFloats
+	var detector *zScoreDetector
+	var quota *quotas
+	var lineProtocolEnabled bool
+	var opcuaEnabled bool
+	var deploymentTags map[string]string
+	var sourceTag string
+	var headerTags map[string]string
+	var lineProtocolDatabases map[string]bool
+	var readingIDTag bool
+	var lineProtocolTCPAddr, lineProtocolUDPAddr string
+	var statsdAddr string
+	var statsdInterval time.Duration
+	var statsdMaxPending, statsdMaxSeries, statsdMaxValues uint64
+	var statsdGaugeExpiry time.Duration
+	var relayURL, relaySecret, relayCAFile string
+	var adminAuthFunc adminAuth
+	var capture *capturer
+	var captureCipher *atRestCipher
+	var dead *deadLetters
+	var typing *typingDecisions
+	var lasts *lastValues
+	var tagCheck *tagValidator
+	var lease *leaderLease
+	var part *partition
+	var layout measurementLayout
+	var highWaterMarkFile string
+	var highWaterMarkInterval time.Duration
+	var origins *originPolicy
+	var backfill *backfillRouting
+	var mem *memoryGuard
+	var createDatabase bool
+	var notifier *failureNotifier
+	var advisorPeriod time.Duration
+	var advisorDiskBudgetMB uint64
+	var advisorApply bool
+	var faultInjection bool
+	var staleness *staleEvents
+	var chatter *chatterSuppression
+	var qualities *qualityMapping
+	var states *stateDurations
+	var histo *histograms
+	var ordering *seriesOrdering
+	var counts *counters
+	var unitsNormalizer *unitNormalizer
+	var waitFor []string
+	var diagnosticsDir string
+	var waitForInterval, waitForTimeout time.Duration
+	var auditInterval time.Duration
+	var ackURLs []string
+	var eventRouteEnabled, eventRouteSync bool
+	var eventRouteTimeout, eventRouteFallback time.Duration
+	var ackTimeout time.Duration
+	startupBanner, startupSummaryToInflux := true, false
+	var headers *responseHeaders
+	lineBatch := uint64(maxLineBatch)
+	var tuner *writeTuner
 	if appSettings := edgexSdk.ApplicationSettings(); appSettings != nil {
+		// preset the settings that aren't set from the hardware profile
+		var tuned []string
+		tuned, err = applyTuningProfile(appSettings)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(err.Error())
+			os.Exit(exitConfig)
+		}
+
+		// report every invalid combination of settings at once
+		if errs := validateSettings(appSettings); len(errs) != 0 {
+			for _, err := range errs {
+				edgexSdk.LoggingClient.Error(fmt.Sprintf("Invalid settings: %s", err))
+			}
+			os.Exit(exitConfig)
+		}
+
+		// keep a copy of all the logs in a rotated file if configured
+		if logFilePath := appSettings["LogFilePath"]; logFilePath != "" {
+			err = setupLogFile(appSettings, logFilePath)
+			if err != nil {
+				edgexSdk.LoggingClient.Error(fmt.Sprintf("unable to log to %s: %s", logFilePath, err))
+				os.Exit(exitFailure)
+			}
+		}
+		if len(tuned) != 0 {
+			edgexSdk.LoggingClient.Info(fmt.Sprintf("using the %s tuning profile for %s", appSettings["TuningProfile"], strings.Join(tuned, ", ")))
+		}
+
 		// check for the hostname, default to localhost
 		influxHost, ok := appSettings["InfluxDBHost"]
 		if !ok {
@@ -50,7 +270,7 @@ func main() {
 			influxPort, err = strconv.ParseUint(influxPortStr, 10, 64)
 			if err != nil || influxPort == 0 {
 				edgexSdk.LoggingClient.Error(fmt.Sprintf("Invalid \"InfluxDBPort\" setting of %s, must be integer greater than 0", influxPortStr))
-				os.Exit(-1)
+				os.Exit(exitConfig)
 			}
 		} else {
 			edgexSdk.LoggingClient.Info("missing value for \"InfluxDBPort\", defaulting to 8086")
@@ -76,129 +296,1863 @@ func main() {
 			influxConfig.Password = influxPassword
 		}
 
+		// queries use separate credentials if they are specified, so that
+		// read-only users of the HTTP API can't be used to write
+		influxReadConfig = influxConfig
+		influxReadUser, ok := appSettings["InfluxDBReadUsername"]
+		if ok && influxReadUser != "" {
+			influxReadConfig.Username = influxReadUser
+			influxReadConfig.Password = appSettings["InfluxDBReadPassword"]
+		}
+
 		// require the database name to insert to
 		ptConfig.Database, ok = appSettings["InfluxDBDatabaseName"]
 		if !ok {
 			edgexSdk.LoggingClient.Error("missing value for \"InfluxDBDatabaseName\"")
-			os.Exit(-1)
+			os.Exit(exitConfig)
 		}
 
 		// require the database precision to use for the database
 		ptConfig.Precision, ok = appSettings["InfluxDBDatabasePrecision"]
 		if !ok {
 			edgexSdk.LoggingClient.Error("missing value for \"InfluxDBDatabasePrecision\"")
-			os.Exit(-1)
+			os.Exit(exitConfig)
+		}
+
+		// the circuit breaker is only enabled if at least one of the limits
+		// is set
+		maxFailures, err := uintSetting(appSettings, "CircuitBreakerMaxFailures", 0)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(err.Error())
+			os.Exit(exitConfig)
+		}
+		maxReadingNames, err := uintSetting(appSettings, "CircuitBreakerMaxReadingNames", 0)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(err.Error())
+			os.Exit(exitConfig)
+		}
+		readingNamesWindow, err := durationSetting(appSettings, "CircuitBreakerReadingNamesWindow", time.Hour)
+		if err != nil || readingNamesWindow == 0 {
+			edgexSdk.LoggingClient.Error(fmt.Sprintf("Invalid \"CircuitBreakerReadingNamesWindow\" setting of %s, must be a positive duration", appSettings["CircuitBreakerReadingNamesWindow"]))
+			os.Exit(exitConfig)
+		}
+		cooldown, err := durationSetting(appSettings, "CircuitBreakerCooldown", 5*time.Minute)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(err.Error())
+			os.Exit(exitConfig)
+		}
+		if maxFailures != 0 || maxReadingNames != 0 {
+			breaker = newCircuitBreaker(edgexSdk.LoggingClient, maxFailures, maxReadingNames, readingNamesWindow, cooldown)
+		}
+
+		// the byte order of base64 encoded floats
+		floats, err = newBinaryFloats(appSettings["BinaryFloatByteOrder"], appSettings["BinaryFloatByteOrderOverrides"])
+		if err != nil {
+			edgexSdk.LoggingClient.Error(fmt.Sprintf("Invalid \"BinaryFloatByteOrder\" or \"BinaryFloatByteOrderOverrides\" setting: %s", err))
+			os.Exit(exitConfig)
+		}
+
+		// what to do with readings that change type or have timestamps far
+		// from now
+		typeMismatch, err := anomalyActionSetting(appSettings, "AnomalyTypeMismatchAction", anomalyLog)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(err.Error())
+			os.Exit(exitConfig)
+		}
+		timestampSkew, err := anomalyActionSetting(appSettings, "AnomalyTimestampSkewAction", anomalyIgnore)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(err.Error())
+			os.Exit(exitConfig)
+		}
+		skewTolerance, err := durationSetting(appSettings, "AnomalyTimestampSkewTolerance", time.Hour)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(err.Error())
+			os.Exit(exitConfig)
+		}
+		// readings from devices that aren't known are only an anomaly if
+		// the known devices are listed, tenants can have their own action
+		// for them
+		unknownDevice, err := unknownDeviceActionSetting(appSettings, "AnomalyUnknownDeviceAction", anomalyLog)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(err.Error())
+			os.Exit(exitConfig)
+		}
+		tenantActions, err := parseTags(appSettings["AnomalyUnknownDeviceTenantActions"])
+		if err != nil {
+			edgexSdk.LoggingClient.Error(fmt.Sprintf("Invalid \"AnomalyUnknownDeviceTenantActions\" setting: %s", err))
+			os.Exit(exitConfig)
+		}
+		tenantUnknownDevice := make(map[string]anomalyAction)
+		for tenant, actionStr := range tenantActions {
+			tenantUnknownDevice[tenant], err = parseUnknownDeviceAction(actionStr)
+			if err != nil {
+				edgexSdk.LoggingClient.Error(fmt.Sprintf("Invalid \"AnomalyUnknownDeviceTenantActions\" setting for tenant %q: %s", tenant, err))
+				os.Exit(exitConfig)
+			}
+		}
+		knownDevices := splitList(appSettings["AnomalyKnownDevices"])
+		for _, pattern := range knownDevices {
+			if _, err := path.Match(pattern, ""); err != nil {
+				edgexSdk.LoggingClient.Error(fmt.Sprintf("Invalid \"AnomalyKnownDevices\" pattern %q: %s", pattern, err))
+				os.Exit(exitConfig)
+			}
+		}
+		anomalies = newAnomalyPolicy(typeMismatch, timestampSkew, skewTolerance, unknownDevice, knownDevices, appSettings["AnomalyTenantTag"], tenantUnknownDevice, floats)
+
+		// flagging outliers with a z-score is only enabled if a threshold is
+		// set
+		zScore, err := floatSetting(appSettings, "AnomalyDetectionZScore", 0)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(err.Error())
+			os.Exit(exitConfig)
+		}
+		alpha, err := floatSetting(appSettings, "AnomalyDetectionAlpha", 0.1)
+		if err != nil || alpha == 0 || alpha > 1 {
+			edgexSdk.LoggingClient.Error(fmt.Sprintf("Invalid \"AnomalyDetectionAlpha\" setting of %s, must be greater than 0 and at most 1", appSettings["AnomalyDetectionAlpha"]))
+			os.Exit(exitConfig)
+		}
+		minSamples, err := uintSetting(appSettings, "AnomalyDetectionMinSamples", 10)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(err.Error())
+			os.Exit(exitConfig)
+		}
+		if zScore != 0 {
+			detector = newZScoreDetector(zScore, alpha, minSamples, 100)
+		}
+
+		// limit how many points each device or tenant may write per minute
+		pointsPerMinute, err := uintSetting(appSettings, "QuotaPointsPerMinute", 0)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(err.Error())
+			os.Exit(exitConfig)
+		}
+		quotaModeStr := appSettings["QuotaMode"]
+		if quotaModeStr == "" {
+			quotaModeStr = "drop"
+		}
+		mode, err := parseQuotaMode(quotaModeStr)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(fmt.Sprintf("Invalid \"QuotaMode\" setting: %s", err))
+			os.Exit(exitConfig)
+		}
+		if pointsPerMinute != 0 {
+			quota = newQuotas(pointsPerMinute, mode, appSettings["QuotaTenantTag"])
+		}
+
+		// accepting raw line protocol is opt-in, as it lets anything that can
+		// reach the service write to the database
+		lineProtocolEnabled, err = boolSetting(appSettings, "LineProtocolWriteEnabled", false)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(err.Error())
+			os.Exit(exitConfig)
+		}
+		// accept EdgeX events posted to /edgex, optionally answering only
+		// once they are written
+		eventRouteEnabled, err = boolSetting(appSettings, "EdgeXRouteEnabled", false)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(err.Error())
+			os.Exit(exitConfig)
+		}
+		eventRouteSync, err = boolSetting(appSettings, "EdgeXRouteSyncWrites", false)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(err.Error())
+			os.Exit(exitConfig)
+		}
+		eventRouteTimeout, err = durationSetting(appSettings, "EdgeXRouteSyncTimeout", 10*time.Second)
+		if err != nil || eventRouteTimeout == 0 {
+			edgexSdk.LoggingClient.Error(fmt.Sprintf("Invalid \"EdgeXRouteSyncTimeout\" setting of %s, must be a positive duration", appSettings["EdgeXRouteSyncTimeout"]))
+			os.Exit(exitConfig)
+		}
+		// answer synchronous requests with an ID to query the write with once
+		// they take this long, instead of failing them at the timeout
+		eventRouteFallback, err = durationSetting(appSettings, "EdgeXRouteAsyncFallback", 0)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(err.Error())
+			os.Exit(exitConfig)
+		}
+		opcuaEnabled, err = boolSetting(appSettings, "OPCUAWriteEnabled", false)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(err.Error())
+			os.Exit(exitConfig)
+		}
+		lineProtocolTCPAddr = appSettings["LineProtocolListenTCP"]
+		lineProtocolUDPAddr = appSettings["LineProtocolListenUDP"]
+		statsdAddr = appSettings["StatsDListenUDP"]
+		statsdInterval, err = durationSetting(appSettings, "StatsDFlushInterval", 10*time.Second)
+		if err != nil || statsdInterval == 0 {
+			edgexSdk.LoggingClient.Error(fmt.Sprintf("Invalid \"StatsDFlushInterval\" setting of %s, must be a positive duration", appSettings["StatsDFlushInterval"]))
+			os.Exit(exitConfig)
+		}
+		statsdMaxPending, err = uintSetting(appSettings, "StatsDMaxPendingFlushes", 60)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(err.Error())
+			os.Exit(exitConfig)
+		}
+		statsdMaxSeries, err = uintSetting(appSettings, "StatsDMaxSeries", 10000)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(err.Error())
+			os.Exit(exitConfig)
+		}
+		statsdMaxValues, err = uintSetting(appSettings, "StatsDMaxValues", 1000)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(err.Error())
+			os.Exit(exitConfig)
+		}
+		statsdGaugeExpiry, err = durationSetting(appSettings, "StatsDGaugeExpiry", time.Hour)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(err.Error())
+			os.Exit(exitConfig)
+		}
+		// edge proxies relay to a central proxy instead of writing to
+		// influx, and central proxies accept relayed batches, both need the
+		// shared secret
+		relayURL = appSettings["RelayURL"]
+		relaySecret = appSettings["RelaySecret"]
+		relayCAFile = appSettings["RelayCAFile"]
+
+		// only write events while holding the lock file shared with other
+		// instances receiving the same events, replayed events are always
+		// written
+		if haLockFile := appSettings["HALockFile"]; haLockFile != "" && replay == nil {
+			haInterval, err := durationSetting(appSettings, "HAPollInterval", 5*time.Second)
+			if err != nil || haInterval == 0 {
+				edgexSdk.LoggingClient.Error(fmt.Sprintf("Invalid \"HAPollInterval\" setting of %s, must be a positive duration", appSettings["HAPollInterval"]))
+				os.Exit(exitConfig)
+			}
+			lease, err = startLeaderLease(edgexSdk.LoggingClient, haLockFile, haInterval, systemClock{})
+			if err != nil {
+				edgexSdk.LoggingClient.Error(fmt.Sprintf("unable to use %s for leader election: %s", haLockFile, err))
+				os.Exit(exitFailure)
+			}
+		}
+
+		// only write the share of devices hashed to this instance
+		partitionCount, err := uintSetting(appSettings, "PartitionCount", 0)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(err.Error())
+			os.Exit(exitConfig)
+		}
+		partitionIndex, err := uintSetting(appSettings, "PartitionIndex", 0)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(err.Error())
+			os.Exit(exitConfig)
+		}
+		if partitionCount > math.MaxInt32 {
+			edgexSdk.LoggingClient.Error(fmt.Sprintf("Invalid \"PartitionCount\" setting of %d, must be at most %d", partitionCount, math.MaxInt32))
+			os.Exit(exitConfig)
+		}
+		if partitionCount > 1 && replay == nil {
+			part = &partition{index: int32(partitionIndex), count: int32(partitionCount)}
+		}
+
+		// write a measurement per device or per resource
+		layoutStr := appSettings["MeasurementLayout"]
+		if layoutStr == "" {
+			layoutStr = "per-device"
+		}
+		layout, err = parseMeasurementLayout(layoutStr)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(fmt.Sprintf("Invalid \"MeasurementLayout\" setting: %s", err))
+			os.Exit(exitConfig)
+		}
+
+		// remember the newest reading written for each device across
+		// restarts
+		highWaterMarkFile = appSettings["HighWaterMarkFile"]
+
+		// count and checksum the readings written for every device in every
+		// interval to audit their delivery
+		auditInterval, err = durationSetting(appSettings, "DeliveryAuditInterval", 0)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(err.Error())
+			os.Exit(exitConfig)
+		}
+		// tell workflows when points are written
+		ackURLs = splitList(appSettings["AckWebhookURLs"])
+		ackTimeout, err = durationSetting(appSettings, "AckWebhookTimeout", 10*time.Second)
+		if err != nil || ackTimeout == 0 {
+			edgexSdk.LoggingClient.Error(fmt.Sprintf("Invalid \"AckWebhookTimeout\" setting of %s, must be a positive duration", appSettings["AckWebhookTimeout"]))
+			os.Exit(exitConfig)
+		}
+		highWaterMarkInterval, err = durationSetting(appSettings, "HighWaterMarkSaveInterval", 30*time.Second)
+		if err != nil || highWaterMarkInterval == 0 {
+			edgexSdk.LoggingClient.Error(fmt.Sprintf("Invalid \"HighWaterMarkSaveInterval\" setting of %s, must be a positive duration", appSettings["HighWaterMarkSaveInterval"]))
+			os.Exit(exitConfig)
+		}
+
+		// give readings without an origin a real time instead of the epoch
+		originStr := appSettings["ZeroOriginPolicy"]
+		if originStr == "" {
+			originStr = "keep"
+		}
+		originMode, err := parseOriginMode(originStr)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(fmt.Sprintf("Invalid \"ZeroOriginPolicy\" setting: %s", err))
+			os.Exit(exitConfig)
+		}
+		if originMode != originKeep {
+			origins = newOriginPolicy(originMode)
+		}
+
+		// write late readings to a separate retention policy or measurement
+		backfillAge, err := durationSetting(appSettings, "BackfillAge", 0)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(err.Error())
+			os.Exit(exitConfig)
+		}
+		if backfillAge != 0 {
+			backfill = &backfillRouting{
+				age:               backfillAge,
+				retentionPolicy:   appSettings["BackfillRetentionPolicy"],
+				measurementSuffix: appSettings["BackfillMeasurementSuffix"],
+			}
+		}
+
+		// tag every point with the id of its reading, which makes every
+		// reading its own series
+		readingIDTag, err = boolSetting(appSettings, "ReadingIDTag", true)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(err.Error())
+			os.Exit(exitConfig)
+		}
+
+		// record the path every point arrived through in this tag
+		sourceTag = appSettings["SourceTag"]
+
+		// keep the types chosen for values to debug them
+		typingWindow, err := durationSetting(appSettings, "TypingStatsWindow", time.Hour)
+		if err != nil || typingWindow < typeCountBuckets {
+			edgexSdk.LoggingClient.Error(fmt.Sprintf("Invalid \"TypingStatsWindow\" setting of %s, must be a positive duration", appSettings["TypingStatsWindow"]))
+			os.Exit(exitConfig)
+		}
+		typing = newTypingDecisions(typingWindow)
+
+		// cache the last value written for resources to serve dashboards
+		maxLastSeries, err := uintSetting(appSettings, "LastValueMaxSeries", 10000)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(err.Error())
+			os.Exit(exitConfig)
+		}
+		if maxLastSeries != 0 {
+			lasts = newLastValues(int(maxLastSeries))
+		}
+
+		// create the database again if it is dropped while running
+		createDatabase, err = boolSetting(appSettings, "InfluxDBCreateMissingDatabase", false)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(err.Error())
+			os.Exit(exitConfig)
+		}
+
+		// allow failing and delaying writes through the admin routes, only
+		// for testing
+		faultInjection, err = boolSetting(appSettings, "FaultInjectionEnabled", false)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(err.Error())
+			os.Exit(exitConfig)
+		}
+
+		// recommend shard group and retention durations for the observed
+		// load
+		advisorPeriod, err = durationSetting(appSettings, "AdvisorObservationPeriod", 0)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(err.Error())
+			os.Exit(exitConfig)
+		}
+		advisorDiskBudgetMB, err = uintSetting(appSettings, "AdvisorDiskBudgetMB", 0)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(err.Error())
+			os.Exit(exitConfig)
+		}
+		advisorApply, err = boolSetting(appSettings, "AdvisorApplyShardDuration", false)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(err.Error())
+			os.Exit(exitConfig)
+		}
+
+		// raise support-notifications alerts for events that can't be written
+		notifyAfter, err := uintSetting(appSettings, "NotifyAfterFailedWrites", 0)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(err.Error())
+			os.Exit(exitConfig)
+		}
+		if notifyAfter != 0 {
+			category, severity := appSettings["NotifyCategory"], appSettings["NotifySeverity"]
+			if category == "" {
+				category = string(notifications.SW_HEALTH)
+			}
+			if severity == "" {
+				severity = string(notifications.CRITICAL)
+			}
+			switch notifications.CategoryEnum(strings.ToUpper(category)) {
+			case notifications.SECURITY, notifications.HW_HEALTH, notifications.SW_HEALTH:
+			default:
+				edgexSdk.LoggingClient.Error(fmt.Sprintf("Invalid \"NotifyCategory\" setting of %s, must be one of SECURITY, HW_HEALTH or SW_HEALTH", category))
+				os.Exit(exitConfig)
+			}
+			switch notifications.SeverityEnum(strings.ToUpper(severity)) {
+			case notifications.CRITICAL, notifications.NORMAL:
+			default:
+				edgexSdk.LoggingClient.Error(fmt.Sprintf("Invalid \"NotifySeverity\" setting of %s, must be CRITICAL or NORMAL", severity))
+				os.Exit(exitConfig)
+			}
+			notifier = newFailureNotifier(notifyAfter, category, severity)
+		}
+
+		// write state changes and on-time of boolean resources
+		if resources := splitList(appSettings["StateDurationResources"]); len(resources) != 0 {
+			interval, err := durationSetting(appSettings, "StateDurationInterval", time.Minute)
+			if err != nil || interval == 0 {
+				edgexSdk.LoggingClient.Error(fmt.Sprintf("Invalid \"StateDurationInterval\" setting of %s, must be a positive duration", appSettings["StateDurationInterval"]))
+				os.Exit(exitConfig)
+			}
+			states = newStateDurations(resources, interval)
+		}
+
+		// summarize high-rate resources in a point per window
+		if resources := splitList(appSettings["HistogramResources"]); len(resources) != 0 {
+			window, err := durationSetting(appSettings, "HistogramWindow", time.Second)
+			if err != nil || window == 0 {
+				edgexSdk.LoggingClient.Error(fmt.Sprintf("Invalid \"HistogramWindow\" setting of %s, must be a positive duration", appSettings["HistogramWindow"]))
+				os.Exit(exitConfig)
+			}
+			bounds, err := parseHistogramBounds(appSettings["HistogramBuckets"])
+			if err != nil {
+				edgexSdk.LoggingClient.Error(fmt.Sprintf("Invalid \"HistogramBuckets\" setting: %s", err))
+				os.Exit(exitConfig)
+			}
+			raw := appSettings["HistogramRawSamples"]
+			if raw == "" {
+				raw = rawDrop
+			}
+			histo, err = newHistograms(resources, window, bounds, raw)
+			if err != nil {
+				edgexSdk.LoggingClient.Error(fmt.Sprintf("Invalid \"HistogramRawSamples\" setting: %s", err))
+				os.Exit(exitConfig)
+			}
+			histo.lc = edgexSdk.LoggingClient
+		}
+
+		// keep older readings from overwriting newer ones written at the same
+		// timestamp when the precision is coarse
+		orderedWrites, err := boolSetting(appSettings, "OrderedSeriesWrites", true)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(err.Error())
+			os.Exit(exitConfig)
+		}
+		if orderedWrites {
+			ordering = newSeriesOrdering(ptConfig.Precision)
+		}
+
+		// correct cumulative counters for resets and rollovers
+		if resources := splitList(appSettings["CounterResources"]); len(resources) != 0 {
+			counterMax, err := floatSetting(appSettings, "CounterRolloverMax", 0)
+			if err != nil {
+				edgexSdk.LoggingClient.Error(err.Error())
+				os.Exit(exitConfig)
+			}
+			counts = newCounters(resources, counterMax)
+		}
+
+		// convert the values of resources declared in different units to the
+		// canonical unit of their quantity
+		if appSettings["UnitResources"] != "" {
+			unitsNormalizer, err = newUnitNormalizer(appSettings["UnitResources"], appSettings["CanonicalUnits"])
+			if err != nil {
+				edgexSdk.LoggingClient.Error(fmt.Sprintf("Invalid \"UnitResources\" or \"CanonicalUnits\" setting: %s", err))
+				os.Exit(exitConfig)
+			}
+		}
+
+		// drop events older than MaxEventAge, or only count them for backfill
+		// routing to write them separately
+		maxEventAge, err := durationSetting(appSettings, "MaxEventAge", 0)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(err.Error())
+			os.Exit(exitConfig)
+		}
+		if maxEventAge != 0 {
+			staleness = newStaleEvents(maxEventAge)
+			switch appSettings["MaxEventAgeAction"] {
+			case "", "drop":
+			case "backfill":
+				staleness.keep = true
+			default:
+				edgexSdk.LoggingClient.Error(fmt.Sprintf("Invalid \"MaxEventAgeAction\" setting of %s, must be \"drop\" or \"backfill\"", appSettings["MaxEventAgeAction"]))
+				os.Exit(exitConfig)
+			}
+		}
+
+		// drop repeats of the same value from chattering sensors
+		chatterWindow, err := durationSetting(appSettings, "ChatterSuppressionWindow", 0)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(err.Error())
+			os.Exit(exitConfig)
+		}
+		if chatterWindow != 0 {
+			chatter = newChatterSuppression(chatterWindow)
+		}
+
+		// write the quality device services indicate in event tags, and drop
+		// readings of the qualities excluded
+		if tag := appSettings["QualityTag"]; tag != "" {
+			values, err := parseTags(appSettings["QualityValues"])
+			if err != nil {
+				edgexSdk.LoggingClient.Error(fmt.Sprintf("Invalid \"QualityValues\" setting: %s", err))
+				os.Exit(exitConfig)
+			}
+			var asField bool
+			switch appSettings["QualityWriteAs"] {
+			case "", "tag":
+			case "field":
+				asField = true
+			default:
+				edgexSdk.LoggingClient.Error(fmt.Sprintf("Invalid \"QualityWriteAs\" setting of %s, must be \"tag\" or \"field\"", appSettings["QualityWriteAs"]))
+				os.Exit(exitConfig)
+			}
+			qualities, err = newQualityMapping(tag, values, splitList(appSettings["QualityExclude"]), asField)
+			if err != nil {
+				edgexSdk.LoggingClient.Error(err.Error())
+				os.Exit(exitConfig)
+			}
+		}
+
+		// slow down ingestion instead of running out of memory
+		memoryBudgetMB, err := uintSetting(appSettings, "MemoryBudgetMB", 0)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(err.Error())
+			os.Exit(exitConfig)
+		}
+		if memoryBudgetMB != 0 {
+			mem = startMemoryGuard(edgexSdk.LoggingClient, memoryBudgetMB*1024*1024)
+		}
+
+		// trade memory for less time spent collecting garbage, '0' keeps the
+		// runtime's default or GOGC
+		gcPercent, err := uintSetting(appSettings, "GCPercent", 0)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(err.Error())
+			os.Exit(exitConfig)
+		}
+		if gcPercent != 0 {
+			debug.SetGCPercent(int(gcPercent))
+		}
+
+		lineBatch, err = uintSetting(appSettings, "LineProtocolMaxBatch", maxLineBatch)
+		if err != nil || lineBatch == 0 {
+			edgexSdk.LoggingClient.Error(fmt.Sprintf("Invalid \"LineProtocolMaxBatch\" setting of %s, must be a positive integer", appSettings["LineProtocolMaxBatch"]))
+			os.Exit(exitConfig)
+		}
+
+		// adapt the batches of line protocol read over TCP to the write
+		// latency, up to LineProtocolMaxBatch lines
+		autoTune, err := boolSetting(appSettings, "LineProtocolAutoTune", false)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(err.Error())
+			os.Exit(exitConfig)
+		}
+		if autoTune {
+			targetLatency, err := durationSetting(appSettings, "LineProtocolAutoTuneTargetLatency", 500*time.Millisecond)
+			if err != nil || targetLatency == 0 {
+				edgexSdk.LoggingClient.Error(fmt.Sprintf("Invalid \"LineProtocolAutoTuneTargetLatency\" setting of %s, must be a positive duration", appSettings["LineProtocolAutoTuneTargetLatency"]))
+				os.Exit(exitConfig)
+			}
+			minBatch, err := uintSetting(appSettings, "LineProtocolAutoTuneMinBatch", 100)
+			if err != nil || minBatch == 0 || minBatch > lineBatch {
+				edgexSdk.LoggingClient.Error(fmt.Sprintf("Invalid \"LineProtocolAutoTuneMinBatch\" setting of %s, must be a positive integer of at most \"LineProtocolMaxBatch\"", appSettings["LineProtocolAutoTuneMinBatch"]))
+				os.Exit(exitConfig)
+			}
+			maxInterval, err := durationSetting(appSettings, "LineProtocolAutoTuneMaxFlushInterval", time.Second)
+			if err != nil {
+				edgexSdk.LoggingClient.Error(err.Error())
+				os.Exit(exitConfig)
+			}
+			tuner = newWriteTuner(targetLatency, int(minBatch), int(lineBatch), maxInterval)
+		}
+
+		// wait for dependencies started at the same time to come up instead
+		// of failing to start
+		waitFor = splitList(appSettings["WaitFor"])
+		waitForInterval, err = durationSetting(appSettings, "WaitForInterval", 2*time.Second)
+		if err != nil || waitForInterval == 0 {
+			edgexSdk.LoggingClient.Error(fmt.Sprintf("Invalid \"WaitForInterval\" setting of %s, must be a positive duration", appSettings["WaitForInterval"]))
+			os.Exit(exitConfig)
+		}
+		waitForTimeout, err = durationSetting(appSettings, "WaitForTimeout", 5*time.Minute)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(err.Error())
+			os.Exit(exitConfig)
+		}
+
+		// write diagnostic dumps here on SIGUSR1
+		diagnosticsDir = appSettings["DiagnosticsDir"]
+
+		// log a summary of how the instance started, and write it to influx
+		startupBanner, err = boolSetting(appSettings, "StartupBanner", true)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(err.Error())
+			os.Exit(exitConfig)
+		}
+		startupSummaryToInflux, err = boolSetting(appSettings, "StartupSummaryToInflux", false)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(err.Error())
+			os.Exit(exitConfig)
+		}
+
+		// keep tag values from breaking line protocol
+		tagValueMaxLength, err := uintSetting(appSettings, "TagValueMaxLength", 256)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(err.Error())
+			os.Exit(exitConfig)
+		}
+		tagCheck = newTagValidator(edgexSdk.LoggingClient, int(tagValueMaxLength))
+
+		// add the configured headers to the responses of the routes
+		headers, err = parseResponseHeaders(appSettings["ResponseHeaders"], appSettings["RouteCacheControl"])
+		if err != nil {
+			edgexSdk.LoggingClient.Error(fmt.Sprintf("Invalid \"ResponseHeaders\" or \"RouteCacheControl\" setting: %s", err))
+			os.Exit(exitConfig)
+		}
+
+		// encrypt captured events at rest with a key from a file or the
+		// secret store
+		var captureKey []byte
+		switch {
+		case appSettings["CaptureEncryptionKeyFile"] != "":
+			captureKey, err = ioutil.ReadFile(appSettings["CaptureEncryptionKeyFile"])
+			if err != nil {
+				edgexSdk.LoggingClient.Error(fmt.Sprintf("unable to read the capture encryption key: %s", err))
+				os.Exit(exitConfig)
+			}
+		case appSettings["CaptureEncryptionSecretPath"] != "":
+			secrets, err := edgexSdk.GetSecrets(appSettings["CaptureEncryptionSecretPath"], atRestSecretKey)
+			if err != nil {
+				edgexSdk.LoggingClient.Error(fmt.Sprintf("unable to get the capture encryption key from the secret store: %s", err))
+				os.Exit(exitUnavailable)
+			}
+			captureKey = []byte(secrets[atRestSecretKey])
+		}
+		if captureKey != nil {
+			captureCipher, err = newAtRestCipher(captureKey)
+			if err != nil {
+				edgexSdk.LoggingClient.Error(fmt.Sprintf("Invalid capture encryption key: %s", err))
+				os.Exit(exitConfig)
+			}
+		}
+
+		// admin routes are only added when requests to them can be
+		// authenticated, with a static token or JWTs from an identity provider
+		switch {
+		case appSettings["AdminToken"] != "":
+			adminAuthFunc = staticTokenAuth(appSettings["AdminToken"])
+		case appSettings["AdminJWKSURL"] != "":
+			adminAuthFunc = newJWTAuth(appSettings["AdminJWKSURL"], appSettings["AdminJWTIssuer"], appSettings["AdminJWTAudience"]).verify
+		}
+		if adminAuthFunc != nil {
+			captureDir := appSettings["CaptureDir"]
+			if captureDir == "" {
+				captureDir = "captures"
+			}
+			capture = newCapturer(captureDir, captureCipher)
+		}
+		// keep the events that are refused instead of dropping them, sealed
+		// with the same key as captured events
+		if appSettings["DeadLetterDir"] != "" {
+			dead = newDeadLetters(appSettings["DeadLetterDir"], captureCipher)
+		}
+
+		deploymentTags, err = parseTags(appSettings["DeploymentTags"])
+		if err != nil {
+			edgexSdk.LoggingClient.Error(fmt.Sprintf("Invalid \"DeploymentTags\" setting: %s", err))
+			os.Exit(exitConfig)
+		}
+		headerTags, err = parseTags(appSettings["HeaderTags"])
+		if err != nil {
+			edgexSdk.LoggingClient.Error(fmt.Sprintf("Invalid \"HeaderTags\" setting: %s", err))
+			os.Exit(exitConfig)
+		}
+		lineProtocolDatabases = make(map[string]bool)
+		for _, db := range splitList(appSettings["LineProtocolDatabases"]) {
+			lineProtocolDatabases[db] = true
 		}
 	} else {
 		edgexSdk.LoggingClient.Error("No application settings found")
-		os.Exit(-1)
+		os.Exit(exitConfig)
 	}
 
-	// Make a new HTTP client connection to influxdb
-	influxClient, err := influx.NewHTTPClient(influxConfig)
+	// Make a new HTTP client connection to influxdb, or to the central proxy
+	// when relaying
+	var influxClient influx.Client
+	if relayURL != "" {
+		influxClient, err = newRelayClient(relayURL, []byte(relaySecret), relayCAFile, 30*time.Second)
+	} else {
+		influxClient, err = influx.NewHTTPClient(influxConfig)
+	}
 	if err != nil {
-		edgexSdk.LoggingClient.Error("No application settings found")
-		os.Exit(-1)
+		edgexSdk.LoggingClient.Error(fmt.Sprintf("unable to create influx client: %s", err))
+		os.Exit(exitConfig)
+	}
+	var missingDB *missingDatabaseClient
+	switch {
+	case soakTest != nil && !soakTest.write:
+		influxClient = &dryRunClient{w: ioutil.Discard}
+	case replay != nil && replay.dryRun:
+		influxClient = &dryRunClient{w: os.Stdout}
+	case relayURL == "":
+		// notice the database being dropped out from under us
+		missingDB = newMissingDatabaseClient(influxClient, edgexSdk.LoggingClient, createDatabase)
+		influxClient = missingDB
+	}
+	var faults *faultInjector
+	if faultInjection && adminAuthFunc != nil && replay == nil {
+		edgexSdk.LoggingClient.Warn("fault injection is enabled, writes can be failed and delayed through /admin/faults")
+		faults = newFaultInjector(influxClient)
+		influxClient = faults
+	}
+
+	// queries use their own client if there are separate read credentials
+	influxReadClient := influxClient
+	if influxReadConfig.Username != influxConfig.Username {
+		influxReadClient, err = influx.NewHTTPClient(influxReadConfig)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(fmt.Sprintf("unable to create influx read client: %s", err))
+			os.Exit(exitConfig)
+		}
+		defer influxReadClient.Close()
+	}
+
+	// don't go on until influx and the other dependencies are up
+	err = waitForDependencies(edgexSdk.LoggingClient, influxClient, waitFor, waitForInterval, waitForTimeout)
+	if err != nil {
+		edgexSdk.LoggingClient.Error(err.Error())
+		os.Exit(exitUnavailable)
+	}
+
+	if remap != nil {
+		if remap.sourceDB == "" {
+			remap.sourceDB = ptConfig.Database
+		}
+		if remap.configuredLayout {
+			remap.sourceLayout = layout
+		}
+		m := &remapper{
+			lc:           edgexSdk.LoggingClient,
+			readClient:   influxReadClient,
+			writeClient:  influxClient,
+			ptConfig:     ptConfig,
+			targetLayout: layout,
+			opts:         remap,
+		}
+		if remap.dryRun {
+			m.writeClient = &dryRunClient{w: os.Stdout}
+		}
+		m.checkpoint, err = loadCheckpoint(remap.checkpointPath, fmt.Sprintf("remap %s into %s", remap.sourceDB, remap.targetDB))
+		if err != nil {
+			edgexSdk.LoggingClient.Error(err.Error())
+			os.Exit(exitUsage)
+		}
+		n, err := m.run()
+		influxClient.Close()
+		if err != nil {
+			edgexSdk.LoggingClient.Error(fmt.Sprintf("remapping %s into %s failed after %d points: %s", remap.sourceDB, remap.targetDB, n, err))
+			os.Exit(partialExitCode(n, err))
+		}
+		edgexSdk.LoggingClient.Info(fmt.Sprintf("remapped %d points from %s into %s", n, remap.sourceDB, remap.targetDB))
+		os.Exit(0)
+	}
+
+	if verify != nil {
+		v := &auditVerifier{
+			readClient: influxReadClient,
+			database:   ptConfig.Database,
+			layout:     layout,
+			opts:       verify,
+		}
+		mismatches, err := v.run(os.Stdout)
+		influxClient.Close()
+		if err != nil {
+			edgexSdk.LoggingClient.Error(fmt.Sprintf("verifying the delivery audit failed: %s", err))
+			os.Exit(exitCode(err, exitFailure))
+		}
+		if mismatches != 0 {
+			edgexSdk.LoggingClient.Error(fmt.Sprintf("%d audited windows don't match the points in influx", mismatches))
+			os.Exit(exitMismatch)
+		}
+		os.Exit(0)
+	}
+
+	// count the requests to every route and how long they take
+	metrics := newRouteMetrics()
+	metrics.headers = headers
+	// count the readings dropped for every reason
+	drops := newDropAccounting()
+	metrics.collectors = append(metrics.collectors, drops.writeMetrics)
+	if mem != nil {
+		metrics.collectors = append(metrics.collectors, mem.writeMetrics)
+	}
+	if staleness != nil {
+		metrics.collectors = append(metrics.collectors, staleness.writeMetrics)
+	}
+	if chatter != nil {
+		metrics.collectors = append(metrics.collectors, chatter.writeMetrics)
+	}
+	if dead != nil {
+		metrics.collectors = append(metrics.collectors, dead.writeMetrics)
+	}
+	if anomalies != nil {
+		metrics.collectors = append(metrics.collectors, anomalies.writeMetrics)
+	}
+	if tuner != nil {
+		metrics.collectors = append(metrics.collectors, tuner.writeMetrics)
+	}
+
+	// predict values of a series from its recent history
+	fc := &forecaster{client: influxReadClient, database: ptConfig.Database, layout: layout}
+	err = edgexSdk.AddRoute("/api/v1/forecast", metrics.wrap("/api/v1/forecast", fc.forecastHandler), http.MethodGet)
+	if err != nil {
+		edgexSdk.LoggingClient.Error(fmt.Sprintf("unable to add /api/v1/forecast route: %s", err))
+		os.Exit(exitFailure)
+	}
+
+	// record the configuration this instance started with, so that changes
+	// in the data can be correlated with configuration changes
+	appSettings := edgexSdk.ApplicationSettings()
+	if replay == nil {
+		changed, err := writeConfigFingerprint(
+			influxClient,
+			influxReadClient,
+			ptConfig,
+			serviceKey,
+			edgexinfluxproxy.Version,
+			configFingerprint(appSettings),
+		)
+		if err != nil {
+			edgexSdk.LoggingClient.Warn(fmt.Sprintf("unable to write configuration fingerprint: %s", err))
+		} else if changed {
+			edgexSdk.LoggingClient.Info("configuration changed since the previous start")
+		}
+	}
+
+	// the proxy's own points, such as the delivery audit, are written to the
+	// database itself rather than the sinks
+	databaseClient := influxClient
+
+	// audit the readings written
+	var audit *deliveryAudit
+	if auditInterval != 0 && replay == nil {
+		audit, err = newDeliveryAudit(edgexSdk.LoggingClient, databaseClient, ptConfig, auditInterval)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(fmt.Sprintf("Invalid \"DeliveryAuditInterval\" setting: %s", err))
+			os.Exit(exitConfig)
+		}
+		go audit.run()
+	}
+
+	// acknowledge the events written
+	var acks *writeAcks
+	if len(ackURLs) != 0 && replay == nil {
+		acks = newWriteAcks(edgexSdk.LoggingClient, ackURLs, ackTimeout)
+		metrics.collectors = append(metrics.collectors, acks.writeMetrics)
+		go acks.run()
+	}
+
+	// hand everything written to influx to the registered sinks enabled in
+	// the configuration as well
+	if sinkNames := splitList(appSettings["Sinks"]); len(sinkNames) != 0 {
+		fanout := &fanoutClient{
+			Client: influxClient,
+			lc:     edgexSdk.LoggingClient,
+			sinks:  make(map[string]edgexinfluxproxy.Sink),
+		}
+		for _, name := range sinkNames {
+			sink, err := edgexinfluxproxy.NewSink(name, edgexSdk.LoggingClient, appSettings)
+			if err != nil {
+				edgexSdk.LoggingClient.Error(fmt.Sprintf("unable to create sink %q: %s", name, err))
+				os.Exit(exitConfig)
+			}
+			fanout.sinks[name] = sink
+		}
+		influxClient = fanout
+		if histo != nil {
+			histo.sinks = fanout.sinks
+		}
+	}
+
+	// count the load written to recommend retention policy settings from
+	var advisor *loadAdvisor
+	if advisorPeriod != 0 && relayURL == "" && replay == nil {
+		advisor = newLoadAdvisor(edgexSdk.LoggingClient, influxClient, influxReadClient, ptConfig, advisorDiskBudgetMB*1024*1024, advisorApply)
+		influxClient = advisor
+		go advisor.report(advisorPeriod)
+	}
+
+	// track the newest reading written for each device
+	marks := newHighWaterMarks()
+
+	// the pipelines writing to InfluxDBs of their own, created below
+	var pipelines []*namedPipeline
+
+	// drop the events of devices decommissioned through the admin routes
+	var decom *decommissions
+	if adminAuthFunc != nil {
+		decom = newDecommissions(influxClient, influxReadClient, ptConfig,
+			breaker.forget, quota.forget, anomalies.forget, detector.forget,
+			typing.forget, marks.forget, origins.forget, states.forget, histo.forget,
+			counts.forget, ordering.forget, lasts.forget,
+			func(device string) {
+				for _, p := range pipelines {
+					p.forget(device)
+				}
+			},
+		)
+		err = decom.load()
+		if err != nil {
+			edgexSdk.LoggingClient.Warn(fmt.Sprintf("unable to load decommissioned devices: %s", err))
+		}
+	}
+
+	// write the events of re-provisioned devices under their canonical name
+	configuredAliases, err := parseTags(appSettings["DeviceAliases"])
+	if err != nil {
+		edgexSdk.LoggingClient.Error(fmt.Sprintf("Invalid \"DeviceAliases\" setting: %s", err))
+		os.Exit(exitConfig)
+	}
+	var aliases *deviceAliases
+	if adminAuthFunc != nil || len(configuredAliases) != 0 {
+		aliases = newDeviceAliases(influxClient, influxReadClient, ptConfig, configuredAliases)
+		err = aliases.load()
+		if err != nil {
+			edgexSdk.LoggingClient.Warn(fmt.Sprintf("unable to load device aliases: %s", err))
+		}
+	}
+
+	// pause and resume ingestion from each source through the admin routes
+	sourceNames := splitList(appSettings["Sources"])
+	pausable := append([]string{
+		sourceEdgeX, sourceWrite, sourceRelay, sourceTCP, sourceUDP, sourceStatsD,
+	}, sourceNames...)
+	if appSettings["SparkplugBrokerURL"] != "" {
+		pausable = append(pausable, sourceSparkplug)
+	}
+	if opcuaEnabled || appSettings["OPCUABrokerURL"] != "" {
+		pausable = append(pausable, sourceOPCUA)
+	}
+
+	// route the events of some devices and the points of some sources to
+	// pipelines writing to InfluxDBs of their own, which are paused by name
+	var pipelineDryRun io.Writer
+	switch {
+	case soakTest != nil && !soakTest.write:
+		pipelineDryRun = ioutil.Discard
+	case replay != nil && replay.dryRun:
+		pipelineDryRun = os.Stdout
+	}
+	for _, name := range splitList(appSettings["Pipelines"]) {
+		for _, taken := range append([]string{sourceAll}, pausable...) {
+			if name == taken {
+				edgexSdk.LoggingClient.Error(fmt.Sprintf("Invalid \"Pipelines\" setting: the name %q is already taken by a source or pipeline", name))
+				os.Exit(exitConfig)
+			}
+		}
+		p, err := newNamedPipeline(edgexSdk.LoggingClient, appSettings, name, pipelineDryRun)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(fmt.Sprintf("Invalid pipeline %q: %s", name, err))
+			os.Exit(exitConfig)
+		}
+		pipelines = append(pipelines, p)
+		pausable = append(append(pausable, name), p.sources...)
+	}
+	if len(pipelines) != 0 {
+		metrics.collectors = append(metrics.collectors, writePipelineMetrics(pipelines))
+	}
+
+	var controls *ingestionControls
+	if adminAuthFunc != nil || readOnly {
+		controls = newIngestionControls(pausable...)
+	}
+	if readOnly && replay == nil {
+		controls.set(sourceAll, true)
+		edgexSdk.LoggingClient.Info("starting read-only, ingestion is paused until resumed through /admin/ingestion or restarted without --read-only")
+	}
+
+	// every event goes through these stages in order:
+	//   - split merged events into an event per device
+	//   - faults delay events when faults are injected
+	//   - aliases rename aliased devices
+	//   - stale drops events older than the maximum age
+	//   - leader drops events while on standby
+	//   - partition drops events of other instances' devices
+	//   - decommission drops events of decommissioned devices
+	//   - pause holds events back while their ingestion is paused
+	//   - memory holds events back while memory is low
+	//   - capture saves events of the devices being debugged
+	//   - circuit-breaker dead-letters events of quarantined devices
+	//   - quota drops or defers events of devices over their quota
+	//   - origin replaces missing origins
+	//   - chatter drops readings repeating their last value
+	//   - quality drops readings of excluded qualities
+	//   - anomaly-policy drops, dead-letters or logs anomalous readings,
+	//     and tags, drops or rejects the readings of devices that aren't
+	//     known
+	//   - pipelines write the events of matching devices to their own
+	//     InfluxDB
+	//   - write sends the rest to InfluxDB
+	topology := newPipelineTopology(appSettings)
+	var eventTags []string
+	for _, tag := range headerTags {
+		eventTags = append(eventTags, tag)
+	}
+	if anomalies.tagsUnknownDevices() {
+		eventTags = append(eventTags, unregisteredTag)
+	}
+	write := writeConfig{
+		influxClient:    influxClient,
+		ptConfig:        ptConfig,
+		layout:          layout,
+		sourceTag:       sourceTag,
+		readingIDTag:    readingIDTag,
+		floats:          floats,
+		eventTags:       eventTags,
+		breaker:         breaker,
+		detector:        detector,
+		typing:          typing,
+		tagCheck:        tagCheck,
+		marks:           marks,
+		backfill:        backfill,
+		states:          states,
+		histo:           histo,
+		counts:          counts,
+		unitsNormalizer: unitsNormalizer,
+		notifier:        notifier,
+		staleness:       staleness,
+		ordering:        ordering,
+		drops:           drops,
+		audit:           audit,
+		acks:            acks,
+		lasts:           lasts,
+		qualities:       qualities,
+	}
+	for _, p := range pipelines {
+		p.write = sendToInfluxDBFunc(p.writeConfig(write))
+	}
+	closeClients := func() {
+		influxClient.Close()
+		for _, p := range pipelines {
+			p.client.Close()
+		}
+	}
+	var pipeline []appcontext.AppFunction
+	pipeline = []appcontext.AppFunction{
+		topology.stage("split", nodeTransform, true, splitFunc(&pipeline)),
+		topology.stage("faults", nodeFilter, faults != nil, faultFunc(faults), "FaultInjectionEnabled"),
+		topology.stage("aliases", nodeTransform, aliases != nil, aliasFunc(aliases), "DeviceAliases"),
+		topology.stage("stale", nodeFilter, staleness != nil, staleFunc(staleness, drops), "MaxEventAge", "MaxEventAgeAction"),
+		topology.stage("leader", nodeFilter, lease != nil, leaderFunc(lease), "HALockFile", "HAPollInterval"),
+		topology.stage("partition", nodeFilter, part != nil, partitionFunc(part), "PartitionCount", "PartitionIndex"),
+		topology.stage("decommission", nodeFilter, decom != nil, decommissionFunc(decom, drops)),
+		topology.stage("pause", nodeFilter, controls != nil, pauseFunc(controls)),
+		topology.stage("memory", nodeFilter, mem != nil, memoryFunc(mem), "MemoryBudgetMB"),
+		topology.stage("capture", nodeFilter, capture != nil, captureFunc(capture), "CaptureDir"),
+		topology.stage("circuit-breaker", nodeFilter, breaker != nil, circuitBreakerFunc(breaker, drops, dead, notifier),
+			"CircuitBreakerMaxFailures", "CircuitBreakerMaxReadingNames", "CircuitBreakerReadingNamesWindow", "CircuitBreakerCooldown", "DeadLetterDir"),
+		topology.stage("quota", nodeFilter, quota != nil, quotaFunc(quota, drops), "QuotaPointsPerMinute", "QuotaMode", "QuotaTenantTag"),
+		topology.stage("origin", nodeTransform, origins != nil, originFunc(origins), "ZeroOriginPolicy"),
+		topology.stage("chatter", nodeFilter, chatter != nil, chatterFunc(chatter, drops), "ChatterSuppressionWindow"),
+		topology.stage("quality", nodeFilter, qualities != nil && len(qualities.exclude) != 0, qualityFunc(qualities, drops), "QualityTag", "QualityValues", "QualityExclude"),
+		topology.stage("anomaly-policy", nodeFilter, anomalies != nil, anomalyPolicyFunc(anomalies, drops, dead),
+			"AnomalyTypeMismatchAction", "AnomalyTimestampSkewAction", "AnomalyTimestampSkewTolerance", "AnomalyUnknownDeviceAction", "AnomalyKnownDevices", "AnomalyTenantTag", "AnomalyUnknownDeviceTenantActions"),
+		topology.stage("pipelines", nodeFilter, len(pipelines) != 0, pipelinesFunc(pipelines, controls), "Pipelines"),
+		topology.stage("write", nodeSink, true,
+			sendToInfluxDBFunc(write),
+			"MeasurementLayout", "ReadingIDTag", "SourceTag", "OrderedSeriesWrites", "DeliveryAuditInterval", "AckWebhookURLs", "LastValueMaxSeries", "QualityWriteAs", "TagValueMaxLength", "AnomalyDetectionZScore",
+			"UnitResources", "CanonicalUnits", "CounterResources", "StateDurationResources", "HistogramResources",
+			"HistogramWindow", "HistogramRawSamples", "BackfillAge", "BackfillRetentionPolicy", "BackfillMeasurementSuffix"),
+	}
+
+	// run the events deferred by throttled quotas through the rest of the
+	// pipeline once they fit
+	if quota != nil && quota.mode == quotaThrottle {
+		go quota.releaseDeferred(edgexSdk.LoggingClient, pipeline[topology.index("quota")+1:])
+	}
+
+	// along with where events and points come from and go to
+	topology.source(sourceEdgeX, true, "EdgeXRouteEnabled", "EdgeXRouteSyncWrites")
+	topology.source(sourceWrite, lineProtocolEnabled, "LineProtocolWriteEnabled", "LineProtocolDatabases", "HeaderTags")
+	topology.source(sourceRelay, relaySecret != "" && relayURL == "", "RelaySecret")
+	topology.source(sourceTCP, lineProtocolTCPAddr != "", "LineProtocolListenTCP")
+	topology.source(sourceUDP, lineProtocolUDPAddr != "", "LineProtocolListenUDP")
+	topology.source(sourceStatsD, statsdAddr != "", "StatsDListenUDP", "StatsDFlushInterval", "StatsDMaxPendingFlushes", "StatsDMaxSeries", "StatsDMaxValues", "StatsDGaugeExpiry")
+	topology.source(sourceSparkplug, appSettings["SparkplugBrokerURL"] != "", "SparkplugBrokerURL", "SparkplugTopic")
+	topology.source(sourceOPCUA, opcuaEnabled || appSettings["OPCUABrokerURL"] != "", "OPCUAWriteEnabled", "OPCUABrokerURL", "OPCUATopic")
+	for _, name := range sourceNames {
+		topology.source(name, true)
+	}
+	if relayURL != "" {
+		topology.sink("relay", true, "RelayURL", "RelayCAFile")
+	} else {
+		topology.sink("influxdb", true, "InfluxDBHost", "InfluxDBPort", "InfluxDBDatabaseName", "InfluxDBDatabasePrecision")
+	}
+	for _, name := range splitList(appSettings["Sinks"]) {
+		topology.sink(name, true)
+	}
+	for _, p := range pipelines {
+		for _, name := range p.sources {
+			topology.source(name, true, p.name+".Sources")
+		}
+		topology.sink(p.name, true, p.name+".Devices", p.name+".Resources", p.name+".InfluxDBHost", p.name+".InfluxDBPort",
+			p.name+".InfluxDBDatabaseName", p.name+".InfluxDBUsername", p.name+".InfluxDBPassword", p.name+".MeasurementLayout", p.name+".Sinks")
+	}
+
+	if soakTest != nil {
+		err := soak(edgexSdk.LoggingClient, pipeline, soakTest)
+		closeClients()
+		if err != nil {
+			edgexSdk.LoggingClient.Error(fmt.Sprintf("soak-test failed: %s", err))
+			os.Exit(exitFailure)
+		}
+		os.Exit(0)
+	}
+	if csvImport != nil {
+		cp, err := loadCheckpoint(csvImport.checkpointPath, "import-csv "+strings.Join(csvImport.paths, " "))
+		if err != nil {
+			edgexSdk.LoggingClient.Error(err.Error())
+			os.Exit(exitUsage)
+		}
+		imported, failed := importCSV(edgexSdk.LoggingClient, pipeline, csvImport, cp)
+		closeClients()
+		edgexSdk.LoggingClient.Info(fmt.Sprintf("imported %d rows, %d failed", imported, failed))
+		if failed != 0 {
+			os.Exit(partialExitCode(imported, nil))
+		}
+		os.Exit(0)
+	}
+	if replay != nil {
+		cp, err := loadCheckpoint(replay.checkpointPath, "replay "+strings.Join(replay.paths, " "))
+		if err != nil {
+			edgexSdk.LoggingClient.Error(err.Error())
+			os.Exit(exitUsage)
+		}
+		replayed, failed := replayFiles(edgexSdk.LoggingClient, pipeline, replay.paths, captureCipher, cp)
+		closeClients()
+		edgexSdk.LoggingClient.Info(fmt.Sprintf("replayed %d events, %d failed", replayed, failed))
+		if failed != 0 {
+			os.Exit(partialExitCode(replayed, nil))
+		}
+		os.Exit(0)
+	}
+
+	// only the leader of an HA pair starts sources, listens, subscribes to
+	// brokers and to EdgeX and registers with the registry, so standbys
+	// wait for the lease first
+	if !lease.isLeader() {
+		edgexSdk.LoggingClient.Info(fmt.Sprintf("waiting for %s before starting", lease.path))
+		lease.waitUntilLeader()
+	}
+
+	// start the registered sources enabled in the configuration
+	for _, name := range sourceNames {
+		source, err := edgexinfluxproxy.NewSource(name, edgexSdk.LoggingClient, appSettings)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(fmt.Sprintf("unable to create source %q: %s", name, err))
+			os.Exit(exitConfig)
+		}
+		err = source.Start(&clientSink{name: name, client: influxClient, ptConfig: ptConfig, controls: controls, lease: lease, sourceTag: sourceTag})
+		if err != nil {
+			edgexSdk.LoggingClient.Error(fmt.Sprintf("unable to start source %q: %s", name, err))
+			os.Exit(exitFailure)
+		}
+	}
+	for _, p := range pipelines {
+		settings := pipelineSettings(appSettings, p.name)
+		for _, name := range p.sources {
+			source, err := edgexinfluxproxy.NewSource(name, edgexSdk.LoggingClient, settings)
+			if err != nil {
+				edgexSdk.LoggingClient.Error(fmt.Sprintf("unable to create source %q of pipeline %q: %s", name, p.name, err))
+				os.Exit(exitConfig)
+			}
+			err = source.Start(&clientSink{name: name, client: p.client, ptConfig: p.ptConfig, controls: controls, lease: lease, sourceTag: sourceTag})
+			if err != nil {
+				edgexSdk.LoggingClient.Error(fmt.Sprintf("unable to start source %q of pipeline %q: %s", name, p.name, err))
+				os.Exit(exitFailure)
+			}
+		}
+	}
+
+	// accept line protocol at an InfluxDB compatible /write endpoint and on
+	// plain TCP/UDP sockets
+	lw := &lineProtocolWriter{client: influxClient, ptConfig: ptConfig, tags: deploymentTags, mem: mem, controls: controls, lease: lease, sourceTag: sourceTag, headerTags: headerTags, databases: lineProtocolDatabases, maxBatch: int(lineBatch), tuner: tuner}
+	if lineProtocolEnabled {
+		err = edgexSdk.AddRoute("/write", metrics.wrap("/write", controls.rejectWhilePaused(sourceWrite, mem.rejectWhilePaused(lw.writeHandler))), http.MethodPost)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(fmt.Sprintf("unable to add /write route: %s", err))
+			os.Exit(exitFailure)
+		}
+	}
+	if eventRouteEnabled {
+		ingest := newEventIngest(edgexSdk.LoggingClient, pipeline, eventRouteSync, eventRouteTimeout, eventRouteFallback)
+		ingest.headerTags = headerTags
+		go ingest.run()
+		err = edgexSdk.AddRoute("/edgex", metrics.wrap("/edgex", controls.rejectWhilePaused(sourceEdgeX, mem.rejectWhilePaused(ingest.eventHandler))), http.MethodPost)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(fmt.Sprintf("unable to add /edgex route: %s", err))
+			os.Exit(exitFailure)
+		}
+		if ingest.tracker != nil {
+			err = edgexSdk.AddRoute("/status/writes/{id}", metrics.wrap("/status/writes", ingest.tracker.statusHandler), http.MethodGet)
+			if err != nil {
+				edgexSdk.LoggingClient.Error(fmt.Sprintf("unable to add /status/writes route: %s", err))
+				os.Exit(exitFailure)
+			}
+		}
+	}
+	if relaySecret != "" && relayURL == "" {
+		rr := newRelayReceiver([]byte(relaySecret), lw)
+		err = edgexSdk.AddRoute("/relay", metrics.wrap("/relay", controls.rejectWhilePaused(sourceRelay, mem.rejectWhilePaused(rr.relayHandler))), http.MethodPost)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(fmt.Sprintf("unable to add /relay route: %s", err))
+			os.Exit(exitFailure)
+		}
+	}
+	if lineProtocolTCPAddr != "" {
+		err = listenLineProtocolTCP(edgexSdk.LoggingClient, lw, lineProtocolTCPAddr)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(fmt.Sprintf("unable to listen for line protocol over TCP: %s", err))
+			os.Exit(exitFailure)
+		}
+	}
+	if lineProtocolUDPAddr != "" {
+		err = listenLineProtocolUDP(edgexSdk.LoggingClient, lw, lineProtocolUDPAddr)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(fmt.Sprintf("unable to listen for line protocol over UDP: %s", err))
+			os.Exit(exitFailure)
+		}
+	}
+
+	// aggregate StatsD metrics from gateway-local processes
+	if statsdAddr != "" {
+		statsdTags := deploymentTags
+		if sourceTag != "" {
+			statsdTags = mergeTags(deploymentTags, map[string]string{sourceTag: sourceStatsD})
+		}
+		statsd := newStatsdServer(edgexSdk.LoggingClient, influxClient, ptConfig, statsdTags)
+		statsd.controls = controls
+		statsd.mem = mem
+		statsd.lease = lease
+		statsd.maxPending = int(statsdMaxPending)
+		statsd.maxSeries = int(statsdMaxSeries)
+		statsd.maxValues = int(statsdMaxValues)
+		statsd.gaugeExpiry = statsdGaugeExpiry
+		metrics.collectors = append(metrics.collectors, statsd.writeMetrics)
+		err = statsd.listen(statsdAddr, statsdInterval)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(fmt.Sprintf("unable to listen for statsd metrics: %s", err))
+			os.Exit(exitFailure)
+		}
+	}
+
+	// map the DataSet fields of OPC UA PubSub JSON publishers into the same
+	// pipeline as EdgeX events
+	opcua := &opcuaAdapter{lc: edgexSdk.LoggingClient, pipeline: pipeline}
+	if opcuaEnabled {
+		err = edgexSdk.AddRoute("/opcua", metrics.wrap("/opcua", controls.rejectWhilePaused(sourceOPCUA, mem.rejectWhilePaused(opcua.opcuaHandler))), http.MethodPost)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(fmt.Sprintf("unable to add /opcua route: %s", err))
+			os.Exit(exitFailure)
+		}
+	}
+	if appSettings["OPCUABrokerURL"] != "" {
+		err = opcua.subscribe(appSettings)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(fmt.Sprintf("unable to subscribe to OPC UA broker: %s", err))
+			os.Exit(exitUnavailable)
+		}
+	}
+
+	// map the metrics of Sparkplug B edge nodes into the same pipeline as
+	// EdgeX events
+	if appSettings["SparkplugBrokerURL"] != "" {
+		err = subscribeSparkplug(edgexSdk.LoggingClient, pipeline, appSettings)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(fmt.Sprintf("unable to subscribe to Sparkplug B broker: %s", err))
+			os.Exit(exitUnavailable)
+		}
+	}
+
+	// list recent outliers if anomaly detection is enabled
+	if detector != nil {
+		err = edgexSdk.AddRoute("/anomalies", metrics.wrap("/anomalies", detector.anomaliesHandler), http.MethodGet)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(fmt.Sprintf("unable to add /anomalies route: %s", err))
+			os.Exit(exitFailure)
+		}
+	}
+
+	// show how far behind each device is
+	if highWaterMarkFile != "" && replay == nil {
+		err = marks.load(highWaterMarkFile)
+		if err != nil {
+			edgexSdk.LoggingClient.Warn(fmt.Sprintf("unable to load high-water marks from %s: %s", highWaterMarkFile, err))
+		}
+		go func() {
+			for range time.Tick(highWaterMarkInterval) {
+				if err := marks.save(highWaterMarkFile); err != nil {
+					edgexSdk.LoggingClient.Warn(fmt.Sprintf("unable to save high-water marks to %s: %s", highWaterMarkFile, err))
+				}
+			}
+		}()
+	}
+	err = edgexSdk.AddRoute("/api/v1/lag", metrics.wrap("/api/v1/lag", pipelineHandler(pipelines, marks.lagHandler, func(p *namedPipeline) http.HandlerFunc {
+		return p.marks.lagHandler
+	})), http.MethodGet)
+	if err != nil {
+		edgexSdk.LoggingClient.Error(fmt.Sprintf("unable to add /api/v1/lag route: %s", err))
+		os.Exit(exitFailure)
+	}
+
+	// serve the last value written for every resource
+	if lasts != nil {
+		err = edgexSdk.AddRoute("/api/v1/last", metrics.wrap("/api/v1/last", pipelineHandler(pipelines, lasts.lastHandler, func(p *namedPipeline) http.HandlerFunc {
+			if p.lasts == nil {
+				return nil
+			}
+			return p.lasts.lastHandler
+		})), http.MethodGet)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(fmt.Sprintf("unable to add /api/v1/last route: %s", err))
+			os.Exit(exitFailure)
+		}
+	}
+
+	// fail readiness while writes fail because the database is missing
+	if missingDB != nil {
+		err = edgexSdk.AddRoute("/api/v1/ready", metrics.wrap("/api/v1/ready", missingDB.readyHandler), http.MethodGet)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(fmt.Sprintf("unable to add /api/v1/ready route: %s", err))
+			os.Exit(exitFailure)
+		}
+	}
+
+	// show how often the values of each resource were typed as each type
+	err = edgexSdk.AddRoute("/stats/typing", metrics.wrap("/stats/typing", pipelineHandler(pipelines, typing.typeCountsHandler, func(p *namedPipeline) http.HandlerFunc {
+		return p.typing.typeCountsHandler
+	})), http.MethodGet)
+	if err != nil {
+		edgexSdk.LoggingClient.Error(fmt.Sprintf("unable to add /stats/typing route: %s", err))
+		os.Exit(exitFailure)
+	}
+
+	// show the readings dropped for every reason and device
+	err = edgexSdk.AddRoute("/stats/drops", metrics.wrap("/stats/drops", pipelineHandler(pipelines, drops.dropsHandler, func(p *namedPipeline) http.HandlerFunc {
+		return p.drops.dropsHandler
+	})), http.MethodGet)
+	if err != nil {
+		edgexSdk.LoggingClient.Error(fmt.Sprintf("unable to add /stats/drops route: %s", err))
+		os.Exit(exitFailure)
+	}
+
+	// count the origins replaced for each device
+	if origins != nil {
+		err = edgexSdk.AddRoute("/api/v1/origin-substitutions", metrics.wrap("/api/v1/origin-substitutions", origins.substitutionsHandler), http.MethodGet)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(fmt.Sprintf("unable to add /api/v1/origin-substitutions route: %s", err))
+			os.Exit(exitFailure)
+		}
+	}
+
+	// capture the events of chosen devices and show how their values were
+	// typed to debug them, decommission devices and pause ingestion
+	if capture != nil {
+		err = edgexSdk.AddRoute("/admin/capture", metrics.wrap("/admin/capture", requireAdmin(adminAuthFunc, capture.captureHandler)), http.MethodGet, http.MethodPost, http.MethodDelete)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(fmt.Sprintf("unable to add /admin/capture route: %s", err))
+			os.Exit(exitFailure)
+		}
+		err = edgexSdk.AddRoute("/debug/typing", metrics.wrap("/debug/typing", requireAdmin(adminAuthFunc, typing.typingHandler)), http.MethodGet)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(fmt.Sprintf("unable to add /debug/typing route: %s", err))
+			os.Exit(exitFailure)
+		}
+		err = edgexSdk.AddRoute("/admin/decommission", metrics.wrap("/admin/decommission", requireAdmin(adminAuthFunc, decom.decommissionHandler)), http.MethodGet, http.MethodPost, http.MethodDelete)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(fmt.Sprintf("unable to add /admin/decommission route: %s", err))
+			os.Exit(exitFailure)
+		}
+		err = edgexSdk.AddRoute("/admin/pipeline", metrics.wrap("/admin/pipeline", requireAdmin(adminAuthFunc, topology.pipelineHandler)), http.MethodGet)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(fmt.Sprintf("unable to add /admin/pipeline route: %s", err))
+			os.Exit(exitFailure)
+		}
+		err = edgexSdk.AddRoute("/admin/aliases", metrics.wrap("/admin/aliases", requireAdmin(adminAuthFunc, aliases.aliasesHandler)), http.MethodGet, http.MethodPost, http.MethodDelete)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(fmt.Sprintf("unable to add /admin/aliases route: %s", err))
+			os.Exit(exitFailure)
+		}
+		if faults != nil {
+			err = edgexSdk.AddRoute("/admin/faults", metrics.wrap("/admin/faults", requireAdmin(adminAuthFunc, faults.faultsHandler)), http.MethodGet, http.MethodPost, http.MethodDelete)
+			if err != nil {
+				edgexSdk.LoggingClient.Error(fmt.Sprintf("unable to add /admin/faults route: %s", err))
+				os.Exit(exitFailure)
+			}
+		}
+		if advisor != nil {
+			err = edgexSdk.AddRoute("/admin/advice", metrics.wrap("/admin/advice", requireAdmin(adminAuthFunc, advisor.adviceHandler)), http.MethodGet)
+			if err != nil {
+				edgexSdk.LoggingClient.Error(fmt.Sprintf("unable to add /admin/advice route: %s", err))
+				os.Exit(exitFailure)
+			}
+		}
+		err = edgexSdk.AddRoute("/admin/ingestion", metrics.wrap("/admin/ingestion", requireAdmin(adminAuthFunc, controls.ingestionHandler)), http.MethodGet, http.MethodPost)
+		if err != nil {
+			edgexSdk.LoggingClient.Error(fmt.Sprintf("unable to add /admin/ingestion route: %s", err))
+			os.Exit(exitFailure)
+		}
+	}
+
+	// serve the request metrics of all the routes above for prometheus
+	err = edgexSdk.AddRoute("/metrics", metrics.headers.wrap("/metrics", metrics.metricsHandler), http.MethodGet)
+	if err != nil {
+		edgexSdk.LoggingClient.Error(fmt.Sprintf("unable to add /metrics route: %s", err))
+		os.Exit(exitFailure)
+	}
+
+	// dump the state of the service on SIGUSR1
+	if diagnosticsDir != "" {
+		diag := &diagnostics{dir: diagnosticsDir}
+		diag.add("version", func(w io.Writer) error {
+			_, err := fmt.Fprintln(w, edgexinfluxproxy.Version, runtime.Version())
+			return err
+		})
+		diag.add("settings", redactedSettings(appSettings))
+		diag.add("metrics", func(w io.Writer) error {
+			metrics.writeTo(w)
+			return nil
+		})
+		diag.addJSON("lag", func() interface{} { return marks.lag(time.Now()) })
+		if origins != nil {
+			diag.addJSON("origin substitutions", func() interface{} { return origins.substitutionCounts() })
+		}
+		diag.add("memory", writeMemStats)
+		diag.add("goroutines", writeGoroutines)
+		dumpOnSignal(edgexSdk.LoggingClient, diag)
+	}
+
+	// summarize what this instance listens on, reads from and writes to
+	summary := newStartupSummary(edgexinfluxproxy.Version, serviceKey, os.Args[1:], readOnly, map[string]string{
+		sourceTCP:    lineProtocolTCPAddr,
+		sourceUDP:    lineProtocolUDPAddr,
+		sourceStatsD: statsdAddr,
+	}, topology, metrics, ptConfig, lw.maxBatch)
+	if startupBanner {
+		edgexSdk.LoggingClient.Info(fmt.Sprintf("starting %s", summary))
+	}
+	if startupSummaryToInflux {
+		if err := summary.write(databaseClient, ptConfig); err != nil {
+			edgexSdk.LoggingClient.Warn(fmt.Sprintf("unable to write startup summary: %s", err))
+		}
 	}
 
 	// close the client once the function returns, as we don't return from
 	// this function unless error, but we will keep using the influx client
 	// until an error happens
-	defer influxClient.Close()
+	defer closeClients()
 
-	// the only function in the pipeline is to send it to influxDB
-	// TODO: allow filtering by device name from the configuration.toml file
-	err = edgexSdk.SetFunctionsPipeline(
-		sendToInfluxDBFunc(influxClient, ptConfig),
-	)
+	err = edgexSdk.SetFunctionsPipeline(pipeline...)
 	if err != nil {
 		edgexSdk.LoggingClient.Error(fmt.Sprintf("%s", err))
-		os.Exit(-1)
+		os.Exit(exitFailure)
 	}
 
 	// run the SDK service
 	err = edgexSdk.MakeItRun()
 	if err != nil {
 		edgexSdk.LoggingClient.Error("MakeItRun returned error: ", err.Error())
-		os.Exit(-1)
+		os.Exit(exitFailure)
 	}
 
 	os.Exit(0)
 }
 
-// sendToInfluxDB sends each data event to InfluxDB as a point
-func sendToInfluxDBFunc(influxClient influx.Client, ptConfig influx.BatchPointsConfig) func(edgexcontext *appcontext.Context, params ...interface{}) (bool, interface{}) {
+// writeConfig is what the write stage writes with, the state it keeps and
+// the features it applies, a nil feature is disabled
+type writeConfig struct {
+	influxClient influx.Client
+	ptConfig     influx.BatchPointsConfig
+	layout       measurementLayout
+	sourceTag    string
+	readingIDTag bool
+	floats       *binaryFloats
+	// eventTags are the tags of events written as tags of their points, the
+	// tags header values of events posted to /edgex are added as and the
+	// tag of unknown devices tagged by the anomaly policy
+	eventTags []string
+
+	breaker         *circuitBreaker
+	detector        *zScoreDetector
+	typing          *typingDecisions
+	tagCheck        *tagValidator
+	marks           *highWaterMarks
+	backfill        *backfillRouting
+	states          *stateDurations
+	histo           *histograms
+	counts          *counters
+	unitsNormalizer *unitNormalizer
+	notifier        *failureNotifier
+	staleness       *staleEvents
+	ordering        *seriesOrdering
+	drops           *dropAccounting
+	audit           *deliveryAudit
+	acks            *writeAcks
+	lasts           *lastValues
+	qualities       *qualityMapping
+	// clock is the system clock unless set by tests
+	clock clock
+}
+
+// sendToInfluxDB sends each data event to InfluxDB as a point, reporting
+// readings that can't be turned into points to the circuit breaker and tagging
+// numeric outliers found by the detector
+func sendToInfluxDBFunc(cfg writeConfig) func(edgexcontext *appcontext.Context, params ...interface{}) (bool, interface{}) {
+	if cfg.clock == nil {
+		cfg.clock = systemClock{}
+	}
 	return func(edgexcontext *appcontext.Context, params ...interface{}) (bool, interface{}) {
 		if len(params) < 1 {
 			// We didn't receive a result
 			return false, errors.New("no data received")
 		}
 
+		var events []models.Event
 		for _, obj := range params {
-			event, ok := obj.(models.Event)
-			if !ok {
+			var event models.Event
+			switch v := obj.(type) {
+			case models.Event:
+				event = v
+			case []byte:
+				// events retried by store-and-forward come back as the JSON
+				// we saved with SetRetryData below
+				if err := json.Unmarshal(v, &event); err != nil {
+					edgexcontext.LoggingClient.Error(fmt.Sprintf("unable to decode retried event: %s", err))
+					continue
+				}
+				// retries skip the earlier functions, so drop stale ones
+				// here
+				if cfg.staleness.stale(event, cfg.clock.Now()) && !cfg.staleness.keep {
+					edgexcontext.LoggingClient.Debug(fmt.Sprintf("dropping stale retried event from device %q", event.Device))
+					cfg.drops.add(dropStale, event.Device, len(event.Readings))
+					continue
+				}
+			default:
 				continue
 			}
+			// retried events can still be merged events of many devices
+			events = append(events, splitByDevice(event)...)
+		}
+
+		var rejected error
+	events:
+		for i, event := range events {
+			// write the events of a device one at a time
+			unlock := cfg.ordering.lock(event.Device)
 
 			// Make a new set of batch points for this event
-			bp, err := influx.NewBatchPoints(ptConfig)
+			bp, err := influx.NewBatchPoints(cfg.ptConfig)
 			if err != nil {
 				edgexcontext.LoggingClient.Warn(fmt.Sprintf("%s", err))
 			}
 
+			// late readings go in a separate batch if backfill routing is
+			// enabled
+			var backfillBp influx.BatchPoints
+			arrival := cfg.clock.Now()
+
+			var newest time.Time
+			var archived []*influx.Point
+			var audited []auditEntry
+			var latest []lastValue
+			newestByResource := make(map[string]time.Time)
 			for _, reading := range event.Readings {
-				// TODO: use core-metadata to figure out the real Type instead
-				// of guessing like this
+				// TODO: use core-metadata to figure out the real Type of
+				// readings from device services that don't declare it
+
+				tags := make(map[string]string)
+				if cfg.readingIDTag {
+					tags["id"] = reading.Id
+				}
+				if cfg.sourceTag != "" {
+					tags[cfg.sourceTag] = sourceEdgeX
+				}
+				for _, tag := range cfg.eventTags {
+					if v, ok := event.Tags[tag]; ok {
+						tags[tag] = v
+					}
+				}
+				measurement, field := cfg.layout.point(reading.Device, reading.Name, tags)
 
 				// parse the reading value string into a go type to be send to
 				// influxdb
 				fields := make(map[string]interface{})
-				readingType, boolVal, floatVal, intVal := parseValueType(reading.Value)
+				readingType, boolVal, floatVal, intVal := parseReadingValue(reading, cfg.floats)
 				switch readingType {
 				case boolType:
-					fields[reading.Name] = boolVal
+					fields[field] = boolVal
 				case intType:
-					fields[reading.Name] = intVal
+					fields[field] = intVal
 				case floatType:
-					fields[reading.Name] = floatVal
+					fields[field] = floatVal
 				case stringType:
-					fields[reading.Name] = reading.Value
+					fields[field] = reading.Value
 				}
 
+				// write numbers in the canonical unit of their quantity,
+				// which is tagged, keeping integers integers so that the
+				// type of their field doesn't change
+				var canonicalUnit string
+				normalizedOK := false
+				switch readingType {
+				case intType:
+					var converted int64
+					converted, canonicalUnit, normalizedOK = cfg.unitsNormalizer.normalizeInt(reading.Device, reading.Name, intVal)
+					if normalizedOK {
+						intVal = converted
+						fields[field] = intVal
+						break
+					}
+					// write the converted value next to the raw one
+					var normalized float64
+					normalized, canonicalUnit, normalizedOK = cfg.unitsNormalizer.normalize(reading.Device, reading.Name, float64(intVal))
+					if normalizedOK {
+						fields[field+normalizedFieldSuffix] = normalized
+					}
+				case floatType:
+					var normalized float64
+					normalized, canonicalUnit, normalizedOK = cfg.unitsNormalizer.normalize(reading.Device, reading.Name, floatVal)
+					if normalizedOK {
+						floatVal = normalized
+						fields[field] = floatVal
+					}
+				}
+				if normalizedOK {
+					tags[unitTag] = canonicalUnit
+				}
+
+				cfg.typing.record(reading.Device, reading.Name, typingDecision{
+					Type:          readingType.String(),
+					Sample:        reading.Value,
+					ValueType:     reading.ValueType,
+					FloatEncoding: reading.FloatEncoding,
+					Time:          cfg.clock.Now(),
+				})
+
 				// Calculate the unix time from the origin time in the reading
 				// note that the origin time is in milliseconds
 				unixTime := float64(reading.Origin) / float64(time.Second/time.Nanosecond)
 				unixTimeSec := math.Floor(unixTime)
 				unixTimeNSec := int64((unixTime - unixTimeSec) * float64(time.Second/time.Nanosecond))
 
-				// Make the point for this reading with the name as the device
-				// it originated
+				// need to make sure the Time value returned is in UTC - but
+				// note we don't have to convert it before hand because Unix
+				// time is always in UTC, but time.Time is in the local
+				// timezone
+				ptTime := time.Unix(int64(unixTimeSec), unixTimeNSec)
+
+				// don't let an older reading replace a newer value written
+				// at the same timestamp
+				if cfg.ordering.overwrites(reading.Device, reading.Name, ptTime) {
+					edgexcontext.LoggingClient.Debug(fmt.Sprintf("skipping reading %s of device %q older than the one written at the same timestamp", reading.Name, reading.Device))
+					cfg.drops.add(dropOutOfOrder, reading.Device, 1)
+					continue
+				}
+				if ptTime.After(newestByResource[reading.Name]) {
+					newestByResource[reading.Name] = ptTime
+				}
+
+				// write the corrected value of counters next to the raw one
+				switch readingType {
+				case intType:
+					if corrected, ok := cfg.counts.correct(reading.Device, reading.Name, float64(intVal), ptTime); ok {
+						fields[field+correctedFieldSuffix] = int64(corrected)
+					}
+				case floatType:
+					if corrected, ok := cfg.counts.correct(reading.Device, reading.Name, floatVal, ptTime); ok {
+						fields[field+correctedFieldSuffix] = corrected
+					}
+				}
+
+				// tag numeric outliers
+				anomalous := false
+				switch readingType {
+				case intType:
+					anomalous = cfg.detector.observe(reading.Device, reading.Name, float64(intVal), ptTime)
+				case floatType:
+					anomalous = cfg.detector.observe(reading.Device, reading.Name, floatVal, ptTime)
+				}
+				if anomalous {
+					tags["anomaly"] = "true"
+				}
+
+				// along with the quality the device service indicated
+				level, hasQuality := cfg.qualities.quality(event, reading.Name)
+				if hasQuality {
+					if cfg.qualities.asField {
+						fields[field+qualityFieldSuffix] = level
+					} else {
+						tags[qualityTag] = level
+					}
+				}
+				cfg.tagCheck.sanitize(reading.Device, tags)
+
+				late := cfg.backfill.late(ptTime, arrival)
+				if late {
+					measurement += cfg.backfill.measurementSuffix
+				}
+
+				// Make the point for this reading in the measurement of the
+				// device it originated from, or of the resource
 				pt, err := influx.NewPoint(
-					reading.Device,
-					map[string]string{
-						"id": reading.Id,
-					},
+					measurement,
+					tags,
 					fields,
-					// need to make sure the Time value returned is in UTC -
-					// but note we don't have to convert it before hand
-					// because Unix time is always in UTC, but time.Time is in
-					// the local timezone
-					time.Unix(int64(unixTimeSec), unixTimeNSec),
+					ptTime,
 				)
 				if err != nil {
 					// TODO : send error via channel
 					log.Printf("error creating reading point: %+v\n", err)
+					if tripped := cfg.breaker.recordFailure(event.Device); tripped != "" {
+						cfg.notifier.quarantined(edgexcontext, event.Device, cfg.breaker.cooldown, tripped)
+					}
+					continue
+				}
+				cfg.breaker.recordSuccess(event.Device)
+				if ptTime.After(newest) {
+					newest = ptTime
+				}
+				if cfg.lasts != nil {
+					// the last value is in the canonical unit, even when
+					// written next to the raw one
+					value := fields[field]
+					if normalized, ok := fields[field+normalizedFieldSuffix]; ok {
+						value = normalized
+					}
+					last := lastValue{Device: reading.Device, Resource: reading.Name, Value: value, Time: ptTime}
+					if anomalous {
+						last.Flags = append(last.Flags, qualityAnomaly)
+					}
+					if late {
+						last.Flags = append(last.Flags, qualityLate)
+					}
+					if normalizedOK {
+						last.Flags = append(last.Flags, qualityConverted)
+					}
+					if hasQuality && level != qualityGood {
+						last.Flags = append(last.Flags, level)
+					}
+					latest = append(latest, last)
 				}
 
-				// Add it to the batch set
-				bp.AddPoint(pt)
+				// along with the state changes and on-time of boolean
+				// resources
+				pts := []*influx.Point{pt}
+				if readingType == boolType {
+					pts = append(pts, cfg.states.observe(reading.Device, reading.Name, boolVal, ptTime)...)
+				}
+
+				// or the windows completed by samples of high-rate resources,
+				// whose samples are only written if kept
+				written := true
+				if readingType == intType || readingType == floatType {
+					value := floatVal
+					if readingType == intType {
+						value = float64(intVal)
+					}
+					if summaries, ok := cfg.histo.observe(reading.Device, reading.Name, value, ptTime); ok {
+						switch cfg.histo.raw {
+						case rawInflux:
+							summaries = append(summaries, pt)
+						case rawSinks:
+							archived = append(archived, pt)
+						}
+						pts = summaries
+						written = cfg.histo.raw == rawInflux
+					}
+				}
+
+				// Add them to the batch set
+				if !late {
+					bp.AddPoints(pts)
+					// late readings aren't audited, as they are written
+					// elsewhere
+					if written {
+						audited = append(audited, auditEntry{device: reading.Device, resource: reading.Name, tags: tags, value: fields[field], t: ptTime})
+					}
+					continue
+				}
+				if backfillBp == nil {
+					backfillBp, err = influx.NewBatchPoints(cfg.backfill.batchConfig(cfg.ptConfig))
+					if err != nil {
+						edgexcontext.LoggingClient.Warn(fmt.Sprintf("%s", err))
+						continue
+					}
+				}
+				backfillBp.AddPoints(pts)
 			}
 
 			// finally write all these points out to influx
-			err = influxClient.Write(bp)
-			if err != nil {
-				log.Printf("error writing points to influx: %+v\n", err)
+			batches := []influx.BatchPoints{bp}
+			if backfillBp != nil {
+				batches = append(batches, backfillBp)
 			}
+			for _, batch := range batches {
+				if len(batch.Points()) == 0 {
+					// every reading was summarized
+					continue
+				}
+				err = cfg.influxClient.Write(batch)
+				if err == nil {
+					continue
+				}
+				failure := classifyWriteError(err)
+				cfg.notifier.writeFailed(edgexcontext, event, failure)
+				if failure.permanent {
+					// retrying would only fail the same way again, so drop
+					// the event
+					msg := fmt.Sprintf("dropping event from device %q rejected by influx: %s", event.Device, failure.message)
+					if failure.dropped != 0 {
+						msg += fmt.Sprintf(" (%d of %d points dropped)", failure.dropped, len(batch.Points()))
+					}
+					edgexcontext.LoggingClient.Error(msg)
+					cfg.drops.add(dropRejected, event.Device, len(event.Readings))
+					ack := newWriteAck(ackFailed, []string{event.Device}, []influx.BatchPoints{batch})
+					ack.Error = failure.message
+					cfg.acks.acknowledge(ack)
+					unlock()
+					// the events of other devices can still be written
+					rejected = err
+					continue events
+				}
+
+				log.Printf("error writing points to influx: %s\n", failure.message)
+				// save the event so that the SDK can retry the write later
+				// if store-and-forward is enabled, otherwise this is a no-op
+				// and the event is lost, along with the events not written yet
+				if payload, jsonErr := json.Marshal(mergeEvents(events[i:])); jsonErr == nil {
+					edgexcontext.SetRetryData(payload)
+				}
+				unlock()
+				return false, err
+			}
+			cfg.ordering.written(event.Device, newestByResource)
+			unlock()
+			cfg.audit.record(audited, cfg.clock.Now())
+			cfg.lasts.record(latest)
+			cfg.acks.acknowledge(newWriteAck(ackWritten, []string{event.Device}, batches))
+			cfg.notifier.writeSucceeded(event)
+			cfg.histo.archive(archived)
+			if !newest.IsZero() {
+				cfg.marks.record(event.Device, newest, cfg.clock.Now())
+			}
+		}
+		if rejected != nil {
+			return false, rejected
 		}
 
 		return true, nil
@@ -215,9 +2169,80 @@ const (
 	stringType
 )
 
+func (t dataValueType) String() string {
+	switch t {
+	case boolType:
+		return "bool"
+	case intType:
+		return "int"
+	case floatType:
+		return "float"
+	case stringType:
+		return "string"
+	}
+	return fmt.Sprintf("dataValueType(%d)", int(t))
+}
+
+// parseReadingValue parses the value of the reading as the value type declared
+// by the device service, falling back to guessing the type from the value if
+// no scalar type was declared or the value doesn't parse as it
+func parseReadingValue(reading models.Reading, floats *binaryFloats) (typeStr dataValueType, boolVal bool, floatVal float64, intVal int64) {
+	// custom decodings registered by embedders take precedence
+	if value, ok := edgexinfluxproxy.ParseValue(reading); ok {
+		switch value := value.(type) {
+		case bool:
+			return boolType, value, 0, 0
+		case int64:
+			return intType, false, 0, value
+		case float64:
+			return floatType, false, value, 0
+		}
+	}
+
+	valueStr := strings.TrimSpace(reading.Value)
+	var err error
+	switch strings.ToLower(reading.ValueType) {
+	case "bool":
+		if boolVal, err = strconv.ParseBool(valueStr); err == nil {
+			return boolType, boolVal, 0, 0
+		}
+	case "string":
+		return stringType, false, 0, 0
+	case "int8", "int16", "int32", "int64":
+		if intVal, err = strconv.ParseInt(valueStr, 10, 64); err == nil {
+			return intType, false, 0, intVal
+		}
+	case "uint8", "uint16", "uint32", "uint64":
+		uintVal, err := strconv.ParseUint(valueStr, 10, 64)
+		if err == nil {
+			if uintVal > math.MaxInt64 {
+				// influx integers are signed
+				return floatType, false, float64(uintVal), 0
+			}
+			return intType, false, 0, int64(uintVal)
+		}
+	case "float32", "float64":
+		if !strings.EqualFold(reading.FloatEncoding, "base64") {
+			if floatVal, err = strconv.ParseFloat(valueStr, 64); err == nil {
+				return floatType, false, floatVal, 0
+			}
+			break
+		}
+		data, err := base64.StdEncoding.DecodeString(valueStr)
+		if err != nil {
+			break
+		}
+		// declared floats can also be half precision
+		if floatVal, ok := decodeBinaryFloat(data, floats.byteOrder(reading.Device, reading.Name), true); ok {
+			return floatType, false, floatVal, 0
+		}
+	}
+	return parseValueType(reading.Value, floats.byteOrder(reading.Device, reading.Name))
+}
+
 // parseValueType attempts to parse the value of the string value into a
 // proper go type
-func parseValueType(valueStr string) (typeStr dataValueType, boolVal bool, floatVal float64, intVal int64) {
+func parseValueType(valueStr string, order binary.ByteOrder) (typeStr dataValueType, boolVal bool, floatVal float64, intVal int64) {
 
 	// first check for boolean
 	// NOTE: string values of true/false that aren't boolean currently will
@@ -248,13 +2273,13 @@ func parseValueType(valueStr string) (typeStr dataValueType, boolVal bool, float
 		case 4:
 			// float 32
 			typeStr = floatType
-			bits := binary.BigEndian.Uint32(data)
+			bits := order.Uint32(data)
 			floatVal = float64(math.Float32frombits(bits))
 			return
 		case 8:
 			// float 64
 			typeStr = floatType
-			bits := binary.BigEndian.Uint64(data)
+			bits := order.Uint64(data)
 			floatVal = math.Float64frombits(bits)
 			return
 		}
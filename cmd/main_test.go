@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/anonymouse64/edgex-influx-proxy/pkg/testutil"
+	"github.com/edgexfoundry/app-functions-sdk-go/appcontext"
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+	"github.com/edgexfoundry/go-mod-core-contracts/models"
+	influx "github.com/influxdata/influxdb1-client/v2"
+)
+
+// benchmarkEvent returns an event of the device with the given number of
+// readings, cycling through the value types devices commonly send
+func benchmarkEvent(readings int) models.Event {
+	origin := time.Unix(1600000000, 0).UnixNano()
+	event := models.Event{Device: "Random-Device", Origin: origin}
+	for i := 0; i < readings; i++ {
+		reading := models.Reading{
+			Id:     fmt.Sprintf("reading-%d", i),
+			Device: event.Device,
+			Origin: origin + int64(i),
+		}
+		switch i % 4 {
+		case 0:
+			reading.Name, reading.ValueType, reading.Value = fmt.Sprintf("Int%d", i), models.ValueTypeInt64, strconv.Itoa(i*1000)
+		case 1:
+			reading.Name, reading.ValueType, reading.Value = fmt.Sprintf("Float%d", i), models.ValueTypeFloat64, "1.234500e+01"
+			reading.FloatEncoding = models.ENotation
+		case 2:
+			reading.Name, reading.ValueType, reading.Value = fmt.Sprintf("Bool%d", i), models.ValueTypeBool, "true"
+		case 3:
+			reading.Name, reading.ValueType, reading.Value = fmt.Sprintf("String%d", i), models.ValueTypeString, "running"
+		}
+		event.Readings = append(event.Readings, reading)
+	}
+	return event
+}
+
+// benchmarkSizes are the numbers of readings of the events benchmarked, from
+// a single sensor to a device reporting a large register map at once
+var benchmarkSizes = []int{1, 10, 100}
+
+func BenchmarkDecodeEvent(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		payload, err := json.Marshal(benchmarkEvent(size))
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(fmt.Sprintf("readings=%d", size), func(b *testing.B) {
+			b.SetBytes(int64(len(payload)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				var event models.Event
+				if err := json.Unmarshal(payload, &event); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkParseReadingValue(b *testing.B) {
+	floats, err := newBinaryFloats("big", "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	encoded := make([]byte, 8)
+	binary.BigEndian.PutUint64(encoded, math.Float64bits(12.345))
+
+	for _, reading := range []models.Reading{
+		{Name: "int", ValueType: models.ValueTypeInt64, Value: "123456"},
+		{Name: "float-enotation", ValueType: models.ValueTypeFloat64, FloatEncoding: models.ENotation, Value: "1.234500e+01"},
+		{Name: "float-base64", ValueType: models.ValueTypeFloat64, FloatEncoding: models.Base64Encoding, Value: base64.StdEncoding.EncodeToString(encoded)},
+		{Name: "bool", ValueType: models.ValueTypeBool, Value: "true"},
+		{Name: "string", ValueType: models.ValueTypeString, Value: "running"},
+		{Name: "untyped", Value: "42"},
+	} {
+		reading := reading
+		b.Run(reading.Name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				parseReadingValue(reading, floats)
+			}
+		})
+	}
+}
+
+func BenchmarkPointMapping(b *testing.B) {
+	for name, layout := range map[string]measurementLayout{"per-device": perDeviceLayout, "per-resource": perResourceLayout} {
+		layout := layout
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			t := time.Unix(1600000000, 0)
+			for i := 0; i < b.N; i++ {
+				tags := map[string]string{"id": "reading-0"}
+				measurement, field := layout.point("Random-Device", "Temperature", tags)
+				if _, err := influx.NewPoint(measurement, tags, map[string]interface{}{field: 12.345}, t); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkBatchSerialization(b *testing.B) {
+	client := &testutil.RecordingClient{Discard: true}
+	for _, size := range benchmarkSizes {
+		bp, err := influx.NewBatchPoints(influx.BatchPointsConfig{Database: "edgex", Precision: "ns"})
+		if err != nil {
+			b.Fatal(err)
+		}
+		for i, reading := range benchmarkEvent(size).Readings {
+			pt, err := influx.NewPoint(reading.Device, map[string]string{"id": reading.Id},
+				map[string]interface{}{reading.Name: float64(i)}, time.Unix(0, reading.Origin))
+			if err != nil {
+				b.Fatal(err)
+			}
+			bp.AddPoint(pt)
+		}
+		b.Run(fmt.Sprintf("points=%d", size), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := client.Write(bp); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkSendToInfluxDB(b *testing.B) {
+	lc := logger.NewMockClient()
+	floats, err := newBinaryFloats("big", "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	write := sendToInfluxDBFunc(writeConfig{
+		influxClient: &testutil.RecordingClient{Discard: true},
+		ptConfig:     influx.BatchPointsConfig{Database: "edgex", Precision: "ns"},
+		readingIDTag: true,
+		floats:       floats,
+		tagCheck:     newTagValidator(lc, 256),
+		marks:        newHighWaterMarks(),
+		drops:        newDropAccounting(),
+	})
+	edgexcontext := &appcontext.Context{LoggingClient: lc}
+
+	for _, size := range benchmarkSizes {
+		event := benchmarkEvent(size)
+		b.Run(fmt.Sprintf("readings=%d", size), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if ok, result := write(edgexcontext, event); !ok {
+					b.Fatal(result)
+				}
+			}
+		})
+	}
+}
+
+// testWriteConfig returns the configuration of a write stage with nothing
+// but the defaults enabled, writing to the client at the time of the clock
+func testWriteConfig(client influx.Client, clk clock) writeConfig {
+	lc := logger.NewMockClient()
+	floats, _ := newBinaryFloats("big", "")
+	return writeConfig{
+		influxClient: client,
+		ptConfig:     influx.BatchPointsConfig{Database: "edgex", Precision: "ns"},
+		floats:       floats,
+		tagCheck:     newTagValidator(lc, 256),
+		marks:        newHighWaterMarks(),
+		drops:        newDropAccounting(),
+		clock:        clk,
+	}
+}
+
+func TestSendToInfluxDBFieldTypes(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	for _, tc := range []struct {
+		name    string
+		reading models.Reading
+		want    interface{}
+	}{
+		{"int", models.Reading{ValueType: models.ValueTypeInt64, Value: "42"}, int64(42)},
+		{"float", models.Reading{ValueType: models.ValueTypeFloat64, FloatEncoding: models.ENotation, Value: "1.250000e+00"}, 1.25},
+		{"bool", models.Reading{ValueType: models.ValueTypeBool, Value: "true"}, true},
+		{"string", models.Reading{ValueType: models.ValueTypeString, Value: "running"}, "running"},
+		{"untyped int", models.Reading{Value: "42"}, int64(42)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &testutil.RecordingClient{}
+			write := sendToInfluxDBFunc(testWriteConfig(client, testutil.NewFakeClock(now)))
+
+			reading := tc.reading
+			reading.Device, reading.Name, reading.Origin = "Device", "Resource", now.UnixNano()
+			ok, result := write(&appcontext.Context{LoggingClient: logger.NewMockClient()}, models.Event{Device: "Device", Readings: []models.Reading{reading}})
+			if !ok {
+				t.Fatalf("write failed: %v", result)
+			}
+
+			points := client.Points()
+			if len(points) != 1 {
+				t.Fatalf("got %d points, want 1", len(points))
+			}
+			fields, err := points[0].Fields()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fields["Resource"] != tc.want {
+				t.Errorf("got field %#v, want %#v", fields["Resource"], tc.want)
+			}
+			if !points[0].Time().Equal(now) {
+				t.Errorf("got time %s, want %s", points[0].Time(), now)
+			}
+		})
+	}
+}
+
+func TestSendToInfluxDBWriteFailures(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	for _, tc := range []struct {
+		name string
+		err  error
+		// retried events are saved for store-and-forward, rejected ones are
+		// dropped
+		wantRetry bool
+		wantDrops uint64
+	}{
+		{"unreachable", errors.New("connection refused"), true, 0},
+		{"rejected", errors.New(`{"error":"field type conflict"}`), false, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &testutil.RecordingClient{Err: tc.err}
+			cfg := testWriteConfig(client, testutil.NewFakeClock(now))
+			write := sendToInfluxDBFunc(cfg)
+
+			edgexcontext := &appcontext.Context{LoggingClient: logger.NewMockClient()}
+			ok, _ := write(edgexcontext, models.Event{Device: "Device", Readings: []models.Reading{
+				{Device: "Device", Name: "Resource", Value: "42", Origin: now.UnixNano()},
+			}})
+			if ok {
+				t.Fatal("write succeeded")
+			}
+			if got := edgexcontext.RetryData != nil; got != tc.wantRetry {
+				t.Errorf("got retry data %v, want %v", got, tc.wantRetry)
+			}
+			if got := cfg.drops.reasons[dropRejected].Total; got != tc.wantDrops {
+				t.Errorf("got %d rejected readings, want %d", got, tc.wantDrops)
+			}
+		})
+	}
+}
+
+func TestSendToInfluxDBStaleRetries(t *testing.T) {
+	origin := time.Unix(1600000000, 0)
+	for _, tc := range []struct {
+		name string
+		// age is how long after the origin the event is retried
+		age       time.Duration
+		wantWrite bool
+	}{
+		{"fresh", time.Minute, true},
+		{"stale", 2 * time.Hour, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			clk := testutil.NewFakeClock(origin)
+			client := &testutil.RecordingClient{}
+			cfg := testWriteConfig(client, clk)
+			cfg.staleness = newStaleEvents(time.Hour)
+			write := sendToInfluxDBFunc(cfg)
+
+			payload, err := json.Marshal(models.Event{Device: "Device", Origin: origin.UnixNano(), Readings: []models.Reading{
+				{Device: "Device", Name: "Resource", Value: "42", Origin: origin.UnixNano()},
+			}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			clk.Advance(tc.age)
+			if ok, result := write(&appcontext.Context{LoggingClient: logger.NewMockClient()}, payload); !ok {
+				t.Fatalf("write failed: %v", result)
+			}
+			if got := len(client.Points()) == 1; got != tc.wantWrite {
+				t.Errorf("got written %v, want %v", got, tc.wantWrite)
+			}
+			if !tc.wantWrite {
+				return
+			}
+			// the high-water mark records when the reading was written
+			mark := cfg.marks.lag(clk.Now())["Device"]
+			if !mark.Written.Equal(clk.Now()) || !mark.Origin.Equal(origin) {
+				t.Errorf("got high-water mark %+v, want origin %s written at %s", mark.highWaterMark, origin, clk.Now())
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	"github.com/edgexfoundry/app-functions-sdk-go/appcontext"
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+)
+
+const (
+	// memoryPauseRatio is the share of the budget in use at which ingestion
+	// is paused
+	memoryPauseRatio = 0.9
+	// memoryShrinkRatio is the share of the budget in use from which batches
+	// are made smaller
+	memoryShrinkRatio = 0.5
+	// memoryPollInterval is how often memory usage is sampled
+	memoryPollInterval = time.Second
+)
+
+// memoryGuard keeps the memory used by the heap within a budget by making
+// batches smaller as usage grows and pausing ingestion near the budget, so
+// that the service slows down instead of being killed for running out of
+// memory
+type memoryGuard struct {
+	budget uint64
+	lc     logger.LoggingClient
+
+	// inUse is the last sampled heap usage in bytes
+	inUse  uint64
+	paused int32
+}
+
+// startMemoryGuard samples memory usage in the background
+func startMemoryGuard(lc logger.LoggingClient, budget uint64) *memoryGuard {
+	g := &memoryGuard{budget: budget, lc: lc}
+	g.sample()
+	go func() {
+		for range time.Tick(memoryPollInterval) {
+			g.sample()
+		}
+	}()
+	return g
+}
+
+func (g *memoryGuard) sample() {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	inUse := stats.HeapInuse + stats.StackInuse
+	atomic.StoreUint64(&g.inUse, inUse)
+
+	wasPaused := atomic.LoadInt32(&g.paused) == 1
+	switch {
+	case !wasPaused && float64(inUse) >= memoryPauseRatio*float64(g.budget):
+		// give memory back before deciding to pause
+		debug.FreeOSMemory()
+		runtime.ReadMemStats(&stats)
+		inUse = stats.HeapInuse + stats.StackInuse
+		atomic.StoreUint64(&g.inUse, inUse)
+		if float64(inUse) >= memoryPauseRatio*float64(g.budget) {
+			atomic.StoreInt32(&g.paused, 1)
+			g.lc.Warn(fmt.Sprintf("using %d of %d bytes of memory, pausing ingestion", inUse, g.budget))
+		}
+	case wasPaused && float64(inUse) < memoryShrinkRatio*float64(g.budget):
+		atomic.StoreInt32(&g.paused, 0)
+		g.lc.Info(fmt.Sprintf("using %d of %d bytes of memory, resuming ingestion", inUse, g.budget))
+	}
+}
+
+// isPaused returns whether ingestion should be paused
+func (g *memoryGuard) isPaused() bool {
+	return g != nil && atomic.LoadInt32(&g.paused) == 1
+}
+
+// waitUntilResumed blocks while ingestion is paused
+func (g *memoryGuard) waitUntilResumed() {
+	for g.isPaused() {
+		time.Sleep(memoryPollInterval)
+	}
+}
+
+// batchLimit scales a batch size down from max to a tenth of it as memory
+// usage grows from memoryShrinkRatio to memoryPauseRatio of the budget
+func (g *memoryGuard) batchLimit(max int) int {
+	if g == nil {
+		return max
+	}
+	usage := float64(atomic.LoadUint64(&g.inUse)) / float64(g.budget)
+	if usage <= memoryShrinkRatio {
+		return max
+	}
+	scale := 1 - 0.9*(usage-memoryShrinkRatio)/(memoryPauseRatio-memoryShrinkRatio)
+	if scale < 0.1 {
+		scale = 0.1
+	}
+	if limit := int(float64(max) * scale); limit > 1 {
+		return limit
+	}
+	return 1
+}
+
+// rejectWhilePaused wraps a handler to respond 503 while ingestion is paused
+func (g *memoryGuard) rejectWhilePaused(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if g.isPaused() {
+			w.Header().Set("Retry-After", "1")
+			writeInfluxProblem(w, r, "memory budget exceeded, try again later", http.StatusServiceUnavailable)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// writeMetrics writes the memory usage in the Prometheus text format
+func (g *memoryGuard) writeMetrics(w io.Writer) {
+	paused := 0
+	if g.isPaused() {
+		paused = 1
+	}
+	fmt.Fprintf(w, "# HELP %smemory_in_use_bytes Heap and stack memory in use.\n", metricsPrefix)
+	fmt.Fprintf(w, "# TYPE %smemory_in_use_bytes gauge\n", metricsPrefix)
+	fmt.Fprintf(w, "%smemory_in_use_bytes %d\n", metricsPrefix, atomic.LoadUint64(&g.inUse))
+	fmt.Fprintf(w, "# HELP %smemory_budget_bytes Configured memory budget.\n", metricsPrefix)
+	fmt.Fprintf(w, "# TYPE %smemory_budget_bytes gauge\n", metricsPrefix)
+	fmt.Fprintf(w, "%smemory_budget_bytes %d\n", metricsPrefix, g.budget)
+	fmt.Fprintf(w, "# HELP %singestion_paused Whether ingestion is paused for memory.\n", metricsPrefix)
+	fmt.Fprintf(w, "# TYPE %singestion_paused gauge\n", metricsPrefix)
+	fmt.Fprintf(w, "%singestion_paused %d\n", metricsPrefix, paused)
+}
+
+// memoryFunc holds events back while ingestion is paused, slowing down
+// whatever delivers them
+func memoryFunc(g *memoryGuard) func(edgexcontext *appcontext.Context, params ...interface{}) (bool, interface{}) {
+	return func(edgexcontext *appcontext.Context, params ...interface{}) (bool, interface{}) {
+		if len(params) < 1 {
+			// We didn't receive a result
+			return false, errors.New("no data received")
+		}
+
+		g.waitUntilResumed()
+		return true, params[0]
+	}
+}
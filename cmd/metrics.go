@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// metricsPrefix prefixes the names of all the metrics served
+const metricsPrefix = "edgex_influx_proxy_"
+
+// requestDurationBuckets are the upper bounds in seconds of the request
+// duration histogram buckets
+var requestDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// routeStats are the metrics of one route
+type routeStats struct {
+	// requests counts the requests by status class, such as "2xx"
+	requests map[string]uint64
+	// buckets counts the requests that took at most the duration of the
+	// bucket with the same index
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+// routeMetrics counts the requests to each route and how long they took.
+// Routes are labelled by the path they were added with rather than the path
+// requested, so that the number of series is bounded.
+type routeMetrics struct {
+	mu     sync.Mutex
+	routes map[string]*routeStats
+	// collectors write other metrics after the route metrics
+	collectors []func(w io.Writer)
+	// headers are added to the responses of the routes
+	headers *responseHeaders
+}
+
+func newRouteMetrics() *routeMetrics {
+	return &routeMetrics{
+		routes: make(map[string]*routeStats),
+	}
+}
+
+// statusRecorder remembers the status code written to a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// wrap instruments the handler of the route
+func (m *routeMetrics) wrap(route string, handler http.HandlerFunc) http.HandlerFunc {
+	m.mu.Lock()
+	m.routes[route] = &routeStats{
+		requests: make(map[string]uint64),
+		buckets:  make([]uint64, len(requestDurationBuckets)),
+	}
+	m.mu.Unlock()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		m.headers.set(route, rec)
+		handler(rec, r)
+		m.observe(route, rec.status, time.Since(start))
+	}
+}
+
+func (m *routeMetrics) observe(route string, status int, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := m.routes[route]
+	stats.requests[fmt.Sprintf("%dxx", status/100)]++
+	seconds := d.Seconds()
+	for i, bound := range requestDurationBuckets {
+		if seconds <= bound {
+			stats.buckets[i]++
+		}
+	}
+	stats.sum += seconds
+	stats.count++
+}
+
+// metricsHandler serves the metrics in the Prometheus text format
+func (m *routeMetrics) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.writeTo(w)
+}
+
+// writeTo writes the metrics in the Prometheus text format
+func (m *routeMetrics) writeTo(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	routes := make([]string, 0, len(m.routes))
+	for route := range m.routes {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+
+	requests := metricsPrefix + "http_requests_total"
+	fmt.Fprintf(w, "# HELP %s HTTP requests by route and status class.\n", requests)
+	fmt.Fprintf(w, "# TYPE %s counter\n", requests)
+	for _, route := range routes {
+		stats := m.routes[route]
+		classes := make([]string, 0, len(stats.requests))
+		for class := range stats.requests {
+			classes = append(classes, class)
+		}
+		sort.Strings(classes)
+		for _, class := range classes {
+			fmt.Fprintf(w, "%s{route=%q,status=%q} %d\n", requests, route, class, stats.requests[class])
+		}
+	}
+
+	duration := metricsPrefix + "http_request_duration_seconds"
+	fmt.Fprintf(w, "# HELP %s HTTP request durations by route.\n", duration)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", duration)
+	for _, route := range routes {
+		stats := m.routes[route]
+		for i, bound := range requestDurationBuckets {
+			fmt.Fprintf(w, "%s_bucket{route=%q,le=\"%g\"} %d\n", duration, route, bound, stats.buckets[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{route=%q,le=\"+Inf\"} %d\n", duration, route, stats.count)
+		fmt.Fprintf(w, "%s_sum{route=%q} %g\n", duration, route, stats.sum)
+		fmt.Fprintf(w, "%s_count{route=%q} %d\n", duration, route, stats.count)
+	}
+
+	for _, collect := range m.collectors {
+		collect(w)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+	influx "github.com/influxdata/influxdb1-client/v2"
+)
+
+// missingDatabaseClient is an InfluxDB client that notices writes failing
+// because their database was dropped, so that readiness can report it, and
+// optionally creates the database again and retries the write
+type missingDatabaseClient struct {
+	influx.Client
+	lc logger.LoggingClient
+	// create is whether missing databases are created again
+	create bool
+
+	mu      sync.Mutex
+	missing map[string]bool
+}
+
+func newMissingDatabaseClient(client influx.Client, lc logger.LoggingClient, create bool) *missingDatabaseClient {
+	return &missingDatabaseClient{
+		Client:  client,
+		lc:      lc,
+		create:  create,
+		missing: make(map[string]bool),
+	}
+}
+
+func (c *missingDatabaseClient) Write(bp influx.BatchPoints) error {
+	err := c.Client.Write(bp)
+	if err == nil || !classifyWriteError(err).databaseNotFound {
+		if err == nil {
+			c.setMissing(bp.Database(), false)
+		}
+		return err
+	}
+
+	if !c.create {
+		c.setMissing(bp.Database(), true)
+		return err
+	}
+	c.lc.Warn(fmt.Sprintf("database %q not found, creating it again", bp.Database()))
+	if createErr := c.createDatabase(bp.Database()); createErr != nil {
+		c.lc.Error(fmt.Sprintf("unable to create database %q: %s", bp.Database(), createErr))
+		c.setMissing(bp.Database(), true)
+		return err
+	}
+	err = c.Client.Write(bp)
+	c.setMissing(bp.Database(), err != nil && classifyWriteError(err).databaseNotFound)
+	return err
+}
+
+func (c *missingDatabaseClient) createDatabase(database string) error {
+	resp, err := c.Client.Query(influx.NewQuery("CREATE DATABASE "+quoteIdentifier(database), "", ""))
+	if err != nil {
+		return err
+	}
+	return resp.Error()
+}
+
+func (c *missingDatabaseClient) setMissing(database string, missing bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if missing {
+		c.missing[database] = true
+	} else {
+		delete(c.missing, database)
+	}
+}
+
+// readyHandler serves /api/v1/ready, which fails with the missing databases
+// while the latest write to any of them failed because it doesn't exist
+func (c *missingDatabaseClient) readyHandler(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	missing := make([]string, 0, len(c.missing))
+	for database := range c.missing {
+		missing = append(missing, database)
+	}
+	c.mu.Unlock()
+	sort.Strings(missing)
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(missing) != 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	resp := struct {
+		Ready            bool     `json:"ready"`
+		MissingDatabases []string `json:"missingDatabases,omitempty"`
+	}{len(missing) == 0, missing}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		writeProblem(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
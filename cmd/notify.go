@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/edgexfoundry/app-functions-sdk-go/appcontext"
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/notifications"
+	"github.com/edgexfoundry/go-mod-core-contracts/models"
+)
+
+// maxNotifiedEvents bounds how many failing events write attempts are
+// counted for
+const maxNotifiedEvents = 10000
+
+// failureNotifier raises an EdgeX support-notifications alert when an event
+// is dropped because InfluxDB rejected it, or its write has failed too many
+// times, so that the failure shows up in the EdgeX operations tooling
+type failureNotifier struct {
+	// after is how many failed writes of the same event raise an alert
+	after    uint64
+	category notifications.CategoryEnum
+	severity notifications.SeverityEnum
+
+	mu       sync.Mutex
+	attempts map[string]uint64
+}
+
+func newFailureNotifier(after uint64, category, severity string) *failureNotifier {
+	return &failureNotifier{
+		after:    after,
+		category: notifications.CategoryEnum(strings.ToUpper(category)),
+		severity: notifications.SeverityEnum(strings.ToUpper(severity)),
+		attempts: make(map[string]uint64),
+	}
+}
+
+// eventKey identifies an event across the retries of its write
+func eventKey(event models.Event) string {
+	if event.ID != "" {
+		return event.ID
+	}
+	return fmt.Sprintf("%s/%d", event.Device, event.Origin)
+}
+
+// writeFailed counts a failed write of the event, raising an alert if it was
+// dropped or failed too many times
+func (n *failureNotifier) writeFailed(edgexcontext *appcontext.Context, event models.Event, failure writeFailure) {
+	if n == nil {
+		return
+	}
+
+	key := eventKey(event)
+	n.mu.Lock()
+	attempts := n.attempts[key] + 1
+	if failure.permanent || attempts >= n.after {
+		delete(n.attempts, key)
+	} else if _, ok := n.attempts[key]; ok || len(n.attempts) < maxNotifiedEvents {
+		n.attempts[key] = attempts
+	}
+	n.mu.Unlock()
+
+	switch {
+	case failure.permanent:
+		n.send(edgexcontext, "write-failure", fmt.Sprintf("Dropped event from device %q rejected by InfluxDB: %s", event.Device, failure.message))
+	case attempts == n.after:
+		n.send(edgexcontext, "write-failure", fmt.Sprintf("Writing event from device %q to InfluxDB failed %d times: %s", event.Device, attempts, failure.message))
+	}
+}
+
+// writeSucceeded stops counting the failed writes of the event
+func (n *failureNotifier) writeSucceeded(event models.Event) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	delete(n.attempts, eventKey(event))
+}
+
+// quarantined raises an alert that the circuit breaker quarantined the
+// device for the cooldown
+func (n *failureNotifier) quarantined(edgexcontext *appcontext.Context, device string, cooldown time.Duration, reason string) {
+	if n == nil {
+		return
+	}
+	n.send(edgexcontext, "quarantine", fmt.Sprintf("Quarantined device %q for %s: %s", device, cooldown, reason))
+}
+
+// send raises an alert of the kind, which is part of its slug
+func (n *failureNotifier) send(edgexcontext *appcontext.Context, kind, content string) {
+	if edgexcontext.NotificationsClient == nil {
+		edgexcontext.LoggingClient.Warn("unable to raise a notification, [Clients.Notifications] is not configured")
+		return
+	}
+	now := time.Now()
+	notification := notifications.Notification{
+		Slug:     fmt.Sprintf("%s-%s-%d", serviceKey, kind, now.UnixNano()),
+		Sender:   serviceKey,
+		Category: n.category,
+		Severity: n.severity,
+		Content:  content,
+		Labels:   []string{serviceKey, "influxdb"},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := edgexcontext.NotificationsClient.SendNotification(ctx, notification); err != nil {
+		edgexcontext.LoggingClient.Error(fmt.Sprintf("unable to raise a notification: %s", err))
+	}
+}
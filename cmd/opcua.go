@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/edgexfoundry/app-functions-sdk-go/appcontext"
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+	"github.com/edgexfoundry/go-mod-core-contracts/models"
+)
+
+// maxOPCUABody bounds the size of a network message posted to /opcua
+const maxOPCUABody = 10 << 20
+
+// opcuaValueTypes are the EdgeX value types of the OPC UA built-in types
+// that can be written as readings, by type id
+var opcuaValueTypes = map[int]string{
+	1:  "Bool",
+	2:  "Int8",
+	3:  "Uint8",
+	4:  "Int16",
+	5:  "Uint16",
+	6:  "Int32",
+	7:  "Uint32",
+	8:  "Int64",
+	9:  "Uint64",
+	10: "Float32",
+	11: "Float64",
+	12: "String",
+	// DateTime and Guid values are written as their string form
+	13: "String",
+	14: "String",
+}
+
+// opcuaNetworkMessage is an OPC UA PubSub JSON network message, its
+// DataSetMessages may also be sent on their own
+type opcuaNetworkMessage struct {
+	MessageType string                `json:"MessageType"`
+	PublisherID json.RawMessage       `json:"PublisherId"`
+	Messages    []opcuaDataSetMessage `json:"Messages"`
+}
+
+type opcuaDataSetMessage struct {
+	DataSetWriterID   json.RawMessage            `json:"DataSetWriterId"`
+	DataSetWriterName string                     `json:"DataSetWriterName"`
+	PublisherID       json.RawMessage            `json:"PublisherId"`
+	MessageType       string                     `json:"MessageType"`
+	Timestamp         time.Time                  `json:"Timestamp"`
+	Payload           map[string]json.RawMessage `json:"Payload"`
+}
+
+// opcuaField is a DataSet field, either a bare value, a Variant with its
+// type or a DataValue wrapping either
+type opcuaField struct {
+	Type            *int            `json:"Type"`
+	Body            json.RawMessage `json:"Body"`
+	Value           json.RawMessage `json:"Value"`
+	StatusCode      json.RawMessage `json:"StatusCode"`
+	SourceTimestamp time.Time       `json:"SourceTimestamp"`
+}
+
+// rawString returns a JSON string or number as a string, without the quotes
+// of a string
+func rawString(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	return string(bytes.TrimSpace(raw))
+}
+
+// opcuaReading returns the reading of a DataSet field, or false if the field
+// is null, has a bad status or a value that can't be written
+func opcuaReading(name string, raw json.RawMessage) (models.Reading, time.Time, bool) {
+	reading := models.Reading{Name: name}
+	var field opcuaField
+	if bytes.HasPrefix(bytes.TrimSpace(raw), []byte("{")) {
+		if err := json.Unmarshal(raw, &field); err != nil {
+			return reading, time.Time{}, false
+		}
+		if len(field.Value) != 0 {
+			// a DataValue, whose severity is in the top two bits of its
+			// status code, which is omitted when good
+			if code, err := strconv.ParseUint(rawString(field.StatusCode), 10, 32); err == nil && code&0x80000000 != 0 {
+				return reading, time.Time{}, false
+			}
+			var variant opcuaField
+			if bytes.HasPrefix(bytes.TrimSpace(field.Value), []byte("{")) && json.Unmarshal(field.Value, &variant) == nil && variant.Type != nil {
+				field.Type, field.Body = variant.Type, variant.Body
+			} else {
+				field.Body = field.Value
+			}
+		}
+	} else {
+		field.Body = raw
+	}
+
+	body := bytes.TrimSpace(field.Body)
+	if len(body) == 0 || string(body) == "null" {
+		return reading, time.Time{}, false
+	}
+	if field.Type != nil {
+		valueType, ok := opcuaValueTypes[*field.Type]
+		if !ok {
+			return reading, time.Time{}, false
+		}
+		reading.ValueType, reading.Value = valueType, rawString(body)
+	} else {
+		// without its type, a value is typed from its JSON form
+		switch body[0] {
+		case 't', 'f':
+			reading.ValueType, reading.Value = "Bool", string(body)
+		case '"':
+			reading.ValueType, reading.Value = "String", rawString(body)
+		case '[', '{':
+			return reading, time.Time{}, false
+		default:
+			reading.ValueType, reading.Value = "Int64", string(body)
+			if _, err := strconv.ParseInt(reading.Value, 10, 64); err != nil {
+				reading.ValueType = "Float64"
+			}
+		}
+	}
+	if reading.ValueType == "Float32" || reading.ValueType == "Float64" {
+		bitSize := 64
+		if reading.ValueType == "Float32" {
+			bitSize = 32
+		}
+		f, err := strconv.ParseFloat(reading.Value, bitSize)
+		if err != nil {
+			return reading, time.Time{}, false
+		}
+		reading.FloatEncoding = "eNotation"
+		reading.Value = strconv.FormatFloat(f, 'e', -1, bitSize)
+	}
+	return reading, field.SourceTimestamp, true
+}
+
+// opcuaEvents returns an event for every DataSetMessage with data of an OPC
+// UA PubSub JSON network message or DataSetMessage. Devices are named after
+// the publisher and the name, or else the id, of the DataSetWriter.
+func opcuaEvents(payload []byte) ([]models.Event, error) {
+	var msg opcuaNetworkMessage
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		return nil, err
+	}
+	if msg.Messages == nil {
+		if msg.MessageType != "" && msg.MessageType != "ua-data" {
+			// such as ua-metadata
+			return nil, nil
+		}
+		var dsm opcuaDataSetMessage
+		if err := json.Unmarshal(payload, &dsm); err != nil {
+			return nil, err
+		}
+		if dsm.Payload == nil {
+			return nil, errors.New("neither a network message nor a DataSetMessage")
+		}
+		msg.Messages = []opcuaDataSetMessage{dsm}
+	} else if msg.MessageType != "" && msg.MessageType != "ua-data" {
+		return nil, nil
+	}
+
+	var events []models.Event
+	for _, dsm := range msg.Messages {
+		if dsm.MessageType == "ua-keepalive" || len(dsm.Payload) == 0 {
+			continue
+		}
+		publisher := rawString(dsm.PublisherID)
+		if publisher == "" {
+			publisher = rawString(msg.PublisherID)
+		}
+		writer := dsm.DataSetWriterName
+		if writer == "" {
+			writer = rawString(dsm.DataSetWriterID)
+		}
+		device := strings.Trim(publisher+"/"+writer, "/")
+
+		event := models.Event{Device: device}
+		if !dsm.Timestamp.IsZero() {
+			event.Origin = dsm.Timestamp.UnixNano()
+		}
+		// in the same order every time
+		names := make([]string, 0, len(dsm.Payload))
+		for name := range dsm.Payload {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			reading, sourceTimestamp, ok := opcuaReading(name, dsm.Payload[name])
+			if !ok {
+				continue
+			}
+			reading.Device = device
+			reading.Origin = event.Origin
+			if !sourceTimestamp.IsZero() {
+				reading.Origin = sourceTimestamp.UnixNano()
+			}
+			event.Readings = append(event.Readings, reading)
+		}
+		if len(event.Readings) != 0 {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+// opcuaAdapter runs the DataSetMessages of OPC UA PubSub JSON publishers
+// through the pipeline, as received over MQTT or posted to /opcua
+type opcuaAdapter struct {
+	lc       logger.LoggingClient
+	pipeline []appcontext.AppFunction
+}
+
+// ingest runs the events of a network message through the pipeline
+func (a *opcuaAdapter) ingest(correlationID string, payload []byte) error {
+	events, err := opcuaEvents(payload)
+	if err != nil {
+		return fmt.Errorf("invalid OPC UA JSON message: %v", err)
+	}
+	for _, event := range events {
+		if _, err := runPipeline(a.lc, a.pipeline, sourceCorrelationID(sourceOPCUA, correlationID), event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *opcuaAdapter) handle(_ mqtt.Client, msg mqtt.Message) {
+	if err := a.ingest(msg.Topic(), msg.Payload()); err != nil {
+		a.lc.Error(fmt.Sprintf("error ingesting OPC UA message from %s: %s", msg.Topic(), err))
+	}
+}
+
+// opcuaHandler serves /opcua, which accepts a network message or
+// DataSetMessage in the body
+func (a *opcuaAdapter) opcuaHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxOPCUABody))
+	if err != nil {
+		writeProblem(w, r, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	events, err := opcuaEvents(body)
+	if err != nil {
+		writeProblem(w, r, fmt.Sprintf("invalid OPC UA JSON message: %s", err), http.StatusBadRequest)
+		return
+	}
+	for _, event := range events {
+		if _, err := runPipeline(a.lc, a.pipeline, sourceCorrelationID(sourceOPCUA, r.URL.Path), event); err != nil {
+			writeProblem(w, r, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// subscribe subscribes to the OPC UA PubSub topic of the broker
+func (a *opcuaAdapter) subscribe(appSettings map[string]string) error {
+	clientID := appSettings["OPCUAClientID"]
+	if clientID == "" {
+		clientID = serviceKey + "-opcua"
+	}
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(appSettings["OPCUABrokerURL"])
+	opts.SetClientID(clientID)
+	opts.SetUsername(appSettings["OPCUAUsername"])
+	opts.SetPassword(appSettings["OPCUAPassword"])
+	topic := appSettings["OPCUATopic"]
+	if topic == "" {
+		topic = "opcua/json/data/#"
+	}
+	return subscribeMQTT(a.lc, opts, topic, a.handle)
+}
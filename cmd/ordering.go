@@ -0,0 +1,111 @@
+package main
+
+import (
+	"hash/fnv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// orderingShards is how many locks the devices are spread over
+const orderingShards = 64
+
+// precisionDuration returns the duration of a database precision, points
+// within the same duration share their timestamp
+func precisionDuration(precision string) time.Duration {
+	switch precision {
+	case "u", "us":
+		return time.Microsecond
+	case "ms":
+		return time.Millisecond
+	case "s":
+		return time.Second
+	case "m":
+		return time.Minute
+	case "h":
+		return time.Hour
+	}
+	return time.Nanosecond
+}
+
+// seriesOrdering keeps the points of a series from being overwritten by
+// older ones. Events of the same device can be written concurrently, and
+// store-and-forward retries arrive long after newer events, so with a
+// precision coarser than nanoseconds an older reading can be written last
+// at the same timestamp as a newer one, replacing its value. The events of
+// a device are written one at a time, and a reading older than the newest
+// written for its series at the same timestamp is skipped.
+type seriesOrdering struct {
+	precision time.Duration
+	locks     [orderingShards]sync.Mutex
+
+	mu sync.Mutex
+	// newest is the newest origin written for every device/resource
+	newest map[string]time.Time
+}
+
+func newSeriesOrdering(precision string) *seriesOrdering {
+	return &seriesOrdering{
+		precision: precisionDuration(precision),
+		newest:    make(map[string]time.Time),
+	}
+}
+
+// lock holds off writing other events of the device until the returned
+// function is called
+func (o *seriesOrdering) lock(device string) func() {
+	if o == nil {
+		return func() {}
+	}
+	h := fnv.New32a()
+	h.Write([]byte(device))
+	mu := &o.locks[h.Sum32()%orderingShards]
+	mu.Lock()
+	return mu.Unlock
+}
+
+// overwrites returns whether a reading of the resource at t would replace a
+// newer value already written at the same timestamp
+func (o *seriesOrdering) overwrites(device, resource string, t time.Time) bool {
+	if o == nil || o.precision == time.Nanosecond {
+		return false
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	newest, ok := o.newest[device+"/"+resource]
+	return ok && t.Before(newest) && t.Truncate(o.precision).Equal(newest.Truncate(o.precision))
+}
+
+// written records the newest origins written for the resources of a device
+func (o *seriesOrdering) written(device string, newest map[string]time.Time) {
+	if o == nil || o.precision == time.Nanosecond {
+		return
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for resource, t := range newest {
+		key := device + "/" + resource
+		if t.After(o.newest[key]) {
+			o.newest[key] = t
+		}
+	}
+}
+
+// forget drops the newest origins of the device's resources
+func (o *seriesOrdering) forget(device string) {
+	if o == nil {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for key := range o.newest {
+		if strings.HasPrefix(key, device+"/") {
+			delete(o.newest, key)
+		}
+	}
+}
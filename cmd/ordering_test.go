@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSeriesOrderingConcurrentWrites(t *testing.T) {
+	o := newSeriesOrdering("s")
+	base := time.Unix(1600000000, 0)
+
+	// stored is what InfluxDB ends up with for each series at the shared
+	// timestamp, written like the write stage does
+	var mu sync.Mutex
+	stored := make(map[string]time.Time)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 200; i++ {
+		device := fmt.Sprintf("device-%d", i%4)
+		// the events of every device arrive out of order, all within the
+		// same second
+		origin := base.Add(time.Duration((i*37)%200) * time.Millisecond)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock := o.lock(device)
+			defer unlock()
+
+			if o.overwrites(device, "Temperature", origin) {
+				return
+			}
+			mu.Lock()
+			stored[device] = origin
+			mu.Unlock()
+			o.written(device, map[string]time.Time{"Temperature": origin})
+		}()
+	}
+	wg.Wait()
+
+	for d := 0; d < 4; d++ {
+		device := fmt.Sprintf("device-%d", d)
+		var newest time.Time
+		for i := d; i < 200; i += 4 {
+			origin := base.Add(time.Duration((i*37)%200) * time.Millisecond)
+			if origin.After(newest) {
+				newest = origin
+			}
+		}
+		if !stored[device].Equal(newest) {
+			t.Errorf("%s: stored %s, want the newest %s", device, stored[device], newest)
+		}
+	}
+}
+
+func TestSeriesOrderingOverwrites(t *testing.T) {
+	o := newSeriesOrdering("s")
+	newest := time.Unix(1600000000, int64(500*time.Millisecond))
+	o.written("device", map[string]time.Time{"Temperature": newest})
+
+	for _, tc := range []struct {
+		name string
+		t    time.Time
+		want bool
+	}{
+		{"older in the same second", newest.Add(-100 * time.Millisecond), true},
+		{"newer in the same second", newest.Add(100 * time.Millisecond), false},
+		{"the same origin", newest, false},
+		{"older in an earlier second", newest.Add(-time.Second), false},
+	} {
+		if got := o.overwrites("device", "Temperature", tc.t); got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+	if o.overwrites("device", "Humidity", newest.Add(-100*time.Millisecond)) {
+		t.Error("a series never written was overwritten")
+	}
+
+	o.forget("device")
+	if o.overwrites("device", "Temperature", newest.Add(-100*time.Millisecond)) {
+		t.Error("a forgotten series was overwritten")
+	}
+}
+
+func TestSeriesOrderingNanosecondPrecision(t *testing.T) {
+	// every origin is its own timestamp, so nothing can be overwritten
+	o := newSeriesOrdering("ns")
+	newest := time.Unix(1600000000, 500)
+	o.written("device", map[string]time.Time{"Temperature": newest})
+	if o.overwrites("device", "Temperature", newest.Add(-1)) {
+		t.Error("a reading overwrote another at nanosecond precision")
+	}
+
+	var nilOrdering *seriesOrdering
+	nilOrdering.lock("device")()
+	nilOrdering.written("device", map[string]time.Time{"Temperature": newest})
+	if nilOrdering.overwrites("device", "Temperature", newest) {
+		t.Error("a disabled ordering reported an overwrite")
+	}
+}
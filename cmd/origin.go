@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/edgexfoundry/app-functions-sdk-go/appcontext"
+	"github.com/edgexfoundry/go-mod-core-contracts/models"
+)
+
+// originMode is what replaces the origin of readings without one
+type originMode int
+
+const (
+	// originKeep writes readings without an origin at the epoch
+	originKeep originMode = iota
+	// originEvent uses the origin of the event, or the arrival time if the
+	// event doesn't have one either
+	originEvent
+	// originArrival uses the arrival time
+	originArrival
+)
+
+func parseOriginMode(s string) (originMode, error) {
+	switch s {
+	case "keep":
+		return originKeep, nil
+	case "event":
+		return originEvent, nil
+	case "arrival":
+		return originArrival, nil
+	}
+	return 0, fmt.Errorf("invalid origin policy %q, must be one of \"keep\", \"event\" or \"arrival\"", s)
+}
+
+// originPolicy replaces the zero or negative origins of readings. Replaced
+// origins of a device are kept strictly increasing, so that readings
+// arriving together don't overwrite each other in influx.
+type originPolicy struct {
+	mode originMode
+
+	mu            sync.Mutex
+	last          map[string]int64
+	substitutions map[string]uint64
+}
+
+func newOriginPolicy(mode originMode) *originPolicy {
+	return &originPolicy{
+		mode:          mode,
+		last:          make(map[string]int64),
+		substitutions: make(map[string]uint64),
+	}
+}
+
+// fix replaces the missing origins of the event's readings, returning how
+// many were replaced
+func (p *originPolicy) fix(event *models.Event, arrival time.Time) int {
+	fallback := arrival.UnixNano()
+	if p.mode == originEvent && event.Origin > 0 {
+		fallback = event.Origin
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	fixed := 0
+	for i := range event.Readings {
+		if event.Readings[i].Origin > 0 {
+			continue
+		}
+		origin := fallback
+		if last := p.last[event.Device]; origin <= last {
+			origin = last + 1
+		}
+		p.last[event.Device] = origin
+		event.Readings[i].Origin = origin
+		fixed++
+	}
+	if fixed != 0 {
+		p.substitutions[event.Device] += uint64(fixed)
+	}
+	return fixed
+}
+
+// originFunc replaces the zero or negative origins of readings
+func originFunc(p *originPolicy) func(edgexcontext *appcontext.Context, params ...interface{}) (bool, interface{}) {
+	return func(edgexcontext *appcontext.Context, params ...interface{}) (bool, interface{}) {
+		if len(params) < 1 {
+			// We didn't receive a result
+			return false, errors.New("no data received")
+		}
+
+		event, ok := params[0].(models.Event)
+		if !ok || p == nil {
+			// not an event, let the next function decide what to do with it
+			return true, params[0]
+		}
+
+		// don't modify the readings of the event passed to us
+		event.Readings = append([]models.Reading(nil), event.Readings...)
+		if n := p.fix(&event, time.Now()); n != 0 {
+			edgexcontext.LoggingClient.Debug(fmt.Sprintf("replaced the origin of %d readings from device %q", n, event.Device))
+		}
+
+		return true, event
+	}
+}
+
+// substitutionCounts returns how many origins were replaced for each device
+func (p *originPolicy) substitutionCounts() map[string]uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	counts := make(map[string]uint64, len(p.substitutions))
+	for device, n := range p.substitutions {
+		counts[device] = n
+	}
+	return counts
+}
+
+// substitutionsHandler serves how many origins were replaced for each device
+func (p *originPolicy) substitutionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(p.substitutionCounts()); err != nil {
+		writeProblem(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// forget drops the last origin and substitution count of the device
+func (p *originPolicy) forget(device string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.last, device)
+	delete(p.substitutions, device)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"hash/fnv"
+
+	"github.com/edgexfoundry/app-functions-sdk-go/appcontext"
+	"github.com/edgexfoundry/go-mod-core-contracts/models"
+)
+
+// partition is the share of devices this instance writes when several
+// instances receive the same events
+type partition struct {
+	index, count int32
+}
+
+// owns returns whether the device belongs to this instance's partition
+func (p *partition) owns(device string) bool {
+	if p == nil {
+		return true
+	}
+	h := fnv.New64a()
+	h.Write([]byte(device))
+	return jumpHash(h.Sum64(), p.count) == p.index
+}
+
+// jumpHash is the jump consistent hash of Lamping and Veach, which only moves
+// 1/n of the keys when the number of buckets grows to n
+func jumpHash(key uint64, buckets int32) int32 {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int32(b)
+}
+
+// partitionFunc drops events from devices belonging to other instances
+func partitionFunc(p *partition) func(edgexcontext *appcontext.Context, params ...interface{}) (bool, interface{}) {
+	return func(edgexcontext *appcontext.Context, params ...interface{}) (bool, interface{}) {
+		if len(params) < 1 {
+			// We didn't receive a result
+			return false, errors.New("no data received")
+		}
+
+		event, ok := params[0].(models.Event)
+		if !ok {
+			// not an event, let the next function decide what to do with it
+			return true, params[0]
+		}
+
+		if !p.owns(event.Device) {
+			return false, nil
+		}
+
+		return true, event
+	}
+}
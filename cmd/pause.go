@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/edgexfoundry/app-functions-sdk-go/appcontext"
+)
+
+// names of the built-in sources of points that can be paused
+const (
+	sourceEdgeX  = "edgex"
+	sourceWrite  = "write"
+	sourceRelay  = "relay"
+	sourceTCP    = "tcp"
+	sourceUDP    = "udp"
+	sourceStatsD = "statsd"
+	// sourceAll pauses or resumes all the sources at once
+	sourceAll = "all"
+)
+
+// errSourcePaused is returned to registered sources writing while paused
+var errSourcePaused = errors.New("ingestion from this source is paused")
+
+// ingestionControls pauses and resumes ingestion from individual sources,
+// such as during influx maintenance. Sources that can hold data back do so
+// while paused, so that it is buffered upstream, the others drop it.
+type ingestionControls struct {
+	mu     sync.Mutex
+	paused map[string]bool
+}
+
+func newIngestionControls(sources ...string) *ingestionControls {
+	c := &ingestionControls{paused: make(map[string]bool)}
+	for _, source := range sources {
+		c.paused[source] = false
+	}
+	return c
+}
+
+// isPaused returns whether ingestion from the source is paused
+func (c *ingestionControls) isPaused(source string) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.paused[source]
+}
+
+// waitUntilResumed blocks while ingestion from the source is paused
+func (c *ingestionControls) waitUntilResumed(source string) {
+	for c.isPaused(source) {
+		time.Sleep(time.Second)
+	}
+}
+
+// set pauses or resumes ingestion from the source
+func (c *ingestionControls) set(source string, paused bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if source == sourceAll {
+		for source := range c.paused {
+			c.paused[source] = paused
+		}
+		return nil
+	}
+	if _, ok := c.paused[source]; !ok {
+		return fmt.Errorf("unknown source %q", source)
+	}
+	c.paused[source] = paused
+	return nil
+}
+
+// state returns whether each source is paused
+func (c *ingestionControls) state() map[string]bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	state := make(map[string]bool, len(c.paused))
+	for source, paused := range c.paused {
+		state[source] = paused
+	}
+	return state
+}
+
+// sources returns the names of the sources that can be paused
+func (c *ingestionControls) sources() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sources := make([]string, 0, len(c.paused))
+	for source := range c.paused {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	return sources
+}
+
+// rejectWhilePaused wraps the handler of a source to respond 503 while it is
+// paused
+func (c *ingestionControls) rejectWhilePaused(source string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if c.isPaused(source) {
+			w.Header().Set("Retry-After", "60")
+			writeInfluxProblem(w, r, errSourcePaused.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// sourceCorrelationID returns the correlation ID events of a source other
+// than EdgeX are run through the pipeline with, from which pauseFunc tells
+// which source to hold them back for
+func sourceCorrelationID(source, id string) string {
+	return source + ":" + id
+}
+
+// eventSource returns the source of the events run through the pipeline with
+// the context
+func eventSource(edgexcontext *appcontext.Context) string {
+	for _, source := range []string{sourceSparkplug, sourceOPCUA} {
+		if strings.HasPrefix(edgexcontext.CorrelationID, source+":") {
+			return source
+		}
+	}
+	return sourceEdgeX
+}
+
+// pauseFunc holds events back while ingestion from their source is paused
+func pauseFunc(c *ingestionControls) func(edgexcontext *appcontext.Context, params ...interface{}) (bool, interface{}) {
+	return func(edgexcontext *appcontext.Context, params ...interface{}) (bool, interface{}) {
+		if len(params) < 1 {
+			// We didn't receive a result
+			return false, errors.New("no data received")
+		}
+
+		c.waitUntilResumed(eventSource(edgexcontext))
+		return true, params[0]
+	}
+}
+
+// ingestionHandler serves /admin/ingestion:
+//
+//	GET lists whether each source is paused
+//	POST ?source=X&paused=true pauses the source, paused=false resumes it
+func (c *ingestionControls) ingestionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		query := r.URL.Query()
+		paused, err := boolSetting(map[string]string{"paused": query.Get("paused")}, "paused", true)
+		if err != nil {
+			writeProblem(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := c.set(query.Get("source"), paused); err != nil {
+			writeProblem(w, r, fmt.Sprintf("%s, must be %s or one of %v", err, sourceAll, c.sources()), http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(c.state()); err != nil {
+		writeProblem(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// runIngestionCommand asks a running instance to pause or resume ingestion
+// from a source through /admin/ingestion:
+//
+//	admin pause|resume [-url <service URL>] [-token <token>] <source>
+func runIngestionCommand(action string, args []string) error {
+	fs := flag.NewFlagSet("admin "+action, flag.ContinueOnError)
+	serviceURL := fs.String("url", "http://localhost:48095", "URL of the running service")
+	token := fs.String("token", os.Getenv("ADMIN_TOKEN"), "bearer token for the admin routes, defaults to $ADMIN_TOKEN")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: admin %s [-url <service URL>] [-token <token>] <source>", action)
+	}
+
+	query := url.Values{}
+	query.Set("source", fs.Arg(0))
+	query.Set("paused", fmt.Sprint(action == "pause"))
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(*serviceURL, "/")+"/admin/ingestion?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+*token)
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &statusError{status: resp.StatusCode, msg: fmt.Sprintf("%s: %s", resp.Status, strings.TrimSpace(string(body)))}
+	}
+	fmt.Print(string(body))
+	return nil
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/edgexfoundry/app-functions-sdk-go/appcontext"
+)
+
+// kinds of the nodes of the pipeline topology
+const (
+	nodeSource    = "source"
+	nodeFilter    = "filter"
+	nodeTransform = "transform"
+	nodeSink      = "sink"
+)
+
+// topologyNode is a source, stage or sink of the pipeline with the settings
+// configuring it, stages also count the events through them
+type topologyNode struct {
+	Name     string            `json:"name"`
+	Kind     string            `json:"kind"`
+	Enabled  bool              `json:"enabled"`
+	Settings map[string]string `json:"settings,omitempty"`
+	// In counts the events passed to a stage, Out those it passed on,
+	// Dropped those it filtered out and Errors those it failed
+	In      *uint64 `json:"in,omitempty"`
+	Out     *uint64 `json:"out,omitempty"`
+	Dropped *uint64 `json:"dropped,omitempty"`
+	Errors  *uint64 `json:"errors,omitempty"`
+}
+
+// names of the sources of events that go through the pipeline besides
+// EdgeX
+const (
+	sourceSparkplug = "sparkplug"
+	sourceOPCUA     = "opcua"
+)
+
+// stageCounters are the live counters of a stage
+type stageCounters struct {
+	in, out, dropped, errors uint64
+}
+
+// pipelineTopology is the effective topology of the proxy as served by
+// /admin/pipeline, so that operators can check how complex configurations
+// route events: the sources events and points arrive from, the stages of
+// the EdgeX pipeline in order and the sinks points are written to.
+type pipelineTopology struct {
+	appSettings map[string]string
+	sources     []topologyNode
+	stages      []topologyNode
+	counters    []*stageCounters
+	sinks       []topologyNode
+}
+
+func newPipelineTopology(appSettings map[string]string) *pipelineTopology {
+	return &pipelineTopology{appSettings: appSettings}
+}
+
+// settings returns the non-empty values of the settings, with secrets
+// redacted
+func (t *pipelineTopology) settings(keys []string) map[string]string {
+	var settings map[string]string
+	for _, key := range keys {
+		value := t.appSettings[key]
+		if value == "" {
+			continue
+		}
+		if settings == nil {
+			settings = make(map[string]string)
+		}
+		for _, secret := range []string{"Password", "Secret", "Token", "AccessKey"} {
+			if strings.Contains(key, secret) {
+				value = "<redacted>"
+			}
+		}
+		settings[key] = value
+	}
+	return settings
+}
+
+// source adds a source of events or points
+func (t *pipelineTopology) source(name string, enabled bool, settings ...string) {
+	t.sources = append(t.sources, topologyNode{Name: name, Kind: nodeSource, Enabled: enabled, Settings: t.settings(settings)})
+}
+
+// sink adds a destination of the points written
+func (t *pipelineTopology) sink(name string, enabled bool, settings ...string) {
+	t.sinks = append(t.sinks, topologyNode{Name: name, Kind: nodeSink, Enabled: enabled, Settings: t.settings(settings)})
+}
+
+// stage adds the next function of the pipeline, returning it counting the
+// events through it
+func (t *pipelineTopology) stage(name, kind string, enabled bool, fn appcontext.AppFunction, settings ...string) appcontext.AppFunction {
+	c := &stageCounters{}
+	t.stages = append(t.stages, topologyNode{Name: name, Kind: kind, Enabled: enabled, Settings: t.settings(settings)})
+	t.counters = append(t.counters, c)
+	return func(edgexcontext *appcontext.Context, params ...interface{}) (bool, interface{}) {
+		atomic.AddUint64(&c.in, 1)
+		ok, result := fn(edgexcontext, params...)
+		switch _, isErr := result.(error); {
+		case ok:
+			atomic.AddUint64(&c.out, 1)
+		case isErr:
+			atomic.AddUint64(&c.errors, 1)
+		default:
+			atomic.AddUint64(&c.dropped, 1)
+		}
+		return ok, result
+	}
+}
+
+// index returns the position of the named stage in the pipeline, or -1
+func (t *pipelineTopology) index(name string) int {
+	for i, stage := range t.stages {
+		if stage.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// snapshot returns the stages with their current counters
+func (t *pipelineTopology) snapshot() []topologyNode {
+	stages := make([]topologyNode, len(t.stages))
+	for i, stage := range t.stages {
+		c := t.counters[i]
+		in, out := atomic.LoadUint64(&c.in), atomic.LoadUint64(&c.out)
+		dropped, errors := atomic.LoadUint64(&c.dropped), atomic.LoadUint64(&c.errors)
+		stage.In, stage.Out, stage.Dropped, stage.Errors = &in, &out, &dropped, &errors
+		stages[i] = stage
+	}
+	return stages
+}
+
+// writeDot writes the topology as a Graphviz digraph, disabled nodes are
+// dashed
+func (t *pipelineTopology) writeDot(w io.Writer, stages []topologyNode) {
+	style := func(n topologyNode) string {
+		if n.Enabled {
+			return "solid"
+		}
+		return "dashed"
+	}
+	fmt.Fprintln(w, "digraph pipeline {")
+	fmt.Fprintln(w, "  rankdir=LR;")
+	for _, n := range t.sources {
+		fmt.Fprintf(w, "  %q [shape=invhouse, style=%s];\n", "source:"+n.Name, style(n))
+	}
+	for i, n := range stages {
+		fmt.Fprintf(w, "  %q [shape=box, style=%s, label=%q];\n", "stage:"+n.Name, style(n),
+			fmt.Sprintf("%s (%s)\nin %d, out %d\ndropped %d, errors %d", n.Name, n.Kind, *n.In, *n.Out, *n.Dropped, *n.Errors))
+		if i > 0 {
+			fmt.Fprintf(w, "  %q -> %q;\n", "stage:"+stages[i-1].Name, "stage:"+n.Name)
+		}
+	}
+	for _, n := range t.sinks {
+		fmt.Fprintf(w, "  %q [shape=house, style=%s];\n", "sink:"+n.Name, style(n))
+	}
+	if len(stages) == 0 || len(t.sinks) == 0 {
+		fmt.Fprintln(w, "}")
+		return
+	}
+	// events go through the stages to the primary sink, the other sources
+	// write points to it directly, and it copies them to the other sinks
+	primary := "sink:" + t.sinks[0].Name
+	for _, n := range t.sources {
+		to := primary
+		if n.Name == sourceEdgeX || n.Name == sourceSparkplug || n.Name == sourceOPCUA {
+			to = "stage:" + stages[0].Name
+		}
+		fmt.Fprintf(w, "  %q -> %q;\n", "source:"+n.Name, to)
+	}
+	fmt.Fprintf(w, "  %q -> %q;\n", "stage:"+stages[len(stages)-1].Name, primary)
+	for _, n := range t.sinks[1:] {
+		fmt.Fprintf(w, "  %q -> %q [label=copy];\n", primary, "sink:"+n.Name)
+	}
+	fmt.Fprintln(w, "}")
+}
+
+// pipelineHandler serves /admin/pipeline, as JSON or with ?format=dot as a
+// Graphviz digraph
+func (t *pipelineTopology) pipelineHandler(w http.ResponseWriter, r *http.Request) {
+	stages := t.snapshot()
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+	case "dot":
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+		t.writeDot(w, stages)
+		return
+	default:
+		writeProblem(w, r, "format must be json or dot", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(map[string][]topologyNode{
+		"sources": t.sources,
+		"stages":  stages,
+		"sinks":   t.sinks,
+	})
+	if err != nil {
+		writeProblem(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	edgexinfluxproxy "github.com/anonymouse64/edgex-influx-proxy"
+	"github.com/edgexfoundry/app-functions-sdk-go/appcontext"
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+	"github.com/edgexfoundry/go-mod-core-contracts/models"
+	influx "github.com/influxdata/influxdb1-client/v2"
+)
+
+// namedPipeline writes the events of some devices to an InfluxDB of its own,
+// so that one proxy can serve several projects, such as HVAC devices to one
+// database and energy meters to another. It is configured by the
+// application settings prefixed with its name and a dot, falling back to the
+// proxy's own for InfluxDB, and can be paused like a source by its name.
+type namedPipeline struct {
+	name string
+	// devices and resources are the patterns of the devices routed to the
+	// pipeline and of their resources it writes, as for path.Match
+	devices   []string
+	resources []string
+	// sources are the registered sources writing to the pipeline
+	sources []string
+
+	client   influx.Client
+	ptConfig influx.BatchPointsConfig
+	layout   measurementLayout
+	// write is the write stage of the pipeline, set once the shared state it
+	// needs is created
+	write appcontext.AppFunction
+
+	// the types, high-water marks, last values and drops of the pipeline are
+	// kept apart from the proxy's own, and served by the same routes with
+	// ?pipeline=name
+	typing *typingDecisions
+	marks  *highWaterMarks
+	lasts  *lastValues
+	drops  *dropAccounting
+
+	written, filtered, failed uint64
+}
+
+// pipelineSettings returns the settings of the named pipeline, which are
+// the application settings prefixed with its name and a dot, along with the
+// application settings it doesn't override
+func pipelineSettings(appSettings map[string]string, name string) map[string]string {
+	settings := make(map[string]string, len(appSettings))
+	for k, v := range appSettings {
+		settings[k] = v
+	}
+	// the sources and sinks of the proxy are its own
+	delete(settings, "Sources")
+	delete(settings, "Sinks")
+	prefix := name + "."
+	for k, v := range appSettings {
+		if strings.HasPrefix(k, prefix) {
+			settings[strings.TrimPrefix(k, prefix)] = v
+		}
+	}
+	return settings
+}
+
+// newNamedPipeline creates the named pipeline from the application
+// settings, printing the points it would write to dryRun instead of writing
+// them if it isn't nil
+func newNamedPipeline(lc logger.LoggingClient, appSettings map[string]string, name string, dryRun io.Writer) (*namedPipeline, error) {
+	settings := pipelineSettings(appSettings, name)
+	p := &namedPipeline{
+		name:      name,
+		devices:   splitList(settings["Devices"]),
+		resources: splitList(settings["Resources"]),
+		sources:   splitList(settings["Sources"]),
+	}
+	if len(p.devices) == 0 && len(p.sources) == 0 {
+		return nil, fmt.Errorf("%q or %q is required", name+".Devices", name+".Sources")
+	}
+	for _, pattern := range append(append([]string(nil), p.devices...), p.resources...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q of pipeline %q", pattern, name)
+		}
+	}
+
+	host := settings["InfluxDBHost"]
+	if host == "" {
+		host = "localhost"
+	}
+	port := uint64(8086)
+	if portStr := settings["InfluxDBPort"]; portStr != "" {
+		var err error
+		port, err = strconv.ParseUint(portStr, 10, 64)
+		if err != nil || port == 0 {
+			return nil, fmt.Errorf("invalid %q setting of %s, must be integer greater than 0", name+".InfluxDBPort", portStr)
+		}
+	}
+	p.ptConfig = influx.BatchPointsConfig{
+		Database:  settings["InfluxDBDatabaseName"],
+		Precision: settings["InfluxDBDatabasePrecision"],
+	}
+	if p.ptConfig.Database == "" {
+		return nil, fmt.Errorf("missing value for %q", name+".InfluxDBDatabaseName")
+	}
+	layoutStr := settings["MeasurementLayout"]
+	if layoutStr == "" {
+		layoutStr = "per-device"
+	}
+	var err error
+	p.layout, err = parseMeasurementLayout(layoutStr)
+	if err != nil {
+		return nil, err
+	}
+
+	typingWindow, err := durationSetting(settings, "TypingStatsWindow", time.Hour)
+	if err != nil || typingWindow < typeCountBuckets {
+		return nil, fmt.Errorf("invalid %q setting of %s, must be a positive duration", name+".TypingStatsWindow", settings["TypingStatsWindow"])
+	}
+	maxLastSeries, err := uintSetting(settings, "LastValueMaxSeries", 10000)
+	if err != nil {
+		return nil, err
+	}
+	p.typing = newTypingDecisions(typingWindow)
+	p.marks = newHighWaterMarks()
+	if maxLastSeries != 0 {
+		p.lasts = newLastValues(int(maxLastSeries))
+	}
+	p.drops = newDropAccounting()
+
+	if dryRun != nil {
+		p.client = &dryRunClient{w: dryRun}
+	} else {
+		p.client, err = influx.NewHTTPClient(influx.HTTPConfig{
+			Addr:     fmt.Sprintf("http://%s:%d", host, port),
+			Username: settings["InfluxDBUsername"],
+			Password: settings["InfluxDBPassword"],
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// and hand what it writes to its own sinks
+	if sinkNames := splitList(settings["Sinks"]); len(sinkNames) != 0 && dryRun == nil {
+		fanout := &fanoutClient{
+			Client: p.client,
+			lc:     lc,
+			sinks:  make(map[string]edgexinfluxproxy.Sink),
+		}
+		for _, sinkName := range sinkNames {
+			sink, err := edgexinfluxproxy.NewSink(sinkName, lc, settings)
+			if err != nil {
+				return nil, fmt.Errorf("unable to create sink %q: %v", sinkName, err)
+			}
+			fanout.sinks[sinkName] = sink
+		}
+		p.client = fanout
+	}
+	return p, nil
+}
+
+// writeConfig returns the configuration of the pipeline's write stage, based
+// on the proxy's own. Its events went through every stage of the proxy's
+// pipeline before being routed to it, and it writes them with the same
+// circuit breaker, anomaly detection, units, counters, state durations,
+// ordering, acks and notifier, which are all kept by device. It doesn't
+// route late readings to the backfill retention policy nor audit deliveries,
+// which are of the proxy's own database, nor summarize high-rate resources,
+// whose raw samples are archived to the proxy's own sinks.
+func (p *namedPipeline) writeConfig(own writeConfig) writeConfig {
+	cfg := own
+	cfg.influxClient, cfg.ptConfig, cfg.layout = p.client, p.ptConfig, p.layout
+	cfg.typing, cfg.marks, cfg.lasts, cfg.drops = p.typing, p.marks, p.lasts, p.drops
+	cfg.backfill, cfg.audit, cfg.histo = nil, nil, nil
+	return cfg
+}
+
+// forget drops what the pipeline keeps of the device
+func (p *namedPipeline) forget(device string) {
+	p.typing.forget(device)
+	p.marks.forget(device)
+	p.lasts.forget(device)
+}
+
+// pipelineHandler serves a route with the handler of the pipeline named by
+// the pipeline query parameter, or with the proxy's own without one
+func pipelineHandler(pipelines []*namedPipeline, own http.HandlerFunc, handler func(p *namedPipeline) http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("pipeline")
+		if name == "" {
+			own(w, r)
+			return
+		}
+		for _, p := range pipelines {
+			if p.name != name {
+				continue
+			}
+			if h := handler(p); h != nil {
+				h(w, r)
+				return
+			}
+			break
+		}
+		writeProblem(w, r, fmt.Sprintf("no pipeline %q", name), http.StatusNotFound)
+	}
+}
+
+// matchesAny returns whether the pattern of one of the names matches name
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// routePipeline returns the first of the pipelines the device's events are routed
+// to, or nil if they go through the proxy's own
+func routePipeline(pipelines []*namedPipeline, device string) *namedPipeline {
+	for _, p := range pipelines {
+		if matchesAny(p.devices, device) {
+			return p
+		}
+	}
+	return nil
+}
+
+func writePipelineMetrics(pipelines []*namedPipeline) func(w io.Writer) {
+	return func(w io.Writer) {
+		fmt.Fprintf(w, "# HELP %spipeline_events_total Events routed to each named pipeline by result.\n", metricsPrefix)
+		fmt.Fprintf(w, "# TYPE %spipeline_events_total counter\n", metricsPrefix)
+		for _, p := range pipelines {
+			fmt.Fprintf(w, "%spipeline_events_total{pipeline=%q,result=\"written\"} %d\n", metricsPrefix, p.name, atomic.LoadUint64(&p.written))
+			fmt.Fprintf(w, "%spipeline_events_total{pipeline=%q,result=\"filtered\"} %d\n", metricsPrefix, p.name, atomic.LoadUint64(&p.filtered))
+			fmt.Fprintf(w, "%spipeline_events_total{pipeline=%q,result=\"failed\"} %d\n", metricsPrefix, p.name, atomic.LoadUint64(&p.failed))
+		}
+		fmt.Fprintf(w, "# HELP %spipeline_dropped_readings_total Readings each named pipeline dropped instead of writing, by reason.\n", metricsPrefix)
+		fmt.Fprintf(w, "# TYPE %spipeline_dropped_readings_total counter\n", metricsPrefix)
+		reasons := append([]string(nil), dropReasons...)
+		sort.Strings(reasons)
+		for _, p := range pipelines {
+			p.drops.mu.Lock()
+			for _, reason := range reasons {
+				fmt.Fprintf(w, "%spipeline_dropped_readings_total{pipeline=%q,reason=%q} %d\n", metricsPrefix, p.name, reason, p.drops.reasons[reason].Total)
+			}
+			p.drops.mu.Unlock()
+		}
+	}
+}
+
+// pipelineRetry is the retry data of events a named pipeline failed to write,
+// so that store-and-forward retries them with the pipeline's write stage
+// rather than the proxy's own
+type pipelineRetry struct {
+	Pipeline string          `json:"pipeline"`
+	Data     json.RawMessage `json:"data"`
+}
+
+// pipelinesFunc writes the events of the devices routed to a named pipeline
+// to its InfluxDB instead of passing them on, holding them back while the
+// pipeline is paused. Events it fails to write are saved for store-and-forward
+// tagged with the name of their pipeline, and retried events of the devices
+// routed to a pipeline are written by it as well.
+func pipelinesFunc(pipelines []*namedPipeline, controls *ingestionControls) func(edgexcontext *appcontext.Context, params ...interface{}) (bool, interface{}) {
+	byName := make(map[string]*namedPipeline, len(pipelines))
+	for _, p := range pipelines {
+		byName[p.name] = p
+	}
+	return func(edgexcontext *appcontext.Context, params ...interface{}) (bool, interface{}) {
+		if len(params) < 1 {
+			// We didn't receive a result
+			return false, errors.New("no data received")
+		}
+
+		switch v := params[0].(type) {
+		case models.Event:
+			p := routePipeline(pipelines, v.Device)
+			if p == nil {
+				return true, v
+			}
+			controls.waitUntilResumed(p.name)
+			return false, p.writeEvent(edgexcontext, v)
+		case []byte:
+			return retryPipelines(edgexcontext, pipelines, byName, v)
+		default:
+			// not an event, let the next function decide what to do with it
+			return true, params[0]
+		}
+	}
+}
+
+// retryPipelines writes the retried data with the pipelines it belongs to,
+// passing on the events of the devices that aren't routed to any
+func retryPipelines(edgexcontext *appcontext.Context, pipelines []*namedPipeline, byName map[string]*namedPipeline, data []byte) (bool, interface{}) {
+	var retry pipelineRetry
+	if err := json.Unmarshal(data, &retry); err == nil && retry.Pipeline != "" {
+		if p, ok := byName[retry.Pipeline]; ok {
+			return false, p.run(edgexcontext, retry.Data)
+		}
+		// the pipeline was removed since, so route its events again
+		edgexcontext.LoggingClient.Warn(fmt.Sprintf("retrying events of removed pipeline %q", retry.Pipeline))
+		data = retry.Data
+	}
+
+	// events saved by the split function can be of any device, routed to a
+	// pipeline or not
+	var event models.Event
+	if err := json.Unmarshal(data, &event); err != nil {
+		// let the write function report it
+		return true, data
+	}
+	var rest []models.Event
+	var firstErr error
+	for _, event := range splitByDevice(event) {
+		p := routePipeline(pipelines, event.Device)
+		if p == nil {
+			rest = append(rest, event)
+			continue
+		}
+		payload, err := json.Marshal(event)
+		if err != nil {
+			continue
+		}
+		if err := p.run(edgexcontext, payload); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		return false, firstErr
+	}
+	if len(rest) == 0 {
+		return false, nil
+	}
+	payload, err := json.Marshal(mergeEvents(rest))
+	if err != nil {
+		return false, err
+	}
+	return true, payload
+}
+
+// writeEvent writes the event with the write stage of the pipeline, saving it
+// as retry data of the pipeline if the write fails
+func (p *namedPipeline) writeEvent(edgexcontext *appcontext.Context, event models.Event) error {
+	if len(p.resources) != 0 {
+		readings := make([]models.Reading, 0, len(event.Readings))
+		for _, reading := range event.Readings {
+			if matchesAny(p.resources, reading.Name) {
+				readings = append(readings, reading)
+			}
+		}
+		event.Readings = readings
+	}
+	if len(event.Readings) == 0 {
+		atomic.AddUint64(&p.filtered, 1)
+		return nil
+	}
+	return p.run(edgexcontext, event)
+}
+
+// run runs the write stage of the pipeline with a context of its own, so that
+// the retry data it sets can be tagged with the pipeline before it is saved
+func (p *namedPipeline) run(edgexcontext *appcontext.Context, data interface{}) error {
+	sub := *edgexcontext
+	sub.RetryData = nil
+	ok, result := p.write(&sub, data)
+	if sub.RetryData != nil {
+		if payload, err := json.Marshal(pipelineRetry{Pipeline: p.name, Data: sub.RetryData}); err == nil {
+			edgexcontext.SetRetryData(payload)
+		}
+	}
+	if !ok {
+		atomic.AddUint64(&p.failed, 1)
+		if err, isErr := result.(error); isErr {
+			return fmt.Errorf("pipeline %q: %v", p.name, err)
+		}
+		return nil
+	}
+	atomic.AddUint64(&p.written, 1)
+	return nil
+}
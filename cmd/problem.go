@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// correlationIDHeader is the header EdgeX services pass correlation ids in
+const correlationIDHeader = "X-Correlation-ID"
+
+// problem is an RFC 7807 problem details error response. Errors have no
+// more specific type than their status code, so the type is always
+// about:blank and the title the text of the status code.
+type problem struct {
+	Type          string `json:"type"`
+	Title         string `json:"title"`
+	Status        int    `json:"status"`
+	Detail        string `json:"detail,omitempty"`
+	Instance      string `json:"instance,omitempty"`
+	CorrelationID string `json:"correlationId,omitempty"`
+	// Error repeats the detail for the InfluxDB compatible endpoints, whose
+	// clients expect InfluxDB's {"error": ...} responses
+	Error string `json:"error,omitempty"`
+}
+
+func newProblem(r *http.Request, detail string, status int) problem {
+	return problem{
+		Type:          "about:blank",
+		Title:         http.StatusText(status),
+		Status:        status,
+		Detail:        detail,
+		Instance:      r.URL.Path,
+		CorrelationID: r.Header.Get(correlationIDHeader),
+	}
+}
+
+// writeProblem responds with an application/problem+json error, it is used
+// like http.Error
+func writeProblem(w http.ResponseWriter, r *http.Request, detail string, status int) {
+	encodeProblem(w, newProblem(r, detail, status))
+}
+
+// writeInfluxProblem responds with an application/problem+json error that
+// InfluxDB clients can also read the message of
+func writeInfluxProblem(w http.ResponseWriter, r *http.Request, detail string, status int) {
+	p := newProblem(r, detail, status)
+	p.Error = detail
+	encodeProblem(w, p)
+}
+
+func encodeProblem(w http.ResponseWriter, p problem) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/edgexfoundry/app-functions-sdk-go/appcontext"
+	"github.com/edgexfoundry/go-mod-core-contracts/models"
+)
+
+// quality levels of readings
+const (
+	qualityGood      = "good"
+	qualityBad       = "bad"
+	qualityUncertain = "uncertain"
+)
+
+const (
+	// qualityTag is the tag the quality of readings is written in
+	qualityTag = "quality"
+	// qualityFieldSuffix is appended to the field of a resource for the
+	// field its quality is written in instead
+	qualityFieldSuffix = "_quality"
+)
+
+func validQuality(level string) bool {
+	return level == qualityGood || level == qualityBad || level == qualityUncertain
+}
+
+// qualityMapping maps the quality indicators device services put in event
+// tags to good, bad or uncertain, so that bad quality data can be excluded
+// from analytics. The tag applies to every reading of the event, unless the
+// tag followed by a dot and the name of the resource overrides it.
+type qualityMapping struct {
+	tag string
+	// values map indicators to quality levels, indicators that are neither
+	// mapped nor a level themselves are uncertain
+	values map[string]string
+	// exclude are the levels of readings to drop
+	exclude map[string]bool
+	// asField writes the quality in a field next to the value rather than a
+	// tag
+	asField bool
+}
+
+func newQualityMapping(tag string, values map[string]string, exclude []string, asField bool) (*qualityMapping, error) {
+	q := &qualityMapping{
+		tag:     tag,
+		values:  make(map[string]string),
+		exclude: make(map[string]bool),
+		asField: asField,
+	}
+	for indicator, level := range values {
+		if !validQuality(level) {
+			return nil, fmt.Errorf("invalid quality %q of %q, must be one of \"good\", \"bad\" or \"uncertain\"", level, indicator)
+		}
+		q.values[strings.ToLower(indicator)] = level
+	}
+	for _, level := range exclude {
+		if !validQuality(level) {
+			return nil, fmt.Errorf("invalid quality %q to exclude, must be one of \"good\", \"bad\" or \"uncertain\"", level)
+		}
+		q.exclude[level] = true
+	}
+	return q, nil
+}
+
+// quality returns the quality of the event's reading of the resource, and
+// whether the device service indicated any
+func (q *qualityMapping) quality(event models.Event, resource string) (string, bool) {
+	if q == nil {
+		return "", false
+	}
+	indicator, ok := event.Tags[q.tag+"."+resource]
+	if !ok {
+		indicator, ok = event.Tags[q.tag]
+	}
+	if !ok {
+		return "", false
+	}
+	indicator = strings.ToLower(strings.TrimSpace(indicator))
+	if level, ok := q.values[indicator]; ok {
+		return level, true
+	}
+	if validQuality(indicator) {
+		return indicator, true
+	}
+	return qualityUncertain, true
+}
+
+// excluded returns whether the event's reading of the resource is of a
+// quality to drop
+func (q *qualityMapping) excluded(event models.Event, resource string) bool {
+	level, ok := q.quality(event, resource)
+	return ok && q.exclude[level]
+}
+
+// qualityFunc drops the readings of a quality to exclude
+func qualityFunc(q *qualityMapping, drops *dropAccounting) func(edgexcontext *appcontext.Context, params ...interface{}) (bool, interface{}) {
+	return func(edgexcontext *appcontext.Context, params ...interface{}) (bool, interface{}) {
+		if len(params) < 1 {
+			// We didn't receive a result
+			return false, errors.New("no data received")
+		}
+
+		event, ok := params[0].(models.Event)
+		if !ok || q == nil || len(q.exclude) == 0 {
+			// not an event, let the next function decide what to do with it
+			return true, params[0]
+		}
+
+		readings := make([]models.Reading, 0, len(event.Readings))
+		for _, reading := range event.Readings {
+			if !q.excluded(event, reading.Name) {
+				readings = append(readings, reading)
+			}
+		}
+		drops.add(dropQuality, event.Device, len(event.Readings)-len(readings))
+		if len(readings) == 0 {
+			return false, nil
+		}
+		event.Readings = readings
+
+		return true, event
+	}
+}
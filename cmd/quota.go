@@ -0,0 +1,260 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/edgexfoundry/app-functions-sdk-go/appcontext"
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+	"github.com/edgexfoundry/go-mod-core-contracts/models"
+)
+
+// quotaWindow is the period over which quotas are counted
+const quotaWindow = time.Minute
+
+// quotaMode is what happens to events over quota
+type quotaMode int
+
+const (
+	// quotaDrop drops the events over quota
+	quotaDrop quotaMode = iota
+	// quotaThrottle defers events over quota until the next window
+	quotaThrottle
+	// quotaAlert only logs that the quota was exceeded
+	quotaAlert
+)
+
+func parseQuotaMode(s string) (quotaMode, error) {
+	switch s {
+	case "drop":
+		return quotaDrop, nil
+	case "throttle":
+		return quotaThrottle, nil
+	case "alert":
+		return quotaAlert, nil
+	}
+	return 0, fmt.Errorf("invalid quota mode %q, must be one of \"drop\", \"throttle\" or \"alert\"", s)
+}
+
+// quotas limits how many points each device, or each tenant if events are
+// tagged with one, may write per minute
+type quotas struct {
+	pointsPerWindow uint64
+	mode            quotaMode
+	// tenantTag is the event tag identifying the tenant, if empty quotas are
+	// per device
+	tenantTag string
+	// clock is the system clock unless set by tests
+	clock clock
+
+	mu      sync.Mutex
+	windows map[string]*quotaUsage
+	// deferred are the events over quota held back by key in throttle mode,
+	// until the window they are written in
+	deferred map[string]*deferredEvents
+}
+
+// deferredEvents are the events of a key deferred to a later window, which
+// are at most a window's worth of points
+type deferredEvents struct {
+	events []models.Event
+	points uint64
+}
+
+type quotaUsage struct {
+	start   time.Time
+	points  uint64
+	dropped uint64
+	alerted bool
+}
+
+func newQuotas(pointsPerMinute uint64, mode quotaMode, tenantTag string) *quotas {
+	return &quotas{
+		pointsPerWindow: pointsPerMinute,
+		mode:            mode,
+		tenantTag:       tenantTag,
+		clock:           systemClock{},
+		windows:         make(map[string]*quotaUsage),
+		deferred:        make(map[string]*deferredEvents),
+	}
+}
+
+// key returns who the event counts against
+func (q *quotas) key(event models.Event) string {
+	if q.tenantTag != "" {
+		if tenant, ok := event.Tags[q.tenantTag]; ok {
+			return "tenant " + tenant
+		}
+	}
+	return "device " + event.Device
+}
+
+// take counts n points against the key's quota. It returns whether they fit,
+// whether this is the first time the quota was exceeded in the window, and
+// how many points were dropped in the previous window if this starts a new
+// one.
+func (q *quotas) take(key string, n uint64, now time.Time) (ok bool, first bool, droppedBefore uint64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.takeLocked(key, n, now)
+}
+
+// takeLocked is take with the lock held
+func (q *quotas) takeLocked(key string, n uint64, now time.Time) (ok bool, first bool, droppedBefore uint64) {
+	usage, exists := q.windows[key]
+	if !exists || now.Sub(usage.start) >= quotaWindow {
+		if exists {
+			droppedBefore = usage.dropped
+		}
+		usage = &quotaUsage{start: now}
+		q.windows[key] = usage
+	}
+
+	if usage.points+n <= q.pointsPerWindow {
+		usage.points += n
+		return true, false, droppedBefore
+	}
+
+	first = !usage.alerted
+	usage.alerted = true
+	if q.mode == quotaDrop {
+		usage.dropped += n
+	}
+	return false, first, droppedBefore
+}
+
+// throttle counts the points of the event against the key's quota like
+// take, deferring the event if they don't fit or earlier events of the key
+// are deferred already, so that they keep their order. It returns whether the
+// event fits now and whether it was deferred, events are neither if a
+// window's worth of points is deferred already.
+func (q *quotas) throttle(key string, event models.Event, now time.Time) (ok, deferred, first bool, droppedBefore uint64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	n := uint64(len(event.Readings))
+	d, waiting := q.deferred[key]
+	if !waiting {
+		ok, first, droppedBefore = q.takeLocked(key, n, now)
+		if ok {
+			return true, false, false, droppedBefore
+		}
+		d = &deferredEvents{}
+	}
+	if d.points+n > q.pointsPerWindow {
+		return false, false, first, droppedBefore
+	}
+	d.events = append(d.events, event)
+	d.points += n
+	q.deferred[key] = d
+	return false, true, first, droppedBefore
+}
+
+// due returns the deferred events that fit the quotas of their keys at now,
+// keeping the rest deferred
+func (q *quotas) due(now time.Time) []models.Event {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var due []models.Event
+	for key, d := range q.deferred {
+		for len(d.events) != 0 {
+			n := uint64(len(d.events[0].Readings))
+			if ok, _, _ := q.takeLocked(key, n, now); !ok {
+				break
+			}
+			due = append(due, d.events[0])
+			d.events = d.events[1:]
+			d.points -= n
+		}
+		if len(d.events) == 0 {
+			delete(q.deferred, key)
+		}
+	}
+	return due
+}
+
+// releaseDeferred runs the deferred events through the rest of the pipeline
+// once they fit the quotas of their keys, checking every second
+func (q *quotas) releaseDeferred(lc logger.LoggingClient, rest []appcontext.AppFunction) {
+	for now := range q.clock.Tick(time.Second) {
+		for _, event := range q.due(now) {
+			if _, err := runPipeline(lc, rest, "", event); err != nil {
+				lc.Error(fmt.Sprintf("error writing deferred event from device %q: %s", event.Device, err))
+			}
+		}
+	}
+}
+
+// quotaFunc enforces the quotas on each event
+func quotaFunc(q *quotas, drops *dropAccounting) func(edgexcontext *appcontext.Context, params ...interface{}) (bool, interface{}) {
+	return func(edgexcontext *appcontext.Context, params ...interface{}) (bool, interface{}) {
+		if len(params) < 1 {
+			// We didn't receive a result
+			return false, errors.New("no data received")
+		}
+
+		event, ok := params[0].(models.Event)
+		if !ok || q == nil {
+			// not an event, let the next function decide what to do with it
+			return true, params[0]
+		}
+
+		key := q.key(event)
+		n := uint64(len(event.Readings))
+		if q.mode == quotaThrottle {
+			fits, deferred, first, droppedBefore := q.throttle(key, event, q.clock.Now())
+			if droppedBefore != 0 {
+				edgexcontext.LoggingClient.Warn(fmt.Sprintf("dropped %d points from %s over quota in the last window", droppedBefore, key))
+			}
+			switch {
+			case fits:
+				return true, event
+			case deferred:
+				if first {
+					edgexcontext.LoggingClient.Warn(fmt.Sprintf("%s exceeded its quota of %d points per minute, deferring its events to the next minute", key, q.pointsPerWindow))
+				}
+				return false, nil
+			}
+			// a window's worth is deferred already, or the event would
+			// never fit
+			edgexcontext.LoggingClient.Warn(fmt.Sprintf("dropping event with %d readings from %s, more than can be deferred within its quota of %d points per minute", n, key, q.pointsPerWindow))
+			drops.add(dropQuota, event.Device, len(event.Readings))
+			return false, nil
+		}
+
+		fits, first, droppedBefore := q.take(key, n, q.clock.Now())
+		if droppedBefore != 0 {
+			edgexcontext.LoggingClient.Warn(fmt.Sprintf("dropped %d points from %s over quota in the last window", droppedBefore, key))
+		}
+		if fits {
+			return true, event
+		}
+		if q.mode == quotaAlert {
+			if first {
+				edgexcontext.LoggingClient.Warn(fmt.Sprintf("%s exceeded its quota of %d points per minute", key, q.pointsPerWindow))
+			}
+			return true, event
+		}
+		if first {
+			edgexcontext.LoggingClient.Warn(fmt.Sprintf("%s exceeded its quota of %d points per minute, dropping its events until the next minute", key, q.pointsPerWindow))
+		}
+		drops.add(dropQuota, event.Device, len(event.Readings))
+		return false, nil
+	}
+}
+
+// forget drops the usage of the device, tenants' usage is kept
+func (q *quotas) forget(device string) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.windows, "device "+device)
+	delete(q.deferred, "device "+device)
+}
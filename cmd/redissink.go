@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	edgexinfluxproxy "github.com/anonymouse64/edgex-influx-proxy"
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+	influx "github.com/influxdata/influxdb1-client/v2"
+)
+
+// redisTimeout bounds connecting to redis and every batch of commands
+const redisTimeout = 10 * time.Second
+
+func init() {
+	edgexinfluxproxy.RegisterSink("redis-stream", newRedisStreamSink)
+}
+
+// redisStreamSink adds every point to a redis stream with XADD, capped at
+// about maxLen entries, for local consumers of live telemetry. It speaks
+// just enough of the redis protocol to do so.
+type redisStreamSink struct {
+	addr     string
+	password string
+	key      string
+	maxLen   uint64
+
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func newRedisStreamSink(lc logger.LoggingClient, settings map[string]string) (edgexinfluxproxy.Sink, error) {
+	s := &redisStreamSink{
+		addr:     settings["RedisStreamAddress"],
+		password: settings["RedisStreamPassword"],
+		key:      settings["RedisStreamKey"],
+	}
+	if s.addr == "" {
+		s.addr = "localhost:6379"
+	}
+	if s.key == "" {
+		s.key = serviceKey
+	}
+	var err error
+	s.maxLen, err = uintSetting(settings, "RedisStreamMaxLen", 10000)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// writeCommand writes a command as a RESP array of bulk strings
+func writeCommand(w io.Writer, args ...string) error {
+	if _, err := fmt.Fprintf(w, "*%d\r\n", len(args)); err != nil {
+		return err
+	}
+	for _, arg := range args {
+		if _, err := fmt.Fprintf(w, "$%d\r\n%s\r\n", len(arg), arg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readReply reads a simple, integer or bulk string reply, returning error
+// replies as errors
+func readReply(r *bufio.Reader) error {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if len(line) < 3 {
+		return errors.New("invalid reply from redis")
+	}
+	switch line[0] {
+	case '+', ':':
+		return nil
+	case '-':
+		return errors.New(line[1 : len(line)-2])
+	case '$':
+		n, err := strconv.Atoi(line[1 : len(line)-2])
+		if err != nil {
+			return errors.New("invalid reply from redis")
+		}
+		if n < 0 {
+			return nil
+		}
+		_, err = r.Discard(n + 2)
+		return err
+	}
+	return fmt.Errorf("unexpected reply from redis: %q", line)
+}
+
+// connect dials redis and authenticates if a password is set
+func (s *redisStreamSink) connect() error {
+	conn, err := net.DialTimeout("tcp", s.addr, redisTimeout)
+	if err != nil {
+		return err
+	}
+	s.conn, s.r = conn, bufio.NewReader(conn)
+	if s.password == "" {
+		return nil
+	}
+	s.conn.SetDeadline(time.Now().Add(redisTimeout))
+	if err := writeCommand(s.conn, "AUTH", s.password); err != nil {
+		return err
+	}
+	return readReply(s.r)
+}
+
+func (s *redisStreamSink) Write(points []*influx.Point) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.xadd(points)
+	if err != nil && s.conn != nil {
+		// start over with a new connection next time
+		s.conn.Close()
+		s.conn = nil
+	}
+	return err
+}
+
+// xadd adds the points to the stream in a single round trip
+func (s *redisStreamSink) xadd(points []*influx.Point) error {
+	if s.conn == nil {
+		if err := s.connect(); err != nil {
+			return err
+		}
+	}
+	s.conn.SetDeadline(time.Now().Add(redisTimeout))
+
+	w := bufio.NewWriter(s.conn)
+	maxLen := strconv.FormatUint(s.maxLen, 10)
+	for _, pt := range points {
+		tags, err := json.Marshal(pt.Tags())
+		if err != nil {
+			return err
+		}
+		fields, err := pt.Fields()
+		if err != nil {
+			return err
+		}
+		fieldsJSON, err := json.Marshal(fields)
+		if err != nil {
+			return err
+		}
+		err = writeCommand(w, "XADD", s.key, "MAXLEN", "~", maxLen, "*",
+			"measurement", pt.Name(),
+			"tags", string(tags),
+			"fields", string(fieldsJSON),
+			"time", pt.Time().UTC().Format(time.RFC3339Nano),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	var firstErr error
+	for range points {
+		if err := readReply(s.r); err != nil {
+			if _, isNetErr := err.(net.Error); isNetErr || err == io.EOF {
+				return err
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
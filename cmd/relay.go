@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	influx "github.com/influxdata/influxdb1-client/v2"
+)
+
+const (
+	relayTimestampHeader = "X-Relay-Timestamp"
+	relayNonceHeader     = "X-Relay-Nonce"
+	relaySignatureHeader = "X-Relay-Signature"
+
+	// relayMaxSkew is how old (or how far in the future) a relayed batch may
+	// be, which also bounds how long nonces need to be remembered
+	relayMaxSkew = 5 * time.Minute
+
+	// relayRoute identifies the relay endpoint in signatures, in place of
+	// the path of the request, which reverse proxies in front of the central
+	// proxy may rewrite
+	relayRoute = "/relay"
+)
+
+// signRelayBody computes the signature of a relayed batch, which covers the
+// method, route and parameters of the request along with the body, so that
+// a batch can't be replayed to another database
+func signRelayBody(secret []byte, method string, params url.Values, timestamp, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(method + "\n" + relayRoute + "\n" + relayQuery(params) + "\n" + timestamp + "\n" + nonce + "\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// relayQuery returns the canonical query string of the parameters of a
+// relayed batch, ignoring any others
+func relayQuery(params url.Values) string {
+	canonical := url.Values{}
+	for _, name := range []string{"db", "rp", "precision"} {
+		canonical.Set(name, params.Get(name))
+	}
+	return canonical.Encode()
+}
+
+// relayClient is used in place of the InfluxDB client on edge proxies, and
+// forwards every batch to the /relay endpoint of a central proxy
+type relayClient struct {
+	url    *url.URL
+	secret []byte
+	client *http.Client
+}
+
+func newRelayClient(relayURL string, secret []byte, caFile string, timeout time.Duration) (*relayClient, error) {
+	u, err := url.Parse(relayURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "https" {
+		return nil, errors.New("relaying requires an https URL")
+	}
+	tlsConfig, err := loadClientTLSConfig("", "", caFile)
+	if err != nil {
+		return nil, err
+	}
+	return &relayClient{
+		url:    u,
+		secret: secret,
+		client: &http.Client{
+			Timeout:   timeout,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+	}, nil
+}
+
+func (c *relayClient) Write(bp influx.BatchPoints) error {
+	var body bytes.Buffer
+	for _, pt := range bp.Points() {
+		body.WriteString(pt.PrecisionString(bp.Precision()))
+		body.WriteByte('\n')
+	}
+
+	nonceBytes := make([]byte, 16)
+	if _, err := rand.Read(nonceBytes); err != nil {
+		return err
+	}
+	nonce := hex.EncodeToString(nonceBytes)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	params := url.Values{}
+	params.Set("db", bp.Database())
+	params.Set("rp", bp.RetentionPolicy())
+	params.Set("precision", bp.Precision())
+	u := *c.url
+	u.RawQuery = relayQuery(params)
+	req, err := http.NewRequest(http.MethodPost, u.String(), bytes.NewReader(body.Bytes()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	req.Header.Set(relayTimestampHeader, timestamp)
+	req.Header.Set(relayNonceHeader, nonce)
+	req.Header.Set(relaySignatureHeader, signRelayBody(c.secret, req.Method, params, timestamp, nonce, body.Bytes()))
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		// the central proxy answers with the same JSON errors as InfluxDB,
+		// so return the body just like the InfluxDB client does
+		respBody, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		return errors.New(string(respBody))
+	}
+	return nil
+}
+
+func (c *relayClient) Ping(timeout time.Duration) (time.Duration, string, error) {
+	return 0, "", errors.New("ping is not supported when relaying to another proxy")
+}
+
+func (c *relayClient) Query(q influx.Query) (*influx.Response, error) {
+	return nil, errors.New("queries are not supported when relaying to another proxy")
+}
+
+func (c *relayClient) QueryAsChunk(q influx.Query) (*influx.ChunkedResponse, error) {
+	return nil, errors.New("queries are not supported when relaying to another proxy")
+}
+
+func (c *relayClient) Close() error {
+	return nil
+}
+
+// relayReceiver accepts signed batches from edge proxies on central proxies
+type relayReceiver struct {
+	secret []byte
+	lw     *lineProtocolWriter
+
+	mu     sync.Mutex
+	nonces map[string]time.Time
+}
+
+func newRelayReceiver(secret []byte, lw *lineProtocolWriter) *relayReceiver {
+	return &relayReceiver{
+		secret: secret,
+		lw:     lw,
+		nonces: make(map[string]time.Time),
+	}
+}
+
+// checkReplay returns an error if the timestamp is outside of the allowed
+// window or the nonce was already used, and remembers the nonce otherwise
+func (rr *relayReceiver) checkReplay(timestamp, nonce string, now time.Time) error {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("invalid timestamp")
+	}
+	skew := now.Sub(time.Unix(ts, 0))
+	if skew > relayMaxSkew || skew < -relayMaxSkew {
+		return errors.New("timestamp outside of allowed window")
+	}
+	if nonce == "" {
+		return errors.New("missing nonce")
+	}
+
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	// forget nonces that are too old to be replayed anyway
+	for n, seen := range rr.nonces {
+		if now.Sub(seen) > 2*relayMaxSkew {
+			delete(rr.nonces, n)
+		}
+	}
+	if _, ok := rr.nonces[nonce]; ok {
+		return errors.New("nonce already used")
+	}
+	rr.nonces[nonce] = now
+	return nil
+}
+
+// relayHandler serves /relay, verifying the signature of the batch before
+// writing it
+func (rr *relayReceiver) relayHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxLineProtocolBody))
+	if err != nil {
+		writeInfluxProblem(w, r, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	timestamp := r.Header.Get(relayTimestampHeader)
+	nonce := r.Header.Get(relayNonceHeader)
+	query := r.URL.Query()
+	expected := signRelayBody(rr.secret, r.Method, query, timestamp, nonce, body)
+	if !hmac.Equal([]byte(expected), []byte(r.Header.Get(relaySignatureHeader))) {
+		writeInfluxProblem(w, r, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+	if err := rr.checkReplay(timestamp, nonce, time.Now()); err != nil {
+		writeInfluxProblem(w, r, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	err = rr.lw.write(sourceRelay, body, nil, query.Get("db"), query.Get("rp"), query.Get("precision"))
+	if err != nil {
+		switch err.(type) {
+		case *lineProtocolError:
+			writeInfluxProblem(w, r, err.Error(), http.StatusBadRequest)
+			return
+		case *databaseNotAllowedError:
+			writeInfluxProblem(w, r, err.Error(), http.StatusForbidden)
+			return
+		}
+		failure := classifyWriteError(err)
+		if failure.permanent {
+			// keep the original message so the edge proxy classifies it the
+			// same way
+			writeInfluxProblem(w, r, failure.message, http.StatusBadRequest)
+			return
+		}
+		writeInfluxProblem(w, r, failure.message, http.StatusServiceUnavailable)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
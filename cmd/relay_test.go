@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anonymouse64/edgex-influx-proxy/pkg/testutil"
+	influx "github.com/influxdata/influxdb1-client/v2"
+)
+
+func TestRelayHandler(t *testing.T) {
+	secret := []byte("secret")
+	body := []byte("cpu,host=gw1 usage=12.5 1600000000000000000\n")
+	params := url.Values{"db": {"edgex"}, "rp": {"autogen"}, "precision": {"ns"}}
+
+	// signed returns a request signed with the timestamp and nonce, and
+	// changed by tamper after signing
+	signed := func(timestamp time.Time, nonce string, tamper func(*http.Request)) *http.Request {
+		ts := strconv.FormatInt(timestamp.Unix(), 10)
+		req := httptest.NewRequest(http.MethodPost, "/relay?"+relayQuery(params), bytes.NewReader(body))
+		req.Header.Set(relayTimestampHeader, ts)
+		req.Header.Set(relayNonceHeader, nonce)
+		req.Header.Set(relaySignatureHeader, signRelayBody(secret, http.MethodPost, params, ts, nonce, body))
+		if tamper != nil {
+			tamper(req)
+		}
+		return req
+	}
+	setParam := func(name, value string) func(*http.Request) {
+		return func(r *http.Request) {
+			q := r.URL.Query()
+			q.Set(name, value)
+			r.URL.RawQuery = q.Encode()
+		}
+	}
+
+	client := &testutil.RecordingClient{}
+	rr := newRelayReceiver(secret, &lineProtocolWriter{
+		client:    client,
+		ptConfig:  influx.BatchPointsConfig{Database: "edgex"},
+		databases: map[string]bool{"other": true},
+	})
+	now := time.Now()
+
+	for _, tc := range []struct {
+		name   string
+		req    *http.Request
+		status int
+	}{
+		{"valid", signed(now, "nonce-1", nil), http.StatusNoContent},
+		{"rewritten path", signed(now, "nonce-2", func(r *http.Request) { r.URL.Path = "/hub/relay" }), http.StatusNoContent},
+		{"bad signature", signed(now, "nonce-3", func(r *http.Request) { r.Header.Set(relaySignatureHeader, "00") }), http.StatusUnauthorized},
+		{"wrong secret", signed(now, "nonce-4", func(r *http.Request) {
+			r.Header.Set(relaySignatureHeader, signRelayBody([]byte("other"), http.MethodPost, params, strconv.FormatInt(now.Unix(), 10), "nonce-4", body))
+		}), http.StatusUnauthorized},
+		{"changed db", signed(now, "nonce-5", setParam("db", "other")), http.StatusUnauthorized},
+		{"changed rp", signed(now, "nonce-6", setParam("rp", "forever")), http.StatusUnauthorized},
+		{"changed precision", signed(now, "nonce-7", setParam("precision", "s")), http.StatusUnauthorized},
+		{"reused nonce", signed(now, "nonce-1", nil), http.StatusUnauthorized},
+		{"missing nonce", signed(now, "", nil), http.StatusUnauthorized},
+		{"old timestamp", signed(now.Add(-relayMaxSkew-time.Minute), "nonce-8", nil), http.StatusUnauthorized},
+		{"future timestamp", signed(now.Add(relayMaxSkew+time.Minute), "nonce-9", nil), http.StatusUnauthorized},
+		{"skewed within the window", signed(now.Add(-relayMaxSkew+time.Minute), "nonce-10", nil), http.StatusNoContent},
+	} {
+		rec := httptest.NewRecorder()
+		rr.relayHandler(rec, tc.req)
+		if rec.Code != tc.status {
+			t.Errorf("%s: got status %d, want %d: %s", tc.name, rec.Code, tc.status, rec.Body)
+		}
+	}
+	if n := len(client.Batches()); n != 3 {
+		t.Errorf("wrote %d batches, want 3", n)
+	}
+}
+
+func TestRelayClientBehindRewritingProxy(t *testing.T) {
+	recording := &testutil.RecordingClient{}
+	rr := newRelayReceiver([]byte("secret"), &lineProtocolWriter{
+		client:   recording,
+		ptConfig: influx.BatchPointsConfig{Database: "edgex"},
+	})
+	// a reverse proxy serving the central proxy's /relay under /hub
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.URL.Path = strings.TrimPrefix(r.URL.Path, "/hub")
+		rr.relayHandler(w, r)
+	}))
+	defer server.Close()
+
+	u, err := url.Parse(server.URL + "/hub/relay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &relayClient{url: u, secret: []byte("secret"), client: server.Client()}
+	bp, err := influx.NewBatchPoints(influx.BatchPointsConfig{Database: "edgex", Precision: "ns"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pt, err := influx.NewPoint("cpu", nil, map[string]interface{}{"usage": 12.5}, time.Unix(1600000000, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bp.AddPoint(pt)
+
+	if err := client.Write(bp); err != nil {
+		t.Fatalf("relaying failed: %v", err)
+	}
+	if n := len(recording.Points()); n != 1 {
+		t.Errorf("wrote %d points, want 1", n)
+	}
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+	"github.com/influxdata/influxdb1-client/models"
+	influx "github.com/influxdata/influxdb1-client/v2"
+)
+
+// remapProgressPages is how many pages of a measurement are remapped between
+// progress reports
+const remapProgressPages = 20
+
+// remapOptions are the arguments of the admin remap command
+type remapOptions struct {
+	sourceDB, targetDB string
+	// sourceLayout is the layout the points in the source database were
+	// written with, they are written with the configured layout
+	sourceLayout measurementLayout
+	dropTags     map[string]bool
+	// timeMultiplier scales the timestamps, such as 1000000 for origins in
+	// milliseconds that were written as nanoseconds
+	timeMultiplier int64
+	batchSize      int
+	dryRun         bool
+	// configuredLayout reads the points with the configured layout instead
+	// of sourceLayout
+	configuredLayout bool
+	// checkpointPath is the file recording the progress, empty disables it
+	checkpointPath string
+	// sdkArgs are the arguments after "--", which are passed on to the SDK
+	sdkArgs []string
+}
+
+// parseRemapArgs parses the arguments following admin remap:
+//
+//	admin remap -target-db <db> [options] [-- <SDK arguments>]
+func parseRemapArgs(args []string) (*remapOptions, error) {
+	opts := &remapOptions{}
+	for i, arg := range args {
+		if arg == "--" {
+			opts.sdkArgs = args[i+1:]
+			args = args[:i]
+			break
+		}
+	}
+
+	var sourceLayout, dropTags string
+	fs := flag.NewFlagSet("admin remap", flag.ContinueOnError)
+	fs.StringVar(&opts.sourceDB, "source-db", "", "database to read the points from, defaults to the configured database")
+	fs.StringVar(&opts.targetDB, "target-db", "", "database to write the remapped points to")
+	fs.StringVar(&sourceLayout, "source-layout", "per-device", "measurement layout the points were written with")
+	fs.StringVar(&dropTags, "drop-tags", "", "comma separated tags to remove, such as id")
+	fs.Int64Var(&opts.timeMultiplier, "time-multiplier", 1, "multiply timestamps by this, such as 1000000 for milliseconds written as nanoseconds")
+	fs.IntVar(&opts.batchSize, "batch-size", 5000, "points to read and write at a time")
+	fs.BoolVar(&opts.dryRun, "dry-run", false, "print the points instead of writing them to influx")
+	fs.StringVar(&opts.checkpointPath, "checkpoint", "", "file to record the progress in, to resume from it after an interruption")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if opts.dryRun && opts.checkpointPath != "" {
+		return nil, errors.New("-checkpoint can't be used with -dry-run")
+	}
+	if opts.targetDB == "" || fs.NArg() != 0 {
+		return nil, errors.New("usage: admin remap -target-db <db> [options] [-- <SDK arguments>]")
+	}
+	if opts.timeMultiplier < 1 || opts.batchSize < 1 {
+		return nil, errors.New("-time-multiplier and -batch-size must be positive")
+	}
+
+	var err error
+	opts.sourceLayout, err = parseMeasurementLayout(sourceLayout)
+	if err != nil {
+		return nil, err
+	}
+	opts.dropTags = make(map[string]bool)
+	for _, tag := range splitList(dropTags) {
+		opts.dropTags[tag] = true
+	}
+	return opts, nil
+}
+
+// parseDedupeArgs parses the arguments following admin dedupe-series, which
+// remaps the points without their per-reading id tag, collapsing the series
+// it split them into:
+//
+//	admin dedupe-series -target-db <db> [options] [-- <SDK arguments>]
+func parseDedupeArgs(args []string) (*remapOptions, error) {
+	opts := &remapOptions{
+		timeMultiplier:   1,
+		configuredLayout: true,
+		dropTags:         map[string]bool{"id": true},
+	}
+	for i, arg := range args {
+		if arg == "--" {
+			opts.sdkArgs = args[i+1:]
+			args = args[:i]
+			break
+		}
+	}
+
+	fs := flag.NewFlagSet("admin dedupe-series", flag.ContinueOnError)
+	fs.StringVar(&opts.sourceDB, "source-db", "", "database to read the points from, defaults to the configured database")
+	fs.StringVar(&opts.targetDB, "target-db", "", "database to write the deduplicated points to")
+	fs.IntVar(&opts.batchSize, "batch-size", 5000, "points to read and write at a time")
+	fs.BoolVar(&opts.dryRun, "dry-run", false, "print the points instead of writing them to influx")
+	fs.StringVar(&opts.checkpointPath, "checkpoint", "", "file to record the progress in, to resume from it after an interruption")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if opts.dryRun && opts.checkpointPath != "" {
+		return nil, errors.New("-checkpoint can't be used with -dry-run")
+	}
+	if opts.targetDB == "" || fs.NArg() != 0 {
+		return nil, errors.New("usage: admin dedupe-series -target-db <db> [options] [-- <SDK arguments>]")
+	}
+	if opts.batchSize < 1 {
+		return nil, errors.New("-batch-size must be positive")
+	}
+	return opts, nil
+}
+
+// queryRows runs the query, returning the series of all its results
+func queryRows(client influx.Client, database, command string) ([]models.Row, error) {
+	resp, err := client.Query(influx.NewQuery(command, database, "ns"))
+	if err != nil {
+		return nil, err
+	}
+	if resp.Error() != nil {
+		return nil, resp.Error()
+	}
+	var rows []models.Row
+	for _, result := range resp.Results {
+		rows = append(rows, result.Series...)
+	}
+	return rows, nil
+}
+
+// remapper rewrites the points of a database written with older mapping
+// rules under the current ones into another database
+type remapper struct {
+	lc           logger.LoggingClient
+	readClient   influx.Client
+	writeClient  influx.Client
+	ptConfig     influx.BatchPointsConfig
+	targetLayout measurementLayout
+	opts         *remapOptions
+	// checkpoint records the progress to resume from
+	checkpoint *checkpoint
+}
+
+// run remaps every measurement, returning how many points were written
+func (m *remapper) run() (int, error) {
+	if m.opts.sourceDB == m.opts.targetDB {
+		return 0, errors.New("the target database must differ from the source database")
+	}
+
+	names, err := m.keys("SHOW MEASUREMENTS")
+	if err != nil {
+		return 0, fmt.Errorf("unable to list measurements: %v", err)
+	}
+	measurements := make([]string, 0, len(names))
+	for name := range names {
+		measurements = append(measurements, name)
+	}
+	sort.Strings(measurements)
+
+	total := 0
+	for i, measurement := range measurements {
+		if m.checkpoint.done(measurement) {
+			m.lc.Info(fmt.Sprintf("skipping measurement %q (%d of %d), already remapped", measurement, i+1, len(measurements)))
+			continue
+		}
+		n, err := m.remapMeasurement(measurement)
+		total += n
+		if err != nil {
+			return total, fmt.Errorf("unable to remap measurement %q: %v", measurement, err)
+		}
+		if err := m.checkpoint.complete(measurement, time.Time{}); err != nil {
+			return total, fmt.Errorf("unable to save the checkpoint: %v", err)
+		}
+		m.lc.Info(fmt.Sprintf("remapped measurement %q (%d of %d), %d points so far", measurement, i+1, len(measurements), total))
+	}
+	return total, nil
+}
+
+// keys returns the values of the first column of the rows returned by a SHOW
+// query, mapped to the values of the second column if there is one
+func (m *remapper) keys(command string) (map[string]string, error) {
+	rows, err := queryRows(m.readClient, m.opts.sourceDB, command)
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[string]string)
+	for _, row := range rows {
+		for _, values := range row.Values {
+			if len(values) == 0 {
+				continue
+			}
+			key, _ := values[0].(string)
+			var val string
+			if len(values) >= 2 {
+				val, _ = values[1].(string)
+			}
+			keys[key] = val
+		}
+	}
+	return keys, nil
+}
+
+// remapMeasurement rewrites the points of the measurement a page at a time
+func (m *remapper) remapMeasurement(measurement string) (int, error) {
+	// tags and fields are returned alike by SELECT *, so find out which
+	// columns are tags, and the types of the fields
+	tagKeys, err := m.keys("SHOW TAG KEYS FROM " + quoteIdentifier(measurement))
+	if err != nil {
+		return 0, err
+	}
+	fieldTypes, err := m.keys("SHOW FIELD KEYS FROM " + quoteIdentifier(measurement))
+	if err != nil {
+		return 0, err
+	}
+
+	ptConfig := m.ptConfig
+	ptConfig.Database = m.opts.targetDB
+
+	total := 0
+	start := m.checkpoint.offset(measurement)
+	if start != 0 {
+		m.lc.Info(fmt.Sprintf("resuming measurement %q after %d rows", measurement, start))
+	}
+	for offset := start; ; offset += m.opts.batchSize {
+		rows, err := queryRows(m.readClient, m.opts.sourceDB, fmt.Sprintf(
+			"SELECT * FROM %s ORDER BY time LIMIT %d OFFSET %d",
+			quoteIdentifier(measurement), m.opts.batchSize, offset,
+		))
+		if err != nil {
+			return total, err
+		}
+
+		bp, err := influx.NewBatchPoints(ptConfig)
+		if err != nil {
+			return total, err
+		}
+		read := 0
+		for _, row := range rows {
+			read += len(row.Values)
+			for _, values := range row.Values {
+				for _, pt := range m.remapRow(measurement, tagKeys, fieldTypes, row.Columns, values) {
+					bp.AddPoint(pt)
+				}
+			}
+		}
+		if read == 0 {
+			return total, nil
+		}
+		if err := m.writeClient.Write(bp); err != nil {
+			return total, err
+		}
+		total += len(bp.Points())
+		var newest time.Time
+		for _, pt := range bp.Points() {
+			if pt.Time().After(newest) {
+				newest = pt.Time()
+			}
+		}
+		if err := m.checkpoint.progress(measurement, offset+read, newest); err != nil {
+			return total, fmt.Errorf("unable to save the checkpoint: %v", err)
+		}
+		if page := offset/m.opts.batchSize + 1; page%remapProgressPages == 0 {
+			m.lc.Info(fmt.Sprintf("remapping measurement %q, %d rows read so far", measurement, offset+read))
+		}
+	}
+}
+
+// remapRow returns the points for the values of one row, one per field
+func (m *remapper) remapRow(measurement string, tagKeys, fieldTypes map[string]string, columns []string, values []interface{}) []*influx.Point {
+	if len(values) != len(columns) || len(columns) == 0 {
+		return nil
+	}
+	ts, ok := values[0].(json.Number)
+	if !ok {
+		return nil
+	}
+	nsec, err := ts.Int64()
+	if err != nil {
+		return nil
+	}
+	ptTime := time.Unix(0, nsec*m.opts.timeMultiplier)
+
+	rowTags := make(map[string]string)
+	for i := 1; i < len(columns); i++ {
+		if _, isTag := tagKeys[columns[i]]; isTag && !m.opts.dropTags[columns[i]] {
+			if v, ok := values[i].(string); ok && v != "" {
+				rowTags[columns[i]] = v
+			}
+		}
+	}
+
+	var points []*influx.Point
+	for i := 1; i < len(columns); i++ {
+		if _, isTag := tagKeys[columns[i]]; isTag || values[i] == nil {
+			continue
+		}
+		value, ok := fieldValue(fieldTypes[columns[i]], values[i])
+		if !ok {
+			continue
+		}
+
+		// work out the device and resource the value is a reading of
+		device, resource := measurement, columns[i]
+		tags := make(map[string]string, len(rowTags))
+		for k, v := range rowTags {
+			tags[k] = v
+		}
+		if m.opts.sourceLayout == perResourceLayout {
+			device, resource = tags[deviceTag], measurement
+			delete(tags, deviceTag)
+		}
+
+		target, field := m.targetLayout.point(device, resource, tags)
+		pt, err := influx.NewPoint(target, tags, map[string]interface{}{field: value}, ptTime)
+		if err != nil {
+			m.lc.Warn(fmt.Sprintf("skipping point of measurement %q: %s", measurement, err))
+			continue
+		}
+		points = append(points, pt)
+	}
+	return points
+}
+
+// fieldValue converts a value returned by a query to the go type of its
+// influx field type
+func fieldValue(typ string, v interface{}) (interface{}, bool) {
+	switch typ {
+	case "integer":
+		if num, ok := v.(json.Number); ok {
+			i, err := num.Int64()
+			return i, err == nil
+		}
+	case "float":
+		if num, ok := v.(json.Number); ok {
+			f, err := num.Float64()
+			return f, err == nil
+		}
+	case "boolean":
+		b, ok := v.(bool)
+		return b, ok
+	case "string":
+		s, ok := v.(string)
+		return s, ok
+	}
+	return nil, false
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/edgexfoundry/app-functions-sdk-go/appcontext"
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+	"github.com/edgexfoundry/go-mod-core-contracts/models"
+	influx "github.com/influxdata/influxdb1-client/v2"
+)
+
+// replayCheckpointEvery is how many files are replayed between saving the
+// checkpoint
+const replayCheckpointEvery = 100
+
+// errReplayStopped stops replaying at the first failure when resumable
+var errReplayStopped = errors.New("replay stopped")
+
+// replayOptions are the arguments of the replay-file command
+type replayOptions struct {
+	dryRun bool
+	paths  []string
+	// checkpointPath is the file recording the progress, empty disables it
+	checkpointPath string
+	// sdkArgs are the arguments after "--", which are passed on to the SDK
+	sdkArgs []string
+}
+
+// parseReplayArgs parses the arguments following replay-file:
+//
+//	replay-file [-dry-run] [-checkpoint <file>] <path>... [-- <SDK arguments>]
+func parseReplayArgs(args []string) (*replayOptions, error) {
+	opts := &replayOptions{}
+	for i, arg := range args {
+		if arg == "--" {
+			opts.sdkArgs = args[i+1:]
+			args = args[:i]
+			break
+		}
+	}
+
+	fs := flag.NewFlagSet("replay-file", flag.ContinueOnError)
+	fs.BoolVar(&opts.dryRun, "dry-run", false, "print the points instead of writing them to influx")
+	fs.StringVar(&opts.checkpointPath, "checkpoint", "", "file to record the progress in, to resume from it after an interruption")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	opts.paths = fs.Args()
+	if len(opts.paths) == 0 {
+		return nil, errors.New("usage: replay-file [-dry-run] [-checkpoint <file>] <path>... [-- <SDK arguments>]")
+	}
+	if opts.dryRun && opts.checkpointPath != "" {
+		return nil, errors.New("-checkpoint can't be used with -dry-run")
+	}
+	return opts, nil
+}
+
+// replayFiles runs the events saved in the files through the pipeline, paths
+// that are directories are replayed with all the .json files under them, as
+// saved by /admin/capture, including encrypted ones decrypted with the cipher.
+// With a checkpoint, the files already replayed are skipped, and replaying
+// stops at the first failure so that resuming retries it.
+func replayFiles(lc logger.LoggingClient, pipeline []appcontext.AppFunction, paths []string, cipher *atRestCipher, cp *checkpoint) (replayed, failed int) {
+	for _, path := range paths {
+		if cp.done(path) {
+			lc.Info(fmt.Sprintf("skipping %s, already replayed", path))
+			continue
+		}
+		// directories are walked in lexical order, so the files replayed
+		// before are the first ones
+		skip := cp.offset(path)
+		if skip != 0 {
+			lc.Info(fmt.Sprintf("resuming %s after %d files", path, skip))
+		}
+		n := 0
+		var newest time.Time
+		err := filepath.Walk(path, func(name string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() || (name != path && !strings.HasSuffix(name, ".json") && !strings.HasSuffix(name, ".json"+encryptedSuffix)) {
+				return nil
+			}
+			n++
+			if n <= skip {
+				return nil
+			}
+			t, err := replayFile(lc, pipeline, name, cipher)
+			if err != nil {
+				lc.Error(fmt.Sprintf("replaying %s failed: %s", name, err))
+				failed++
+				if cp != nil {
+					return errReplayStopped
+				}
+				return nil
+			}
+			replayed++
+			if t.After(newest) {
+				newest = t
+			}
+			if n%replayCheckpointEvery == 0 {
+				if err := cp.progress(path, n, newest); err != nil {
+					lc.Warn(fmt.Sprintf("unable to save the checkpoint: %s", err))
+				}
+			}
+			return nil
+		})
+		if err == errReplayStopped {
+			if err := cp.progress(path, n-1, newest); err != nil {
+				lc.Warn(fmt.Sprintf("unable to save the checkpoint: %s", err))
+			}
+			lc.Info(fmt.Sprintf("stopped replaying, run the same command again to resume from %s", cp.path))
+			return replayed, failed
+		}
+		if err != nil {
+			lc.Error(fmt.Sprintf("unable to read %s: %s", path, err))
+			failed++
+			continue
+		}
+		if err := cp.complete(path, newest); err != nil {
+			lc.Warn(fmt.Sprintf("unable to save the checkpoint: %s", err))
+		}
+	}
+	return replayed, failed
+}
+
+// replayFile runs the event saved in the file through the pipeline like the
+// SDK would have when it was received, returning the time of the event
+func replayFile(lc logger.LoggingClient, pipeline []appcontext.AppFunction, name string, cipher *atRestCipher) (time.Time, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if strings.HasSuffix(name, encryptedSuffix) {
+		if cipher == nil {
+			return time.Time{}, errors.New("the file is encrypted, but no CaptureEncryptionKeyFile or CaptureEncryptionSecretPath is configured")
+		}
+		if data, err = cipher.open(data); err != nil {
+			return time.Time{}, err
+		}
+	}
+	var event models.Event
+	if err := json.Unmarshal(data, &event); err != nil {
+		return time.Time{}, fmt.Errorf("invalid event: %v", err)
+	}
+
+	filtered, err := runPipeline(lc, pipeline, name, event)
+	if filtered {
+		lc.Info(fmt.Sprintf("event in %s was filtered out of the pipeline", name))
+	}
+	return eventTime(event), err
+}
+
+// runPipeline runs an event that didn't come from the SDK through the
+// pipeline, returning whether a function filtered it out or the error of the
+// function that failed
+func runPipeline(lc logger.LoggingClient, pipeline []appcontext.AppFunction, correlationID string, event models.Event) (bool, error) {
+	edgexcontext := &appcontext.Context{
+		CorrelationID: correlationID,
+		LoggingClient: lc,
+	}
+	return runFunctions(edgexcontext, pipeline, event)
+}
+
+// runFunctions runs the event through the functions with the context,
+// returning whether a function filtered it out or the error of the function
+// that failed
+func runFunctions(edgexcontext *appcontext.Context, pipeline []appcontext.AppFunction, event models.Event) (bool, error) {
+	var result interface{} = event
+	for _, fn := range pipeline {
+		var ok bool
+		ok, result = fn(edgexcontext, result)
+		if !ok {
+			if err, isErr := result.(error); isErr {
+				return false, err
+			}
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// dryRunClient prints the points it is asked to write instead of writing
+// them to influx
+type dryRunClient struct {
+	influx.Client
+	w io.Writer
+}
+
+func (c *dryRunClient) Write(bp influx.BatchPoints) error {
+	for _, pt := range bp.Points() {
+		if _, err := fmt.Fprintln(c.w, pt.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *dryRunClient) Ping(timeout time.Duration) (time.Duration, string, error) {
+	return 0, "", nil
+}
+
+func (c *dryRunClient) Close() error {
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// boolSetting parses the named application setting as a boolean, returning
+// def if the setting is missing or empty
+func boolSetting(appSettings map[string]string, name string, def bool) (bool, error) {
+	valStr, ok := appSettings[name]
+	if !ok || valStr == "" {
+		return def, nil
+	}
+	val, err := strconv.ParseBool(valStr)
+	if err != nil {
+		return false, fmt.Errorf("invalid %q setting of %s, must be true or false", name, valStr)
+	}
+	return val, nil
+}
+
+// uintSetting parses the named application setting as an unsigned integer,
+// returning def if the setting is missing or empty
+func uintSetting(appSettings map[string]string, name string, def uint64) (uint64, error) {
+	valStr, ok := appSettings[name]
+	if !ok || valStr == "" {
+		return def, nil
+	}
+	val, err := strconv.ParseUint(valStr, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %q setting of %s, must be a non-negative integer", name, valStr)
+	}
+	return val, nil
+}
+
+// floatSetting parses the named application setting as a non-negative
+// floating point number, returning def if the setting is missing or empty
+func floatSetting(appSettings map[string]string, name string, def float64) (float64, error) {
+	valStr, ok := appSettings[name]
+	if !ok || valStr == "" {
+		return def, nil
+	}
+	val, err := strconv.ParseFloat(valStr, 64)
+	if err != nil || val < 0 || math.IsNaN(val) || math.IsInf(val, 0) {
+		return 0, fmt.Errorf("invalid %q setting of %s, must be a non-negative number", name, valStr)
+	}
+	return val, nil
+}
+
+// durationSetting parses the named application setting as a duration such as
+// "30s" or "5m", returning def if the setting is missing or empty
+func durationSetting(appSettings map[string]string, name string, def time.Duration) (time.Duration, error) {
+	valStr, ok := appSettings[name]
+	if !ok || valStr == "" {
+		return def, nil
+	}
+	val, err := time.ParseDuration(valStr)
+	if err != nil || val < 0 {
+		return 0, fmt.Errorf("invalid %q setting of %s, must be a non-negative duration", name, valStr)
+	}
+	return val, nil
+}
+
+// anomalyActionSetting parses the named application setting as an anomaly
+// action, returning def if the setting is missing or empty
+func anomalyActionSetting(appSettings map[string]string, name string, def anomalyAction) (anomalyAction, error) {
+	valStr, ok := appSettings[name]
+	if !ok || valStr == "" {
+		return def, nil
+	}
+	val, err := parseAnomalyAction(valStr)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %q setting: %v", name, err)
+	}
+	return val, nil
+}
+
+// unknownDeviceActionSetting parses the named application setting as an
+// unknown device action, returning def if the setting is missing or empty
+func unknownDeviceActionSetting(appSettings map[string]string, name string, def anomalyAction) (anomalyAction, error) {
+	valStr, ok := appSettings[name]
+	if !ok || valStr == "" {
+		return def, nil
+	}
+	val, err := parseUnknownDeviceAction(valStr)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %q setting: %v", name, err)
+	}
+	return val, nil
+}
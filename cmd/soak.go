@@ -0,0 +1,191 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/edgexfoundry/app-functions-sdk-go/appcontext"
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+	"github.com/edgexfoundry/go-mod-core-contracts/models"
+)
+
+// soakOptions are the arguments of the soak-test command
+type soakOptions struct {
+	duration, sampleInterval, warmup time.Duration
+	devices, workers                 int
+	rate                             float64
+	// maxHeapGrowth in bytes and maxGoroutineGrowth are how much the heap
+	// and the number of goroutines may grow over the run by their trend
+	maxHeapGrowth      uint64
+	maxGoroutineGrowth float64
+	// write writes the points to influx instead of discarding them
+	write bool
+	// sdkArgs are the arguments after "--", which are passed on to the SDK
+	sdkArgs []string
+}
+
+// parseSoakArgs parses the arguments following soak-test:
+//
+//	soak-test [-duration <d>] [-devices <n>] [-rate <n>] [options] [-- <SDK arguments>]
+func parseSoakArgs(args []string) (*soakOptions, error) {
+	opts := &soakOptions{}
+	for i, arg := range args {
+		if arg == "--" {
+			opts.sdkArgs = args[i+1:]
+			args = args[:i]
+			break
+		}
+	}
+
+	var maxHeapGrowthMB uint64
+	fs := flag.NewFlagSet("soak-test", flag.ContinueOnError)
+	fs.DurationVar(&opts.duration, "duration", 10*time.Minute, "how long to run the pipeline for")
+	fs.DurationVar(&opts.sampleInterval, "sample-interval", 10*time.Second, "how often to sample the heap and goroutines")
+	fs.DurationVar(&opts.warmup, "warmup", time.Minute, "how long to run before sampling, while caches fill up")
+	fs.IntVar(&opts.devices, "devices", 10, "number of simulated devices")
+	fs.IntVar(&opts.workers, "workers", 4, "events run through the pipeline concurrently")
+	fs.Float64Var(&opts.rate, "rate", 100, "events per second across all devices")
+	fs.Uint64Var(&maxHeapGrowthMB, "max-heap-growth-mb", 16, "fail if the heap grows by more than this over the run")
+	fs.Float64Var(&opts.maxGoroutineGrowth, "max-goroutine-growth", 10, "fail if the goroutines grow by more than this over the run")
+	fs.BoolVar(&opts.write, "write", false, "write the points to influx instead of discarding them")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != 0 {
+		return nil, errors.New("usage: soak-test [-duration <d>] [-devices <n>] [-rate <n>] [options] [-- <SDK arguments>]")
+	}
+	if opts.duration <= opts.warmup || opts.sampleInterval <= 0 || opts.devices < 1 || opts.workers < 1 || opts.rate <= 0 {
+		return nil, errors.New("-duration must be longer than -warmup, and -sample-interval, -devices, -workers and -rate must be positive")
+	}
+	opts.maxHeapGrowth = maxHeapGrowthMB * 1024 * 1024
+	return opts, nil
+}
+
+// soakSample is the heap and goroutines in use at a point of the run
+type soakSample struct {
+	at         time.Duration
+	heap       uint64
+	goroutines int
+}
+
+// simulatedEvent returns an event like the ones the device-virtual simulator
+// sends, with random values
+func simulatedEvent(r *rand.Rand, device string, now time.Time) models.Event {
+	origin := now.UnixNano()
+	readings := []models.Reading{
+		{Name: "Int64", Value: strconv.FormatInt(r.Int63n(1000000)-500000, 10), ValueType: "Int64"},
+		{Name: "Float64", Value: strconv.FormatFloat(r.NormFloat64()*100, 'e', 6, 64), ValueType: "Float64", FloatEncoding: "eNotation"},
+		{Name: "Bool", Value: strconv.FormatBool(r.Intn(2) == 0), ValueType: "Bool"},
+		{Name: "String", Value: fmt.Sprintf("value-%d", r.Intn(100)), ValueType: "String"},
+	}
+	for i := range readings {
+		readings[i].Device = device
+		readings[i].Origin = origin
+	}
+	return models.Event{Device: device, Origin: origin, Readings: readings}
+}
+
+// sampleRuntime returns the live heap after a collection and the number of
+// goroutines
+func sampleRuntime(at time.Duration) soakSample {
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return soakSample{at: at, heap: stats.HeapAlloc, goroutines: runtime.NumGoroutine()}
+}
+
+// trendGrowth returns how much the values grow over the samples by the
+// least squares slope of their trend, so that a single spike doesn't fail
+// the run but steady growth does
+func trendGrowth(samples []soakSample, value func(soakSample) float64) float64 {
+	if len(samples) < 2 {
+		return 0
+	}
+	var sumX, sumY, sumXY, sumXX float64
+	n := float64(len(samples))
+	for _, s := range samples {
+		x, y := s.at.Seconds(), value(s)
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXX += x * x
+	}
+	denom := n*sumXX - sumX*sumX
+	if denom == 0 {
+		return 0
+	}
+	slope := (n*sumXY - sumX*sumY) / denom
+	return slope * (samples[len(samples)-1].at - samples[0].at).Seconds()
+}
+
+// soak runs simulated events through the pipeline at the rate for the
+// duration, sampling the heap and goroutines after the warmup, and returns
+// an error if either trends upward by more than allowed
+func soak(lc logger.LoggingClient, pipeline []appcontext.AppFunction, opts *soakOptions) error {
+	events := make(chan models.Event)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var sent, failed int
+	for i := 0; i < opts.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for event := range events {
+				_, err := runPipeline(lc, pipeline, "soak-test", event)
+				mu.Lock()
+				sent++
+				if err != nil {
+					failed++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	start := time.Now()
+	send := time.NewTicker(time.Duration(float64(time.Second) / opts.rate))
+	defer send.Stop()
+	sample := time.NewTicker(opts.sampleInterval)
+	defer sample.Stop()
+	var samples []soakSample
+	for device := 0; time.Since(start) < opts.duration; {
+		select {
+		case now := <-send.C:
+			events <- simulatedEvent(r, fmt.Sprintf("Soak-Device-%d", device), now)
+			device = (device + 1) % opts.devices
+		case <-sample.C:
+			at := time.Since(start)
+			if at < opts.warmup {
+				continue
+			}
+			s := sampleRuntime(at)
+			samples = append(samples, s)
+			mu.Lock()
+			lc.Info(fmt.Sprintf("soak-test at %s: %d events, %d failed, heap %d bytes, %d goroutines", at.Round(time.Second), sent, failed, s.heap, s.goroutines))
+			mu.Unlock()
+		}
+	}
+	close(events)
+	wg.Wait()
+
+	heapGrowth := trendGrowth(samples, func(s soakSample) float64 { return float64(s.heap) })
+	goroutineGrowth := trendGrowth(samples, func(s soakSample) float64 { return float64(s.goroutines) })
+	lc.Info(fmt.Sprintf("soak-test done: %d events, %d failed, heap grew by %.0f bytes and goroutines by %.1f over %d samples", sent, failed, heapGrowth, goroutineGrowth, len(samples)))
+	if len(samples) < 2 {
+		return errors.New("too few samples to find a trend, run for longer or sample more often")
+	}
+	if heapGrowth > float64(opts.maxHeapGrowth) {
+		return fmt.Errorf("the heap grew by %.0f bytes, more than %d", heapGrowth, opts.maxHeapGrowth)
+	}
+	if goroutineGrowth > opts.maxGoroutineGrowth {
+		return fmt.Errorf("the goroutines grew by %.1f, more than %.1f", goroutineGrowth, opts.maxGoroutineGrowth)
+	}
+	return nil
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/edgexfoundry/app-functions-sdk-go/appcontext"
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+	"github.com/edgexfoundry/go-mod-core-contracts/models"
+)
+
+// sparkplugNamespace is the first level of every Sparkplug B topic
+const sparkplugNamespace = "spBv1.0"
+
+// Sparkplug B metric data types that can be written as readings, the others
+// such as datasets and templates are skipped
+const (
+	sparkplugInt8     = 1
+	sparkplugInt16    = 2
+	sparkplugInt32    = 3
+	sparkplugInt64    = 4
+	sparkplugUInt8    = 5
+	sparkplugUInt16   = 6
+	sparkplugUInt32   = 7
+	sparkplugUInt64   = 8
+	sparkplugFloat    = 9
+	sparkplugDouble   = 10
+	sparkplugBoolean  = 11
+	sparkplugString   = 12
+	sparkplugDateTime = 13
+	sparkplugText     = 14
+	sparkplugUUID     = 15
+)
+
+var errTruncatedProtobuf = errors.New("truncated protobuf message")
+
+// protoField is a field of a protobuf message, with its value as a varint,
+// fixed32, fixed64 or the bytes of a length-delimited value
+type protoField struct {
+	number int
+	varint uint64
+	bytes  []byte
+}
+
+// readProtoFields splits a protobuf message into its fields, Sparkplug B
+// payloads are simple enough to decode without generated code
+func readProtoFields(b []byte) ([]protoField, error) {
+	var fields []protoField
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, errTruncatedProtobuf
+		}
+		b = b[n:]
+		f := protoField{number: int(key >> 3)}
+		switch key & 7 {
+		case 0:
+			f.varint, n = binary.Uvarint(b)
+			if n <= 0 {
+				return nil, errTruncatedProtobuf
+			}
+			b = b[n:]
+		case 1:
+			if len(b) < 8 {
+				return nil, errTruncatedProtobuf
+			}
+			f.varint = binary.LittleEndian.Uint64(b)
+			b = b[8:]
+		case 2:
+			length, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < length {
+				return nil, errTruncatedProtobuf
+			}
+			f.bytes = b[n : n+int(length)]
+			b = b[n+int(length):]
+		case 5:
+			if len(b) < 4 {
+				return nil, errTruncatedProtobuf
+			}
+			f.varint = uint64(binary.LittleEndian.Uint32(b))
+			b = b[4:]
+		default:
+			return nil, fmt.Errorf("unsupported protobuf wire type %d", key&7)
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// sparkplugMetric is a metric of a Sparkplug B payload
+type sparkplugMetric struct {
+	name      string
+	alias     uint64
+	hasAlias  bool
+	timestamp uint64
+	datatype  uint64
+	isNull    bool
+	// value is the raw value, decoded according to datatype
+	varint uint64
+	str    string
+}
+
+// decodeSparkplugPayload returns the timestamp and metrics of a Sparkplug B
+// payload
+func decodeSparkplugPayload(b []byte) (uint64, []sparkplugMetric, error) {
+	fields, err := readProtoFields(b)
+	if err != nil {
+		return 0, nil, err
+	}
+	var timestamp uint64
+	var metrics []sparkplugMetric
+	for _, f := range fields {
+		switch f.number {
+		case 1:
+			timestamp = f.varint
+		case 2:
+			m, err := decodeSparkplugMetric(f.bytes)
+			if err != nil {
+				return 0, nil, err
+			}
+			metrics = append(metrics, m)
+		}
+	}
+	return timestamp, metrics, nil
+}
+
+func decodeSparkplugMetric(b []byte) (sparkplugMetric, error) {
+	var m sparkplugMetric
+	fields, err := readProtoFields(b)
+	if err != nil {
+		return m, err
+	}
+	for _, f := range fields {
+		switch f.number {
+		case 1:
+			m.name = string(f.bytes)
+		case 2:
+			m.alias, m.hasAlias = f.varint, true
+		case 3:
+			m.timestamp = f.varint
+		case 4:
+			m.datatype = f.varint
+		case 7:
+			m.isNull = f.varint != 0
+		case 10, 11, 12, 13, 14:
+			// int, long, float, double and boolean values
+			m.varint = f.varint
+		case 15:
+			m.str = string(f.bytes)
+		}
+	}
+	return m, nil
+}
+
+// reading returns the metric as a reading with the value type EdgeX would
+// have declared for it, or false if its type can't be written
+func (m sparkplugMetric) reading() (models.Reading, bool) {
+	r := models.Reading{Name: m.name}
+	switch m.datatype {
+	// signed values are sent as their two's complement in a uint32, in their
+	// own width or sign extended to 32 bits depending on the implementation,
+	// so only the bits of their width are kept
+	case sparkplugInt8:
+		r.ValueType, r.Value = "Int32", strconv.FormatInt(int64(int8(uint8(m.varint))), 10)
+	case sparkplugInt16:
+		r.ValueType, r.Value = "Int32", strconv.FormatInt(int64(int16(uint16(m.varint))), 10)
+	case sparkplugInt32:
+		r.ValueType, r.Value = "Int32", strconv.FormatInt(int64(int32(uint32(m.varint))), 10)
+	case sparkplugInt64, sparkplugDateTime:
+		r.ValueType, r.Value = "Int64", strconv.FormatInt(int64(m.varint), 10)
+	case sparkplugUInt8, sparkplugUInt16, sparkplugUInt32, sparkplugUInt64:
+		r.ValueType, r.Value = "Uint64", strconv.FormatUint(m.varint, 10)
+	case sparkplugFloat:
+		r.ValueType, r.FloatEncoding = "Float32", "eNotation"
+		r.Value = strconv.FormatFloat(float64(math.Float32frombits(uint32(m.varint))), 'e', -1, 32)
+	case sparkplugDouble:
+		r.ValueType, r.FloatEncoding = "Float64", "eNotation"
+		r.Value = strconv.FormatFloat(math.Float64frombits(m.varint), 'e', -1, 64)
+	case sparkplugBoolean:
+		r.ValueType, r.Value = "Bool", strconv.FormatBool(m.varint != 0)
+	case sparkplugString, sparkplugText, sparkplugUUID:
+		r.ValueType, r.Value = "String", m.str
+	default:
+		return r, false
+	}
+	return r, true
+}
+
+// sparkplugSubscriber turns the BIRTH and DATA messages of Sparkplug B edge
+// nodes and devices into EdgeX events and runs them through the pipeline,
+// so that they are written with the same mapping rules. Devices are named
+// group/node for node metrics and group/node/device for device metrics.
+type sparkplugSubscriber struct {
+	lc       logger.LoggingClient
+	pipeline []appcontext.AppFunction
+
+	mu sync.Mutex
+	// aliases maps the aliases declared by the BIRTH messages of each node
+	// and device to metric names
+	aliases map[string]map[uint64]string
+}
+
+func newSparkplugSubscriber(lc logger.LoggingClient, pipeline []appcontext.AppFunction) *sparkplugSubscriber {
+	return &sparkplugSubscriber{
+		lc:       lc,
+		pipeline: pipeline,
+		aliases:  make(map[string]map[uint64]string),
+	}
+}
+
+// event returns the event of a Sparkplug B message, or false for messages
+// without metrics to write, such as DEATH and CMD messages
+func (s *sparkplugSubscriber) event(topic string, payload []byte) (models.Event, bool, error) {
+	// spBv1.0/group/type/node[/device]
+	parts := strings.Split(topic, "/")
+	if len(parts) < 4 || len(parts) > 5 || parts[0] != sparkplugNamespace {
+		return models.Event{}, false, nil
+	}
+	msgType := parts[2]
+	device := parts[1] + "/" + parts[3]
+	if len(parts) == 5 {
+		device += "/" + parts[4]
+	}
+	birth := msgType == "NBIRTH" || msgType == "DBIRTH"
+	if !birth && msgType != "NDATA" && msgType != "DDATA" {
+		return models.Event{}, false, nil
+	}
+
+	timestamp, metrics, err := decodeSparkplugPayload(payload)
+	if err != nil {
+		return models.Event{}, false, err
+	}
+
+	s.mu.Lock()
+	if msgType == "NBIRTH" {
+		// a new session of the node, forget the aliases of its devices too
+		for key := range s.aliases {
+			if key == device || strings.HasPrefix(key, device+"/") {
+				delete(s.aliases, key)
+			}
+		}
+	}
+	if birth {
+		aliases := make(map[uint64]string)
+		for _, m := range metrics {
+			if m.hasAlias && m.name != "" {
+				aliases[m.alias] = m.name
+			}
+		}
+		s.aliases[device] = aliases
+	}
+	aliases := s.aliases[device]
+	s.mu.Unlock()
+
+	event := models.Event{Device: device, Origin: int64(timestamp) * int64(time.Millisecond)}
+	for _, m := range metrics {
+		if m.name == "" && m.hasAlias {
+			m.name = aliases[m.alias]
+		}
+		if m.name == "" || m.isNull {
+			continue
+		}
+		reading, ok := m.reading()
+		if !ok {
+			continue
+		}
+		reading.Device = device
+		reading.Origin = event.Origin
+		if m.timestamp != 0 {
+			reading.Origin = int64(m.timestamp) * int64(time.Millisecond)
+		}
+		event.Readings = append(event.Readings, reading)
+	}
+	return event, len(event.Readings) != 0, nil
+}
+
+func (s *sparkplugSubscriber) handle(_ mqtt.Client, msg mqtt.Message) {
+	event, ok, err := s.event(msg.Topic(), msg.Payload())
+	if err != nil {
+		s.lc.Warn(fmt.Sprintf("ignoring invalid Sparkplug B payload on %s: %s", msg.Topic(), err))
+		return
+	}
+	if !ok {
+		return
+	}
+	if _, err := runPipeline(s.lc, s.pipeline, sourceCorrelationID(sourceSparkplug, msg.Topic()), event); err != nil {
+		s.lc.Error(fmt.Sprintf("error writing Sparkplug B metrics from %s: %s", msg.Topic(), err))
+	}
+}
+
+// subscribeSparkplug subscribes to the Sparkplug B topic of the broker
+func subscribeSparkplug(lc logger.LoggingClient, pipeline []appcontext.AppFunction, appSettings map[string]string) error {
+	topic := appSettings["SparkplugTopic"]
+	if topic == "" {
+		topic = sparkplugNamespace + "/#"
+	}
+	clientID := appSettings["SparkplugClientID"]
+	if clientID == "" {
+		clientID = serviceKey
+	}
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(appSettings["SparkplugBrokerURL"])
+	opts.SetClientID(clientID)
+	opts.SetUsername(appSettings["SparkplugUsername"])
+	opts.SetPassword(appSettings["SparkplugPassword"])
+	return subscribeMQTT(lc, opts, topic, newSparkplugSubscriber(lc, pipeline).handle)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+)
+
+// protobuf encodes the fields of a protobuf message, the values being
+// varints for uint64, fixed64 for float64, fixed32 for float32 and
+// length-delimited for strings and nested messages
+func protobuf(fields ...interface{}) []byte {
+	var b []byte
+	buf := make([]byte, binary.MaxVarintLen64)
+	appendUvarint := func(b []byte, v uint64) []byte {
+		return append(b, buf[:binary.PutUvarint(buf, v)]...)
+	}
+	for i := 0; i < len(fields); i += 2 {
+		number := uint64(fields[i].(int))
+		switch v := fields[i+1].(type) {
+		case uint64:
+			b = appendUvarint(b, number<<3)
+			b = appendUvarint(b, v)
+		case float64:
+			b = appendUvarint(b, number<<3|1)
+			binary.LittleEndian.PutUint64(buf, math.Float64bits(v))
+			b = append(b, buf[:8]...)
+		case float32:
+			b = appendUvarint(b, number<<3|5)
+			binary.LittleEndian.PutUint32(buf, math.Float32bits(v))
+			b = append(b, buf[:4]...)
+		case string:
+			b = appendUvarint(b, number<<3|2)
+			b = appendUvarint(b, uint64(len(v)))
+			b = append(b, v...)
+		case []byte:
+			b = appendUvarint(b, number<<3|2)
+			b = appendUvarint(b, uint64(len(v)))
+			b = append(b, v...)
+		}
+	}
+	return b
+}
+
+// sparkplugPayloads are a node and device session, the BIRTH messages
+// declaring aliases that the DATA messages use instead of names
+var sparkplugPayloads = map[string][]byte{
+	"NBIRTH": protobuf(
+		1, uint64(1600000000000),
+		2, protobuf(1, "Temperature", 2, uint64(1), 4, uint64(sparkplugDouble), 13, 21.5),
+		2, protobuf(1, "Offset", 2, uint64(2), 4, uint64(sparkplugInt8), 10, uint64(0xFFFFFFFE)),
+		2, protobuf(1, "Online", 2, uint64(3), 4, uint64(sparkplugBoolean), 14, uint64(1)),
+		2, protobuf(1, "bdSeq", 4, uint64(sparkplugUInt64), 11, uint64(7)),
+	),
+	"NDATA": protobuf(
+		1, uint64(1600000001000),
+		2, protobuf(2, uint64(1), 4, uint64(sparkplugDouble), 13, 22.25),
+		2, protobuf(2, uint64(2), 4, uint64(sparkplugInt8), 10, uint64(0xE9)),
+		2, protobuf(2, uint64(9), 4, uint64(sparkplugDouble), 13, 1.0),
+	),
+	"DBIRTH": protobuf(
+		1, uint64(1600000000500),
+		2, protobuf(1, "Level", 2, uint64(1), 4, uint64(sparkplugInt16), 10, uint64(0xFFFF8000)),
+		2, protobuf(1, "Name", 2, uint64(2), 4, uint64(sparkplugString), 15, "tank"),
+		2, protobuf(1, "Ratio", 2, uint64(3), 4, uint64(sparkplugFloat), 12, float32(0.5)),
+	),
+	"DDATA": protobuf(
+		1, uint64(1600000002000),
+		2, protobuf(2, uint64(1), 3, uint64(1600000001500), 4, uint64(sparkplugInt16), 10, uint64(0x7FFF)),
+		2, protobuf(2, uint64(3), 4, uint64(sparkplugFloat), 7, uint64(1)),
+		2, protobuf(1, "Pressure", 4, uint64(sparkplugInt32), 10, uint64(0x80000000)),
+	),
+}
+
+func TestSparkplugEvents(t *testing.T) {
+	s := newSparkplugSubscriber(logger.NewMockClient(), nil)
+
+	type reading struct{ name, valueType, value string }
+	for _, tc := range []struct {
+		topic    string
+		payload  string
+		device   string
+		origin   int64
+		readings []reading
+	}{
+		{"spBv1.0/plant/NBIRTH/gw1", "NBIRTH", "plant/gw1", 1600000000000000000, []reading{
+			{"Temperature", "Float64", "2.15e+01"},
+			{"Offset", "Int32", "-2"},
+			{"Online", "Bool", "true"},
+			{"bdSeq", "Uint64", "7"},
+		}},
+		{"spBv1.0/plant/DBIRTH/gw1/tank1", "DBIRTH", "plant/gw1/tank1", 1600000000500000000, []reading{
+			{"Level", "Int32", "-32768"},
+			{"Name", "String", "tank"},
+			{"Ratio", "Float32", "5e-01"},
+		}},
+		// aliases resolve to the names of the node's own BIRTH, unknown
+		// aliases are skipped
+		{"spBv1.0/plant/NDATA/gw1", "NDATA", "plant/gw1", 1600000001000000000, []reading{
+			{"Temperature", "Float64", "2.225e+01"},
+			{"Offset", "Int32", "-23"},
+		}},
+		// and to those of the device's, null values are skipped
+		{"spBv1.0/plant/DDATA/gw1/tank1", "DDATA", "plant/gw1/tank1", 1600000002000000000, []reading{
+			{"Level", "Int32", "32767"},
+			{"Pressure", "Int32", "-2147483648"},
+		}},
+	} {
+		event, ok, err := s.event(tc.topic, sparkplugPayloads[tc.payload])
+		if err != nil || !ok {
+			t.Fatalf("%s: got %v, %v", tc.topic, ok, err)
+		}
+		if event.Device != tc.device || event.Origin != tc.origin {
+			t.Errorf("%s: got device %q at %d, want %q at %d", tc.topic, event.Device, event.Origin, tc.device, tc.origin)
+		}
+		if len(event.Readings) != len(tc.readings) {
+			t.Errorf("%s: got %d readings, want %d", tc.topic, len(event.Readings), len(tc.readings))
+			continue
+		}
+		for i, want := range tc.readings {
+			got := event.Readings[i]
+			if got.Name != want.name || got.ValueType != want.valueType || got.Value != want.value || got.Device != tc.device {
+				t.Errorf("%s: got reading %s %s %s of %s, want %v", tc.topic, got.Name, got.ValueType, got.Value, got.Device, want)
+			}
+		}
+	}
+	// metrics with a timestamp of their own keep it
+	event, _, err := s.event("spBv1.0/plant/DDATA/gw1/tank1", sparkplugPayloads["DDATA"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if origin := event.Readings[0].Origin; origin != 1600000001500000000 {
+		t.Errorf("the timestamp of a metric gave origin %d", origin)
+	}
+
+	// a new session of the node forgets the aliases of its devices
+	if _, _, err := s.event("spBv1.0/plant/NBIRTH/gw1", sparkplugPayloads["NBIRTH"]); err != nil {
+		t.Fatal(err)
+	}
+	event, _, err = s.event("spBv1.0/plant/DDATA/gw1/tank1", sparkplugPayloads["DDATA"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(event.Readings) != 1 || event.Readings[0].Name != "Pressure" {
+		t.Errorf("got readings %v after a new NBIRTH, want only the named Pressure", event.Readings)
+	}
+
+	// messages without metrics to write
+	for _, topic := range []string{"spBv1.0/plant/NDEATH/gw1", "spBv1.0/plant/DCMD/gw1/tank1", "spAv1.0/plant/NDATA/gw1", "spBv1.0/plant"} {
+		if _, ok, err := s.event(topic, sparkplugPayloads["NDATA"]); ok || err != nil {
+			t.Errorf("%s: got %v, %v, want it ignored", topic, ok, err)
+		}
+	}
+}
+
+func TestSparkplugInvalidPayloads(t *testing.T) {
+	payload := sparkplugPayloads["NBIRTH"]
+	metric := protobuf(1, "Temperature", 4, uint64(sparkplugDouble), 13, 21.5)
+
+	for name, b := range map[string][]byte{
+		"truncated payload":         payload[:len(payload)-3],
+		"truncated key":             {0x80},
+		"truncated varint":          {0x08, 0x80},
+		"truncated fixed64":         {0x09, 0x00, 0x00},
+		"truncated fixed32":         {0x15, 0x00, 0x00},
+		"oversized length":          {0x12, 0x10, 0x01},
+		"length beyond int range":   {0x12, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01},
+		"truncated metric":          protobuf(2, metric[:len(metric)-1]),
+		"unsupported wire type":     {0x0b},
+		"oversized metric length":   append([]byte{0x12, 0x7f}, metric...),
+		"length of a nested metric": protobuf(2, append([]byte{0x0a, 0x40}, "Temperature"...)),
+	} {
+		if _, _, err := decodeSparkplugPayload(b); err == nil {
+			t.Errorf("%s: decoded without an error", name)
+		}
+	}
+}
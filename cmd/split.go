@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/edgexfoundry/app-functions-sdk-go/appcontext"
+	"github.com/edgexfoundry/go-mod-core-contracts/models"
+)
+
+// splitByDevice returns an event for every device with readings in the
+// event, in the order of their first reading, such as for the merged events
+// interval exports send. Readings without a device are of the event's
+// device. An event whose readings are all of its device is returned as is.
+func splitByDevice(event models.Event) []models.Event {
+	var devices []string
+	byDevice := make(map[string][]models.Reading)
+	for _, reading := range event.Readings {
+		if reading.Device == "" {
+			reading.Device = event.Device
+		}
+		if _, ok := byDevice[reading.Device]; !ok {
+			devices = append(devices, reading.Device)
+		}
+		byDevice[reading.Device] = append(byDevice[reading.Device], reading)
+	}
+	if len(devices) <= 1 && (len(devices) == 0 || devices[0] == event.Device) {
+		return []models.Event{event}
+	}
+
+	events := make([]models.Event, len(devices))
+	for i, device := range devices {
+		events[i] = event
+		events[i].Device = device
+		events[i].Readings = byDevice[device]
+	}
+	return events
+}
+
+// mergeEvents returns the readings of the events in one event, the inverse
+// of splitByDevice
+func mergeEvents(events []models.Event) models.Event {
+	merged := events[0]
+	merged.Readings = nil
+	for _, event := range events {
+		merged.Readings = append(merged.Readings, event.Readings...)
+	}
+	return merged
+}
+
+// retriedEvent decodes the retry data a function of the pipeline saved for an
+// event, unwrapping the retry data of named pipelines, which are routed to
+// again by device when retried
+func retriedEvent(data []byte) (models.Event, error) {
+	var retry pipelineRetry
+	if err := json.Unmarshal(data, &retry); err == nil && retry.Pipeline != "" {
+		data = retry.Data
+	}
+	var event models.Event
+	err := json.Unmarshal(data, &event)
+	return event, err
+}
+
+// splitFunc runs the events of every device in a merged event through the
+// rest of the pipeline on their own, so that every function sees the device
+// the readings are of rather than the device of the merged event. It must be
+// the first function of the pipeline. Events of one device are passed on.
+func splitFunc(pipeline *[]appcontext.AppFunction) func(edgexcontext *appcontext.Context, params ...interface{}) (bool, interface{}) {
+	return func(edgexcontext *appcontext.Context, params ...interface{}) (bool, interface{}) {
+		if len(params) < 1 {
+			// We didn't receive a result
+			return false, errors.New("no data received")
+		}
+
+		event, ok := params[0].(models.Event)
+		if !ok {
+			// not an event, let the next function decide what to do with it
+			return true, params[0]
+		}
+
+		events := splitByDevice(event)
+		if len(events) == 1 {
+			return true, events[0]
+		}
+
+		// the events are run with their own context, so that what the rest
+		// of the pipeline saved to retry can be saved together. That is the
+		// events as the write stage got them, as retries skip the functions
+		// before it, and only what it didn't write.
+		var firstErr error
+		var retry []models.Event
+		for _, event := range events {
+			sub := *edgexcontext
+			sub.RetryData = nil
+			_, err := runFunctions(&sub, (*pipeline)[1:], event)
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+			if sub.RetryData == nil {
+				continue
+			}
+			retried, err := retriedEvent(sub.RetryData)
+			if err != nil {
+				edgexcontext.LoggingClient.Error(fmt.Sprintf("unable to decode retry data of device %q: %s", event.Device, err))
+				continue
+			}
+			retry = append(retry, retried)
+		}
+		if len(retry) != 0 {
+			if payload, err := json.Marshal(mergeEvents(retry)); err == nil {
+				edgexcontext.SetRetryData(payload)
+			}
+		}
+		if firstErr != nil {
+			return false, firstErr
+		}
+		// the rest of the pipeline already ran
+		return false, nil
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/anonymouse64/edgex-influx-proxy/pkg/testutil"
+	"github.com/edgexfoundry/app-functions-sdk-go/appcontext"
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+	"github.com/edgexfoundry/go-mod-core-contracts/models"
+	influx "github.com/influxdata/influxdb1-client/v2"
+)
+
+// failingClient fails the writes of the measurements while they are set
+type failingClient struct {
+	*testutil.RecordingClient
+	failing map[string]bool
+}
+
+func (c *failingClient) Write(bp influx.BatchPoints) error {
+	for _, pt := range bp.Points() {
+		if c.failing[pt.Name()] {
+			return errors.New("connection refused")
+		}
+	}
+	return c.RecordingClient.Write(bp)
+}
+
+func TestSplitRetriesTransformedEvents(t *testing.T) {
+	client := &failingClient{RecordingClient: &testutil.RecordingClient{}, failing: map[string]bool{"Canonical-B": true}}
+	ptConfig := influx.BatchPointsConfig{Database: "edgex", Precision: "ns"}
+	drops := newDropAccounting()
+	aliases := newDeviceAliases(client, client, ptConfig, map[string]string{"Device-B": "Canonical-B"})
+	now := time.Unix(1600000000, 0)
+	write := testWriteConfig(client, testutil.NewFakeClock(now))
+
+	// the stages of the proxy's pipeline, disabled ones passing everything
+	// on as in main
+	var pipeline []appcontext.AppFunction
+	pipeline = []appcontext.AppFunction{
+		splitFunc(&pipeline),
+		faultFunc(nil),
+		aliasFunc(aliases),
+		staleFunc(nil, drops),
+		leaderFunc(nil),
+		decommissionFunc(newDecommissions(client, client, ptConfig), drops),
+		circuitBreakerFunc(nil, drops, nil, nil),
+		quotaFunc(nil, drops),
+		pipelinesFunc(nil, nil),
+		sendToInfluxDBFunc(write),
+	}
+
+	origin := now.UnixNano()
+	merged := models.Event{Device: "Device-A", Origin: origin, Readings: []models.Reading{
+		{Device: "Device-A", Name: "Temperature", Value: "21", ValueType: "Int64", Origin: origin},
+		{Device: "Device-B", Name: "Humidity", Value: "40", ValueType: "Int64", Origin: origin},
+	}}
+	edgexcontext := &appcontext.Context{LoggingClient: logger.NewMockClient()}
+	if _, err := runFunctions(edgexcontext, pipeline, merged); err == nil {
+		t.Fatal("the failed write of Device-B wasn't reported")
+	}
+	if edgexcontext.RetryData == nil {
+		t.Fatal("nothing was saved to retry")
+	}
+
+	// store-and-forward retries the saved data through the whole pipeline
+	delete(client.failing, "Canonical-B")
+	retry := &appcontext.Context{LoggingClient: logger.NewMockClient()}
+	var result interface{} = edgexcontext.RetryData
+	for _, fn := range pipeline {
+		var ok bool
+		if ok, result = fn(retry, result); !ok {
+			break
+		}
+	}
+	if err, isErr := result.(error); isErr {
+		t.Fatalf("retry failed: %v", err)
+	}
+
+	var measurements []string
+	for _, pt := range client.Points() {
+		measurements = append(measurements, pt.Name())
+	}
+	sort.Strings(measurements)
+	// Device-A was written once, before the retry, and Device-B as its
+	// canonical name
+	if want := []string{"Canonical-B", "Device-A"}; len(measurements) != len(want) || measurements[0] != want[0] || measurements[1] != want[1] {
+		t.Errorf("wrote %v, want %v", measurements, want)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/edgexfoundry/app-functions-sdk-go/appcontext"
+	"github.com/edgexfoundry/go-mod-core-contracts/models"
+)
+
+// staleEvents drops events older than a maximum age, such as the backlog
+// replayed by store-and-forward after a long outage, so that they don't
+// swamp InfluxDB. Stale events can be passed on instead, for backfill
+// routing to write them to its retention policy.
+type staleEvents struct {
+	maxAge time.Duration
+	// keep passes stale events on instead of dropping them
+	keep  bool
+	count uint64
+	// clock is the system clock unless set by tests
+	clock clock
+}
+
+func newStaleEvents(maxAge time.Duration) *staleEvents {
+	return &staleEvents{maxAge: maxAge, clock: systemClock{}}
+}
+
+// eventTime returns the origin of the event, or of its newest reading if the
+// event has none, origins being in nanoseconds
+func eventTime(event models.Event) time.Time {
+	origin := event.Origin
+	if origin <= 0 {
+		for _, reading := range event.Readings {
+			if reading.Origin > origin {
+				origin = reading.Origin
+			}
+		}
+	}
+	return time.Unix(0, origin)
+}
+
+// stale returns whether the event is older than the maximum age, counting it
+// if it is
+func (s *staleEvents) stale(event models.Event, now time.Time) bool {
+	if s == nil || now.Sub(eventTime(event)) <= s.maxAge {
+		return false
+	}
+	atomic.AddUint64(&s.count, 1)
+	return true
+}
+
+func (s *staleEvents) writeMetrics(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %sstale_events_total Events older than the maximum event age.\n", metricsPrefix)
+	fmt.Fprintf(w, "# TYPE %sstale_events_total counter\n", metricsPrefix)
+	fmt.Fprintf(w, "%sstale_events_total %d\n", metricsPrefix, atomic.LoadUint64(&s.count))
+}
+
+// staleFunc drops events older than the maximum age
+func staleFunc(s *staleEvents, drops *dropAccounting) func(edgexcontext *appcontext.Context, params ...interface{}) (bool, interface{}) {
+	return func(edgexcontext *appcontext.Context, params ...interface{}) (bool, interface{}) {
+		if len(params) < 1 {
+			// We didn't receive a result
+			return false, errors.New("no data received")
+		}
+
+		event, ok := params[0].(models.Event)
+		if !ok || s == nil {
+			// not an event, let the next function decide what to do with it
+			return true, params[0]
+		}
+
+		if s.stale(event, s.clock.Now()) && !s.keep {
+			edgexcontext.LoggingClient.Debug(fmt.Sprintf("dropping stale event from device %q", event.Device))
+			drops.add(dropStale, event.Device, len(event.Readings))
+			return false, nil
+		}
+
+		return true, event
+	}
+}
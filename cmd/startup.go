@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	influx "github.com/influxdata/influxdb1-client/v2"
+)
+
+// startupMeasurement is where the startup summary is written if enabled
+const startupMeasurement = "proxy_startup"
+
+// startupBatching are the parameters points are written to influx with
+type startupBatching struct {
+	Database        string `json:"database"`
+	RetentionPolicy string `json:"retentionPolicy,omitempty"`
+	Precision       string `json:"precision"`
+	// MaxLineBatch is the most lines from a TCP connection written at once
+	MaxLineBatch int `json:"maxLineBatch"`
+}
+
+// startupSummary describes how an instance was started in one place, so
+// that support can tell what it is doing from its first log lines
+type startupSummary struct {
+	Version string `json:"version"`
+	Service string `json:"service"`
+	// Registry is whether the SDK was asked to register with the registry
+	Registry bool `json:"registry"`
+	ReadOnly bool `json:"readOnly"`
+	// Listeners are the addresses of the sockets listened on, by source
+	Listeners map[string]string `json:"listeners,omitempty"`
+	Routes    []string          `json:"routes"`
+	Sources   []string          `json:"sources"`
+	Sinks     []string          `json:"sinks"`
+	// Stages are the enabled stages of the EdgeX pipeline, and Filters how
+	// many of them can drop events
+	Stages   []string        `json:"stages"`
+	Filters  int             `json:"filters"`
+	Batching startupBatching `json:"batching"`
+}
+
+// registryRequested returns whether the SDK arguments ask it to use the
+// registry, with -r or --registry
+func registryRequested(args []string) bool {
+	for _, arg := range args {
+		switch name := strings.TrimLeft(arg, "-"); {
+		case name == arg:
+		case name == "r" || name == "registry" || strings.HasPrefix(name, "registry="):
+			return true
+		}
+	}
+	return false
+}
+
+// newStartupSummary summarizes the topology, routes and batching of the
+// instance, where lineBatch is the most lines from a TCP connection
+// written at once
+func newStartupSummary(version, service string, args []string, readOnly bool, listeners map[string]string, topology *pipelineTopology, metrics *routeMetrics, ptConfig influx.BatchPointsConfig, lineBatch int) *startupSummary {
+	s := &startupSummary{
+		Version:  version,
+		Service:  service,
+		Registry: registryRequested(args),
+		ReadOnly: readOnly,
+		Routes:   []string{"/metrics"},
+		Batching: startupBatching{
+			Database:        ptConfig.Database,
+			RetentionPolicy: ptConfig.RetentionPolicy,
+			Precision:       ptConfig.Precision,
+			MaxLineBatch:    lineBatch,
+		},
+	}
+	for source, addr := range listeners {
+		if addr == "" {
+			continue
+		}
+		if s.Listeners == nil {
+			s.Listeners = make(map[string]string)
+		}
+		s.Listeners[source] = addr
+	}
+
+	metrics.mu.Lock()
+	for route := range metrics.routes {
+		s.Routes = append(s.Routes, route)
+	}
+	metrics.mu.Unlock()
+	sort.Strings(s.Routes)
+
+	for _, n := range topology.sources {
+		if n.Enabled {
+			s.Sources = append(s.Sources, n.Name)
+		}
+	}
+	for _, n := range topology.sinks {
+		if n.Enabled {
+			s.Sinks = append(s.Sinks, n.Name)
+		}
+	}
+	for _, n := range topology.stages {
+		if !n.Enabled {
+			continue
+		}
+		s.Stages = append(s.Stages, n.Name)
+		if n.Kind == nodeFilter {
+			s.Filters++
+		}
+	}
+	return s
+}
+
+// String returns the summary as a single line of JSON
+func (s *startupSummary) String() string {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err.Error()
+	}
+	return string(b)
+}
+
+// write writes the summary to influx
+func (s *startupSummary) write(client influx.Client, ptConfig influx.BatchPointsConfig) error {
+	bp, err := influx.NewBatchPoints(ptConfig)
+	if err != nil {
+		return err
+	}
+	pt, err := influx.NewPoint(
+		startupMeasurement,
+		map[string]string{"service": s.Service},
+		map[string]interface{}{
+			"version": s.Version,
+			"summary": s.String(),
+			"sources": int64(len(s.Sources)),
+			"sinks":   int64(len(s.Sinks)),
+			"filters": int64(s.Filters),
+			"routes":  int64(len(s.Routes)),
+		},
+		time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+	bp.AddPoint(pt)
+	return client.Write(bp)
+}
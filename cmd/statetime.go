@@ -0,0 +1,145 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	influx "github.com/influxdata/influxdb1-client/v2"
+)
+
+const (
+	// stateChangeMeasurement is where a point is written whenever a tracked
+	// boolean resource changes state
+	stateChangeMeasurement = "state_changes"
+	// stateOnTimeMeasurement is where the time a tracked boolean resource
+	// was true is written for every interval
+	stateOnTimeMeasurement = "state_on_time"
+	// maxStateIntervals bounds how many intervals are written at once after
+	// a gap in the readings of a resource, older ones are skipped
+	maxStateIntervals = 1440
+)
+
+// stateSeries is the state of one boolean resource of one device
+type stateSeries struct {
+	state bool
+	// since is when the resource changed to its current state
+	since time.Time
+	// last is the time of the latest reading
+	last time.Time
+	// intervalStart is the start of the interval being accumulated and
+	// onTime how long the resource was true in it up to last
+	intervalStart time.Time
+	onTime        time.Duration
+}
+
+// stateDurations turns the readings of boolean resources, such as a door
+// being open or a motor running, into points for every change of state and
+// for how long they were true in every interval. A resource is assumed to
+// keep its state until its next reading, so an interval is only written once
+// a reading after it arrives.
+type stateDurations struct {
+	resources map[string]bool
+	interval  time.Duration
+
+	mu     sync.Mutex
+	series map[string]*stateSeries
+}
+
+func newStateDurations(resources []string, interval time.Duration) *stateDurations {
+	s := &stateDurations{
+		resources: make(map[string]bool),
+		interval:  interval,
+		series:    make(map[string]*stateSeries),
+	}
+	for _, resource := range resources {
+		s.resources[resource] = true
+	}
+	return s
+}
+
+// observe records the state of the resource at t and returns the points for
+// the state change and the intervals it completes, if any. Readings older
+// than the latest one of the resource are ignored.
+func (s *stateDurations) observe(device, resource string, state bool, t time.Time) []*influx.Point {
+	if s == nil || !s.resources[resource] {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tags := map[string]string{"device": device, "resource": resource}
+	key := device + "/" + resource
+	ser, ok := s.series[key]
+	if !ok {
+		s.series[key] = &stateSeries{
+			state:         state,
+			since:         t,
+			last:          t,
+			intervalStart: t.Truncate(s.interval),
+		}
+		return s.points(nil, stateChangeMeasurement, tags, map[string]interface{}{"state": state}, t)
+	}
+	if t.Before(ser.last) {
+		return nil
+	}
+
+	var pts []*influx.Point
+	if skip := t.Sub(ser.intervalStart)/s.interval - maxStateIntervals; skip > 0 {
+		ser.intervalStart = ser.intervalStart.Add(skip * s.interval)
+		ser.last = ser.intervalStart
+		ser.onTime = 0
+	}
+	for end := ser.intervalStart.Add(s.interval); !t.Before(end); end = end.Add(s.interval) {
+		if ser.state {
+			ser.onTime += end.Sub(ser.last)
+		}
+		pts = s.points(pts, stateOnTimeMeasurement, tags, map[string]interface{}{
+			"on_seconds": ser.onTime.Seconds(),
+			"on_ratio":   float64(ser.onTime) / float64(s.interval),
+		}, ser.intervalStart)
+		ser.intervalStart = end
+		ser.last = end
+		ser.onTime = 0
+	}
+	if ser.state {
+		ser.onTime += t.Sub(ser.last)
+	}
+	ser.last = t
+
+	if state != ser.state {
+		pts = s.points(pts, stateChangeMeasurement, tags, map[string]interface{}{
+			"state":                     state,
+			"previous_duration_seconds": t.Sub(ser.since).Seconds(),
+		}, t)
+		ser.state = state
+		ser.since = t
+	}
+	return pts
+}
+
+// points appends a new point to pts, the fields are always valid so the
+// point can't fail to be made
+func (s *stateDurations) points(pts []*influx.Point, measurement string, tags map[string]string, fields map[string]interface{}, t time.Time) []*influx.Point {
+	pt, err := influx.NewPoint(measurement, tags, fields, t)
+	if err != nil {
+		return pts
+	}
+	return append(pts, pt)
+}
+
+// forget drops the state of the device's resources
+func (s *stateDurations) forget(device string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key := range s.series {
+		if strings.HasPrefix(key, device+"/") {
+			delete(s.series, key)
+		}
+	}
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+	influx "github.com/influxdata/influxdb1-client/v2"
+)
+
+// statsdMetric is a single parsed StatsD line such as "requests:1|c|@0.5"
+type statsdMetric struct {
+	name string
+	// value is the metric value, already scaled by the sample rate for
+	// counters
+	value float64
+	// kind is one of "c", "g", "ms", "h" or "s"
+	kind string
+	// relative is set for gauges with an explicit sign, which adjust the
+	// current value rather than replacing it
+	relative bool
+	// member is the value of a set, which may be any string
+	member string
+	// tags are DogStatsD style "#key:value" tags
+	tags map[string]string
+}
+
+// parseStatsdLine parses a line of the form name:value|type[|@rate][|#tags]
+func parseStatsdLine(line string) (*statsdMetric, error) {
+	colon := strings.Index(line, ":")
+	if colon <= 0 {
+		return nil, fmt.Errorf("missing metric name in %q", line)
+	}
+	m := &statsdMetric{name: line[:colon]}
+
+	parts := strings.Split(line[colon+1:], "|")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("missing metric type in %q", line)
+	}
+
+	valStr := parts[0]
+	m.kind = parts[1]
+	switch m.kind {
+	case "c", "g", "ms", "h", "s":
+	default:
+		return nil, fmt.Errorf("unsupported metric type %q in %q", m.kind, line)
+	}
+	if m.kind == "g" && (strings.HasPrefix(valStr, "+") || strings.HasPrefix(valStr, "-")) {
+		m.relative = true
+	}
+
+	rate := 1.0
+	for _, part := range parts[2:] {
+		switch {
+		case strings.HasPrefix(part, "@"):
+			r, err := strconv.ParseFloat(part[1:], 64)
+			if err != nil || r <= 0 || r > 1 {
+				return nil, fmt.Errorf("invalid sample rate in %q", line)
+			}
+			rate = r
+		case strings.HasPrefix(part, "#"):
+			m.tags = make(map[string]string)
+			for _, tag := range strings.Split(part[1:], ",") {
+				kv := strings.SplitN(tag, ":", 2)
+				if len(kv) == 2 && kv[0] != "" && kv[1] != "" {
+					m.tags[kv[0]] = kv[1]
+				}
+			}
+		}
+	}
+
+	if m.kind == "s" {
+		// sets count unique values, which may be any string
+		m.member = valStr
+		return m, nil
+	}
+
+	val, err := strconv.ParseFloat(valStr, 64)
+	if err != nil || math.IsNaN(val) || math.IsInf(val, 0) {
+		return nil, fmt.Errorf("invalid metric value in %q", line)
+	}
+	if m.kind == "c" {
+		val /= rate
+	}
+	m.value = val
+	return m, nil
+}
+
+// mergeTags returns a copy of a with the tags of b added
+func mergeTags(a, b map[string]string) map[string]string {
+	tags := make(map[string]string, len(a)+len(b))
+	for k, v := range a {
+		tags[k] = v
+	}
+	for k, v := range b {
+		tags[k] = v
+	}
+	return tags
+}
+
+// statsdAggregate holds the values of a metric received during one flush
+// interval
+type statsdAggregate struct {
+	name    string
+	kind    string
+	tags    map[string]string
+	value   float64
+	timings []float64
+	members map[string]struct{}
+}
+
+// statsdServer aggregates StatsD metrics and writes them to InfluxDB every
+// flush interval
+type statsdServer struct {
+	lc       logger.LoggingClient
+	client   influx.Client
+	ptConfig influx.BatchPointsConfig
+	// tags are added to every point
+	tags map[string]string
+	// controls drop metrics while ingestion from statsd is paused
+	controls *ingestionControls
+	// mem drops the flushes kept to retry while memory runs low
+	mem *memoryGuard
+	// lease drops the flushes of standbys, as the leader writes its own
+	lease *leaderLease
+	// maxPending is the most flushes that failed to be written which are
+	// kept to retry with the next flush, the oldest are dropped first
+	maxPending int
+	// maxSeries is the most series aggregated in a flush interval and
+	// gauges kept across them, the metrics of other series are dropped, zero
+	// doesn't limit them
+	maxSeries int
+	// maxValues is the most timings of a timer and members of a set
+	// aggregated in a flush interval, the others are dropped, zero doesn't
+	// limit them
+	maxValues int
+	// gaugeExpiry is how long the last value of a gauge that isn't updated
+	// is kept for relative updates, zero keeps them forever
+	gaugeExpiry time.Duration
+	// clock is the system clock unless set by tests
+	clock clock
+
+	// pending are the flushes waiting to be retried, only used by flush
+	pending []influx.BatchPoints
+
+	mu         sync.Mutex
+	aggregates map[string]*statsdAggregate
+	// gauges keeps the last value of every gauge across flushes so that
+	// relative updates have something to apply to
+	gauges map[string]*statsdGauge
+	// droppedSeries and droppedValues count the metrics dropped for
+	// maxSeries and maxValues
+	droppedSeries uint64
+	droppedValues uint64
+}
+
+// statsdGauge is the last value of a gauge and when it was last updated
+type statsdGauge struct {
+	value   float64
+	updated time.Time
+}
+
+func newStatsdServer(lc logger.LoggingClient, client influx.Client, ptConfig influx.BatchPointsConfig, tags map[string]string) *statsdServer {
+	return &statsdServer{
+		lc:         lc,
+		client:     client,
+		ptConfig:   ptConfig,
+		tags:       tags,
+		clock:      systemClock{},
+		aggregates: make(map[string]*statsdAggregate),
+		gauges:     make(map[string]*statsdGauge),
+	}
+}
+
+// seriesKey identifies a metric by name, type and tags
+func seriesKey(name, kind string, tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(name)
+	b.WriteString("|")
+	b.WriteString(kind)
+	for _, k := range keys {
+		fmt.Fprintf(&b, ",%s=%s", k, tags[k])
+	}
+	return b.String()
+}
+
+// add aggregates the metric into the current flush interval
+func (s *statsdServer) add(m *statsdMetric) {
+	kind := m.kind
+	if kind == "h" {
+		// histograms are just timers with another name
+		kind = "ms"
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := seriesKey(m.name, kind, m.tags)
+	agg, ok := s.aggregates[key]
+	if !ok {
+		// new series are dropped once there are too many, as every one of
+		// them is held until the next flush
+		if s.maxSeries > 0 && len(s.aggregates) >= s.maxSeries {
+			s.droppedSeries++
+			return
+		}
+		if _, known := s.gauges[key]; kind == "g" && !known && s.maxSeries > 0 && len(s.gauges) >= s.maxSeries {
+			s.droppedSeries++
+			return
+		}
+		agg = &statsdAggregate{name: m.name, kind: kind, tags: m.tags}
+		s.aggregates[key] = agg
+	}
+
+	switch kind {
+	case "c":
+		agg.value += m.value
+	case "g":
+		gauge, ok := s.gauges[key]
+		if !ok {
+			gauge = &statsdGauge{}
+			s.gauges[key] = gauge
+		}
+		if m.relative {
+			gauge.value += m.value
+		} else {
+			gauge.value = m.value
+		}
+		gauge.updated = s.clock.Now()
+		agg.value = gauge.value
+	case "ms":
+		if s.maxValues > 0 && len(agg.timings) >= s.maxValues {
+			s.droppedValues++
+			return
+		}
+		agg.timings = append(agg.timings, m.value)
+	case "s":
+		if agg.members == nil {
+			agg.members = make(map[string]struct{})
+		}
+		if _, ok := agg.members[m.member]; !ok && s.maxValues > 0 && len(agg.members) >= s.maxValues {
+			s.droppedValues++
+			return
+		}
+		agg.members[m.member] = struct{}{}
+	}
+}
+
+// fields returns the InfluxDB fields for the aggregate
+func (agg *statsdAggregate) fields() map[string]interface{} {
+	switch agg.kind {
+	case "ms":
+		sort.Float64s(agg.timings)
+		sum := 0.0
+		for _, t := range agg.timings {
+			sum += t
+		}
+		n := len(agg.timings)
+		return map[string]interface{}{
+			"count": int64(n),
+			"sum":   sum,
+			"mean":  sum / float64(n),
+			"min":   agg.timings[0],
+			"max":   agg.timings[n-1],
+			"p90":   agg.timings[int(math.Ceil(0.9*float64(n)))-1],
+		}
+	case "s":
+		return map[string]interface{}{"value": int64(len(agg.members))}
+	}
+	return map[string]interface{}{"value": agg.value}
+}
+
+// flush writes the metrics aggregated since the last flush
+func (s *statsdServer) flush(now time.Time) {
+	s.mu.Lock()
+	aggregates := s.aggregates
+	s.aggregates = make(map[string]*statsdAggregate)
+	if s.gaugeExpiry > 0 {
+		// forget gauges that stopped being updated, such as those of
+		// processes that exited
+		for key, gauge := range s.gauges {
+			if now.Sub(gauge.updated) > s.gaugeExpiry {
+				delete(s.gauges, key)
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	if len(aggregates) == 0 {
+		return
+	}
+
+	bp, err := influx.NewBatchPoints(s.ptConfig)
+	if err != nil {
+		s.lc.Error(fmt.Sprintf("unable to create batch points for statsd metrics: %s", err))
+		return
+	}
+	for _, agg := range aggregates {
+		pt, err := influx.NewPoint(agg.name, mergeTags(agg.tags, s.tags), agg.fields(), now)
+		if err != nil {
+			s.lc.Warn(fmt.Sprintf("unable to create point for statsd metric %q: %s", agg.name, err))
+			continue
+		}
+		bp.AddPoint(pt)
+	}
+
+	if !s.lease.isLeader() {
+		return
+	}
+	s.pending = append(s.pending, bp)
+	s.retry()
+}
+
+// retry writes the pending flushes oldest first, stopping at the first that
+// fails to be written so that it is retried with the next flush, like
+// store-and-forward retries events
+func (s *statsdServer) retry() {
+	for len(s.pending) != 0 {
+		err := s.client.Write(s.pending[0])
+		if err == nil {
+			s.pending = s.pending[1:]
+			continue
+		}
+		failure := classifyWriteError(err)
+		if failure.permanent {
+			// retrying would only fail the same way again
+			s.lc.Error(fmt.Sprintf("dropping statsd metrics rejected by influx: %s", failure.message))
+			s.pending = s.pending[1:]
+			continue
+		}
+		s.lc.Error(fmt.Sprintf("error writing statsd metrics to influx: %s", failure.message))
+		break
+	}
+
+	max := s.maxPending
+	if s.mem.isPaused() && max > 1 {
+		// only keep the newest flush while memory is low
+		max = 1
+	}
+	if len(s.pending) > max {
+		s.lc.Warn(fmt.Sprintf("dropping %d flushes of statsd metrics that failed to be written", len(s.pending)-max))
+		s.pending = append([]influx.BatchPoints(nil), s.pending[len(s.pending)-max:]...)
+	}
+}
+
+// writeMetrics writes the gauges kept and the metrics dropped for the limits
+// in the Prometheus text format
+func (s *statsdServer) writeMetrics(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %sstatsd_gauges Gauges whose last value is kept for relative updates.\n", metricsPrefix)
+	fmt.Fprintf(w, "# TYPE %sstatsd_gauges gauge\n", metricsPrefix)
+	fmt.Fprintf(w, "%sstatsd_gauges %d\n", metricsPrefix, len(s.gauges))
+	fmt.Fprintf(w, "# HELP %sstatsd_dropped_metrics_total StatsD metrics dropped for exceeding the series or values limits.\n", metricsPrefix)
+	fmt.Fprintf(w, "# TYPE %sstatsd_dropped_metrics_total counter\n", metricsPrefix)
+	fmt.Fprintf(w, "%sstatsd_dropped_metrics_total{limit=\"series\"} %d\n", metricsPrefix, s.droppedSeries)
+	fmt.Fprintf(w, "%sstatsd_dropped_metrics_total{limit=\"values\"} %d\n", metricsPrefix, s.droppedValues)
+}
+
+// listen receives StatsD datagrams on addr, writing the aggregated metrics
+// every interval
+func (s *statsdServer) listen(addr string, interval time.Duration) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for now := range s.clock.Tick(interval) {
+			s.flush(now)
+		}
+	}()
+
+	go func() {
+		buf := make([]byte, maxUDPPacket)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				s.lc.Error(fmt.Sprintf("statsd listener on %s stopped: %s", addr, err))
+				return
+			}
+			if s.controls.isPaused(sourceStatsD) {
+				continue
+			}
+			for _, line := range bytes.Split(buf[:n], []byte("\n")) {
+				line = bytes.TrimSpace(line)
+				if len(line) == 0 {
+					continue
+				}
+				m, err := parseStatsdLine(string(line))
+				if err != nil {
+					s.lc.Debug(fmt.Sprintf("ignoring statsd metric from %s: %s", from, err))
+					continue
+				}
+				s.add(m)
+			}
+		}
+	}()
+
+	return nil
+}
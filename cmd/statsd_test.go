@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anonymouse64/edgex-influx-proxy/pkg/testutil"
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+	influx "github.com/influxdata/influxdb1-client/v2"
+)
+
+func TestStatsdLimits(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Unix(1600000000, 0))
+	client := &testutil.RecordingClient{}
+	s := newStatsdServer(logger.NewMockClient(), client, influx.BatchPointsConfig{Database: "edgex"}, nil)
+	s.clock = clk
+	s.maxPending = 1
+	s.maxSeries = 3
+	s.maxValues = 2
+	add := func(lines ...string) {
+		for _, line := range lines {
+			m, err := parseStatsdLine(line)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.add(m)
+		}
+	}
+
+	add(
+		"requests:1|c", "requests:1|c",
+		"latency:10|ms", "latency:20|ms", "latency:30|ms",
+		"users:alice|s", "users:bob|s", "users:alice|s", "users:carol|s",
+		"queue:5|g",
+	)
+	s.flush(clk.Now())
+	fields := make(map[string]map[string]interface{})
+	for _, pt := range client.Points() {
+		f, err := pt.Fields()
+		if err != nil {
+			t.Fatal(err)
+		}
+		fields[pt.Name()] = f
+	}
+	if len(fields) != 3 || fields["queue"] != nil {
+		t.Errorf("wrote %v, want the first 3 series", fields)
+	}
+	if count := fields["latency"]["count"]; count != int64(2) {
+		t.Errorf("aggregated %v timings, want 2", count)
+	}
+	if members := fields["users"]["value"]; members != int64(2) {
+		t.Errorf("counted %v members, want 2", members)
+	}
+
+	var metrics bytes.Buffer
+	s.writeMetrics(&metrics)
+	for _, want := range []string{
+		metricsPrefix + `statsd_dropped_metrics_total{limit="series"} 1`,
+		metricsPrefix + `statsd_dropped_metrics_total{limit="values"} 2`,
+	} {
+		if !strings.Contains(metrics.String(), want) {
+			t.Errorf("metrics don't contain %q:\n%s", want, metrics.String())
+		}
+	}
+}
+
+func TestStatsdGaugeExpiry(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Unix(1600000000, 0))
+	client := &testutil.RecordingClient{}
+	s := newStatsdServer(logger.NewMockClient(), client, influx.BatchPointsConfig{Database: "edgex"}, nil)
+	s.clock = clk
+	s.maxPending = 1
+	s.gaugeExpiry = time.Hour
+	gauge := func(line string) float64 {
+		t.Helper()
+		m, err := parseStatsdLine(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.add(m)
+		s.flush(clk.Now())
+		points := client.Points()
+		fields, err := points[len(points)-1].Fields()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fields["value"].(float64)
+	}
+
+	if got := gauge("queue:10|g"); got != 10 {
+		t.Fatalf("got %v, want 10", got)
+	}
+	clk.Advance(30 * time.Minute)
+	if got := gauge("queue:+5|g"); got != 15 {
+		t.Fatalf("got %v after a relative update, want 15", got)
+	}
+
+	// an idle gauge is forgotten, relative updates start from 0 again
+	clk.Advance(2 * time.Hour)
+	s.flush(clk.Now())
+	if len(s.gauges) != 0 {
+		t.Fatalf("kept %d idle gauges", len(s.gauges))
+	}
+	if got := gauge("queue:+5|g"); got != 5 {
+		t.Errorf("got %v after the gauge expired, want 5", got)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+)
+
+// maxTagOffenders bounds how many devices are remembered as having already
+// been logged for invalid tag values
+const maxTagOffenders = 1000
+
+// tagValueReplacer replaces the characters that line protocol can't escape in
+// tag values, as they would end the line in the middle of a point
+var tagValueReplacer = strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ")
+
+// tagValidator makes tag values safe to write as line protocol, logging the
+// devices sending invalid ones once each
+type tagValidator struct {
+	// maxLength truncates longer values, 0 disables truncation
+	maxLength int
+	lc        logger.LoggingClient
+
+	mu        sync.Mutex
+	offenders map[string]bool
+}
+
+func newTagValidator(lc logger.LoggingClient, maxLength int) *tagValidator {
+	return &tagValidator{
+		maxLength: maxLength,
+		lc:        lc,
+		offenders: make(map[string]bool),
+	}
+}
+
+// sanitize fixes the tag values from the device in place
+func (v *tagValidator) sanitize(device string, tags map[string]string) {
+	for k, val := range tags {
+		fixed := tagValueReplacer.Replace(val)
+		var problems []string
+		if fixed != val {
+			problems = append(problems, "line breaks")
+		}
+		if v.maxLength != 0 && len(fixed) > v.maxLength {
+			n := v.maxLength
+			// don't cut a character in half
+			for n > 0 && !utf8.RuneStart(fixed[n]) {
+				n--
+			}
+			fixed = fixed[:n]
+			problems = append(problems, fmt.Sprintf("more than %d bytes", v.maxLength))
+		}
+		// a trailing backslash would escape the separator after the value
+		if trimmed := strings.TrimRight(fixed, `\`); trimmed != fixed {
+			fixed = trimmed
+			problems = append(problems, "a trailing backslash")
+		}
+		if len(problems) != 0 {
+			tags[k] = fixed
+			v.report(device, k, strings.Join(problems, " and "))
+		}
+	}
+}
+
+func (v *tagValidator) report(device, tag, problem string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.offenders[device] || len(v.offenders) >= maxTagOffenders {
+		return
+	}
+	v.offenders[device] = true
+	v.lc.Warn(fmt.Sprintf("device %q sent a value for tag %q with %s, fixing its tag values", device, tag, problem))
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// tuningProfiles preset the settings that trade memory and CPU for
+// throughput for a class of hardware, so that they don't all have to be
+// tuned by hand. Settings that are set take precedence over the profile.
+var tuningProfiles = map[string]map[string]string{
+	// single core boards with 512MB of memory or less
+	"pi-zero": {
+		"MemoryBudgetMB":            "64",
+		"GCPercent":                 "50",
+		"LineProtocolMaxBatch":      "500",
+		"StatsDFlushInterval":       "30s",
+		"HighWaterMarkSaveInterval": "2m",
+		"TypingStatsWindow":         "10m",
+	},
+	// gateways with a few cores and a few GB of memory
+	"gateway-4core": {
+		"MemoryBudgetMB":            "512",
+		"GCPercent":                 "100",
+		"LineProtocolMaxBatch":      "5000",
+		"StatsDFlushInterval":       "10s",
+		"HighWaterMarkSaveInterval": "30s",
+		"TypingStatsWindow":         "1h",
+	},
+	// servers, where throughput matters more than memory
+	"server": {
+		"GCPercent":                 "200",
+		"LineProtocolMaxBatch":      "20000",
+		"StatsDFlushInterval":       "10s",
+		"HighWaterMarkSaveInterval": "30s",
+		"TypingStatsWindow":         "1h",
+	},
+}
+
+// applyTuningProfile fills in the settings of the profile named by
+// TuningProfile that aren't set, returning the settings it filled in
+func applyTuningProfile(appSettings map[string]string) ([]string, error) {
+	name := appSettings["TuningProfile"]
+	if name == "" {
+		return nil, nil
+	}
+	profile, ok := tuningProfiles[name]
+	if !ok {
+		names := make([]string, 0, len(tuningProfiles))
+		for n := range tuningProfiles {
+			names = append(names, fmt.Sprintf("%q", n))
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("invalid \"TuningProfile\" setting of %s, must be one of %s", name, strings.Join(names, ", "))
+	}
+
+	var applied []string
+	for key, value := range profile {
+		if appSettings[key] != "" {
+			continue
+		}
+		appSettings[key] = value
+		applied = append(applied, key)
+	}
+	sort.Strings(applied)
+	return applied, nil
+}
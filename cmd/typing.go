@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// maxTypingDevices bounds how many devices typing decisions are kept for
+	maxTypingDevices = 1000
+	// maxTypingSample is how much of a raw value is kept as a sample
+	maxTypingSample = 256
+	// typeCountBuckets is how many buckets the type counts window is split
+	// into, the window slides one bucket at a time
+	typeCountBuckets = 12
+)
+
+// typingDecision is the type chosen for the latest value of a resource
+type typingDecision struct {
+	Type string `json:"type"`
+	// Sample is the raw value the type was chosen from
+	Sample string `json:"sample"`
+	// ValueType and FloatEncoding are what the device service declared for
+	// the reading, if anything, the type is only guessed from the sample if
+	// they are missing or the sample doesn't parse as them
+	ValueType     string    `json:"valueType,omitempty"`
+	FloatEncoding string    `json:"floatEncoding,omitempty"`
+	Time          time.Time `json:"time"`
+}
+
+// typeCounts counts how often the values of a resource were typed as each
+// type over a sliding window
+type typeCounts struct {
+	// buckets count the types in consecutive periods, starts are when the
+	// period each bucket counts started
+	buckets [typeCountBuckets]map[string]uint64
+	starts  [typeCountBuckets]time.Time
+}
+
+// typingDecisions keeps the latest typing decision for every resource of
+// every device, to debug values being written with an unexpected type, and
+// how often each type was chosen over a sliding window, since a resource
+// flapping between types leads to field type conflicts
+type typingDecisions struct {
+	// window is how long types are counted for
+	window time.Duration
+
+	mu      sync.Mutex
+	devices map[string]map[string]typingDecision
+	counts  map[string]map[string]*typeCounts
+}
+
+func newTypingDecisions(window time.Duration) *typingDecisions {
+	return &typingDecisions{
+		window:  window,
+		devices: make(map[string]map[string]typingDecision),
+		counts:  make(map[string]map[string]*typeCounts),
+	}
+}
+
+// record remembers the type chosen for a reading's value
+func (t *typingDecisions) record(device, resource string, decision typingDecision) {
+	if t == nil {
+		return
+	}
+	if len(decision.Sample) > maxTypingSample {
+		decision.Sample = decision.Sample[:maxTypingSample]
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	resources, ok := t.devices[device]
+	if !ok {
+		if len(t.devices) >= maxTypingDevices {
+			return
+		}
+		resources = make(map[string]typingDecision)
+		t.devices[device] = resources
+		t.counts[device] = make(map[string]*typeCounts)
+	}
+	resources[resource] = decision
+
+	counts, ok := t.counts[device][resource]
+	if !ok {
+		counts = &typeCounts{}
+		t.counts[device][resource] = counts
+	}
+	period := t.window / typeCountBuckets
+	start := decision.Time.Truncate(period)
+	i := int(start.UnixNano()/int64(period)) % typeCountBuckets
+	if !counts.starts[i].Equal(start) {
+		counts.buckets[i] = make(map[string]uint64)
+		counts.starts[i] = start
+	}
+	counts.buckets[i][decision.Type]++
+}
+
+// resourceTypeCounts is how often the values of a resource were typed as
+// each type within the window, as served by /stats/typing
+type resourceTypeCounts struct {
+	Types map[string]uint64 `json:"types"`
+	// Flapping is whether values were typed as more than one type
+	Flapping bool `json:"flapping"`
+}
+
+// deviceTypeCounts returns how often the values of each of the device's
+// resources were typed as each type within the window
+func (t *typingDecisions) deviceTypeCounts(device string, now time.Time) map[string]resourceTypeCounts {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	stats := make(map[string]resourceTypeCounts)
+	for resource, counts := range t.counts[device] {
+		types := make(map[string]uint64)
+		for i, bucket := range counts.buckets {
+			if now.Sub(counts.starts[i]) >= t.window {
+				continue
+			}
+			for typ, n := range bucket {
+				types[typ] += n
+			}
+		}
+		if len(types) != 0 {
+			stats[resource] = resourceTypeCounts{Types: types, Flapping: len(types) > 1}
+		}
+	}
+	return stats
+}
+
+// deviceDecisions returns the decisions for the device's resources
+func (t *typingDecisions) deviceDecisions(device string) map[string]typingDecision {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	decisions := make(map[string]typingDecision)
+	for resource, decision := range t.devices[device] {
+		decisions[resource] = decision
+	}
+	return decisions
+}
+
+// typingHandler serves /debug/typing?device=X with the type chosen for the
+// latest value of each of the device's resources
+func (t *typingDecisions) typingHandler(w http.ResponseWriter, r *http.Request) {
+	device := r.URL.Query().Get("device")
+	if device == "" {
+		writeProblem(w, r, "device is required", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(t.deviceDecisions(device)); err != nil {
+		writeProblem(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// typeCountsHandler serves /stats/typing?device=X with how often the values
+// of each of the device's resources were typed as each type within the
+// window
+func (t *typingDecisions) typeCountsHandler(w http.ResponseWriter, r *http.Request) {
+	device := r.URL.Query().Get("device")
+	if device == "" {
+		writeProblem(w, r, "device is required", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(t.deviceTypeCounts(device, time.Now())); err != nil {
+		writeProblem(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// forget drops the typing decisions of the device
+func (t *typingDecisions) forget(device string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.devices, device)
+	delete(t.counts, device)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+const (
+	// unitTag is the tag holding the unit normalized values are written in
+	unitTag = "unit"
+	// normalizedFieldSuffix is appended to the field of an integer resource
+	// for the field with its value in the canonical unit, when the value
+	// can't be converted to an integer without changing the type of the field
+	normalizedFieldSuffix = "_normalized"
+)
+
+// unit is a unit of a quantity, converted to the base unit of the quantity
+// as value*factor + offset
+type unit struct {
+	quantity string
+	factor   float64
+	offset   float64
+}
+
+// units is the conversion table of the units readings can be normalized
+// from and to, by the symbols they are commonly declared with
+var units = map[string]unit{
+	"K":    {"temperature", 1, 0},
+	"degC": {"temperature", 1, 273.15},
+	"°C":   {"temperature", 1, 273.15},
+	"C":    {"temperature", 1, 273.15},
+	"degF": {"temperature", 5.0 / 9, 273.15 - 32*5.0/9},
+	"°F":   {"temperature", 5.0 / 9, 273.15 - 32*5.0/9},
+	"F":    {"temperature", 5.0 / 9, 273.15 - 32*5.0/9},
+
+	"Pa":   {"pressure", 1, 0},
+	"hPa":  {"pressure", 100, 0},
+	"kPa":  {"pressure", 1000, 0},
+	"MPa":  {"pressure", 1e6, 0},
+	"mbar": {"pressure", 100, 0},
+	"bar":  {"pressure", 1e5, 0},
+	"psi":  {"pressure", 6894.757293168, 0},
+	"atm":  {"pressure", 101325, 0},
+	"mmHg": {"pressure", 133.322387415, 0},
+	"inHg": {"pressure", 3386.389, 0},
+
+	"mm": {"length", 0.001, 0},
+	"cm": {"length", 0.01, 0},
+	"m":  {"length", 1, 0},
+	"km": {"length", 1000, 0},
+	"in": {"length", 0.0254, 0},
+	"ft": {"length", 0.3048, 0},
+	"mi": {"length", 1609.344, 0},
+
+	"g":  {"mass", 0.001, 0},
+	"kg": {"mass", 1, 0},
+	"t":  {"mass", 1000, 0},
+	"oz": {"mass", 0.028349523125, 0},
+	"lb": {"mass", 0.45359237, 0},
+
+	"m/s":  {"speed", 1, 0},
+	"km/h": {"speed", 1 / 3.6, 0},
+	"mph":  {"speed", 0.44704, 0},
+	"kn":   {"speed", 1852 / 3600.0, 0},
+
+	"J":   {"energy", 1, 0},
+	"kJ":  {"energy", 1000, 0},
+	"MJ":  {"energy", 1e6, 0},
+	"Wh":  {"energy", 3600, 0},
+	"kWh": {"energy", 3.6e6, 0},
+	"MWh": {"energy", 3.6e9, 0},
+
+	"W":  {"power", 1, 0},
+	"kW": {"power", 1000, 0},
+	"MW": {"power", 1e6, 0},
+	"hp": {"power", 745.69987158227022, 0},
+}
+
+// defaultCanonicalUnits are the units each quantity is normalized to unless
+// configured otherwise
+var defaultCanonicalUnits = map[string]string{
+	"temperature": "degC",
+	"pressure":    "kPa",
+	"length":      "m",
+	"mass":        "kg",
+	"speed":       "m/s",
+	"energy":      "kWh",
+	"power":       "W",
+}
+
+// unitNormalizer converts the values of resources declared in different
+// units to the canonical unit of their quantity, so that devices of
+// different vendors write comparable series
+type unitNormalizer struct {
+	// units are the units of resources, by device/resource or by resource
+	// for every device
+	units map[string]string
+	// canonical is the unit of every quantity values are converted to
+	canonical map[string]string
+}
+
+// newUnitNormalizer returns a normalizer for the comma separated
+// resource=unit pairs, where the resource may be qualified by a device as
+// device/resource, converting to the comma separated canonical units, which
+// replace the default unit of their quantity
+func newUnitNormalizer(resourceUnits, canonicalUnits string) (*unitNormalizer, error) {
+	resources, err := parseTags(resourceUnits)
+	if err != nil {
+		return nil, err
+	}
+	for resource, symbol := range resources {
+		if _, ok := units[symbol]; !ok {
+			return nil, fmt.Errorf("unknown unit %q of %s", symbol, resource)
+		}
+	}
+	n := &unitNormalizer{
+		units:     resources,
+		canonical: make(map[string]string, len(defaultCanonicalUnits)),
+	}
+	for quantity, symbol := range defaultCanonicalUnits {
+		n.canonical[quantity] = symbol
+	}
+	for _, symbol := range splitList(canonicalUnits) {
+		u, ok := units[symbol]
+		if !ok {
+			return nil, fmt.Errorf("unknown canonical unit %q", symbol)
+		}
+		n.canonical[u.quantity] = symbol
+	}
+	return n, nil
+}
+
+// conversion returns the unit of the resource and the canonical unit of its
+// quantity, or false if the unit of the resource isn't known
+func (n *unitNormalizer) conversion(device, resource string) (from, to unit, target string, ok bool) {
+	if n == nil {
+		return unit{}, unit{}, "", false
+	}
+	symbol, ok := n.units[device+"/"+resource]
+	if !ok {
+		symbol, ok = n.units[resource]
+	}
+	if !ok {
+		return unit{}, unit{}, "", false
+	}
+	target = n.canonical[units[symbol].quantity]
+	return units[symbol], units[target], target, true
+}
+
+// normalize returns the value of the resource in the canonical unit of its
+// quantity and that unit, or false if the unit of the resource isn't known
+func (n *unitNormalizer) normalize(device, resource string, value float64) (float64, string, bool) {
+	from, to, target, ok := n.conversion(device, resource)
+	if !ok {
+		return 0, "", false
+	}
+	if from == to {
+		return value, target, true
+	}
+	converted := (value*from.factor + from.offset - to.offset) / to.factor
+	// drop the rounding errors of the conversion, such as 212 degF being
+	// 100.00000000000006 degC
+	converted, _ = strconv.ParseFloat(strconv.FormatFloat(converted, 'g', 12, 64), 64)
+	return converted, target, true
+}
+
+// normalizeInt returns the integer value of the resource in the canonical
+// unit of its quantity and that unit, or false if the unit of the resource
+// isn't known or not every integer converts to an integer, such as when
+// converting kWh to MWh or degF to degC
+func (n *unitNormalizer) normalizeInt(device, resource string, value int64) (int64, string, bool) {
+	from, to, target, ok := n.conversion(device, resource)
+	if !ok {
+		return 0, "", false
+	}
+	scale := from.factor / to.factor
+	shift := (from.offset - to.offset) / to.factor
+	if !isIntegral(scale) || !isIntegral(shift) {
+		return 0, "", false
+	}
+	return value*int64(math.Round(scale)) + int64(math.Round(shift)), target, true
+}
+
+// isIntegral checks whether the factor of a conversion is an integer, give
+// or take its rounding errors
+func isIntegral(f float64) bool {
+	return math.Abs(f-math.Round(f)) < 1e-9
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// settingsRules check constraints between application settings, which can't
+// be checked while parsing each setting on its own
+var settingsRules = []func(appSettings map[string]string) error{
+	func(appSettings map[string]string) error {
+		if appSettings["RelayURL"] != "" && appSettings["RelaySecret"] == "" {
+			return errors.New("missing value for \"RelaySecret\", required with \"RelayURL\"")
+		}
+		return nil
+	},
+	func(appSettings map[string]string) error {
+		if appSettings["RelayURL"] == "" {
+			if appSettings["RelayCAFile"] != "" {
+				return errors.New("\"RelayCAFile\" requires an https \"RelayURL\"")
+			}
+			return nil
+		}
+		// the batches are signed but not encrypted
+		u, err := url.Parse(appSettings["RelayURL"])
+		if err != nil || u.Scheme != "https" {
+			return errors.New("\"RelayURL\" must be an https URL")
+		}
+		return nil
+	},
+	func(appSettings map[string]string) error {
+		// tokens of any other client of the identity provider would be
+		// accepted otherwise
+		if appSettings["AdminJWKSURL"] == "" {
+			return nil
+		}
+		for _, key := range []string{"AdminJWTIssuer", "AdminJWTAudience"} {
+			if appSettings[key] == "" {
+				return fmt.Errorf("missing value for %q, required with \"AdminJWKSURL\"", key)
+			}
+		}
+		return nil
+	},
+	func(appSettings map[string]string) error {
+		if appSettings["InfluxDBReadPassword"] != "" && appSettings["InfluxDBReadUsername"] == "" {
+			return errors.New("missing value for \"InfluxDBReadUsername\", required with \"InfluxDBReadPassword\"")
+		}
+		return nil
+	},
+	func(appSettings map[string]string) error {
+		// invalid numbers are reported when the settings are parsed
+		count, err := strconv.ParseUint(appSettings["PartitionCount"], 10, 64)
+		if err != nil || count == 0 {
+			return nil
+		}
+		index, err := strconv.ParseUint(appSettings["PartitionIndex"], 10, 64)
+		if err == nil && index >= count {
+			return fmt.Errorf("\"PartitionIndex\" of %d must be less than \"PartitionCount\" of %d", index, count)
+		}
+		return nil
+	},
+	func(appSettings map[string]string) error {
+		lp, statsd := appSettings["LineProtocolListenUDP"], appSettings["StatsDListenUDP"]
+		if lp != "" && lp == statsd {
+			return fmt.Errorf("\"LineProtocolListenUDP\" and \"StatsDListenUDP\" can't both listen on %s", lp)
+		}
+		return nil
+	},
+	func(appSettings map[string]string) error {
+		file := appSettings["HALockFile"]
+		if file != "" && (file == appSettings["HighWaterMarkFile"] || file == appSettings["LogFilePath"]) {
+			return fmt.Errorf("\"HALockFile\" %s can't also be used for high-water marks or logs", file)
+		}
+		return nil
+	},
+	func(appSettings map[string]string) error {
+		// invalid durations are reported when the settings are parsed
+		age, err := time.ParseDuration(appSettings["BackfillAge"])
+		if err == nil && age != 0 && appSettings["BackfillRetentionPolicy"] == "" && appSettings["BackfillMeasurementSuffix"] == "" {
+			return errors.New("\"BackfillAge\" requires \"BackfillRetentionPolicy\" or \"BackfillMeasurementSuffix\"")
+		}
+		return nil
+	},
+	func(appSettings map[string]string) error {
+		// invalid durations are reported when the settings are parsed
+		if appSettings["MaxEventAgeAction"] != "backfill" {
+			return nil
+		}
+		maxAge, err := time.ParseDuration(appSettings["MaxEventAge"])
+		if err != nil || maxAge == 0 {
+			return nil
+		}
+		age, err := time.ParseDuration(appSettings["BackfillAge"])
+		if err != nil || age == 0 || age > maxAge {
+			return errors.New("\"MaxEventAgeAction\" of backfill requires a \"BackfillAge\" of at most \"MaxEventAge\"")
+		}
+		return nil
+	},
+	func(appSettings map[string]string) error {
+		if appSettings["HistogramResources"] != "" && appSettings["HistogramRawSamples"] == rawSinks && appSettings["Sinks"] == "" {
+			return errors.New("\"HistogramRawSamples\" of sinks requires \"Sinks\"")
+		}
+		return nil
+	},
+	func(appSettings map[string]string) error {
+		if appSettings["DeadLetterDir"] != "" {
+			return nil
+		}
+		for _, key := range []string{"AnomalyTypeMismatchAction", "AnomalyTimestampSkewAction", "AnomalyUnknownDeviceAction"} {
+			if appSettings[key] == "dead-letter" {
+				return fmt.Errorf("%q of dead-letter requires \"DeadLetterDir\"", key)
+			}
+		}
+		tenantActions, _ := parseTags(appSettings["AnomalyUnknownDeviceTenantActions"])
+		for tenant, action := range tenantActions {
+			if action == "dead-letter" {
+				return fmt.Errorf("\"AnomalyUnknownDeviceTenantActions\" of dead-letter for tenant %q requires \"DeadLetterDir\"", tenant)
+			}
+		}
+		return nil
+	},
+	func(appSettings map[string]string) error {
+		if appSettings["AnomalyUnknownDeviceTenantActions"] != "" && appSettings["AnomalyTenantTag"] == "" {
+			return errors.New("missing value for \"AnomalyTenantTag\", required with \"AnomalyUnknownDeviceTenantActions\"")
+		}
+		return nil
+	},
+	func(appSettings map[string]string) error {
+		if appSettings["AdminToken"] != "" && appSettings["AdminJWKSURL"] != "" {
+			return errors.New("only one of \"AdminToken\" and \"AdminJWKSURL\" can be set")
+		}
+		return nil
+	},
+}
+
+// validateSettings checks all the rules, returning every violation instead of
+// stopping at the first one
+func validateSettings(appSettings map[string]string) []error {
+	var errs []error
+	for _, rule := range settingsRules {
+		if err := rule(appSettings); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+	influx "github.com/influxdata/influxdb1-client/v2"
+)
+
+// waitForInflux is the WaitFor entry that waits for influx to answer pings
+const waitForInflux = "influx"
+
+// waitForDependencies blocks until influx answers pings and every address
+// accepts TCP connections, checking every interval, or fails once timeout
+// has passed. Entries of deps are either "influx" or a host:port address such
+// as that of the MQTT broker or core-data.
+func waitForDependencies(lc logger.LoggingClient, client influx.Client, deps []string, interval, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for _, dep := range deps {
+		for {
+			err := checkDependency(client, dep, interval)
+			if err == nil {
+				break
+			}
+			if !time.Now().Before(deadline) {
+				return fmt.Errorf("gave up waiting for %s after %s: %v", dep, timeout, err)
+			}
+			lc.Info(fmt.Sprintf("waiting for %s: %s", dep, err))
+			time.Sleep(interval)
+		}
+	}
+	return nil
+}
+
+func checkDependency(client influx.Client, dep string, timeout time.Duration) error {
+	if strings.EqualFold(dep, waitForInflux) {
+		_, _, err := client.Ping(timeout)
+		return err
+	}
+	conn, err := net.DialTimeout("tcp", dep, timeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// highWaterMark is the newest reading written for a device
+type highWaterMark struct {
+	// Origin is the origin time of the newest reading written
+	Origin time.Time `json:"origin"`
+	// Written is when it was written
+	Written time.Time `json:"written"`
+}
+
+// deviceLag is what the lag endpoint returns for each device
+type deviceLag struct {
+	highWaterMark
+	// Lag is how far behind now the newest reading written is
+	Lag string `json:"lag"`
+	// Idle is how long since anything was written for the device
+	Idle string `json:"idle"`
+}
+
+// highWaterMarks tracks the newest reading written for every device, so that
+// stalled devices and exports can be spotted
+type highWaterMarks struct {
+	mu      sync.Mutex
+	devices map[string]highWaterMark
+	dirty   bool
+}
+
+func newHighWaterMarks() *highWaterMarks {
+	return &highWaterMarks{
+		devices: make(map[string]highWaterMark),
+	}
+}
+
+// record notes that a reading from the device with the origin time was
+// written
+func (h *highWaterMarks) record(device string, origin time.Time, written time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	mark := h.devices[device]
+	if origin.After(mark.Origin) {
+		mark.Origin = origin
+	}
+	mark.Written = written
+	h.devices[device] = mark
+	h.dirty = true
+}
+
+// load reads the marks saved by save, a missing file is not an error
+func (h *highWaterMarks) load(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return json.Unmarshal(data, &h.devices)
+}
+
+// save writes the marks to the file if they changed since the last save
+func (h *highWaterMarks) save(path string) error {
+	h.mu.Lock()
+	if !h.dirty {
+		h.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(h.devices)
+	h.dirty = false
+	h.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// write a new file and rename it over the old one, so that a crash
+	// doesn't leave a truncated file behind
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// lag returns the marks of every device with how far behind they are
+func (h *highWaterMarks) lag(now time.Time) map[string]deviceLag {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	lags := make(map[string]deviceLag, len(h.devices))
+	for device, mark := range h.devices {
+		lags[device] = deviceLag{
+			highWaterMark: mark,
+			Lag:           now.Sub(mark.Origin).String(),
+			Idle:          now.Sub(mark.Written).String(),
+		}
+	}
+	return lags
+}
+
+// lagHandler serves the high-water mark and lag of every device as JSON
+func (h *highWaterMarks) lagHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(h.lag(time.Now())); err != nil {
+		writeProblem(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// forget drops the high-water mark of the device
+func (h *highWaterMarks) forget(device string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.devices[device]; ok {
+		delete(h.devices, device)
+		h.dirty = true
+	}
+}
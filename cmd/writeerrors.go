@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// permanentWriteErrors are substrings of InfluxDB write error messages that
+// mean the points will never be accepted as they are, so retrying the write
+// is pointless
+var permanentWriteErrors = []string{
+	"partial write",
+	"field type conflict",
+	"unable to parse",
+	"points beyond retention policy",
+	"max-values-per-tag limit exceeded",
+	"max-series-per-database limit exceeded",
+}
+
+var droppedPointsRegexp = regexp.MustCompile(`dropped=(\d+)`)
+
+// writeFailure describes why a write to InfluxDB failed
+type writeFailure struct {
+	// message is the error message from InfluxDB, or the client error if
+	// InfluxDB never responded
+	message string
+	// permanent is true if retrying the same points will fail again
+	permanent bool
+	// dropped is the number of points InfluxDB reported as dropped for a
+	// partial write, or 0 if unknown
+	dropped int
+	// databaseNotFound is true if the database written to doesn't exist
+	databaseNotFound bool
+}
+
+// classifyWriteError inspects an error returned from the InfluxDB client's
+// Write method. For error responses the client returns the raw response body,
+// which is a JSON object with an "error" key.
+func classifyWriteError(err error) writeFailure {
+	f := writeFailure{message: err.Error()}
+	if _, ok := err.(*rejectedEventError); ok {
+		// rejected by the anomaly policy before reaching InfluxDB
+		f.permanent = true
+		return f
+	}
+
+	var body struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal([]byte(err.Error()), &body) != nil || body.Error == "" {
+		// not an error response from InfluxDB, so probably a connection
+		// problem which is worth retrying
+		return f
+	}
+
+	f.message = body.Error
+	for _, s := range permanentWriteErrors {
+		if strings.Contains(body.Error, s) {
+			f.permanent = true
+			break
+		}
+	}
+	f.databaseNotFound = strings.Contains(body.Error, "database not found")
+	if m := droppedPointsRegexp.FindStringSubmatch(body.Error); m != nil {
+		f.dropped, _ = strconv.Atoi(m[1])
+	}
+
+	return f
+}
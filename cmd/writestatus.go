@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+)
+
+const (
+	// writeStatusRetention is how long the outcome of a tracked write can be
+	// queried after it finished
+	writeStatusRetention = time.Hour
+	// maxTrackedWrites bounds how many writes are tracked, the oldest finished
+	// ones are forgotten first
+	maxTrackedWrites = 10000
+)
+
+// writeStatus is the outcome of a write of an event posted to /edgex that
+// didn't finish before the client was answered
+type writeStatus struct {
+	ID string `json:"id"`
+	// Status is pending, written, filtered or failed
+	Status   string     `json:"status"`
+	Error    string     `json:"error,omitempty"`
+	Accepted time.Time  `json:"accepted"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+// writeTracker tracks the writes synchronous requests to /edgex stopped
+// waiting for, so that clients can query whether they were eventually written
+// at /status/writes/{id}
+type writeTracker struct {
+	mu     sync.Mutex
+	writes map[string]*writeStatus
+	// order is the IDs in the order they were accepted
+	order []string
+}
+
+func newWriteTracker() *writeTracker {
+	return &writeTracker{writes: make(map[string]*writeStatus)}
+}
+
+// track starts tracking a pending write, returning its ID
+func (t *writeTracker) track() (string, error) {
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(idBytes)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.prune(time.Now())
+	t.writes[id] = &writeStatus{ID: id, Status: "pending", Accepted: time.Now()}
+	t.order = append(t.order, id)
+	return id, nil
+}
+
+// finish records the outcome of the write
+func (t *writeTracker) finish(id string, res ingestResult) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ws, ok := t.writes[id]
+	if !ok {
+		return
+	}
+	now := time.Now()
+	ws.Finished = &now
+	switch {
+	case res.err != nil:
+		ws.Status = "failed"
+		ws.Error = classifyWriteError(res.err).message
+	case res.filtered:
+		ws.Status = "filtered"
+	default:
+		ws.Status = "written"
+	}
+}
+
+// prune forgets the writes that finished more than writeStatusRetention ago,
+// and the oldest finished ones beyond maxTrackedWrites. Pending writes are
+// never forgotten, as every write eventually finishes or fails.
+func (t *writeTracker) prune(now time.Time) {
+	excess := len(t.order) - maxTrackedWrites + 1
+	kept := t.order[:0]
+	for _, id := range t.order {
+		ws := t.writes[id]
+		if ws.Finished != nil && (excess > 0 || now.Sub(*ws.Finished) > writeStatusRetention) {
+			delete(t.writes, id)
+			excess--
+			continue
+		}
+		kept = append(kept, id)
+	}
+	t.order = kept
+}
+
+// statusHandler serves GET /status/writes/{id}
+func (t *writeTracker) statusHandler(w http.ResponseWriter, r *http.Request) {
+	id := path.Base(r.URL.Path)
+	t.mu.Lock()
+	ws, ok := t.writes[id]
+	var status writeStatus
+	if ok {
+		status = *ws
+	}
+	t.mu.Unlock()
+	if !ok {
+		writeProblem(w, r, "no such write, it may have been forgotten", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		writeProblem(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
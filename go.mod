@@ -3,6 +3,7 @@ module github.com/anonymouse64/edgex-influx-proxy
 go 1.15
 
 require (
+	github.com/eclipse/paho.mqtt.golang v1.2.0
 	github.com/edgexfoundry/app-functions-sdk-go v1.3.1
 	github.com/edgexfoundry/go-mod-core-contracts v0.1.112
 	github.com/influxdata/influxdb1-client v0.0.0-20200827194710-b269163b24ab
@@ -0,0 +1,64 @@
+package edgexinfluxproxy
+
+import (
+	"path"
+	"sync"
+
+	"github.com/edgexfoundry/go-mod-core-contracts/models"
+)
+
+// ParserFunc decodes the value of a reading, such as BCD, packed bitfields or
+// a proprietary encoding, returning a bool, int64 or float64. Returning false
+// or a value of any other type falls back to the proxy's own parsing.
+type ParserFunc func(reading models.Reading) (value interface{}, ok bool)
+
+// valueParser is a parser along with the resource names it decodes
+type valueParser struct {
+	pattern string
+	fn      ParserFunc
+}
+
+var (
+	parsersMu sync.RWMutex
+	parsers   []valueParser
+)
+
+// RegisterValueParser makes the proxy decode the values of the resources
+// whose name matches pattern with fn, where pattern is as for path.Match,
+// such as "Meter*". Parsers are tried in the order they are registered. It is
+// meant to be called from the init function of the package providing the
+// parser, and panics if the pattern is malformed or already registered.
+func RegisterValueParser(pattern string, fn ParserFunc) {
+	parsersMu.Lock()
+	defer parsersMu.Unlock()
+
+	if fn == nil {
+		panic("edgexinfluxproxy: RegisterValueParser parser is nil")
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		panic("edgexinfluxproxy: RegisterValueParser pattern " + pattern + " is malformed")
+	}
+	for _, p := range parsers {
+		if p.pattern == pattern {
+			panic("edgexinfluxproxy: RegisterValueParser called twice for pattern " + pattern)
+		}
+	}
+	parsers = append(parsers, valueParser{pattern: pattern, fn: fn})
+}
+
+// ParseValue decodes the value of the reading with the first registered
+// parser whose pattern matches its resource name and that accepts it.
+func ParseValue(reading models.Reading) (interface{}, bool) {
+	parsersMu.RLock()
+	defer parsersMu.RUnlock()
+
+	for _, p := range parsers {
+		if matched, _ := path.Match(p.pattern, reading.Name); !matched {
+			continue
+		}
+		if value, ok := p.fn(reading); ok {
+			return value, true
+		}
+	}
+	return nil, false
+}
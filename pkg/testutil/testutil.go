@@ -0,0 +1,214 @@
+// Package testutil provides test doubles for the proxy and for the sources
+// and sinks registered with it: a clock that only moves when told to, an
+// InfluxDB client and a sink that record what is written to them, and a
+// registrar that records what is registered with it.
+package testutil
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	edgexinfluxproxy "github.com/anonymouse64/edgex-influx-proxy"
+	influx "github.com/influxdata/influxdb1-client/v2"
+)
+
+// FakeClock is a clock whose time only changes when set or advanced.
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+}
+
+type fakeTicker struct {
+	c      chan time.Time
+	period time.Duration
+	next   time.Time
+}
+
+// NewFakeClock returns a clock stopped at now.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now returns the current time of the clock.
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Tick returns a channel delivering the time of the clock every d, like
+// time.Tick. As with time.Tick, ticks are dropped if the receiver falls
+// behind.
+func (c *FakeClock) Tick(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTicker{c: make(chan time.Time, 1), period: d, next: c.now.Add(d)}
+	c.tickers = append(c.tickers, t)
+	return t.c
+}
+
+// Set moves the clock to now, delivering the ticks due by then.
+func (c *FakeClock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+	c.tick()
+}
+
+// Advance moves the clock forward by d, delivering the ticks due by then.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	c.tick()
+}
+
+// tick delivers the ticks due by now, the lock must be held
+func (c *FakeClock) tick() {
+	for _, t := range c.tickers {
+		for !t.next.After(c.now) {
+			select {
+			case t.c <- t.next:
+			default:
+			}
+			t.next = t.next.Add(t.period)
+		}
+	}
+}
+
+// RecordingClient is an InfluxDB client that records the batches written to
+// it instead of sending them anywhere.
+type RecordingClient struct {
+	mu      sync.Mutex
+	batches []influx.BatchPoints
+	// Err is returned by Write, without recording the batch, when set.
+	Err error
+	// Discard makes Write serialize the batch like the real client does
+	// and forget it instead of recording it, for benchmarks.
+	Discard bool
+}
+
+// Write records the batch, or returns Err if set.
+func (c *RecordingClient) Write(bp influx.BatchPoints) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.Err != nil {
+		return c.Err
+	}
+	if c.Discard {
+		for _, pt := range bp.Points() {
+			_ = pt.PrecisionString(bp.Precision())
+		}
+		return nil
+	}
+	c.batches = append(c.batches, bp)
+	return nil
+}
+
+// Batches returns the batches written so far.
+func (c *RecordingClient) Batches() []influx.BatchPoints {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]influx.BatchPoints(nil), c.batches...)
+}
+
+// Points returns the points of every batch written so far.
+func (c *RecordingClient) Points() []*influx.Point {
+	var points []*influx.Point
+	for _, bp := range c.Batches() {
+		points = append(points, bp.Points()...)
+	}
+	return points
+}
+
+// Ping always succeeds.
+func (c *RecordingClient) Ping(timeout time.Duration) (time.Duration, string, error) {
+	return 0, "", nil
+}
+
+// Query is not supported.
+func (c *RecordingClient) Query(q influx.Query) (*influx.Response, error) {
+	return nil, errors.New("queries are not supported by the recording client")
+}
+
+// QueryAsChunk is not supported.
+func (c *RecordingClient) QueryAsChunk(q influx.Query) (*influx.ChunkedResponse, error) {
+	return nil, errors.New("queries are not supported by the recording client")
+}
+
+// Close does nothing.
+func (c *RecordingClient) Close() error {
+	return nil
+}
+
+// RecordingSink is a sink that records the points written to it, to test
+// sources without a proxy.
+type RecordingSink struct {
+	mu     sync.Mutex
+	points []*influx.Point
+	// Err is returned by Write, without recording the points, when set.
+	Err error
+}
+
+// Write records the points, or returns Err if set.
+func (s *RecordingSink) Write(points []*influx.Point) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Err != nil {
+		return s.Err
+	}
+	s.points = append(s.points, points...)
+	return nil
+}
+
+// Points returns the points written so far.
+func (s *RecordingSink) Points() []*influx.Point {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*influx.Point(nil), s.points...)
+}
+
+// RecordingRegistrar is a registrar that records the sources and sinks
+// registered with it, to test the registration of extensions without adding
+// them to the proxy's registry.
+type RecordingRegistrar struct {
+	mu      sync.Mutex
+	sources map[string]edgexinfluxproxy.SourceFactory
+	sinks   map[string]edgexinfluxproxy.SinkFactory
+}
+
+// RegisterSource records the source factory under name.
+func (r *RecordingRegistrar) RegisterSource(name string, factory edgexinfluxproxy.SourceFactory) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.sources == nil {
+		r.sources = make(map[string]edgexinfluxproxy.SourceFactory)
+	}
+	r.sources[name] = factory
+}
+
+// RegisterSink records the sink factory under name.
+func (r *RecordingRegistrar) RegisterSink(name string, factory edgexinfluxproxy.SinkFactory) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.sinks == nil {
+		r.sinks = make(map[string]edgexinfluxproxy.SinkFactory)
+	}
+	r.sinks[name] = factory
+}
+
+// Source returns the source factory registered under name, or nil.
+func (r *RecordingRegistrar) Source(name string) edgexinfluxproxy.SourceFactory {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.sources[name]
+}
+
+// Sink returns the sink factory registered under name, or nil.
+func (r *RecordingRegistrar) Sink(name string) edgexinfluxproxy.SinkFactory {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.sinks[name]
+}
@@ -0,0 +1,35 @@
+package testutil
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeClockTick(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	c := NewFakeClock(start)
+	tick := c.Tick(time.Second)
+
+	c.Advance(time.Second - 1)
+	select {
+	case now := <-tick:
+		t.Fatalf("ticked at %s before a second passed", now)
+	default:
+	}
+
+	c.Advance(1)
+	if now := <-tick; !now.Equal(start.Add(time.Second)) {
+		t.Fatalf("ticked at %s, want %s", now, start.Add(time.Second))
+	}
+
+	// ticks are dropped while the receiver falls behind, as with time.Tick
+	c.Advance(3 * time.Second)
+	if now := <-tick; !now.Equal(start.Add(2 * time.Second)) {
+		t.Fatalf("ticked at %s, want %s", now, start.Add(2*time.Second))
+	}
+	select {
+	case now := <-tick:
+		t.Fatalf("dropped tick at %s was delivered", now)
+	default:
+	}
+}
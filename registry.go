@@ -0,0 +1,142 @@
+package edgexinfluxproxy
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/edgexfoundry/go-mod-core-contracts/clients/logger"
+	influx "github.com/influxdata/influxdb1-client/v2"
+)
+
+// Sink receives a copy of every batch of points that the proxy writes to
+// InfluxDB.
+type Sink interface {
+	Write(points []*influx.Point) error
+}
+
+// Source produces points of its own, in addition to the EdgeX events the
+// proxy receives. Start must not block, and should write to sink for as long
+// as the proxy runs.
+type Source interface {
+	Start(sink Sink) error
+}
+
+// SourceFactory creates a source from the application settings of the proxy.
+type SourceFactory func(lc logger.LoggingClient, settings map[string]string) (Source, error)
+
+// SinkFactory creates a sink from the application settings of the proxy.
+type SinkFactory func(lc logger.LoggingClient, settings map[string]string) (Sink, error)
+
+// Registrar is what sources and sinks are registered with. Packages
+// providing several of them can register them all with a function taking a
+// Registrar, called with DefaultRegistrar from their init function, so that
+// tests can check what they register with a registrar of their own.
+type Registrar interface {
+	RegisterSource(name string, factory SourceFactory)
+	RegisterSink(name string, factory SinkFactory)
+}
+
+// DefaultRegistrar registers with the proxy, as RegisterSource and
+// RegisterSink do.
+var DefaultRegistrar Registrar = registry{}
+
+type registry struct{}
+
+func (registry) RegisterSource(name string, factory SourceFactory) {
+	RegisterSource(name, factory)
+}
+
+func (registry) RegisterSink(name string, factory SinkFactory) {
+	RegisterSink(name, factory)
+}
+
+var (
+	registryMu sync.Mutex
+	sources    = make(map[string]SourceFactory)
+	sinks      = make(map[string]SinkFactory)
+)
+
+// RegisterSource makes a source available under the given name, so that it
+// can be enabled by listing the name in the "Sources" application setting.
+// It is meant to be called from the init function of the package providing
+// the source, and panics if the name is already registered.
+func RegisterSource(name string, factory SourceFactory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if factory == nil {
+		panic("edgexinfluxproxy: RegisterSource factory is nil")
+	}
+	if _, dup := sources[name]; dup {
+		panic("edgexinfluxproxy: RegisterSource called twice for source " + name)
+	}
+	sources[name] = factory
+}
+
+// RegisterSink makes a sink available under the given name, so that it can
+// be enabled by listing the name in the "Sinks" application setting. It is
+// meant to be called from the init function of the package providing the
+// sink, and panics if the name is already registered.
+func RegisterSink(name string, factory SinkFactory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if factory == nil {
+		panic("edgexinfluxproxy: RegisterSink factory is nil")
+	}
+	if _, dup := sinks[name]; dup {
+		panic("edgexinfluxproxy: RegisterSink called twice for sink " + name)
+	}
+	sinks[name] = factory
+}
+
+// NewSource creates the source registered under name.
+func NewSource(name string, lc logger.LoggingClient, settings map[string]string) (Source, error) {
+	registryMu.Lock()
+	factory, ok := sources[name]
+	registryMu.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown source %q (forgotten import?)", name)
+	}
+	return factory(lc, settings)
+}
+
+// NewSink creates the sink registered under name.
+func NewSink(name string, lc logger.LoggingClient, settings map[string]string) (Sink, error) {
+	registryMu.Lock()
+	factory, ok := sinks[name]
+	registryMu.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown sink %q (forgotten import?)", name)
+	}
+	return factory(lc, settings)
+}
+
+// Sources returns the sorted names of the registered sources.
+func Sources() []string {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Sinks returns the sorted names of the registered sinks.
+func Sinks() []string {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	names := make([]string, 0, len(sinks))
+	for name := range sinks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}